@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/charmbracelet/x/ansi"
+)
+
+// --- SCREEN READER MODE ---
+//
+// -screen-reader, or "screen_reader": true in the config, swaps the drawn
+// panel for a few plain lines that a terminal screen reader can follow:
+// the notes being played by name, the instrument, octave and tempo, and
+// whatever the header would flag. An open panel is written out as its
+// text, without the bars, boxes and charts. The visualizer is left out,
+// so the screen only changes when something does.
+
+func (m model) screenReaderView() string {
+	status := []string{m.instName, fmt.Sprintf("octave %+d", m.octaveShift),
+		fmt.Sprintf("volume %.0f%%", m.volume*100), fmt.Sprintf("tempo %.0f", m.bpm)}
+	if m.transposing {
+		status = append(status, fmt.Sprintf("in %s", musicKey{currentKey.signature(), 0}.names()[0]))
+	}
+	if m.playMode != playPoly {
+		status = append(status, playModeNames[m.playMode])
+	}
+	if m.theremin.active {
+		status = append(status, "theremin "+m.theremin.label())
+	}
+	if m.click {
+		status = append(status, "metronome on")
+	}
+	if m.drumsPlaying {
+		status = append(status, "drums playing")
+	}
+	if m.count != nil {
+		status = append(status, "count-in "+m.count.label(time.Now()))
+	}
+	if m.recording {
+		status = append(status, "recording")
+	}
+	lines := []string{"piango: " + strings.Join(status, ", ") + "."}
+
+	playing := "nothing"
+	if names := m.playingNames(); len(names) > 0 {
+		playing = strings.Join(names, ", ")
+	}
+	if m.chord != "" && !m.ear.open {
+		playing += " (" + m.chord + ")"
+	}
+	lines = append(lines, "Playing: "+playing+".")
+	if m.jam != nil {
+		lines = append(lines, m.jam.label())
+	}
+	if m.notification != "" {
+		lines = append(lines, m.notification)
+	}
+	if p := m.panel(); p != "" {
+		lines = append(lines, "")
+		lines = append(lines, plainLines(p)...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// playingNames names the keys held, low to high: pitches as they sound,
+// or the drums of a kit.
+func (m model) playingNames() []string {
+	type playing struct {
+		name string
+		freq float64
+	}
+	var held []playing
+	for i, row := range sortedRows {
+		for col, n := range row {
+			if !m.activeKeys[n.Key] {
+				continue
+			}
+			freq := m.noteFreq(n)
+			if rowInstrument(i).Kit {
+				held = append(held, playing{drumNames[col%len(drumNames)], freq})
+			} else if midi, ok := midiNote(freq); ok {
+				held = append(held, playing{pitchName(int(midi)), freq})
+			}
+		}
+	}
+	sort.SliceStable(held, func(a, b int) bool { return held[a].freq < held[b].freq })
+	names := make([]string, len(held))
+	for i, h := range held {
+		names[i] = h.name
+	}
+	return names
+}
+
+// plainLines is a panel as text: styling, box drawing and block
+// characters are dropped, spacing is evened out and empty lines go.
+func plainLines(s string) []string {
+	var out []string
+	for _, line := range strings.Split(ansi.Strip(s), "\n") {
+		line = strings.Map(func(r rune) rune {
+			if r >= 0x2500 && r <= 0x259f || r >= 0x2800 && r <= 0x28ff { // boxes, blocks, braille
+				return ' '
+			}
+			return r
+		}, line)
+		line = strings.Join(strings.Fields(line), " ")
+		if strings.IndexFunc(line, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) >= 0 {
+			out = append(out, line)
+		}
+	}
+	return out
+}
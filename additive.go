@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// --- ADDITIVE ---
+//
+// An additive instrument is a list of harmonic levels: partial 1 is the
+// fundamental, partial 2 an octave up, and so on. Ctrl+A opens a bar
+// editor for them while the current instrument is additive; edits are
+// heard straight away and ENTER saves them to
+// ~/.config/piango/partials.json, which is loaded at startup.
+
+const (
+	partialStep  = 0.05
+	partialsRows = 8
+)
+
+// additiveOsc plays inst's partials. It reads them on every sample, so
+// they can be edited (under output.Lock()) while notes sound.
+type additiveOsc struct {
+	inst  *Instrument
+	phase float64
+}
+
+func (o *additiveOsc) NextSample(freq, sampleRate float64) float64 {
+	var v, sum float64
+	for k, a := range o.inst.Partials {
+		if a > 0 {
+			v += math.Sin(o.phase*float64(k+1)) * a
+			sum += a
+		}
+	}
+	o.phase = advancePhase(o.phase, freq, sampleRate)
+
+	// Keep the level steady as partials are added
+	return v * 0.2 / math.Max(sum, 1)
+}
+
+func (o *additiveOsc) scatter() { o.phase = rand.Float64() * 2 * math.Pi }
+
+// newOsc builds one of inst's oscillators for a voice.
+func (inst *Instrument) newOsc() Oscillator {
+	if inst.Partials != nil {
+		return &additiveOsc{inst: inst}
+	}
+	return inst.Osc()
+}
+
+func partialsPath() string {
+	dir := configDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "partials.json")
+}
+
+// loadPartials replaces the partials of every additive instrument saved in
+// path. A missing file is fine.
+func loadPartials(path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var saved map[string][]float64
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for name, levels := range saved {
+		inst := findInstrument(name)
+		if inst == nil || inst.Partials == nil {
+			continue
+		}
+		for k := range inst.Partials {
+			inst.Partials[k] = 0
+			if k < len(levels) {
+				inst.Partials[k] = max(0, min(1, levels[k]))
+			}
+		}
+	}
+	return nil
+}
+
+// savePartials writes the partials of every additive instrument to path.
+func savePartials(path string) error {
+	if path == "" {
+		return errors.New("no config directory")
+	}
+	saved := make(map[string][]float64)
+	output.Lock()
+	for _, inst := range instruments {
+		if inst.Partials != nil {
+			saved[inst.Name] = append([]float64(nil), inst.Partials...)
+		}
+	}
+	output.Unlock()
+
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+type partialsEditor struct {
+	open   bool
+	cursor int
+}
+
+func (m model) openPartials() model {
+	if instruments[currentInstID].Partials == nil {
+		m.notification = instruments[currentInstID].Name + " isn't additive"
+		m.notifyClearTime = time.Now().Add(2 * time.Second)
+		return m
+	}
+	m.partials.open = true
+	m.partials.cursor = min(m.partials.cursor, len(instruments[currentInstID].Partials)-1)
+	return m
+}
+
+// handlePartialsKey consumes the keys the partials editor owns.
+func (m model) handlePartialsKey(msg tea.KeyMsg) (model, bool) {
+	levels := instruments[currentInstID].Partials
+	if levels == nil {
+		// Switched to an instrument that isn't additive
+		m.partials.open = false
+		return m, false
+	}
+
+	switch msg.Type {
+	case tea.KeyCtrlA, tea.KeyEscape:
+		m.partials.open = false
+	case tea.KeyLeft:
+		m.partials.cursor = (m.partials.cursor - 1 + len(levels)) % len(levels)
+	case tea.KeyRight:
+		m.partials.cursor = (m.partials.cursor + 1) % len(levels)
+	case tea.KeyUp, tea.KeyDown:
+		step := partialStep
+		if msg.Type == tea.KeyDown {
+			step = -step
+		}
+		output.Lock()
+		k := m.partials.cursor
+		from := levels[k]
+		levels[k] = math.Round(max(0, min(1, levels[k]+step))/partialStep) * partialStep
+		to := levels[k]
+		output.Unlock()
+		m = m.instrumentEdited(harmonicParams(len(levels))[k], from, to)
+	case tea.KeyEnter:
+		if err := savePartials(partialsPath()); err != nil {
+			m.notification = fmt.Sprintf("Save failed: %v", err)
+		} else {
+			m.notification = "Partials saved"
+		}
+		m.notifyClearTime = time.Now().Add(2 * time.Second)
+	default:
+		return m, false
+	}
+	return m, true
+}
+
+func (m model) partialsView() string {
+	inst := instruments[currentInstID]
+	output.Lock()
+	levels := append([]float64(nil), inst.Partials...)
+	output.Unlock()
+
+	lines := []string{presetTitleStyle.Render("--- PARTIALS: " + inst.Name + " ---")}
+	for r := partialsRows; r >= 1; r-- {
+		var line strings.Builder
+		for k, a := range levels {
+			h := a * partialsRows
+			switch {
+			case h >= float64(r):
+				line.WriteString("██")
+			case h >= float64(r)-0.5:
+				line.WriteString("▄▄")
+			default:
+				line.WriteString("  ")
+			}
+			if k == m.partials.cursor {
+				line.WriteString("▏")
+			} else {
+				line.WriteString(" ")
+			}
+		}
+		lines = append(lines, waveColor.Render(line.String()))
+	}
+
+	var labels strings.Builder
+	for k := range levels {
+		fmt.Fprintf(&labels, "%-3d", k+1)
+	}
+	lines = append(lines, presetTextStyle.Render(labels.String()))
+	lines = append(lines, instStyle.UnsetMarginBottom().Render(
+		fmt.Sprintf("Partial %d: %.2f", m.partials.cursor+1, levels[m.partials.cursor])))
+	lines = append(lines, helpStyle.Render("←/→: Select  •  ↑/↓: Level  •  ENTER: Save  •  ESC/CTRL+A: Close"))
+
+	return visStyle.Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
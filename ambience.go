@@ -0,0 +1,223 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gopxl/beep/v2"
+	"github.com/gopxl/beep/v2/wav"
+)
+
+// --- AMBIENT BACKGROUND LAYER ---
+
+// AmbienceLayer is one looping background texture. The built-in layers are
+// generated on the fly, user layers are WAV files decoded into memory.
+type AmbienceLayer struct {
+	Name   string
+	stream beep.Streamer
+}
+
+// Ambience plays the selected layer under the performance mixer with its
+// own volume. It never drains, so it can sit on the speaker permanently.
+// Fields are changed under output.Lock().
+type Ambience struct {
+	layers  []AmbienceLayer
+	current int
+	volume  float64
+}
+
+var ambience = newAmbience()
+
+func newAmbience() *Ambience {
+	a := &Ambience{current: -1, volume: 0.4}
+	a.layers = []AmbienceLayer{
+		{Name: "Rain", stream: &rainStreamer{}},
+		{Name: "Vinyl", stream: &vinylStreamer{}},
+		{Name: "Tape Hiss", stream: &hissStreamer{}},
+	}
+	return a
+}
+
+func (a *Ambience) Stream(samples [][2]float64) (n int, ok bool) {
+	if a.current < 0 || a.volume <= 0 {
+		clear(samples)
+		return len(samples), true
+	}
+
+	sn, _ := a.layers[a.current].stream.Stream(samples)
+	clear(samples[sn:])
+	for i := range samples[:sn] {
+		samples[i][0] *= a.volume
+		samples[i][1] *= a.volume
+	}
+	return len(samples), true
+}
+
+func (a *Ambience) Err() error { return nil }
+
+// Next cycles Off -> layer 1 -> ... -> Off.
+func (a *Ambience) Next() {
+	a.current++
+	if a.current >= len(a.layers) {
+		a.current = -1
+	}
+}
+
+func (a *Ambience) AdjustVolume(delta float64) {
+	a.volume = math.Round((a.volume+delta)*10) / 10
+	if a.volume < 0 {
+		a.volume = 0
+	} else if a.volume > 1 {
+		a.volume = 1
+	}
+}
+
+func (a *Ambience) Name() string {
+	if a.current < 0 {
+		return "Off"
+	}
+	return a.layers[a.current].Name
+}
+
+// loadAmbienceFiles appends every WAV file found in dir as an extra layer.
+// Files that fail to decode are skipped.
+func (a *Ambience) loadAmbienceFiles(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.EqualFold(filepath.Ext(e.Name()), ".wav") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		s, err := loadLoop(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		a.layers = append(a.layers, AmbienceLayer{
+			Name:   strings.TrimSuffix(name, filepath.Ext(name)),
+			stream: s,
+		})
+	}
+}
+
+func loadLoop(path string) (beep.Streamer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s, format, err := wav.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	var src beep.Streamer = s
+	if format.SampleRate != sampleRate {
+		src = beep.Resample(4, format.SampleRate, sampleRate, s)
+	}
+
+	buf := beep.NewBuffer(beep.Format{SampleRate: sampleRate, NumChannels: 2, Precision: 2})
+	buf.Append(src)
+	if buf.Len() == 0 {
+		return nil, os.ErrInvalid
+	}
+	return beep.Loop2(buf.Streamer(0, buf.Len()))
+}
+
+func ambienceDir() string {
+	dir := configDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "ambience")
+}
+
+// Built-in layers. Each is a small stateful noise generator so nothing has
+// to be shipped as a sample file.
+
+type rainStreamer struct {
+	lp    [2]float64
+	drops [2]float64
+}
+
+func (r *rainStreamer) Stream(samples [][2]float64) (n int, ok bool) {
+	smooth, chance, fall := smoothAt(0.25), chanceAt(0.0004), decayAt(0.992)
+	for i := range samples {
+		for c := 0; c < 2; c++ {
+			white := rand.Float64()*2.0 - 1.0
+			r.lp[c] += (white - r.lp[c]) * smooth
+
+			if rand.Float64() < chance {
+				r.drops[c] = 0.3 + rand.Float64()*0.5
+			}
+			r.drops[c] *= fall
+
+			samples[i][c] = r.lp[c]*0.12 + (white-r.lp[c])*r.drops[c]*0.3
+		}
+	}
+	return len(samples), true
+}
+
+func (r *rainStreamer) Err() error { return nil }
+
+type vinylStreamer struct {
+	lp    float64
+	click float64
+}
+
+func (v *vinylStreamer) Stream(samples [][2]float64) (n int, ok bool) {
+	smooth, chance, fall := smoothAt(0.05), chanceAt(0.0003), decayAt(0.6)
+	for i := range samples {
+		white := rand.Float64()*2.0 - 1.0
+		v.lp += (white - v.lp) * smooth
+
+		if rand.Float64() < chance {
+			v.click = (rand.Float64()*2.0 - 1.0) * 0.8
+		}
+		out := v.lp*0.08 + v.click
+		v.click *= fall
+
+		samples[i][0] = out
+		samples[i][1] = out
+	}
+	return len(samples), true
+}
+
+func (v *vinylStreamer) Err() error { return nil }
+
+type hissStreamer struct {
+	prev  [2]float64
+	phase float64
+}
+
+func (h *hissStreamer) Stream(samples [][2]float64) (n int, ok bool) {
+	step := 0.3 * 2 * math.Pi / float64(sampleRate)
+	for i := range samples {
+		wow := 0.85 + 0.15*math.Sin(h.phase)
+		for c := 0; c < 2; c++ {
+			white := rand.Float64()*2.0 - 1.0
+			samples[i][c] = (white - h.prev[c]) * 0.06 * wow
+			h.prev[c] = white
+		}
+		h.phase += step
+		if h.phase >= 2*math.Pi {
+			h.phase -= 2 * math.Pi
+		}
+	}
+	return len(samples), true
+}
+
+func (h *hissStreamer) Err() error { return nil }
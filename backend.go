@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ebitengine/oto/v3"
+	"github.com/gopxl/beep/v2"
+)
+
+// --- AUDIO BACKENDS ---
+
+// AudioBackend is where the mixed signal ends up. speaker and null are
+// always built in; builds with extra tags (see jack.go, portaudio.go)
+// register more. A backend may change sampleRate during Init if the
+// device dictates its own rate.
+type AudioBackend interface {
+	Init(sr beep.SampleRate, bufferSize int) error
+	Play(s ...beep.Streamer)
+	Clear()
+	Close()
+	Latency() time.Duration
+
+	// Lock and Unlock guard anything the render callback reads.
+	Lock()
+	Unlock()
+}
+
+// bufferResizer is a backend whose buffer can change while it plays.
+type bufferResizer interface {
+	Resize(bufferSize int) error
+}
+
+var backends = map[string]AudioBackend{}
+
+func registerBackend(name string, b AudioBackend) {
+	backends[name] = b
+}
+
+func backendNames() []string {
+	var names []string
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var output AudioBackend = &speakerBackend{}
+
+func init() {
+	registerBackend("speaker", output)
+	registerBackend("null", &nullBackend{})
+}
+
+// speakerBackend plays through oto like beep's speaker package, which it
+// replaces so as to keep hold of the player: the buffer is split between
+// the device, fixed once it's open, and the player, which can grow.
+type speakerBackend struct {
+	mu     sync.Mutex
+	player *oto.Player
+	mixer  beep.Mixer
+	buf    [][2]float64
+	device int // frames
+	frames int // in all
+}
+
+func (b *speakerBackend) Init(sr beep.SampleRate, bufferSize int) error {
+	b.device = bufferSize / 2
+	ctx, ready, err := oto.NewContext(&oto.NewContextOptions{
+		SampleRate:   int(sr),
+		ChannelCount: 2,
+		Format:       oto.FormatSignedInt16LE,
+		BufferSize:   sr.D(b.device),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to initialize speaker: %w", err)
+	}
+	<-ready
+	b.player = ctx.NewPlayer(b)
+	b.Resize(bufferSize)
+	b.player.Play()
+	return nil
+}
+
+// Resize gives the player whatever the device doesn't hold. The device
+// can't change once it's open, and holds half the buffer it opened with.
+func (b *speakerBackend) Resize(bufferSize int) error {
+	if b.player == nil {
+		return errors.New("the speaker isn't open")
+	}
+	if bufferSize < b.device*2 {
+		return fmt.Errorf("the speaker can't go below %s without a restart", millis(sampleRate.D(b.device*2)))
+	}
+	player := bufferSize - b.device
+	b.player.SetBufferSize(player * 4)
+	b.frames = b.device + player
+	return nil
+}
+
+// Read renders the mix as 16-bit stereo for oto, from its own goroutine.
+func (b *speakerBackend) Read(p []byte) (int, error) {
+	n := len(p) / 4
+	b.mu.Lock()
+	if len(b.buf) < n {
+		b.buf = make([][2]float64, n)
+	}
+	samples := b.buf[:n]
+	got, _ := b.mixer.Stream(samples)
+	b.mu.Unlock()
+
+	for i, s := range samples {
+		for c := range s {
+			v := 0.0
+			if i < got {
+				v = max(-1, min(1, s[c]))
+			}
+			binary.LittleEndian.PutUint16(p[i*4+c*2:], uint16(int16(v*math.MaxInt16)))
+		}
+	}
+	return n * 4, nil
+}
+
+func (b *speakerBackend) Play(s ...beep.Streamer) {
+	b.mu.Lock()
+	b.mixer.Add(s...)
+	b.mu.Unlock()
+}
+
+func (b *speakerBackend) Clear() {
+	b.mu.Lock()
+	b.mixer.Clear()
+	b.mu.Unlock()
+}
+
+func (b *speakerBackend) Close() {
+	if b.player != nil {
+		b.player.Close()
+		b.player = nil
+		b.Clear()
+	}
+}
+
+func (b *speakerBackend) Latency() time.Duration { return sampleRate.D(b.frames) }
+func (b *speakerBackend) Lock()                  { b.mu.Lock() }
+func (b *speakerBackend) Unlock()                { b.mu.Unlock() }
+
+// DriverLatency is the part of the buffer oto hands the device.
+func (b *speakerBackend) DriverLatency() time.Duration { return sampleRate.D(b.device) }
+
+// nullBackend renders in real time and throws the result away. Useful on
+// machines without a sound card and for exercising the engine headless.
+type nullBackend struct {
+	mu     sync.Mutex
+	mixer  beep.Mixer
+	buf    [][2]float64
+	period time.Duration
+	done   chan struct{}
+}
+
+func (b *nullBackend) Init(sr beep.SampleRate, bufferSize int) error {
+	b.mu.Lock()
+	b.buf = make([][2]float64, bufferSize)
+	b.period = sr.D(bufferSize)
+	b.mu.Unlock()
+	done := make(chan struct{})
+	b.done = done
+
+	go func() {
+		t := time.NewTicker(sr.D(bufferSize))
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				b.mu.Lock()
+				b.mixer.Stream(b.buf)
+				b.mu.Unlock()
+			}
+		}
+	}()
+	return nil
+}
+
+func (b *nullBackend) Play(s ...beep.Streamer) {
+	b.mu.Lock()
+	b.mixer.Add(s...)
+	b.mu.Unlock()
+}
+
+func (b *nullBackend) Clear() {
+	b.mu.Lock()
+	b.mixer.Clear()
+	b.mu.Unlock()
+}
+
+// Resize starts rendering over at the new period.
+func (b *nullBackend) Resize(bufferSize int) error {
+	b.Close()
+	return b.Init(sampleRate, bufferSize)
+}
+
+func (b *nullBackend) Close() {
+	if b.done != nil {
+		close(b.done)
+		b.done = nil
+	}
+}
+
+func (b *nullBackend) Latency() time.Duration { return b.period }
+func (b *nullBackend) Lock()                  { b.mu.Lock() }
+func (b *nullBackend) Unlock()                { b.mu.Unlock() }
@@ -0,0 +1,48 @@
+package main
+
+import "time"
+
+// --- PITCH BEND ---
+//
+// , and . bend every sounding voice down or up while held. Terminals only
+// send key repeats, so the bend is held for as long as repeats keep coming
+// and glides back to centre once they stop.
+
+const (
+	bendRange = 2.0 // semitones
+	bendGlide = 80 * time.Millisecond
+	bendHold  = 600 * time.Millisecond // covers the delay before repeats start
+)
+
+// pitchBend is the bend in semitones that voices glide towards. Changed
+// under output.Lock().
+var pitchBend float64
+
+type bender struct {
+	dir      int
+	lastSeen time.Time
+}
+
+func (m model) bendPitch(dir int) model {
+	m.bend.lastSeen = time.Now()
+	if m.bend.dir != dir {
+		m.bend.dir = dir
+		setPitchBend(float64(dir) * bendRange)
+	}
+	return m
+}
+
+// releaseBend returns the bend to centre once the key stops repeating.
+func (m model) releaseBend(now time.Time) model {
+	if m.bend.dir != 0 && now.Sub(m.bend.lastSeen) > bendHold {
+		m.bend.dir = 0
+		setPitchBend(0)
+	}
+	return m
+}
+
+func setPitchBend(semitones float64) {
+	output.Lock()
+	pitchBend = semitones
+	output.Unlock()
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// --- INSTRUMENT BROWSER ---
+//
+// Tab opens a searchable list of every instrument, built-in and user
+// presets alike. Typing filters by name, the arrows move through the
+// matches playing a short note of each, and ENTER switches to it.
+
+const (
+	browserRows  = 10
+	auditionKey  = "audition" // the voice map slot audition notes use
+	auditionHold = 300 * time.Millisecond
+)
+
+type instrumentBrowser struct {
+	open   bool
+	query  string
+	cursor int // index into matches
+}
+
+func (m model) openBrowser() model {
+	m.browser = instrumentBrowser{open: true}
+	matches := m.browser.matches()
+	for i, id := range matches {
+		if id == currentInstID {
+			m.browser.cursor = i
+		}
+	}
+	return m
+}
+
+// matches lists the ids of the instruments whose names contain the query.
+func (b instrumentBrowser) matches() []int {
+	q := strings.ToLower(b.query)
+	var ids []int
+	for i, inst := range instruments {
+		if strings.Contains(strings.ToLower(inst.Name), q) {
+			ids = append(ids, i)
+		}
+	}
+	return ids
+}
+
+// handleBrowserKey takes every key while the browser is open, since the
+// search is typed on the note keys.
+func (m model) handleBrowserKey(msg tea.KeyMsg) model {
+	b := &m.browser
+	matches := b.matches()
+	move := func(dir int) {
+		if len(matches) == 0 {
+			return
+		}
+		b.cursor = (b.cursor + dir + len(matches)) % len(matches)
+		audition(instruments[matches[b.cursor]])
+	}
+
+	switch msg.Type {
+	case tea.KeyEscape:
+		b.open = false
+	case tea.KeyEnter:
+		if len(matches) > 0 {
+			voiceLock.Lock()
+			currentInstID = matches[b.cursor]
+			m.instName = instruments[currentInstID].Name
+			voiceLock.Unlock()
+		}
+		b.open = false
+	case tea.KeyUp, tea.KeyShiftTab:
+		move(-1)
+	case tea.KeyDown, tea.KeyTab:
+		move(1)
+	case tea.KeyBackspace:
+		if r := []rune(b.query); len(r) > 0 {
+			b.query = string(r[:len(r)-1])
+			b.cursor = 0
+		}
+	case tea.KeySpace:
+		b.query += " "
+		b.cursor = 0
+	case tea.KeyRunes:
+		b.query += string(msg.Runes)
+		b.cursor = 0
+	}
+	return m
+}
+
+// audition plays a short middle-row note on inst, whatever the current
+// instrument is. The watchdog releases it once auditionHold has passed.
+func audition(inst *Instrument) {
+	n := sortedRows[1][0]
+
+	voiceLock.Lock()
+	defer voiceLock.Unlock()
+	if v, ok := voices[auditionKey]; ok {
+		v.release()
+	}
+	v := pool.get(inst, n.Freq, 0.8, false)
+	if inst.Kit {
+		v.streamer.setDrum(n.Key)
+	}
+	v.lastSeen, v.staccato, v.freq = time.Now().Add(auditionHold), true, n.Freq
+	voices[auditionKey] = v
+	v.start()
+}
+
+// kind is the short tag shown beside an instrument in the browser.
+func (inst *Instrument) kind() string {
+	switch {
+	case inst.base != "":
+		return "preset of " + inst.base
+	case inst.Kit:
+		return "drums"
+	case inst.FM != nil:
+		return "FM"
+	case inst.Partials != nil:
+		return "additive"
+	case inst.Layer != nil:
+		return "layered"
+	}
+	return ""
+}
+
+func (m model) browserView() string {
+	b := m.browser
+	matches := b.matches()
+
+	lines := []string{
+		presetTitleStyle.Render(fmt.Sprintf("--- INSTRUMENTS (%d) ---", len(matches))),
+		instStyle.UnsetMarginBottom().Render("Search: " + b.query + "█"),
+	}
+	if len(matches) == 0 {
+		lines = append(lines, presetTextStyle.Render("  No matches"))
+	}
+
+	top := max(0, min(b.cursor-browserRows/2, len(matches)-browserRows))
+	for i := top; i < min(top+browserRows, len(matches)); i++ {
+		inst := instruments[matches[i]]
+		cursor := "  "
+		nameStyle := presetTextStyle
+		if i == b.cursor {
+			cursor = "▶ "
+			nameStyle = instStyle.UnsetMarginBottom()
+		}
+		current := " "
+		if matches[i] == currentInstID {
+			current = "•"
+		}
+		lines = append(lines, fmt.Sprintf("%s%s %s %s", cursor, current,
+			nameStyle.Render(fmt.Sprintf("%-20s", inst.Name)),
+			helpStyle.UnsetMarginTop().Render(inst.kind())))
+	}
+	lines = append(lines, helpStyle.Render("Type to search  •  ↑/↓: Browse  •  ENTER: Select  •  ESC: Close"))
+
+	return visStyle.Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// --- BUFFER SIZE ---
+//
+// The Buffer row of the settings panel resizes the backend's buffer while
+// it plays, to trade latency for headroom: smaller answers keys sooner,
+// larger rides out a busy machine. The mix plays on through the change,
+// voices, effects and all. The speaker can't go below the size it was
+// opened with, since the sound card holds half of that, and JACK's
+// server keeps its own.
+
+var bufferSizes = []time.Duration{
+	5 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond,
+	30 * time.Millisecond, 50 * time.Millisecond, 75 * time.Millisecond,
+	100 * time.Millisecond, 150 * time.Millisecond, 200 * time.Millisecond,
+	300 * time.Millisecond, maxBufferTime,
+}
+
+// setBuffer resizes the backend's buffer to size frames. Callers hold
+// bufferMu.
+func setBuffer(size int) error {
+	r, ok := output.(bufferResizer)
+	if !ok {
+		return errors.New("this backend can't change its buffer while it plays")
+	}
+	if err := r.Resize(size); err != nil {
+		return err
+	}
+	outputBuffer = size
+	slack := output.Latency()
+	output.Lock()
+	mainOut.underruns.restart(slack)
+	output.Unlock()
+	return nil
+}
+
+// nextBufferSize is the size after cur in the direction dir, or cur at
+// either end.
+func nextBufferSize(cur time.Duration, dir int) time.Duration {
+	if dir > 0 {
+		if i := slices.IndexFunc(bufferSizes, func(d time.Duration) bool { return d > cur }); i >= 0 {
+			return bufferSizes[i]
+		}
+		return cur
+	}
+	for _, d := range slices.Backward(bufferSizes) {
+		if d < cur {
+			return d
+		}
+	}
+	return cur
+}
+
+// stepBuffer moves the buffer one size up (dir 1) or down (dir -1).
+func (m model) stepBuffer(dir int) model {
+	bufferMu.Lock()
+	cur := sampleRate.D(outputBuffer)
+	next := nextBufferSize(cur, dir)
+	if next == cur {
+		bufferMu.Unlock()
+		return m
+	}
+	err := setBuffer(sampleRate.N(next))
+	bufferMu.Unlock()
+
+	m.notification = "Buffer: " + millis(next)
+	if err != nil {
+		m.notification = "Buffer: " + err.Error()
+	}
+	m.notifyClearTime = time.Now().Add(3 * time.Second)
+	return m
+}
+
+// bufferView is the Buffer row, with the latency it comes to.
+func bufferView() string {
+	bufferMu.Lock()
+	size, latency := outputBuffer, output.Latency()
+	bufferMu.Unlock()
+	return fmt.Sprintf("%s (%d frames, %s latency)", millis(sampleRate.D(size)), size, millis(latency))
+}
@@ -0,0 +1,106 @@
+package main
+
+// --- CHORDS ---
+//
+// The notes held are named as a chord in the header, as in "Do maj7" or
+// "Fa♯ dim". Octaves and doublings don't matter, only which of the twelve
+// notes are sounding. The lowest note is the root when the chord reads
+// that way; otherwise the chord is written over it as an inversion, as
+// in "La m7/Do". Sets of notes the table doesn't know go unnamed.
+
+type chordShape struct {
+	name      string
+	intervals []int // semitones above the root
+}
+
+// chordShapes are tried in order, so the plainer reading of a set comes
+// first. A seventh without its fifth is still named.
+var chordShapes = []chordShape{
+	{"maj", []int{0, 4, 7}},
+	{"m", []int{0, 3, 7}},
+	{"dim", []int{0, 3, 6}},
+	{"aug", []int{0, 4, 8}},
+	{"sus4", []int{0, 5, 7}},
+	{"sus2", []int{0, 2, 7}},
+	{"5", []int{0, 7}},
+	{"7", []int{0, 4, 7, 10}},
+	{"maj7", []int{0, 4, 7, 11}},
+	{"m7", []int{0, 3, 7, 10}},
+	{"m7♭5", []int{0, 3, 6, 10}},
+	{"dim7", []int{0, 3, 6, 9}},
+	{"mMaj7", []int{0, 3, 7, 11}},
+	{"7sus4", []int{0, 5, 7, 10}},
+	{"6", []int{0, 4, 7, 9}},
+	{"m6", []int{0, 3, 7, 9}},
+	{"add9", []int{0, 2, 4, 7}},
+	{"9", []int{0, 2, 4, 7, 10}},
+	{"maj9", []int{0, 2, 4, 7, 11}},
+	{"m9", []int{0, 2, 3, 7, 10}},
+	{"7", []int{0, 4, 10}},
+	{"maj7", []int{0, 4, 11}},
+	{"m7", []int{0, 3, 10}},
+}
+
+// pitchClasses is the set of notes as a bit per semitone, Do first.
+func pitchClasses(notes []int) uint16 {
+	var set uint16
+	for _, n := range notes {
+		set |= 1 << (n % 12)
+	}
+	return set
+}
+
+func (c chordShape) on(root int) uint16 {
+	var set uint16
+	for _, i := range c.intervals {
+		set |= 1 << ((root + i) % 12)
+	}
+	return set
+}
+
+// chordName names the MIDI notes as a chord, or gives "" if they aren't
+// one.
+func chordName(notes []int) string {
+	if len(notes) < 2 {
+		return ""
+	}
+	set := pitchClasses(notes)
+	bass := notes[0]
+	for _, n := range notes {
+		bass = min(bass, n)
+	}
+	bass %= 12
+
+	// The bass as the root first, then the other notes upward from it
+	for i := range 12 {
+		root := (bass + i) % 12
+		if set&(1<<root) == 0 {
+			continue
+		}
+		for _, c := range chordShapes {
+			if c.on(root) != set {
+				continue
+			}
+			name := noteName(root) + " " + c.name
+			if root != bass {
+				name += "/" + noteName(bass)
+			}
+			return name
+		}
+	}
+	return ""
+}
+
+// heldChord names the chord held, leaving out the drum kit.
+func heldChord(held map[string]heldNote) string {
+	if instruments[currentInstID].Kit {
+		return ""
+	}
+	var notes []int
+	for _, h := range held {
+		if n, ok := midiNote(h.freq); ok {
+			notes = append(notes, int(n))
+		}
+	}
+	return chordName(notes)
+}
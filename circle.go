@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// --- CIRCLE OF FIFTHS ---
+//
+// TAB in the scale panel turns it into the circle of fifths: the major
+// keys around the outside, their relative minors inside, one sharp more
+// with each step clockwise. ←/→ walk the key round the circle and ↑/↓
+// cross between a major key and its relative minor. The key picked is the
+// scale panel's, so it lights up the keyboard and sets the theremin's
+// snaps. ENTER transposes the keyboard into the key: the white keys then
+// play the notes of its signature, Do sounding as the signature's major
+// key note.
+
+const (
+	circleWidth  = 44
+	circleHeight = 13
+)
+
+// signature is the major key whose key signature the key is written
+// with, as semitones above Do. The modes of the major scale share their
+// parent's; the other scales are written as major or minor.
+func (k musicKey) signature() int {
+	switch {
+	case k.scale < 7: // the modes of the major scale, in order
+		return (k.root - scales[0].steps[k.scale] + 12) % 12
+	case k.minor():
+		return (k.root + 3) % 12
+	}
+	return k.root
+}
+
+// minor reports whether the key has a minor third, and so sits on the
+// inner ring.
+func (k musicKey) minor() bool {
+	return slices.Contains(k.steps(), 3) && !slices.Contains(k.steps(), 4)
+}
+
+// accidentals is the key signature: sharps if positive, flats if
+// negative. Six of either is written as sharps.
+func (k musicKey) accidentals() int {
+	fifths := k.signature() * 7 % 12
+	if fifths > 6 {
+		return fifths - 12
+	}
+	return fifths
+}
+
+func signatureLabel(n int) string {
+	switch {
+	case n > 0:
+		return fmt.Sprintf("%d♯", n)
+	case n < 0:
+		return fmt.Sprintf("%d♭", -n)
+	}
+	return "no ♯ or ♭"
+}
+
+// transposition is how far the keyboard is moved into the key, in
+// semitones, the nearer way round.
+func (k musicKey) transposition() int {
+	sig := k.signature()
+	if sig > 6 {
+		return sig - 12
+	}
+	return sig
+}
+
+// noteFreq is the pitch a keyboard key plays, moved by the octave shift
+// and its row's octave and, when it's on, transposed into the key.
+func (m model) noteFreq(n Note) float64 {
+	return n.Freq * math.Exp2(float64(m.shiftSemis()+12*rowOctaves[n.Row])/12)
+}
+
+// stepFifths moves the key round the circle, keeping its scale.
+func stepFifths(dir int) {
+	currentKey.root = (currentKey.root + 7*dir + 12) % 12
+}
+
+// crossRing swaps the key for its relative major or minor.
+func crossRing() {
+	if currentKey.minor() {
+		currentKey = musicKey{root: currentKey.signature(), scale: 0}
+	} else {
+		currentKey = musicKey{root: (currentKey.signature() + 9) % 12, scale: 5}
+	}
+}
+
+type circleLabel struct {
+	col      int
+	text     string
+	selected bool
+}
+
+func (m model) circleView() string {
+	k := currentKey
+	title := fmt.Sprintf("--- CIRCLE OF FIFTHS • %s • %s ---", k, signatureLabel(k.accidentals()))
+	lines := []string{presetTitleStyle.Render(title)}
+
+	rows := make([][]circleLabel, circleHeight)
+	place := func(angle, rx, ry float64, text string, selected bool) {
+		x := circleWidth/2 + int(math.Round(rx*math.Sin(angle)))
+		y := circleHeight/2 - int(math.Round(ry*math.Cos(angle)))
+		rows[y] = append(rows[y], circleLabel{x - lipgloss.Width(text)/2, text, selected})
+	}
+	for i := range 12 {
+		major := i * 7 % 12
+		angle := float64(i) * math.Pi / 6
+		place(angle, 19, 6, musicKey{major, 0}.names()[0], !k.minor() && k.signature() == major)
+		place(angle, 11, 3.5, strings.ToLower(musicKey{(major + 9) % 12, 5}.names()[0]), k.minor() && k.signature() == major)
+	}
+	rows[circleHeight/2] = append(rows[circleHeight/2], circleLabel{circleWidth/2 - 3, signatureLabel(k.accidentals()), false})
+
+	selStyle := notifyStyle.UnsetMarginBottom().UnsetPadding()
+	for _, row := range rows {
+		sort.Slice(row, func(i, j int) bool { return row[i].col < row[j].col })
+		var b strings.Builder
+		at := 0
+		for _, l := range row {
+			b.WriteString(strings.Repeat(" ", max(0, l.col-at)))
+			if l.selected {
+				b.WriteString(selStyle.Render(l.text))
+			} else {
+				b.WriteString(presetTextStyle.Render(l.text))
+			}
+			at = max(at, l.col) + lipgloss.Width(l.text)
+		}
+		lines = append(lines, b.String())
+	}
+
+	status := "Keyboard in Do"
+	if m.transposing {
+		status = fmt.Sprintf("Keyboard transposed %+d to %s", k.transposition(), musicKey{k.signature(), 0}.names()[0])
+	}
+	lines = append(lines, instStyle.UnsetMarginBottom().Render(status),
+		helpStyle.UnsetMarginTop().Render("←/→: Fifth Down / Up  •  ↑/↓: Relative Major / Minor  •  ENTER: Transpose  •  TAB: Scale  •  ESC/CTRL+U: Close"))
+	return visStyle.Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
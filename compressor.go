@@ -0,0 +1,54 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// --- COMPRESSOR ---
+
+// compressor is a feed-forward peak compressor working on both channels
+// together, so the stereo image doesn't wander. A ratio of 1 bypasses it.
+type compressor struct {
+	ThresholdDB float64 `json:"threshold_db"`
+	Ratio       float64 `json:"ratio"`
+	AttackMs    float64 `json:"attack_ms"`
+	ReleaseMs   float64 `json:"release_ms"`
+	MakeupDB    float64 `json:"makeup_db"`
+
+	reduction float64 // current gain reduction, dB
+}
+
+func defaultCompressor() compressor {
+	return compressor{ThresholdDB: -12, Ratio: 1, AttackMs: 5, ReleaseMs: 120}
+}
+
+func (c *compressor) Process(samples [][2]float64) {
+	if c.Ratio <= 1 {
+		c.reduction = 0
+		return
+	}
+
+	ms := func(v float64) time.Duration { return time.Duration(v * float64(time.Millisecond)) }
+	attack, release := glideCoef(ms(c.AttackMs)), glideCoef(ms(c.ReleaseMs))
+	slope := 1 - 1/c.Ratio
+
+	for i := range samples {
+		peak := math.Max(math.Abs(samples[i][0]), math.Abs(samples[i][1]))
+		over := 20*math.Log10(math.Max(peak, 1e-9)) - c.ThresholdDB
+
+		target := 0.0
+		if over > 0 {
+			target = over * slope
+		}
+		if target > c.reduction {
+			c.reduction += (target - c.reduction) * attack
+		} else {
+			c.reduction = flush(c.reduction + (target-c.reduction)*release)
+		}
+
+		g := math.Pow(10, (c.MakeupDB-c.reduction)/20)
+		samples[i][0] *= g
+		samples[i][1] *= g
+	}
+}
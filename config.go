@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// --- CONFIG ---
+
+// Config is read from ~/.config/piango/config.json. Every field is
+// optional; command line flags override whatever the file says.
+type Config struct {
+	Backend  string  `json:"backend"`
+	BufferMs int     `json:"buffer_ms"`
+	Theme    string  `json:"theme"`
+	BPM      float64 `json:"bpm"`      // tempo of the transport
+	Swing    float64 `json:"swing"`    // percent, 50 straight to 75
+	CountIn  float64 `json:"count_in"` // bars of click before recording
+	Volume   float64 `json:"volume"`   // master gain, 0..2
+	Themes   []Theme `json:"themes"`   // user-defined, see buildThemes
+
+	// What's drawn above the keyboard: "spectrum", "braille", "notes" or
+	// "meter"
+	Visualizer string `json:"visualizer"`
+	// Key layout: "solfege" rows an octave apart or "piano" with black keys
+	Keyboard string `json:"keyboard"`
+	// Images for the spectrum: "auto", "kitty", "sixel" or "text"
+	Graphics string `json:"graphics"`
+	// Physical layout the keys are placed for: "qwerty", "azerty", "qwertz",
+	// "dvorak" or "colemak"
+	Keymap string `json:"keymap"`
+
+	// Rate the engine runs at: 44100, 48000, 88200 or 96000 Hz; -rate
+	// overrides it
+	SampleRate int `json:"sample_rate"`
+
+	// Double the audio buffer after an underrun, up to 500 ms;
+	// -auto-buffer turns it on
+	AutoBuffer bool `json:"auto_buffer"`
+
+	// A 10 ms buffer, a higher priority for the audio thread and voices
+	// warmed up before the first key; -low-latency turns it on
+	LowLatency bool `json:"low_latency"`
+
+	// Play the built-in waveforms from precomputed tables, cheaper on slow
+	// machines; -wavetables turns it on
+	Wavetables bool `json:"wavetables"`
+
+	// Pan low notes left and high notes right
+	PitchPan bool `json:"pitch_pan"`
+
+	// Glide the notes still sounding when the octave or transposition
+	// changes, rather than only moving the next ones
+	FollowShift bool `json:"follow_shift"`
+
+	// Velocity of the High, Mid and Low rows in the Per-Row velocity mode
+	RowVelocity [3]float64 `json:"row_velocity"`
+
+	// Octave offset and instrument of the High, Mid and Low rows, see
+	// rows.go
+	Rows [3]rowConfig `json:"rows"`
+
+	// Per-instrument humanization amount (0..1), keyed by instrument name
+	Humanize map[string]float64 `json:"humanize"`
+
+	// Extra modulation routes, keyed by instrument name
+	Modulation map[string][]ModRoute `json:"modulation"`
+
+	// Master bus effects, also adjustable in the settings overlay
+	EQ         equalizer  `json:"eq"`
+	Compressor compressor `json:"compressor"`
+	Phaser     phaser     `json:"phaser"`
+	Flanger    flanger    `json:"flanger"`
+	Tremolo    tremolo    `json:"tremolo"` // on top of any per-instrument tremolo
+
+	// Open Sound Control server, off unless an address is given
+	OSC oscConfig `json:"osc"`
+
+	// Address for the WebSocket remote control API, off when empty
+	WebSocket string `json:"websocket"`
+
+	// Address for the HTTP control API, off when empty
+	HTTPAPI string `json:"http_api"`
+
+	// Web pages, besides those served from the API's own host, allowed to
+	// use the WebSocket and HTTP APIs, e.g. "http://192.168.1.20:8080"
+	Origins []string `json:"origins"`
+
+	// Jam session to host or join; -host and -join override it
+	Jam jamConfig `json:"jam"`
+
+	// SSH server giving each visitor a TUI of their own; -serve overrides it
+	SSH sshConfig `json:"ssh"`
+
+	// Port to mirror notes to as MIDI, off when empty; -midi-out overrides it
+	MIDIOut midiOutConfig `json:"midi_out"`
+
+	// Controller to play from, and its learned knobs; -midi-in overrides the port
+	MIDIIn midiInConfig `json:"midi_in"`
+
+	// AppleMIDI network session, off unless an address is given
+	RTPMIDI rtpMIDIConfig `json:"rtp_midi"`
+
+	// Plain status lines for screen readers instead of the panel;
+	// -screen-reader turns it on
+	ScreenReader bool `json:"screen_reader"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Backend:     "speaker",
+		BufferMs:    50,
+		SampleRate:  44100,
+		Theme:       "neon",
+		BPM:         120,
+		Swing:       50,
+		CountIn:     1,
+		Volume:      1.0,
+		PitchPan:    true,
+		Compressor:  defaultCompressor(),
+		Phaser:      defaultPhaser(),
+		Flanger:     defaultFlanger(),
+		RowVelocity: [3]float64{1.0, 0.8, 0.6},
+	}
+}
+
+func configDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "piango")
+}
+
+func defaultConfigPath() string {
+	dir := configDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "config.json")
+}
+
+// loadConfig starts from the defaults and overlays the file at path. A
+// missing file is not an error.
+func loadConfig(path string) (Config, error) {
+	cfg := defaultConfig()
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// configWatcher notices edits to the config file so the settings that can
+// change at runtime (themes) apply without a restart.
+type configWatcher struct {
+	path      string
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+func newConfigWatcher(path string) *configWatcher {
+	w := &configWatcher{path: path}
+	if fi, err := os.Stat(path); err == nil {
+		w.modTime = fi.ModTime()
+	}
+	return w
+}
+
+// poll stats the file at most once a second and reports whether it
+// changed since the last call.
+func (w *configWatcher) poll(now time.Time) bool {
+	if w.path == "" || now.Sub(w.lastCheck) < time.Second {
+		return false
+	}
+	w.lastCheck = now
+
+	fi, err := os.Stat(w.path)
+	if err != nil || fi.ModTime().Equal(w.modTime) {
+		return false
+	}
+	w.modTime = fi.ModTime()
+	return true
+}
@@ -0,0 +1,41 @@
+package main
+
+import "math"
+
+// --- BITCRUSHER ---
+
+// crusher is a voice's bitcrusher state: the sample being held while
+// decimating, and how many more samples to hold it for.
+type crusher struct {
+	held [2]float64
+	left int
+}
+
+// process reduces the bit depth of a sample pair and holds it for
+// decimate samples, counted at 44.1 kHz so a patch crunches the same at
+// any rate. 16 bits (or 0, unset) and decimate 1 pass through.
+func (c *crusher) process(in [2]float64, bits, decimate float64) [2]float64 {
+	if bits <= 0 {
+		bits = 16
+	}
+	if bits >= 16 && decimate <= 1 {
+		return in
+	}
+	if c.left > 0 {
+		c.left--
+		return c.held
+	}
+
+	if bits < 16 {
+		step := 2 / math.Exp2(math.Max(bits, 1))
+		for ch := range in {
+			in[ch] = math.Round(in[ch]/step) * step
+		}
+	}
+	c.held = in
+	if decimate > 1 {
+		decimate *= float64(sampleRate) / tunedRate
+	}
+	c.left = int(decimate) - 1
+	return in
+}
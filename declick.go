@@ -0,0 +1,64 @@
+package main
+
+// --- DECLICKING ---
+//
+// A level that jumps from one sample to the next clicks, however short
+// the patch's attack or release says it should be. The envelope never
+// ramps faster than declickMs, so taking back a release, as a terminal's
+// auto-repeat does, or letting go of a staccato note stays smooth. A note
+// struck again, or stolen by a mono instrument, is choked: it fades out
+// over retriggerFadeMs, with its layer, while the new note's attack comes
+// in over it, rather than ringing on or cutting off. Panic fades every
+// voice the same way, only slower, before the bank lets them go.
+
+const (
+	declickMs       = 3  // shortest attack or release
+	retriggerFadeMs = 10 // how long a choked note takes to fade
+	panicFadeMs     = 50
+)
+
+// Choke fades the note out quickly. Drum hits are one-shots and play out
+// anyway.
+func (s *SynthStreamer) Choke() {
+	if s.drum == nil {
+		s.fadeOut(retriggerFadeMs)
+	}
+}
+
+// fadeOut releases the note and fades it to silence over ms, or sooner if
+// it's fading already.
+func (s *SynthStreamer) fadeOut(ms float64) {
+	step := 1 / (ms / 1000 * float64(sampleRate))
+	if s.choked {
+		s.fadeStep = max(s.fadeStep, step)
+		return
+	}
+	s.releasing = s.drum == nil
+	s.choked, s.fade, s.fadeStep = true, 1, step
+	if s.delay > 0 {
+		// Still waiting to start, so it needn't be heard at all.
+		s.delay, s.fade = 0, 0
+	}
+}
+
+// faded applies a choked note's fade to frame, and reports when it's over.
+func (s *SynthStreamer) faded(frame *[2]float64) bool {
+	if !s.choked {
+		return false
+	}
+	if s.fade -= s.fadeStep; s.fade <= 0 {
+		s.finished = true
+		return true
+	}
+	frame[0] *= s.fade
+	frame[1] *= s.fade
+	return false
+}
+
+// choke fades the voice out for a note struck again or stolen.
+func (v *ActiveVoice) choke() {
+	if v.streamer.drum == nil {
+		v.released = true
+	}
+	v.send(voiceCmd{op: opChoke})
+}
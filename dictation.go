@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// --- MELODIC DICTATION ---
+//
+// The Melody mode of the ear trainer plays a short tune, and you play it
+// back on the keyboard, in any octave. When you've played as many notes
+// as it had, or pressed ENTER, the attempt is scored out of 100: mostly
+// for the right notes, and the rest for the rhythm, taken at whatever
+// tempo you played it. Scoring well twice running moves up a level, to
+// longer tunes with wider leaps, more notes of the scale, livelier
+// rhythms and other starting notes; a poor score moves back down. Tunes
+// keep to the white keys, so every one can be played back. The level and
+// the scores are kept with the interval stats.
+
+const (
+	maxMelodyLevel = 10
+	levelUpScore   = 80 // twice running
+	levelDownScore = 40
+	pitchWeight    = 0.7 // of the score; the rest is the timing
+)
+
+type melodyDay struct {
+	Tries  int `json:"tries"`
+	Points int `json:"points"`
+}
+
+type melodyStats struct {
+	Level   int                  `json:"level"`
+	Streak  int                  `json:"streak"` // good scores in a row at this level
+	Best    int                  `json:"best"`
+	History map[string]melodyDay `json:"history"` // keyed by date
+}
+
+// heardNote is a note played back.
+type heardNote struct {
+	midi int
+	at   time.Time
+}
+
+type melodyResult struct {
+	score  int
+	right  int     // notes
+	timing float64 // 0 to 1
+}
+
+type dictation struct {
+	melody  []earNote
+	attempt []heardNote
+	result  melodyResult
+}
+
+// melodyLevel is what the tunes at a level are made of.
+type melodyLevel struct {
+	notes   int
+	leap    int // semitones
+	scale   string
+	pool    []int     // the notes used, as semitones above Do
+	rhythms []float64 // note lengths in beats
+	bpm     float64
+}
+
+func levelOf(level int) melodyLevel {
+	l := melodyLevel{notes: 3 + (level-1)/2, leap: min(12, 2+level), bpm: float64(80 + 5*(level-1))}
+	switch {
+	case level <= 3:
+		l.scale, l.pool = "pentatonic from Do", []int{0, 2, 4, 7, 9}
+	case level <= 4:
+		l.scale, l.pool = "major scale from Do", []int{0, 2, 4, 5, 7, 9, 11}
+	default:
+		l.scale, l.pool = "major scale from any note", []int{0, 2, 4, 5, 7, 9, 11}
+	}
+	switch {
+	case level <= 2:
+		l.rhythms = []float64{1}
+	case level <= 6:
+		l.rhythms = []float64{1, 1, 0.5, 2}
+	default:
+		l.rhythms = []float64{1, 0.5, 0.5, 1.5, 2}
+	}
+	return l
+}
+
+// askMelody makes up a tune at the current level and plays it. Up to
+// level 4 it starts on Do.
+func (e *earTrainer) askMelody() {
+	l := levelOf(e.stats.Melody.Level)
+	inScale := func(n int) bool {
+		for _, p := range l.pool {
+			if n%12 == p {
+				return true
+			}
+		}
+		return false
+	}
+
+	beat := 60 / l.bpm
+	e.melody = e.melody[:0]
+	at, prev := 0.0, 60
+	if e.stats.Melody.Level > 4 {
+		prev += l.pool[e.rng.Intn(len(l.pool))]
+	}
+	for i := range l.notes {
+		if i > 0 {
+			var next []int
+			for n := max(earLow, prev-l.leap); n <= min(earHigh, prev+l.leap); n++ {
+				if n != prev && inScale(n) {
+					next = append(next, n)
+				}
+			}
+			prev = next[e.rng.Intn(len(next))]
+		}
+		length := l.rhythms[e.rng.Intn(len(l.rhythms))] * beat
+		e.melody = append(e.melody, earNote{prev, at, length * 0.85})
+		at += length
+	}
+	e.answered = false
+	e.play()
+}
+
+// melodyHit takes a note played back. Notes played while the tune is
+// still sounding don't count.
+func (m model) melodyHit(n int, now time.Time) model {
+	e := &m.ear
+	if e.player != nil && e.player.playing {
+		return m
+	}
+	e.attempt = append(e.attempt, heardNote{n, now})
+	if len(e.attempt) < len(e.melody) {
+		return m
+	}
+	return m.scoreMelody()
+}
+
+// scoreMelody scores what's been played back, moves the level, and saves
+// the stats.
+func (m model) scoreMelody() model {
+	e := &m.ear
+	if len(e.attempt) == 0 || e.answered {
+		return m
+	}
+	e.answered = true
+	e.result = scoreAttempt(e.melody, e.attempt)
+
+	st := &e.stats.Melody
+	date := time.Now().Format(time.DateOnly)
+	day := st.History[date]
+	day.Tries++
+	day.Points += e.result.score
+	st.History[date] = day
+	st.Best = max(st.Best, e.result.score)
+	m.practice.scored("Melodies", e.result.score, 100)
+
+	switch {
+	case e.result.score >= levelUpScore:
+		st.Streak++
+		if st.Streak >= 2 && st.Level < maxMelodyLevel {
+			st.Level++
+			st.Streak = 0
+			m.notification = fmt.Sprintf("Melody level %d", st.Level)
+			m.notifyClearTime = time.Now().Add(2 * time.Second)
+		}
+	case e.result.score < levelDownScore && st.Level > 1:
+		st.Level--
+		st.Streak = 0
+		m.notification = fmt.Sprintf("Back to melody level %d", st.Level)
+		m.notifyClearTime = time.Now().Add(2 * time.Second)
+	default:
+		st.Streak = 0
+	}
+
+	if err := saveEarStats(earPath(), e.stats); err != nil {
+		m.notification = fmt.Sprintf("Ear training: %v", err)
+		m.notifyClearTime = time.Now().Add(4 * time.Second)
+	}
+	return m
+}
+
+// scoreAttempt compares the notes played with the tune, moved to the
+// octave they were played in, and the gaps between them with the tune's,
+// stretched to the same overall length.
+func scoreAttempt(melody []earNote, attempt []heardNote) melodyResult {
+	var r melodyResult
+	n := min(len(melody), len(attempt))
+	if n == 0 {
+		return r
+	}
+	octave := int(math.Round(float64(attempt[0].midi-melody[0].midi)/12)) * 12
+	for i := range n {
+		if attempt[i].midi-octave == melody[i].midi {
+			r.right++
+		}
+	}
+
+	span := melody[n-1].at - melody[0].at
+	played := attempt[n-1].at.Sub(attempt[0].at).Seconds()
+	if n > 1 && played > 0 {
+		sum := 0.0
+		for i := 1; i < n; i++ {
+			want := melody[i].at - melody[i-1].at
+			got := attempt[i].at.Sub(attempt[i-1].at).Seconds() * span / played
+			sum += max(0, 1-math.Abs(got-want)/want)
+		}
+		r.timing = sum / float64(n-1) * float64(n) / float64(len(melody))
+	}
+
+	pitch := float64(r.right) / float64(len(melody))
+	r.score = int(math.Round(100 * (pitchWeight*pitch + (1-pitchWeight)*r.timing)))
+	return r
+}
+
+func (m model) dictationView() string {
+	e := m.ear
+	st := e.stats.Melody
+	today := st.History[time.Now().Format(time.DateOnly)]
+	title := fmt.Sprintf("--- EAR TRAINING • Melody • Level %d • Today %d tries", st.Level, today.Tries)
+	if today.Tries > 0 {
+		title += fmt.Sprintf(", average %d", today.Points/today.Tries)
+	}
+	title += fmt.Sprintf(" • Best %d ---", st.Best)
+	lines := []string{presetTitleStyle.Render(title)}
+
+	l := levelOf(st.Level)
+	leap := "a " + strings.ToLower(intervalFull[l.leap])
+	if l.leap == 12 {
+		leap = "an octave"
+	}
+	lines = append(lines, presetTextStyle.Render(fmt.Sprintf("%d notes, leaps up to %s, %s, %.0f BPM",
+		l.notes, leap, l.scale, l.bpm)))
+
+	status := instStyle.UnsetMarginBottom()
+	switch {
+	case e.answered:
+		r := e.result
+		lines = append(lines, status.Render(fmt.Sprintf("Score %d: %d of %d notes, timing %.0f%%",
+			r.score, r.right, len(e.melody), r.timing*100)))
+		var tune, played []string
+		for i, n := range e.melody {
+			tune = append(tune, fmt.Sprintf("%-6s", pitchName(n.midi)))
+			if i < len(e.attempt) {
+				played = append(played, fmt.Sprintf("%-6s", pitchName(e.attempt[i].midi)))
+			}
+		}
+		lines = append(lines,
+			presetTextStyle.Render("Melody: "+strings.Join(tune, " ")),
+			presetTextStyle.Render("Yours:  "+strings.Join(played, " ")))
+	case e.player != nil && e.player.playing:
+		lines = append(lines, status.Render("Listen…"))
+	default:
+		dots := strings.Repeat("● ", len(e.attempt)) + strings.Repeat("○ ", len(e.melody)-len(e.attempt))
+		lines = append(lines, status.Render("Play it back: "+dots))
+	}
+
+	help := "ENTER: Score  •  SPACE: Hear Again (starts over)  •  TAB: Mode  •  ESC/CTRL+Q: Close"
+	if e.answered {
+		help = "ENTER: Next  •  SPACE: Hear Again  •  TAB: Mode  •  ESC/CTRL+Q: Close"
+	}
+	lines = append(lines, helpStyle.UnsetMarginTop().Render(help))
+	return visStyle.Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// --- DRUM MACHINE ---
+//
+// A 16-step pattern of kit drums that loops at the transport's tempo
+// under live playing. Ctrl+D opens the grid, Ctrl+P starts and stops it
+// from anywhere. Steps are sixteenth notes; each holds a velocity, 0 is
+// off. Swing lengthens each on-beat sixteenth and shortens the one after,
+// as a percentage of the pair: 50 is straight, 67 a triplet shuffle.
+
+const patternSteps = 16
+
+// stepLevels are the velocities Enter cycles a step through
+var stepLevels = []float64{0, 1.0, 0.6, 0.3}
+
+var machineTracks = []int{drumKick, drumSnare, drumClosedHat, drumOpenHat, drumClap}
+
+type machineHit struct {
+	drum     *drumHit
+	velocity float64
+}
+
+// DrumMachine never drains, like Ambience. Fields are changed under
+// output.Lock().
+type DrumMachine struct {
+	pattern [][patternSteps]float64 // per track of machineTracks
+	playing bool
+	level   float64
+
+	step int
+	pos  int // samples into the current step
+	hits []machineHit
+}
+
+var drumMachine = newDrumMachine()
+
+func newDrumMachine() *DrumMachine {
+	dm := &DrumMachine{
+		pattern: make([][patternSteps]float64, len(machineTracks)),
+		level:   0.8,
+	}
+	// A plain backbeat to start from
+	for i := 0; i < patternSteps; i += 2 {
+		dm.pattern[2][i] = 0.6
+	}
+	dm.pattern[0][0], dm.pattern[0][8], dm.pattern[0][10] = 1, 1, 0.6
+	dm.pattern[1][4], dm.pattern[1][12] = 1, 1
+	return dm
+}
+
+func (dm *DrumMachine) Stream(samples [][2]float64) (n int, ok bool) {
+	pair := float64(sampleRate) * 60 / transport.bpm / 2
+	stepLen := func() int {
+		if dm.step%2 == 0 {
+			return max(int(pair*transport.swing/100), 1)
+		}
+		return max(int(pair*(1-transport.swing/100)), 1)
+	}
+
+	for i := range samples {
+		if dm.playing {
+			if dm.pos == 0 {
+				for t, kind := range machineTracks {
+					if v := dm.pattern[t][dm.step]; v > 0 {
+						dm.hits = append(dm.hits, machineHit{newDrum(kind, 1), v})
+					}
+				}
+			}
+			dm.pos++
+			if dm.pos >= stepLen() {
+				dm.pos = 0
+				dm.step = (dm.step + 1) % patternSteps
+			}
+		}
+
+		var v float64
+		for j := 0; j < len(dm.hits); j++ {
+			s, more := dm.hits[j].drum.next()
+			if !more {
+				dm.hits = append(dm.hits[:j], dm.hits[j+1:]...)
+				j--
+				continue
+			}
+			v += s * dm.hits[j].velocity
+		}
+		v *= dm.level
+		samples[i] = [2]float64{v, v}
+	}
+	return len(samples), true
+}
+
+func (dm *DrumMachine) Err() error { return nil }
+
+// --- DRUM MACHINE PANEL ---
+
+type drumPanel struct {
+	open     bool
+	row, col int
+}
+
+func (m model) toggleDrumMachine() model {
+	output.Lock()
+	drumMachine.playing = !drumMachine.playing
+	drumMachine.step, drumMachine.pos = 0, 0
+	m.drumsPlaying = drumMachine.playing
+	output.Unlock()
+	return m
+}
+
+func (m model) adjustTempo(delta float64) model {
+	output.Lock()
+	transport.setBPM(transport.bpm + delta)
+	m.bpm = transport.bpm
+	output.Unlock()
+	return m
+}
+
+// handleDrumKey consumes the keys the grid owns. Note keys fall through so
+// you can play over the loop while programming it.
+func (m model) handleDrumKey(msg tea.KeyMsg) (model, bool) {
+	p := &m.drums
+	switch msg.Type {
+	case tea.KeyCtrlD, tea.KeyEscape:
+		p.open = false
+	case tea.KeyUp:
+		p.row = (p.row - 1 + len(machineTracks)) % len(machineTracks)
+	case tea.KeyDown:
+		p.row = (p.row + 1) % len(machineTracks)
+	case tea.KeyLeft:
+		p.col = (p.col - 1 + patternSteps) % patternSteps
+	case tea.KeyRight:
+		p.col = (p.col + 1) % patternSteps
+	case tea.KeyEnter:
+		output.Lock()
+		cell := &drumMachine.pattern[p.row][p.col]
+		next := 0
+		for i, lvl := range stepLevels {
+			if *cell == lvl {
+				next = (i + 1) % len(stepLevels)
+			}
+		}
+		*cell = stepLevels[next]
+		output.Unlock()
+	case tea.KeyBackspace, tea.KeyDelete:
+		output.Lock()
+		drumMachine.pattern[p.row][p.col] = 0
+		output.Unlock()
+	default:
+		switch msg.String() {
+		case "<":
+			return m.adjustTempo(-2), true
+		case ">":
+			return m.adjustTempo(2), true
+		}
+		return m, false
+	}
+	return m, true
+}
+
+func (m model) drumMachineView() string {
+	output.Lock()
+	playing, step, bpm := drumMachine.playing, drumMachine.step, transport.bpm
+	output.Unlock()
+
+	state := "Stopped"
+	if playing {
+		state = "Playing"
+	}
+	lines := []string{presetTitleStyle.Render(fmt.Sprintf("--- DRUM MACHINE • %.0f BPM • %s ---", bpm, state))}
+
+	for t, kind := range machineTracks {
+		cells := []string{presetTextStyle.Render(fmt.Sprintf("%-6s", drumNames[kind]))}
+		for i := 0; i < patternSteps; i++ {
+			glyph := "·"
+			switch v := drumMachine.pattern[t][i]; {
+			case v >= 1:
+				glyph = "█"
+			case v >= 0.6:
+				glyph = "▆"
+			case v > 0:
+				glyph = "▃"
+			}
+
+			style := presetTextStyle
+			if i%4 == 0 {
+				style = waveColor
+			}
+			if playing && i == step {
+				style = instStyle.UnsetMarginBottom().UnsetPadding()
+			}
+			if m.drums.row == t && m.drums.col == i {
+				style = notifyStyle.UnsetMarginBottom().UnsetPadding()
+			}
+			cells = append(cells, style.Render(glyph))
+		}
+		lines = append(lines, strings.Join(cells, " "))
+	}
+	lines = append(lines, helpStyle.Render("ARROWS: Move  •  ENTER: Velocity  •  BKSP: Clear  •  </>: Tempo  •  CTRL+P: Play/Stop  •  ESC: Close"))
+
+	return visStyle.Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+)
+
+// --- DRUM KIT ---
+//
+// Kit instruments play a synthesized drum per key instead of a pitched
+// note. Each column of the keyboard is one drum and the rows tune it
+// (Low is deeper, High is tighter). Hits are one-shots: they play out
+// their own decay and ignore key release and the watchdog.
+
+const (
+	drumKick = iota
+	drumSnare
+	drumClosedHat
+	drumOpenHat
+	drumClap
+	drumTom
+	drumRim
+)
+
+var drumNames = []string{"Kick", "Snare", "C-Hat", "O-Hat", "Clap", "Tom", "Rim"}
+
+// drumTuning is the pitch factor for the High, Mid and Low rows
+var drumTuning = [3]float64{1.25, 1.0, 0.8}
+
+type drumHit struct {
+	kind      int
+	pitch     float64
+	length    int // samples
+	t         int
+	phase     float64
+	hp, bp    float64 // noise filter state
+	soften    float64 // the snare's noise filter
+	lastNoise float64
+}
+
+// setDrum makes s a hit of the drum for a key, picked from its column and
+// row. The hit is kept in s, so a recycled voice needn't allocate one.
+func (s *SynthStreamer) setDrum(key string) {
+	s.drum = nil
+	key = noteKey(key)
+	n, ok := noteMap[key]
+	if !ok {
+		return
+	}
+	col := 0
+	for i, rn := range sortedRows[n.Row] {
+		if rn.Key == key {
+			col = i
+		}
+	}
+	s.hit = drum(col%len(drumNames), drumTuning[n.Row])
+	s.drum = &s.hit
+}
+
+func newDrum(kind int, pitch float64) *drumHit {
+	d := drum(kind, pitch)
+	return &d
+}
+
+func drum(kind int, pitch float64) drumHit {
+	lengths := [...]float64{0.5, 0.35, 0.08, 0.5, 0.3, 0.6, 0.05}
+	return drumHit{
+		kind:   kind,
+		pitch:  pitch,
+		length: int(lengths[kind] * float64(sampleRate)),
+		soften: smoothAt(0.35),
+	}
+}
+
+// noise returns white noise, and a high-passed copy for the metallic
+// and snappy drums.
+func (d *drumHit) noise() (white, high float64) {
+	white = rand.Float64()*2 - 1
+	high = white - d.lastNoise
+	d.lastNoise = white
+	return white, high
+}
+
+// next renders one sample, reporting false once the hit has died away.
+func (d *drumHit) next() (float64, bool) {
+	if d.t >= d.length {
+		return 0, false
+	}
+	sr := float64(sampleRate)
+	sec := float64(d.t) / sr
+	d.t++
+
+	tone := func(freq float64) float64 {
+		d.phase += freq * 2 * math.Pi / sr
+		if d.phase >= 2*math.Pi {
+			d.phase -= 2 * math.Pi
+		}
+		return math.Sin(d.phase)
+	}
+	decay := func(tau float64) float64 { return math.Exp(-sec / tau) }
+
+	switch d.kind {
+	case drumKick:
+		f := d.pitch * (50 + 110*decay(0.03))
+		return tone(f) * decay(0.18) * 0.7, true
+
+	case drumSnare:
+		white, _ := d.noise()
+		d.bp += (white - d.bp) * d.soften // soften the noise a little
+		body := tone(185*d.pitch) * decay(0.06) * 0.35
+		return body + d.bp*decay(0.1)*0.4, true
+
+	case drumClosedHat, drumOpenHat:
+		_, high := d.noise()
+		tau := 0.015
+		if d.kind == drumOpenHat {
+			tau = 0.15
+		}
+		return high * decay(tau) * 0.2 * d.pitch, true
+
+	case drumClap:
+		// A few quick bursts, then a short tail
+		_, high := d.noise()
+		env := decay(0.08)
+		if burst := math.Mod(sec, 0.011); sec < 0.033 {
+			env = math.Exp(-burst / 0.004)
+		}
+		return high * env * 0.3, true
+
+	case drumTom:
+		f := d.pitch * 110 * (1 + 0.4*decay(0.05))
+		return tone(f) * decay(0.25) * 0.6, true
+
+	default: // rim
+		_, high := d.noise()
+		return (tone(1700*d.pitch)*0.3 + high*0.2) * decay(0.008), true
+	}
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// --- EAR TRAINING ---
+//
+// CTRL+Q opens an interval quiz. piango plays two notes on the current
+// instrument, rising, falling or together, and you name the interval:
+// pick it from the list, or play the second note on the keyboard in any
+// octave. Every answer is counted per interval and per day in
+// ~/.config/piango/eartraining.json, so the list shows how each one is
+// coming along, and the intervals most often missed are asked more. TAB
+// cycles through the ways of playing it and on to melodic dictation.
+
+const (
+	earNoteLength = 0.7 // seconds each note sounds
+	earGap        = 0.1 // between the notes when they're played in turn
+	earLow        = 53  // Fa3, the lowest note a question starts on
+	earHigh       = 79  // Sol5, the highest note a question reaches
+)
+
+var (
+	earModes     = []string{"Rising", "Falling", "Together", "Melody"}
+	intervalFull = []string{"", "Minor 2nd", "Major 2nd", "Minor 3rd", "Major 3rd", "Perfect 4th", "Tritone",
+		"Perfect 5th", "Minor 6th", "Major 6th", "Minor 7th", "Major 7th", "Octave"}
+	intervalShort = []string{"", "m2", "M2", "m3", "M3", "P4", "TT", "P5", "m6", "M6", "m7", "M7", "P8"}
+)
+
+type earScore struct {
+	Asked int `json:"asked"`
+	Right int `json:"right"`
+}
+
+func (s earScore) accuracy() float64 {
+	if s.Asked == 0 {
+		return 0
+	}
+	return float64(s.Right) / float64(s.Asked)
+}
+
+type earStats struct {
+	Intervals map[string]earScore `json:"intervals"` // keyed by short name
+	History   map[string]earScore `json:"history"`   // keyed by date
+	Melody    melodyStats         `json:"melody"`
+}
+
+type earQuestion struct {
+	first, second int // MIDI numbers, in the order played
+}
+
+// semitones is the interval asked, 1 to 12.
+func (q earQuestion) semitones() int {
+	return max(q.first-q.second, q.second-q.first)
+}
+
+// earNote is a note the trainer plays, at seconds from the start.
+type earNote struct {
+	midi       int
+	at, length float64
+}
+
+type earTrainer struct {
+	open     bool
+	stats    earStats
+	mode     int
+	question earQuestion
+	answered bool
+	answer   int // semitones, as answered
+	cursor   int // interval picked in the list, 1 to 12
+	player   *takePlayer
+	rng      *rand.Rand
+	dictation
+}
+
+func (e *earTrainer) melodic() bool {
+	return earModes[e.mode] == "Melody"
+}
+
+func earPath() string {
+	dir := configDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "eartraining.json")
+}
+
+func loadEarStats(path string) (earStats, error) {
+	st := earStats{Intervals: make(map[string]earScore), History: make(map[string]earScore),
+		Melody: melodyStats{Level: 1, History: make(map[string]melodyDay)}}
+	if path == "" {
+		return st, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return st, err
+	}
+	if err := json.Unmarshal(data, &st); err != nil {
+		return st, fmt.Errorf("%s: %w", path, err)
+	}
+	if st.Intervals == nil {
+		st.Intervals = make(map[string]earScore)
+	}
+	if st.History == nil {
+		st.History = make(map[string]earScore)
+	}
+	if st.Melody.History == nil {
+		st.Melody.History = make(map[string]melodyDay)
+	}
+	st.Melody.Level = max(1, st.Melody.Level)
+	return st, nil
+}
+
+func saveEarStats(path string, st earStats) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+func (m model) openEarTraining() model {
+	st, err := loadEarStats(earPath())
+	if err != nil {
+		m.notification = fmt.Sprintf("Ear training: %v", err)
+		m.notifyClearTime = time.Now().Add(4 * time.Second)
+		return m
+	}
+	m.ear = earTrainer{open: true, stats: st, mode: m.ear.mode, cursor: 1,
+		rng: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	m.ear.ask()
+	return m
+}
+
+func (m model) closeEarTraining() model {
+	if m.ear.player != nil {
+		m.ear.player.pause()
+	}
+	m.ear = earTrainer{mode: m.ear.mode}
+	return m
+}
+
+// ask picks the next interval, leaning toward the ones missed most, and
+// plays it.
+func (e *earTrainer) ask() {
+	if e.melodic() {
+		e.askMelody()
+		return
+	}
+	var weights [13]float64
+	total := 0.0
+	for n := 1; n <= 12; n++ {
+		s := e.stats.Intervals[intervalShort[n]]
+		weights[n] = 1 + 2*(1-s.accuracy())
+		if s.Asked == 0 {
+			weights[n] = 2
+		}
+		total += weights[n]
+	}
+	n, pick := 1, e.rng.Float64()*total
+	for ; n < 12 && pick >= weights[n]; n++ {
+		pick -= weights[n]
+	}
+
+	low := earLow + e.rng.Intn(earHigh-n-earLow+1)
+	e.question = earQuestion{low, low + n}
+	if earModes[e.mode] == "Falling" {
+		e.question = earQuestion{low + n, low}
+	}
+	e.answered = false
+	e.play()
+}
+
+// play sounds the question again.
+func (e *earTrainer) play() {
+	if e.melodic() {
+		e.attempt = nil
+		e.playNotes(e.melody)
+		return
+	}
+	second := earNoteLength + earGap
+	if earModes[e.mode] == "Together" {
+		second = 0
+	}
+	e.playNotes([]earNote{{e.question.first, 0, earNoteLength}, {e.question.second, second, earNoteLength}})
+}
+
+// playNotes sounds notes through a take player, as a replay would.
+func (e *earTrainer) playNotes(notes []earNote) {
+	if e.player != nil {
+		e.player.pause()
+	}
+	var tk take
+	for i, n := range notes {
+		key := fmt.Sprintf("ear%d", i)
+		freq := 440 * math.Exp2(float64(n.midi-69)/12)
+		tk.Events = append(tk.Events,
+			takeEvent{T: n.at, Type: "on", Key: key, Freq: freq, Velocity: 0.8},
+			takeEvent{T: n.at + n.length, Type: "off", Key: key})
+		tk.Length = max(tk.Length, n.at+n.length)
+	}
+	sortEvents(tk.Events)
+	e.player = newTakePlayer("ear", tk)
+}
+
+// answerWith scores an answer of n semitones and saves the stats.
+func (m model) answerWith(n int) model {
+	e := &m.ear
+	if e.answered {
+		return m
+	}
+	e.answered, e.answer = true, n
+	right := n == e.question.semitones()
+
+	name := intervalShort[e.question.semitones()]
+	date := time.Now().Format(time.DateOnly)
+	score, day := e.stats.Intervals[name], e.stats.History[date]
+	score.Asked++
+	day.Asked++
+	if right {
+		score.Right++
+		day.Right++
+	}
+	e.stats.Intervals[name], e.stats.History[date] = score, day
+	if right {
+		m.practice.scored("Intervals", 1, 1)
+	} else {
+		m.practice.scored("Intervals", 0, 1)
+	}
+
+	if err := saveEarStats(earPath(), e.stats); err != nil {
+		m.notification = fmt.Sprintf("Ear training: %v", err)
+		m.notifyClearTime = time.Now().Add(4 * time.Second)
+	}
+	return m
+}
+
+// earHit takes a note played on the keyboard as an answer: the second
+// note of the question, in any octave.
+func (m model) earHit(freq float64) model {
+	e := &m.ear
+	if !e.open || e.answered {
+		return m
+	}
+	n, ok := midiNote(freq)
+	if !ok {
+		return m
+	}
+	if e.melodic() {
+		return m.melodyHit(int(n), time.Now())
+	}
+	q := e.question
+	steps := (int(n) - q.first + 120) % 12
+	if q.second < q.first {
+		steps = (q.first - int(n) + 120) % 12
+	}
+	if steps == 0 {
+		steps = 12
+	}
+	return m.answerWith(steps)
+}
+
+// handleEarKey takes the quiz's keys; note keys fall through to play, and
+// to answer.
+func (m model) handleEarKey(msg tea.KeyMsg) (model, bool) {
+	e := &m.ear
+	switch msg.Type {
+	case tea.KeyCtrlQ, tea.KeyEscape:
+		return m.closeEarTraining(), true
+	case tea.KeyUp:
+		e.cursor = (e.cursor+10)%12 + 1
+	case tea.KeyDown:
+		e.cursor = e.cursor%12 + 1
+	case tea.KeyEnter:
+		switch {
+		case e.answered:
+			e.ask()
+		case e.melodic():
+			return m.scoreMelody(), true
+		default:
+			return m.answerWith(e.cursor), true
+		}
+	case tea.KeySpace:
+		e.play()
+	case tea.KeyTab:
+		e.mode = (e.mode + 1) % len(earModes)
+		e.ask()
+	default:
+		return m, false
+	}
+	return m, true
+}
+
+func (m model) earTrainingView() string {
+	e := m.ear
+	if e.melodic() {
+		return m.dictationView()
+	}
+	today := e.stats.History[time.Now().Format(time.DateOnly)]
+	var all earScore
+	for _, s := range e.stats.Intervals {
+		all.Asked += s.Asked
+		all.Right += s.Right
+	}
+	title := fmt.Sprintf("--- EAR TRAINING • %s • Today %d/%d • All %.0f%% ---",
+		earModes[e.mode], today.Right, today.Asked, all.accuracy()*100)
+	lines := []string{presetTitleStyle.Render(title)}
+
+	q, result := e.question, "Which interval? Pick it, or play the second note."
+	if e.answered {
+		asked := fmt.Sprintf("%s (%s → %s)", intervalFull[q.semitones()], pitchName(q.first), pitchName(q.second))
+		result = "✓ " + asked
+		if e.answer != q.semitones() {
+			result = fmt.Sprintf("✗ %s, not a %s", asked, intervalFull[e.answer])
+		}
+	}
+	lines = append(lines, instStyle.UnsetMarginBottom().Render(result))
+
+	selStyle := notifyStyle.UnsetMarginBottom().UnsetPadding()
+	for n := 1; n <= 12; n++ {
+		s := e.stats.Intervals[intervalShort[n]]
+		bar := strings.Repeat("█", int(math.Round(s.accuracy()*10)))
+		line := fmt.Sprintf("  %-12s %-3s %-10s", intervalFull[n], intervalShort[n], bar)
+		if s.Asked > 0 {
+			line += fmt.Sprintf(" %3.0f%% of %d", s.accuracy()*100, s.Asked)
+		}
+		switch {
+		case n == e.cursor:
+			lines = append(lines, selStyle.Render(line))
+		default:
+			lines = append(lines, presetTextStyle.Render(line))
+		}
+	}
+
+	help := "↑/↓: Pick  •  ENTER: Answer  •  SPACE: Hear Again  •  TAB: Mode  •  ESC/CTRL+Q: Close"
+	if e.answered {
+		help = "ENTER: Next  •  SPACE: Hear Again  •  TAB: Mode  •  ESC/CTRL+Q: Close"
+	}
+	lines = append(lines, helpStyle.UnsetMarginTop().Render(help))
+	return visStyle.Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
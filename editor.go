@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// --- INSTRUMENT EDITOR ---
+//
+// Ctrl+E swaps the visualizer for everything editable about the current
+// instrument: the patch, then its harmonic levels if it's additive or its
+// operators if it's FM. Arrows select and adjust, ENTER saves the result
+// as a preset; note keys keep playing so changes can be heard while
+// dialing them in.
+
+const (
+	sliderWidth = 24
+	editorRows  = 14 // sliders shown at once, the list scrolls past that
+)
+
+type instrumentEditor struct {
+	open   bool
+	cursor int
+	top    int // first row on screen
+}
+
+// editorRow is a slider, or a section heading when title is set.
+type editorRow struct {
+	title string
+	param patchParam
+}
+
+// instrumentRows lists the sections that apply to inst.
+func instrumentRows(inst *Instrument) []editorRow {
+	var rows []editorRow
+	section := func(title string, params []patchParam) {
+		rows = append(rows, editorRow{title: title})
+		for _, pp := range params {
+			rows = append(rows, editorRow{param: pp})
+		}
+	}
+
+	section("PATCH", patchParams)
+	if inst.Partials != nil {
+		section("HARMONICS", harmonicParams(len(inst.Partials)))
+	}
+	if inst.FM != nil {
+		section("OPERATORS", operatorParams(len(inst.FM.Ops)))
+	}
+	return rows
+}
+
+func harmonicParams(n int) []patchParam {
+	var params []patchParam
+	for k := range n {
+		params = append(params, patchParam{Name: fmt.Sprintf("Harm %d", k+1), Min: 0, Max: 1, Step: partialStep,
+			Field: func(inst *Instrument) *float64 { return &inst.Partials[k] }})
+	}
+	return params
+}
+
+func operatorParams(n int) []patchParam {
+	var params []patchParam
+	for i := range n {
+		op := func(f func(op *FMOperator) *float64) func(inst *Instrument) *float64 {
+			return func(inst *Instrument) *float64 { return f(&inst.FM.Ops[i]) }
+		}
+		name := func(s string) string { return fmt.Sprintf("Op%d %s", i+1, s) }
+		params = append(params,
+			patchParam{Name: name("Ratio"), Min: 0.25, Max: 16, Step: 0.25,
+				Field: op(func(op *FMOperator) *float64 { return &op.Ratio })},
+			patchParam{Name: name("Tune"), Unit: "ct", Min: -50, Max: 50, Step: 1,
+				Field: op(func(op *FMOperator) *float64 { return &op.Detune })},
+			patchParam{Name: name("Level"), Min: 0, Max: 8, Step: 0.1,
+				Field: op(func(op *FMOperator) *float64 { return &op.Level })},
+			patchParam{Name: name("Att"), Unit: "ms", Min: 0.1, Max: 3000, Step: 1.25, Log: true,
+				Field: op(func(op *FMOperator) *float64 { return &op.AttackMs })},
+			patchParam{Name: name("Dec"), Unit: "ms", Min: 0.1, Max: 8000, Step: 1.25, Log: true,
+				Field: op(func(op *FMOperator) *float64 { return &op.DecayMs })},
+			patchParam{Name: name("Sus"), Min: 0, Max: 1, Step: 0.05,
+				Field: op(func(op *FMOperator) *float64 { return &op.Sustain })},
+			patchParam{Name: name("Rel"), Unit: "ms", Min: 0.1, Max: 5000, Step: 1.25, Log: true,
+				Field: op(func(op *FMOperator) *float64 { return &op.ReleaseMs })},
+		)
+	}
+	params = append(params, patchParam{Name: "Feedback", Min: 0, Max: 2, Step: 0.05,
+		Field: func(inst *Instrument) *float64 { return &inst.FM.Feedback }})
+	return params
+}
+
+// handleEditorKey consumes the keys the editor owns. It reports false for
+// anything else so the key goes through to the normal handlers.
+func (m model) handleEditorKey(msg tea.KeyMsg) (model, bool) {
+	e := &m.editor
+	rows := instrumentRows(instruments[currentInstID])
+	e.cursor = m.editor.clampCursor(rows)
+
+	switch msg.Type {
+	case tea.KeyCtrlE, tea.KeyEscape:
+		e.open = false
+	case tea.KeyUp, tea.KeyDown:
+		dir := 1
+		if msg.Type == tea.KeyUp {
+			dir = -1
+		}
+		// Skip over section headings
+		for {
+			e.cursor = (e.cursor + dir + len(rows)) % len(rows)
+			if rows[e.cursor].title == "" {
+				break
+			}
+		}
+	case tea.KeyLeft:
+		m = m.adjustInstrument(rows[e.cursor].param, -1)
+	case tea.KeyRight:
+		m = m.adjustInstrument(rows[e.cursor].param, 1)
+	case tea.KeyEnter:
+		m.savePrompt = presetPrompt{open: true, name: instruments[currentInstID].Name}
+	case tea.KeyCtrlL:
+		m = m.toggleLearn(rows[e.cursor].param)
+		e = &m.editor
+	default:
+		return m, false
+	}
+	e.scroll(rows)
+	return m, true
+}
+
+// clampCursor keeps the cursor on a slider after switching to an
+// instrument with fewer rows.
+func (e instrumentEditor) clampCursor(rows []editorRow) int {
+	c := max(1, min(e.cursor, len(rows)-1))
+	if rows[c].title != "" {
+		c++
+	}
+	return c
+}
+
+// scroll moves the window so the cursor stays on screen, along with the
+// heading of its section when it's the first slider in it.
+func (e *instrumentEditor) scroll(rows []editorRow) {
+	first := e.cursor
+	if rows[first-1].title != "" {
+		first--
+	}
+	switch {
+	case first < e.top:
+		e.top = first
+	case e.cursor >= e.top+editorRows:
+		e.top = e.cursor - editorRows + 1
+	}
+}
+
+// adjustParam writes under the output lock since voices read the patch
+// from the render callback. It returns the value before and after.
+func adjustParam(pp patchParam, dir int) (from, to float64) {
+	output.Lock()
+	defer output.Unlock()
+
+	field := pp.Field(instruments[currentInstID])
+	from = *field
+	*field = pp.adjust(*field, dir)
+	return from, *field
+}
+
+func (m model) editorView() string {
+	inst := instruments[currentInstID]
+	rows := instrumentRows(inst)
+	cursor := m.editor.clampCursor(rows)
+	top := min(m.editor.top, max(0, len(rows)-editorRows))
+
+	output.Lock()
+	lines := []string{presetTitleStyle.Render("--- INSTRUMENT: " + inst.Name + " ---")}
+	for i := top; i < min(top+editorRows, len(rows)); i++ {
+		if rows[i].title != "" {
+			lines = append(lines, presetTextStyle.Render("  "+rows[i].title))
+			continue
+		}
+		pp := rows[i].param
+		lines = append(lines, sliderLine(pp, *pp.Field(inst), i == cursor))
+	}
+	output.Unlock()
+
+	more := ""
+	if top+editorRows < len(rows) {
+		more = "  ▼"
+	}
+	lines = append(lines, helpStyle.Render("↑/↓: Select  •  ←/→: Adjust  •  ENTER: Save Preset  •  CTRL+L: MIDI Learn  •  ESC/CTRL+E: Close"+more))
+
+	return visStyle.Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
+
+// sliderLine draws one parameter as a name, a bar and its value.
+func sliderLine(pp patchParam, v float64, selected bool) string {
+	filled := int(pp.position(v)*sliderWidth + 0.5)
+	filled = max(0, min(sliderWidth, filled))
+	bar := waveColor.Render(strings.Repeat("█", filled)) +
+		helpStyle.UnsetMarginTop().Render(strings.Repeat("░", sliderWidth-filled))
+
+	cursor := "  "
+	nameStyle := presetTextStyle
+	if selected {
+		cursor = "▶ "
+		nameStyle = instStyle.UnsetMarginBottom()
+	}
+	return fmt.Sprintf("%s%s %s %s",
+		cursor, nameStyle.Render(fmt.Sprintf("%-9s", pp.Name)), bar, pp.format(v))
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"github.com/gopxl/beep/v2"
+)
+
+// --- MASTER BUS ---
+//
+// The voice bank is played through master, which runs the mixed voices
+// through a DC blocker and a chain of effects. Ambience, the drum machine
+// and the metronome are mixed in beside it, dry, and the whole lot goes
+// through the master volume and a soft limiter on the way to the backend.
+// Settings here are read by the render callback, so change them under
+// output.Lock().
+
+const (
+	maxVolume  = 2.0
+	volumeStep = 0.1
+
+	// Samples under limitKnee pass untouched; above it they're bent
+	// smoothly towards full scale and never cross it.
+	limitKnee = 0.8
+)
+
+// outputStage is the one streamer the backend plays.
+type outputStage struct {
+	sources beep.Mixer
+	volume  float64
+	gain    float64    // follows volume, smoothed so changes don't click
+	tap     levelTap   // for the meters, see meters.go
+	load    engineLoad // for the status bar, see status.go
+
+	underruns underrunWatch // see underrun.go
+	raise     bool          // the render thread's priority, see lowlatency.go
+	priority  string        // how raising it went
+}
+
+var mainOut = &outputStage{volume: 1, gain: 1}
+
+// reset drops every source and starts over from the permanent ones.
+func (o *outputStage) reset() {
+	o.sources.Clear()
+	o.sources.Add(ambience, drumMachine, master)
+}
+
+// playDry adds a streamer to the output beside the instrument bus.
+func playDry(s beep.Streamer) {
+	output.Lock()
+	mainOut.sources.Add(s)
+	output.Unlock()
+}
+
+func (o *outputStage) Stream(samples [][2]float64) (n int, ok bool) {
+	start := time.Now()
+	o.underruns.check(start, len(samples))
+	if o.raise {
+		o.raise = false
+		o.priority = raisePriority()
+	}
+	defer func() {
+		o.load.busy += time.Since(start)
+		o.load.audio += sampleRate.D(n)
+	}()
+
+	n, ok = o.sources.Stream(samples)
+	if sanitize(samples[:n]) > 0 {
+		master.clear()
+	}
+	smooth := glideCoef(paramSmoothing)
+	for i := range samples[:n] {
+		o.gain += (o.volume - o.gain) * smooth
+		l, r := samples[i][0]*o.gain, samples[i][1]*o.gain
+		o.tap.take(l, r)
+		samples[i][0] = softLimit(l)
+		samples[i][1] = softLimit(r)
+	}
+	return n, ok
+}
+
+// softLimit is a tanh curve above the knee, so stacking loud voices
+// saturates gently instead of clipping at the converter.
+func softLimit(x float64) float64 {
+	a := math.Abs(x)
+	if a <= limitKnee {
+		return x
+	}
+	y := limitKnee + (1-limitKnee)*math.Tanh((a-limitKnee)/(1-limitKnee))
+	return math.Copysign(y, x)
+}
+
+func (o *outputStage) Err() error { return nil }
+
+// Effect processes a block of the master bus in place.
+type Effect interface {
+	Process(samples [][2]float64)
+}
+
+type masterBus struct {
+	dc         dcBlocker
+	eq         equalizer
+	compressor compressor
+	phaser     phaser
+	flanger    flanger
+	tremolo    tremolo
+	bypass     [len(masterEffects)]bool // switched out, settings kept
+}
+
+var master = &masterBus{}
+
+// masterEffects names the effects after the DC blocker, in chain order,
+// for mappings to bypass.
+var masterEffects = [...]string{"eq", "compressor", "phaser", "flanger", "tremolo"}
+
+// effects is the chain, less any effect bypassed.
+func (b *masterBus) effects() []Effect {
+	chain := []Effect{&b.dc}
+	for i, fx := range []Effect{&b.eq, &b.compressor, &b.phaser, &b.flanger, &b.tremolo} {
+		if !b.bypass[i] {
+			chain = append(chain, fx)
+		}
+	}
+	return chain
+}
+
+func (b *masterBus) Stream(samples [][2]float64) (n int, ok bool) {
+	n, ok = bank.Stream(samples)
+	for _, fx := range b.effects() {
+		fx.Process(samples[:n])
+	}
+	return n, ok
+}
+
+func (b *masterBus) Err() error { return nil }
+
+// tremolo dips the level with a sine LFO. depth 0 leaves the signal alone,
+// 1 swings it all the way down to silence.
+type tremolo struct {
+	Rate  float64 `json:"rate"` // Hz
+	Depth float64 `json:"depth"`
+	phase float64
+}
+
+func (t *tremolo) Process(samples [][2]float64) {
+	if t.Depth <= 0 {
+		return
+	}
+	step := t.Rate * 2 * math.Pi / float64(sampleRate)
+	for i := range samples {
+		g := tremoloGain(t.Depth, t.phase)
+		samples[i][0] *= g
+		samples[i][1] *= g
+		t.phase = math.Mod(t.phase+step, 2*math.Pi)
+	}
+}
+
+func tremoloGain(depth, phase float64) float64 {
+	return 1 - depth*(0.5+0.5*math.Sin(phase))
+}
+
+// dcBlockHz is well under the lowest note, so only the offset goes.
+const dcBlockHz = 10
+
+// dcBlocker is a one-pole high-pass that takes out the offset some
+// waveforms carry, such as a narrow pulse or a crushed wave, which would
+// otherwise thump the speakers as notes start and stop. It's always on,
+// ahead of the effects so the compressor doesn't react to it.
+type dcBlocker struct {
+	in, out [2]float64
+}
+
+func (d *dcBlocker) Process(samples [][2]float64) {
+	r := math.Exp(-2 * math.Pi * dcBlockHz / float64(sampleRate))
+	for i := range samples {
+		for ch := 0; ch < 2; ch++ {
+			x := samples[i][ch]
+			d.out[ch] = flush(x - d.in[ch] + r*d.out[ch])
+			d.in[ch] = x
+			samples[i][ch] = d.out[ch]
+		}
+	}
+}
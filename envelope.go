@@ -0,0 +1,41 @@
+package main
+
+import "math"
+
+// --- ENVELOPES ---
+
+// adsr is a linear attack/decay/sustain/release envelope running from 0
+// to 1. It starts in its attack; once released it falls to 0 from
+// wherever it is.
+type adsr struct {
+	level     float64
+	attacking bool
+}
+
+func newADSR() adsr { return adsr{attacking: true} }
+
+// next advances the envelope one sample and returns its level.
+func (e *adsr) next(attackMs, decayMs, sustain, releaseMs float64, releasing bool) float64 {
+	sr := float64(sampleRate)
+	perMs := func(ms float64) float64 { return math.Max(ms/1000*sr, 1) }
+
+	switch {
+	case releasing:
+		e.level = math.Max(e.level-1/perMs(releaseMs), 0)
+		e.attacking = false
+	case e.attacking:
+		e.level += 1 / perMs(attackMs)
+		if e.level >= 1 {
+			e.level = 1
+			e.attacking = false
+		}
+	case e.level > sustain:
+		e.level = math.Max(e.level-(1-sustain)/perMs(decayMs), sustain)
+	}
+	return e.level
+}
+
+// done reports whether a released envelope has reached 0.
+func (e *adsr) done() bool {
+	return e.level <= 0 && !e.attacking
+}
@@ -0,0 +1,114 @@
+package main
+
+import "math"
+
+// --- EQUALIZER ---
+
+const (
+	eqLowFreq  = 200.0
+	eqMidFreq  = 1000.0
+	eqHighFreq = 4000.0
+)
+
+const (
+	biquadPeak = iota
+	biquadLowShelf
+	biquadHighShelf
+	biquadLowpass
+)
+
+// biquad is a second-order filter (transposed direct form II) with its
+// own state for each stereo channel. Coefficients follow the RBJ audio
+// EQ cookbook.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	z                  [2][2]float64
+}
+
+func (f *biquad) set(kind int, freq, q, gainDB float64) {
+	a := math.Pow(10, gainDB/40)
+	w0 := 2 * math.Pi * freq / float64(sampleRate)
+	cos, sin := math.Cos(w0), math.Sin(w0)
+	alpha := sin / (2 * q)
+	sq := 2 * math.Sqrt(a) * alpha
+
+	var b0, b1, b2, a0, a1, a2 float64
+	switch kind {
+	case biquadLowShelf:
+		b0 = a * ((a + 1) - (a-1)*cos + sq)
+		b1 = 2 * a * ((a - 1) - (a+1)*cos)
+		b2 = a * ((a + 1) - (a-1)*cos - sq)
+		a0 = (a + 1) + (a-1)*cos + sq
+		a1 = -2 * ((a - 1) + (a+1)*cos)
+		a2 = (a + 1) + (a-1)*cos - sq
+	case biquadHighShelf:
+		b0 = a * ((a + 1) + (a-1)*cos + sq)
+		b1 = -2 * a * ((a - 1) + (a+1)*cos)
+		b2 = a * ((a + 1) + (a-1)*cos - sq)
+		a0 = (a + 1) - (a-1)*cos + sq
+		a1 = 2 * ((a - 1) - (a+1)*cos)
+		a2 = (a + 1) - (a-1)*cos - sq
+	case biquadLowpass:
+		b0 = (1 - cos) / 2
+		b1 = 1 - cos
+		b2 = (1 - cos) / 2
+		a0 = 1 + alpha
+		a1 = -2 * cos
+		a2 = 1 - alpha
+	default:
+		b0 = 1 + alpha*a
+		b1 = -2 * cos
+		b2 = 1 - alpha*a
+		a0 = 1 + alpha/a
+		a1 = -2 * cos
+		a2 = 1 - alpha/a
+	}
+	f.b0, f.b1, f.b2 = b0/a0, b1/a0, b2/a0
+	f.a1, f.a2 = a1/a0, a2/a0
+}
+
+func (f *biquad) process(x float64, ch int) float64 {
+	z := &f.z[ch]
+	y := f.b0*x + z[0]
+	z[0] = flush(f.b1*x - f.a1*y + z[1])
+	z[1] = flush(f.b2*x - f.a2*y)
+	return y
+}
+
+// equalizer is a three-band EQ: shelves for the lows and highs and a
+// broad bell in the middle. Gains are in dB.
+type equalizer struct {
+	Low  float64 `json:"low"`
+	Mid  float64 `json:"mid"`
+	High float64 `json:"high"`
+
+	bands   [3]biquad
+	applied [3]float64 // gains the coefficients were computed for
+	rate    float64
+}
+
+func (eq *equalizer) Process(samples [][2]float64) {
+	gains := [3]float64{eq.Low, eq.Mid, eq.High}
+	if gains == ([3]float64{}) {
+		return
+	}
+
+	// Recompute only when a gain or the sample rate moved
+	if gains != eq.applied || eq.rate != float64(sampleRate) {
+		eq.bands[0].set(biquadLowShelf, eqLowFreq, math.Sqrt2/2, gains[0])
+		eq.bands[1].set(biquadPeak, eqMidFreq, 0.7, gains[1])
+		eq.bands[2].set(biquadHighShelf, eqHighFreq, math.Sqrt2/2, gains[2])
+		eq.applied = gains
+		eq.rate = float64(sampleRate)
+	}
+
+	for i := range samples {
+		for ch := 0; ch < 2; ch++ {
+			x := samples[i][ch]
+			for b := range eq.bands {
+				x = eq.bands[b].process(x, ch)
+			}
+			samples[i][ch] = x
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+// --- PERFORMANCE EVENTS ---
+//
+// Outputs that report what's being played (OSC, the WebSocket API) are fed
+// from the UI tick: perfTracker compares the held notes and the current
+// instrument with the last tick and hands the differences, plus a spectrum
+// frame, to every sink.
+
+type perfEvent struct {
+	Type     string // note_on, note_off, instrument or spectrum
+	Key      string
+	Freq     float64
+	Velocity float64
+	Index    int
+	Name     string
+	Bars     []float64
+}
+
+// eventSink must not block: it's called from the UI goroutine.
+type eventSink interface {
+	emit(events []perfEvent)
+}
+
+type heldNote struct {
+	freq, velocity float64
+}
+
+type perfTracker struct {
+	sinks []eventSink
+	held  map[string]float64 // key → freq of notes reported as on
+	inst  int
+}
+
+func newPerfTracker(sinks ...eventSink) *perfTracker {
+	return &perfTracker{sinks: sinks, held: make(map[string]float64), inst: -1}
+}
+
+// update sends whatever changed since the last tick.
+func (t *perfTracker) update(held map[string]heldNote, inst int, spectrum []float64) {
+	var events []perfEvent
+	for key, freq := range t.held {
+		if _, ok := held[key]; !ok {
+			events = append(events, perfEvent{Type: "note_off", Key: key, Freq: freq})
+			delete(t.held, key)
+		}
+	}
+	for key, n := range held {
+		if _, ok := t.held[key]; !ok {
+			events = append(events, perfEvent{Type: "note_on", Key: key, Freq: n.freq, Velocity: n.velocity})
+			t.held[key] = n.freq
+		}
+	}
+
+	if inst != t.inst {
+		events = append(events, perfEvent{Type: "instrument", Index: inst, Name: instruments[inst].Name})
+		t.inst = inst
+	}
+
+	bars := append([]float64(nil), spectrum...)
+	events = append(events, perfEvent{Type: "spectrum", Bars: bars})
+
+	for _, s := range t.sinks {
+		s.emit(events)
+	}
+}
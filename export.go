@@ -0,0 +1,551 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/gopxl/beep/v2"
+)
+
+// --- EXPORT ---
+//
+// CTRL+X in the replay panel renders the selected take through the
+// current instrument and writes it next to the take as WAV or FLAC, both
+// encoded here, or as OGG or MP3 when ffmpeg (or oggenc or lame) is
+// installed to do the encoding. Files are tagged with the instrument and
+// the tempo the take was recorded at.
+
+type exportTags struct {
+	Title      string
+	Instrument string
+	BPM        float64
+}
+
+// fields are the tags as name/value pairs, the way most formats want them.
+func (t exportTags) fields() [][2]string {
+	f := [][2]string{{"TITLE", t.Title}, {"INSTRUMENT", t.Instrument}}
+	if t.BPM > 0 {
+		f = append(f, [2]string{"BPM", strconv.FormatFloat(t.BPM, 'f', -1, 64)})
+	}
+	return append(f, [2]string{"ENCODER", "piango"})
+}
+
+type exportFormat struct {
+	Name  string
+	Ext   string
+	write func(path string, samples [][2]float64, tags exportTags) error
+	ready func() error // nil when the format can be written here
+}
+
+var exportFormats = []exportFormat{
+	{Name: "WAV", Ext: ".wav", write: writeFileWith(writeWAV)},
+	{Name: "FLAC", Ext: ".flac", write: writeFileWith(writeFLAC)},
+	{Name: "OGG", Ext: ".ogg", write: externalEncode("ogg"), ready: encoderReady("ogg")},
+	{Name: "MP3", Ext: ".mp3", write: externalEncode("mp3"), ready: encoderReady("mp3")},
+}
+
+func writeFileWith(enc func(io.Writer, [][2]float64, exportTags) error) func(string, [][2]float64, exportTags) error {
+	return func(path string, samples [][2]float64, tags exportTags) error {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		w := bufio.NewWriter(f)
+		if err := enc(w, samples, tags); err != nil {
+			f.Close()
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+}
+
+// pcm16 turns a sample into a 16-bit integer.
+func pcm16(x float64) int16 {
+	return int16(math.Round(max(-1, min(1, x)) * math.MaxInt16))
+}
+
+// writeWAV writes 16-bit stereo PCM with the tags in a LIST/INFO chunk.
+func writeWAV(w io.Writer, samples [][2]float64, tags exportTags) error {
+	var info bytes.Buffer
+	info.WriteString("INFO")
+	addInfo := func(id, text string) {
+		text += "\x00"
+		if len(text)%2 == 1 {
+			text += "\x00"
+		}
+		info.WriteString(id)
+		binary.Write(&info, binary.LittleEndian, uint32(len(text)))
+		info.WriteString(text)
+	}
+	addInfo("INAM", tags.Title)
+	addInfo("ISFT", "piango")
+	comment := "Instrument: " + tags.Instrument
+	if tags.BPM > 0 {
+		comment += fmt.Sprintf(", BPM: %g", tags.BPM)
+	}
+	addInfo("ICMT", comment)
+
+	dataLen := uint32(len(samples) * 4)
+	le := binary.LittleEndian
+	var hdr []byte
+	hdr = append(hdr, "RIFF"...)
+	hdr = le.AppendUint32(hdr, 4+(8+16)+(8+uint32(info.Len()))+(8+dataLen))
+	hdr = append(hdr, "WAVEfmt "...)
+	hdr = le.AppendUint32(hdr, 16)
+	hdr = le.AppendUint16(hdr, 1) // PCM
+	hdr = le.AppendUint16(hdr, 2)
+	hdr = le.AppendUint32(hdr, uint32(sampleRate))
+	hdr = le.AppendUint32(hdr, uint32(sampleRate)*4)
+	hdr = le.AppendUint16(hdr, 4)
+	hdr = le.AppendUint16(hdr, 16)
+	hdr = append(hdr, "LIST"...)
+	hdr = le.AppendUint32(hdr, uint32(info.Len()))
+	hdr = append(hdr, info.Bytes()...)
+	hdr = append(hdr, "data"...)
+	hdr = le.AppendUint32(hdr, dataLen)
+	if _, err := w.Write(hdr); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 0, 4096)
+	for i, s := range samples {
+		buf = le.AppendUint16(buf, uint16(pcm16(s[0])))
+		buf = le.AppendUint16(buf, uint16(pcm16(s[1])))
+		if len(buf) == cap(buf) || i == len(samples)-1 {
+			if _, err := w.Write(buf); err != nil {
+				return err
+			}
+			buf = buf[:0]
+		}
+	}
+	return nil
+}
+
+// FLAC: 16-bit stereo in fixed-size blocks, each channel coded on its own
+// as a constant, with the best of the fixed predictors, or verbatim.
+
+const flacBlock = 4096
+
+func writeFLAC(w io.Writer, samples [][2]float64, tags exportTags) error {
+	chans := [2][]int32{make([]int32, len(samples)), make([]int32, len(samples))}
+	sum := md5.New()
+	var pcm [4]byte
+	for i, s := range samples {
+		l, r := pcm16(s[0]), pcm16(s[1])
+		chans[0][i], chans[1][i] = int32(l), int32(r)
+		binary.LittleEndian.PutUint16(pcm[0:], uint16(l))
+		binary.LittleEndian.PutUint16(pcm[2:], uint16(r))
+		sum.Write(pcm[:])
+	}
+
+	var bw flacBits
+	bw.bytes = append(bw.bytes, "fLaC"...)
+
+	// STREAMINFO
+	bw.write(0, 1)
+	bw.write(0, 7)
+	bw.write(34, 24)
+	bw.write(flacBlock, 16)
+	bw.write(flacBlock, 16)
+	bw.write(0, 24) // frame sizes unknown
+	bw.write(0, 24)
+	bw.write(uint64(sampleRate), 20)
+	bw.write(2-1, 3)
+	bw.write(16-1, 5)
+	bw.write(uint64(len(samples)), 36)
+	bw.bytes = append(bw.bytes, sum.Sum(nil)...)
+
+	// VORBIS_COMMENT, little-endian unlike the rest
+	var vc []byte
+	vendor := "piango"
+	vc = binary.LittleEndian.AppendUint32(vc, uint32(len(vendor)))
+	vc = append(vc, vendor...)
+	fields := tags.fields()
+	vc = binary.LittleEndian.AppendUint32(vc, uint32(len(fields)))
+	for _, f := range fields {
+		c := f[0] + "=" + f[1]
+		vc = binary.LittleEndian.AppendUint32(vc, uint32(len(c)))
+		vc = append(vc, c...)
+	}
+	bw.write(1, 1)
+	bw.write(4, 7)
+	bw.write(uint64(len(vc)), 24)
+	bw.bytes = append(bw.bytes, vc...)
+	if _, err := w.Write(bw.bytes); err != nil {
+		return err
+	}
+
+	for frame, start := 0, 0; start < len(samples); frame, start = frame+1, start+flacBlock {
+		n := min(flacBlock, len(samples)-start)
+		var f flacBits
+		f.write(0xFFF8, 16) // sync, fixed block size
+		if n == flacBlock {
+			f.write(12, 4) // 256 << (12-8)
+		} else {
+			f.write(7, 4) // 16-bit size at the end of the header
+		}
+		rate, rateBits := flacRateCode()
+		f.write(rate, 4)
+		f.write(1, 4) // left, right
+		f.write(4, 3) // 16 bits per sample
+		f.write(0, 1)
+		f.utf8(uint64(frame))
+		if n != flacBlock {
+			f.write(uint64(n-1), 16)
+		}
+		if rateBits > 0 {
+			f.write(uint64(sampleRate), rateBits)
+		}
+		f.write(uint64(flacCRC8(f.bytes)), 8)
+
+		for _, ch := range chans {
+			f.subframe(ch[start : start+n])
+		}
+		f.align()
+		f.write(uint64(flacCRC16(f.bytes)), 16)
+		if _, err := w.Write(f.bytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flacRateCode is the header's sample rate code, and the width of the rate
+// in Hz after the header for rates without a code of their own.
+func flacRateCode() (uint64, uint) {
+	codes := map[beep.SampleRate]uint64{
+		88200: 1, 8000: 4, 16000: 5, 22050: 6, 24000: 7, 32000: 8, 44100: 9, 48000: 10, 96000: 11,
+	}
+	if c, ok := codes[sampleRate]; ok {
+		return c, 0
+	}
+	return 13, 16
+}
+
+// flacBits writes big-endian bit fields.
+type flacBits struct {
+	bytes []byte
+	acc   uint64
+	n     uint
+}
+
+func (b *flacBits) write(v uint64, width uint) {
+	for width > 0 {
+		k := min(width, 56-b.n)
+		width -= k
+		b.acc = b.acc<<k | (v>>width)&(1<<k-1)
+		b.n += k
+		for b.n >= 8 {
+			b.n -= 8
+			b.bytes = append(b.bytes, byte(b.acc>>b.n))
+		}
+	}
+}
+
+func (b *flacBits) align() {
+	if b.n > 0 {
+		b.write(0, 8-b.n)
+	}
+}
+
+// utf8 writes the frame number in FLAC's extended UTF-8 coding.
+func (b *flacBits) utf8(v uint64) {
+	if v < 0x80 {
+		b.write(v, 8)
+		return
+	}
+	n := 2
+	for v >= 1<<(5*n+1) {
+		n++
+	}
+	lead := uint64(0xFF<<(8-n)) & 0xFF // n ones, then a zero
+	b.write(lead|v>>(6*(n-1)), 8)
+	for i := n - 2; i >= 0; i-- {
+		b.write(0x80|(v>>(6*i))&0x3F, 8)
+	}
+}
+
+// subframe codes one channel of a block the cheapest way it can.
+func (b *flacBits) subframe(x []int32) {
+	constant := true
+	for _, v := range x {
+		if v != x[0] {
+			constant = false
+			break
+		}
+	}
+	if constant {
+		b.write(0, 1)
+		b.write(0, 6)
+		b.write(0, 1)
+		b.write(uint64(uint16(x[0])), 16)
+		return
+	}
+
+	bestOrder, bestBits, bestK := -1, 16*len(x), 0
+	var bestRes []int64
+	for order := 0; order <= min(4, len(x)-1); order++ {
+		res := fixedResidual(x, order)
+		k, size := riceParam(res)
+		if size += 16 * order; size < bestBits {
+			bestOrder, bestBits, bestK, bestRes = order, size, k, res
+		}
+	}
+
+	b.write(0, 1)
+	if bestOrder < 0 {
+		b.write(1, 6) // verbatim
+		b.write(0, 1)
+		for _, v := range x {
+			b.write(uint64(uint16(v)), 16)
+		}
+		return
+	}
+	b.write(uint64(8|bestOrder), 6)
+	b.write(0, 1)
+	for _, v := range x[:bestOrder] {
+		b.write(uint64(uint16(v)), 16)
+	}
+	b.write(0, 2) // Rice, 4-bit parameter
+	b.write(0, 4) // one partition
+	b.write(uint64(bestK), 4)
+	for _, r := range bestRes {
+		u := uint64(r<<1 ^ r>>63)
+		for q := u >> bestK; q > 0; q-- {
+			b.write(0, 1)
+		}
+		b.write(1, 1)
+		b.write(u, uint(bestK))
+	}
+}
+
+// fixedResidual is what's left after the fixed predictor of this order.
+func fixedResidual(x []int32, order int) []int64 {
+	res := make([]int64, 0, len(x)-order)
+	for i := order; i < len(x); i++ {
+		a, b, c, d, e := int64(x[i]), int64(0), int64(0), int64(0), int64(0)
+		if order >= 1 {
+			b = int64(x[i-1])
+		}
+		if order >= 2 {
+			c = int64(x[i-2])
+		}
+		if order >= 3 {
+			d = int64(x[i-3])
+		}
+		if order >= 4 {
+			e = int64(x[i-4])
+		}
+		switch order {
+		case 0:
+			res = append(res, a)
+		case 1:
+			res = append(res, a-b)
+		case 2:
+			res = append(res, a-2*b+c)
+		case 3:
+			res = append(res, a-3*b+3*c-d)
+		case 4:
+			res = append(res, a-4*b+6*c-4*d+e)
+		}
+	}
+	return res
+}
+
+// riceParam picks the Rice parameter for res and reports the bits it costs.
+func riceParam(res []int64) (int, int) {
+	var total uint64
+	for _, r := range res {
+		total += uint64(r<<1 ^ r>>63)
+	}
+	k := 0
+	if n := uint64(len(res)); n > 0 && total > n {
+		k = min(14, bits.Len64(total/n)-1)
+	}
+	size := 4 + 2 + 4 + len(res)*(k+1) + int(total>>k)
+	return k, size
+}
+
+func flacCRC8(data []byte) byte {
+	var crc byte
+	for _, b := range data {
+		crc ^= b
+		for range 8 {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func flacCRC16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// OGG and MP3 go through whichever encoder is installed, fed a WAV on
+// stdin.
+
+func encoderCommand(format, path string, tags exportTags) (*exec.Cmd, error) {
+	if _, err := exec.LookPath("ffmpeg"); err == nil {
+		args := []string{"-y", "-loglevel", "error", "-f", "wav", "-i", "-"}
+		for _, f := range tags.fields() {
+			args = append(args, "-metadata", f[0]+"="+f[1])
+		}
+		if format == "ogg" {
+			args = append(args, "-c:a", "libvorbis", "-q:a", "5")
+		} else {
+			args = append(args, "-c:a", "libmp3lame", "-q:a", "2")
+			if sampleRate > 48000 {
+				args = append(args, "-ar", "48000") // MP3 goes no higher
+			}
+		}
+		return exec.Command("ffmpeg", append(args, path)...), nil
+	}
+
+	switch format {
+	case "ogg":
+		if _, err := exec.LookPath("oggenc"); err == nil {
+			args := []string{"-Q", "-q", "5", "-o", path}
+			for _, f := range tags.fields() {
+				args = append(args, "-c", f[0]+"="+f[1])
+			}
+			return exec.Command("oggenc", append(args, "-")...), nil
+		}
+		return nil, errors.New("OGG needs ffmpeg or oggenc")
+	default:
+		if _, err := exec.LookPath("lame"); err == nil {
+			args := []string{"--quiet", "-V", "2", "--tt", tags.Title, "--tc", "Instrument: " + tags.Instrument}
+			if tags.BPM > 0 {
+				args = append(args, "--tv", fmt.Sprintf("TBPM=%g", tags.BPM))
+			}
+			return exec.Command("lame", append(args, "-", path)...), nil
+		}
+		return nil, errors.New("MP3 needs ffmpeg or lame")
+	}
+}
+
+// encoderReady looks for an encoder once; the dialog asks on every frame.
+func encoderReady(format string) func() error {
+	return sync.OnceValue(func() error {
+		_, err := encoderCommand(format, "", exportTags{})
+		return err
+	})
+}
+
+func externalEncode(format string) func(string, [][2]float64, exportTags) error {
+	return func(path string, samples [][2]float64, tags exportTags) error {
+		cmd, err := encoderCommand(format, path, tags)
+		if err != nil {
+			return err
+		}
+		var wav bytes.Buffer
+		if err := writeWAV(&wav, samples, tags); err != nil {
+			return err
+		}
+		cmd.Stdin = &wav
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%s: %v %s", filepath.Base(cmd.Path), err, bytes.TrimSpace(out))
+		}
+		return nil
+	}
+}
+
+// exportDone reports a finished export to the TUI.
+type exportDone struct {
+	path string
+	err  error
+}
+
+// exportTake renders and writes a take in the background.
+func exportTake(tf takeFile, f exportFormat) tea.Cmd {
+	inst, bus, volume := liveRenderSetup()
+	dir := takesDir()
+	return func() tea.Msg {
+		tags := exportTags{Title: tf.name, Instrument: inst.Name, BPM: tf.take.BPM}
+		samples := renderTake(tf.take, inst, bus, volume)
+		path := filepath.Join(dir, tf.name+f.Ext)
+		return exportDone{path, f.write(path, samples, tags)}
+	}
+}
+
+// handleExportKey runs the format choice of the replay panel.
+func (m model) handleExportKey(msg tea.KeyMsg) (model, tea.Cmd) {
+	r := &m.replay
+	switch msg.Type {
+	case tea.KeyEscape, tea.KeyCtrlX:
+		r.exporting = false
+	case tea.KeyUp:
+		r.format = (r.format - 1 + len(exportFormats)) % len(exportFormats)
+	case tea.KeyDown:
+		r.format = (r.format + 1) % len(exportFormats)
+	case tea.KeyEnter:
+		f := exportFormats[r.format]
+		if f.ready != nil {
+			if err := f.ready(); err != nil {
+				m.notification = err.Error()
+				m.notifyClearTime = time.Now().Add(3 * time.Second)
+				return m, nil
+			}
+		}
+		tf := r.takes[r.cursor]
+		r.exporting = false
+		m.notification = fmt.Sprintf("Exporting %s as %s...", tf.name, f.Name)
+		m.notifyClearTime = time.Now().Add(time.Minute)
+		return m, exportTake(tf, f)
+	}
+	return m, nil
+}
+
+func (m model) exportView() []string {
+	r := m.replay
+	lines := []string{"", instStyle.UnsetMarginBottom().Render("Export " + r.takes[r.cursor].name + " as:")}
+	for i, f := range exportFormats {
+		cursor := "  "
+		nameStyle := presetTextStyle
+		if i == r.format {
+			cursor = "▶ "
+			nameStyle = instStyle.UnsetMarginBottom()
+		}
+		note := ""
+		if f.ready != nil {
+			if err := f.ready(); err != nil {
+				note = err.Error()
+			}
+		}
+		lines = append(lines, fmt.Sprintf("%s%s %s", cursor,
+			nameStyle.Render(fmt.Sprintf("%-5s", f.Name)), helpStyle.UnsetMarginTop().Render(note)))
+	}
+	return append(lines, helpStyle.Render("↑/↓: Format  •  ENTER: Export  •  ESC/CTRL+X: Back"))
+}
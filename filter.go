@@ -0,0 +1,39 @@
+package main
+
+import "math"
+
+// --- VOICE FILTER ---
+
+const (
+	filterMinHz = 20.0
+	filterMaxHz = 20000.0 // the filter is bypassed from here up
+)
+
+// svf is a resonant low-pass, the trapezoidal state-variable design, which
+// stays stable while the cutoff is swept every sample. One state per
+// stereo channel.
+type svf struct {
+	ic1, ic2 [2]float64
+}
+
+// lowpass filters a sample pair at cutoff Hz. resonance 0..1 runs from a
+// gentle slope up to a sharp peak just short of self-oscillation.
+func (f *svf) lowpass(in [2]float64, cutoff, resonance float64) [2]float64 {
+	sr := float64(sampleRate)
+	cutoff = math.Max(filterMinHz, math.Min(cutoff, sr*0.45))
+	g := math.Tan(math.Pi * cutoff / sr)
+	k := 2 - 1.95*math.Max(0, math.Min(resonance, 1)) // 1/Q
+	a1 := 1 / (1 + g*(g+k))
+	a2 := g * a1
+	a3 := g * a2
+
+	for ch := range in {
+		v3 := in[ch] - f.ic2[ch]
+		v1 := a1*f.ic1[ch] + a2*v3
+		v2 := f.ic2[ch] + a2*f.ic1[ch] + a3*v3
+		f.ic1[ch] = flush(2*v1 - f.ic1[ch])
+		f.ic2[ch] = flush(2*v2 - f.ic2[ch])
+		in[ch] = v2
+	}
+	return in
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+)
+
+// --- FM ENGINE ---
+//
+// An FM instrument is a handful of sine operators wired together by an
+// algorithm, DX-style. Each operator runs at a ratio of the note's pitch
+// with its own envelope; a modulator's Level is how far (in radians) it
+// bends the phase of the operators it feeds, a carrier's is how loud it
+// is. Operators are numbered from 0 and only ever modulate lower numbers.
+
+const maxOperators = 4
+
+type FMOperator struct {
+	Ratio     float64 `json:"ratio"`
+	Detune    float64 `json:"detune"` // cents
+	Level     float64 `json:"level"`
+	AttackMs  float64 `json:"attack_ms"`
+	DecayMs   float64 `json:"decay_ms"`
+	Sustain   float64 `json:"sustain"` // 0..1
+	ReleaseMs float64 `json:"release_ms"`
+}
+
+type FM struct {
+	Algorithm int          `json:"algorithm"`
+	Ops       []FMOperator `json:"operators"`
+	Feedback  float64      `json:"feedback"` // the top operator modulating itself, in radians
+}
+
+const (
+	fmStack    = iota // 3 → 2 → 1 → 0
+	fmPairs           // 1 → 0 and 3 → 2, two carriers
+	fmBranch          // 1, 2 and 3 all → 0
+	fmY               // 3 → 2, and 2 and 1 → 0
+	fmAdditive        // every operator is a carrier
+)
+
+// fmAlgorithm says which operators modulate each one and which are heard.
+type fmAlgorithm struct {
+	mods     [maxOperators][]int
+	carriers []int
+}
+
+var fmAlgorithms = []fmAlgorithm{
+	fmStack:    {mods: [maxOperators][]int{{1}, {2}, {3}}, carriers: []int{0}},
+	fmPairs:    {mods: [maxOperators][]int{{1}, nil, {3}}, carriers: []int{0, 2}},
+	fmBranch:   {mods: [maxOperators][]int{{1, 2, 3}}, carriers: []int{0}},
+	fmY:        {mods: [maxOperators][]int{{1, 2}, nil, {3}}, carriers: []int{0}},
+	fmAdditive: {carriers: []int{0, 1, 2, 3}},
+}
+
+// fmVoice is an FM instrument's running state inside a voice. Every
+// unison copy keeps its own operator phases; the envelopes are shared.
+type fmVoice struct {
+	*FM
+	alg      *fmAlgorithm
+	ratio    [maxOperators]float64
+	env      [maxOperators]adsr
+	level    [maxOperators]float64 // envelope times Level, this sample
+	phases   [maxUnison][maxOperators]float64
+	feedback [maxUnison]float64 // the top operator's last output
+}
+
+func newFMVoice(fm *FM) *fmVoice {
+	if fm == nil {
+		return nil
+	}
+	v := &fmVoice{FM: fm, alg: &fmAlgorithms[fm.Algorithm]}
+	for i := range fm.Ops {
+		v.env[i] = newADSR()
+	}
+	// Unison copies start scattered so they don't comb at the attack
+	for j := 1; j < maxUnison; j++ {
+		for i := range fm.Ops {
+			v.phases[j][i] = rand.Float64() * 2 * math.Pi
+		}
+	}
+	return v
+}
+
+// restart readies a recycled voice's operators for a new note on fm.
+// Their phases carry on.
+func (v *fmVoice) restart(fm *FM) {
+	v.FM, v.alg = fm, &fmAlgorithms[fm.Algorithm]
+	for i := range fm.Ops {
+		v.env[i] = newADSR()
+	}
+	v.feedback = [maxUnison]float64{}
+}
+
+// advance moves the operator envelopes on by one sample. Ratios are
+// picked up here too, so they can be edited while notes sound.
+func (v *fmVoice) advance(releasing bool) {
+	for i, op := range v.Ops {
+		v.ratio[i] = op.Ratio * math.Exp2(op.Detune/1200)
+		v.level[i] = op.Level * v.env[i].next(op.AttackMs, op.DecayMs, op.Sustain, op.ReleaseMs, releasing)
+	}
+}
+
+// render plays unison copy j for one sample and moves its phases on by
+// step, the phase increment of the note itself.
+func (v *fmVoice) render(j int, step float64) float64 {
+	const twoPi = 2 * math.Pi
+	n := len(v.Ops)
+	ph := &v.phases[j]
+
+	// Top down, so every modulator is ready before what it feeds
+	var out [maxOperators]float64
+	for i := n - 1; i >= 0; i-- {
+		p := ph[i]
+		for _, m := range v.alg.mods[i] {
+			if m < n {
+				p += out[m]
+			}
+		}
+		if i == n-1 && v.Feedback > 0 {
+			p += v.feedback[j] * v.Feedback
+		}
+		out[i] = math.Sin(p) * v.level[i]
+
+		ph[i] += step * v.ratio[i]
+		if ph[i] >= twoPi {
+			ph[i] = math.Mod(ph[i], twoPi)
+		}
+	}
+	if v.Feedback > 0 && v.level[n-1] > 0 {
+		v.feedback[j] = out[n-1] / v.level[n-1]
+	}
+
+	var sum float64
+	for _, c := range v.alg.carriers {
+		if c < n {
+			sum += out[c]
+		}
+	}
+	return sum * 0.2
+}
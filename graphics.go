@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/x/ansi"
+)
+
+// --- GRAPHICS ---
+//
+// In a terminal that shows images, the spectrum is drawn as one, at the
+// screen's own pixels, instead of in block characters. Kitty and Ghostty
+// take the Kitty graphics protocol; foot, WezTerm, mlterm and Contour take
+// sixel. "graphics" in the config is "auto", the default, which goes by
+// the terminal's environment and keeps to text anywhere else: inside tmux
+// or screen, for SSH visitors, and for sixel when the terminal doesn't
+// report the size of its cells. "kitty", "sixel" or "text" pick one.
+//
+// The image rides at the end of the visualizer's last line, which is blank
+// like the others: the cursor is saved, moved back to the top left of the
+// block, the image drawn there and the cursor put back, so the panel goes
+// on being drawn line by line round it.
+
+const (
+	gfxText = iota
+	gfxKitty
+	gfxSixel
+)
+
+var graphicsNames = []string{"text", "kitty", "sixel"}
+
+const (
+	kittyImageID = 7462
+	kittyChunk   = 4096 // base64 bytes per escape sequence
+)
+
+// kittyClear takes the spectrum's image down when a panel covers it.
+var kittyClear = fmt.Sprintf("\x1b_Ga=d,d=i,i=%d,q=2\x1b\\", kittyImageID)
+
+// graphicsMode looks up "graphics" from the config, finding out what the
+// terminal takes for "auto" or an empty one.
+func graphicsMode(name string) (int, error) {
+	if name == "" || strings.EqualFold(name, "auto") {
+		return detectGraphics(), nil
+	}
+	if i := slices.IndexFunc(graphicsNames, func(n string) bool { return strings.EqualFold(n, name) }); i >= 0 {
+		return i, nil
+	}
+	return gfxText, fmt.Errorf("unknown graphics %q (auto, %s)", name, strings.Join(graphicsNames, ", "))
+}
+
+func detectGraphics() int {
+	term, program := os.Getenv("TERM"), os.Getenv("TERM_PROGRAM")
+	switch {
+	case os.Getenv("TMUX") != "" || strings.HasPrefix(term, "screen") || strings.HasPrefix(term, "tmux"):
+		return gfxText // they'd pass the images on in the wrong place
+	case os.Getenv("KITTY_WINDOW_ID") != "" || term == "xterm-kitty" || term == "xterm-ghostty" || program == "ghostty":
+		return gfxKitty
+	case strings.HasPrefix(term, "foot") || term == "mlterm" || term == "contour" || program == "WezTerm":
+		if w, _ := cellPixels(); w > 0 {
+			return gfxSixel
+		}
+	}
+	return gfxText
+}
+
+// graphicsView draws the spectrum as an image the size of the text one,
+// or reports false if it can't.
+func (m model) graphicsView(l layout) (string, bool) {
+	width, height := visSize(l)
+	cw, ch := cellPixels()
+	if cw == 0 || ch == 0 {
+		if m.graphics == gfxSixel {
+			return "", false
+		}
+		cw, ch = 8, 16 // kitty fits the image to the cells anyway
+	}
+
+	img := m.spectrumImage(width*cw, height*ch/6*6)
+	var seq string
+	if m.graphics == gfxKitty {
+		seq = kittyImage(img, width, height)
+	} else {
+		seq = sixelImage(img)
+	}
+
+	lines := make([]string, height)
+	for i := range lines {
+		lines[i] = strings.Repeat(" ", width)
+	}
+	lines[height-1] += ansi.SaveCursor + ansi.CursorUp(height-1) + ansi.CursorBackward(width) +
+		seq + ansi.RestoreCursor
+	return l.vis().Render(strings.Join(lines, "\n")), true
+}
+
+// spectrumImage is the spectrum curve mirrored about the middle, in the
+// theme's visualizer color on a clear background.
+func (m model) spectrumImage(w, h int) *image.Paletted {
+	r, g, b, _ := waveColor.GetForeground().RGBA()
+	wave := color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), 0xff}
+	img := image.NewPaletted(image.Rect(0, 0, w, h), color.Palette{color.Transparent, wave})
+
+	mid := float64(h) / 2
+	for x, v := range m.spectrumCurve(w) {
+		for y := range h {
+			if math.Abs(float64(y)+0.5-mid) <= max(v*mid, 1) {
+				img.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+	return img
+}
+
+// kittyImage sends img as a PNG and places it over cols by rows cells at
+// the cursor, replacing the last one, without moving the cursor or
+// getting a reply.
+func kittyImage(img image.Image, cols, rows int) string {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return ""
+	}
+	data := base64.StdEncoding.EncodeToString(buf.Bytes())
+
+	var b strings.Builder
+	for i := 0; i < len(data); i += kittyChunk {
+		more := 0
+		if i+kittyChunk < len(data) {
+			more = 1
+		}
+		chunk := data[i:min(i+kittyChunk, len(data))]
+		if i == 0 {
+			fmt.Fprintf(&b, "\x1b_Ga=T,f=100,i=%d,p=1,c=%d,r=%d,C=1,q=2,m=%d;%s\x1b\\",
+				kittyImageID, cols, rows, more, chunk)
+		} else {
+			fmt.Fprintf(&b, "\x1b_Gm=%d;%s\x1b\\", more, chunk)
+		}
+	}
+	return b.String()
+}
+
+// sixelImage encodes img, six rows to a band, with runs of the same column
+// pattern shortened. Clear pixels are painted in the background color so
+// each frame covers the last.
+func sixelImage(img *image.Paletted) string {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	r, g, bl, _ := img.Palette[1].RGBA()
+	pct := func(c uint32) int { return int(c * 100 / 0xffff) }
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "\x1bP0;0;0q\"1;1;%d;%d#1;2;%d;%d;%d", w, h, pct(r), pct(g), pct(bl))
+	for band := 0; band < h; band += 6 {
+		b.WriteString("#1")
+		var last byte
+		run := 0
+		flush := func() {
+			if run > 3 {
+				fmt.Fprintf(&b, "!%d%c", run, last)
+			} else {
+				b.WriteString(strings.Repeat(string(last), run))
+			}
+		}
+		for x := range w {
+			six := byte(63)
+			for dy := 0; dy < 6 && band+dy < h; dy++ {
+				if img.ColorIndexAt(x, band+dy) == 1 {
+					six += 1 << dy
+				}
+			}
+			if six != last {
+				flush()
+				last, run = six, 0
+			}
+			run++
+		}
+		flush()
+		b.WriteByte('-')
+	}
+	b.WriteString("\x1b\\")
+	return b.String()
+}
@@ -0,0 +1,7 @@
+package main
+
+// cellPixels is zero: the Windows console doesn't give the size of its
+// cells in pixels, so sixel stays off.
+func cellPixels() (w, h int) {
+	return 0, 0
+}
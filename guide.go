@@ -0,0 +1,195 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// --- PLAY-ALONG GUIDE ---
+//
+// TAB in the replay panel turns on the guide: a take, MIDI file or score
+// then stops before each of its notes that's on the keyboard, lights the
+// keys, and waits for them to be played before going on. Notes struck
+// together wait together. The notes that are played by hand aren't played
+// back; the rest, the ones the keyboard can't reach, carry on as
+// accompaniment.
+//
+// It's also a game. TAB steps through three difficulties, which set how
+// fast the piece goes, how many notes of a chord are yours and how much
+// of the keyboard is used. Every step is worth up to 100 points, all of
+// them if it's played within a moment of lighting up and fewer the longer
+// it's waited for, and each wrong key costs 25. A play from the start to
+// the end is scored against the song's high scores, see highscores.go.
+
+const (
+	guideChord   = 0.05 // seconds; notes starting closer together are one step
+	guideGrace   = 0.4  // seconds to play a step for full points, at speed 1
+	guideFade    = 1.5  // seconds more until it's worth nothing
+	guidePoints  = 100
+	guidePenalty = 25 // a wrong key
+)
+
+// difficulty is how hard the play-along game is.
+type difficulty struct {
+	Name  string
+	Speed float64 // how fast the piece plays
+	Chord int     // most notes of a chord to play, the highest kept; 0 for all
+	Rows  []int   // keyboard rows played on; notes for the others play themselves
+}
+
+var difficulties = []difficulty{
+	{"Easy", 0.75, 1, []int{1}},
+	{"Medium", 1, 2, []int{0, 1}},
+	{"Hard", 1.25, 0, []int{0, 1, 2}},
+}
+
+type guide struct {
+	level   difficulty
+	keys    map[int]string  // the take's note-ons to be played by hand, by event
+	waiting map[string]bool // keys still to play before going on
+	from    int             // first event not yet waited for
+	asked   time.Time       // when the keys waited for lit up
+
+	scored   bool // played from the start, so it counts
+	steps    int
+	points   int
+	mistakes int
+	done     bool // got to the end
+	result   *playAlongResult
+}
+
+// newGuide finds which notes of the take are on the keyboard as it
+// stands, moved by the octave shift and the transposition, and which of
+// those the difficulty leaves to the player.
+func (m model) newGuide(tk take, level difficulty) *guide {
+	onKeys := make(map[int]string)
+	for _, n := range noteMap {
+		if !slices.Contains(level.Rows, n.Row) {
+			continue
+		}
+		if midi, ok := midiNote(m.noteFreq(n)); ok {
+			onKeys[int(midi)] = n.Key
+		}
+	}
+	key := func(ev takeEvent) string {
+		midi, ok := midiNote(ev.Freq)
+		if !ok {
+			return ""
+		}
+		return onKeys[int(midi)]
+	}
+	g := &guide{level: level, keys: make(map[int]string), scored: true}
+
+	// Gather each chord's notes on the keyboard, then keep the top ones
+	var chord []int
+	flush := func() {
+		sort.SliceStable(chord, func(a, b int) bool { return tk.Events[chord[a]].Freq > tk.Events[chord[b]].Freq })
+		if level.Chord > 0 && len(chord) > level.Chord {
+			chord = chord[:level.Chord]
+		}
+		for _, i := range chord {
+			g.keys[i] = key(tk.Events[i])
+		}
+		chord = chord[:0]
+	}
+	for i, ev := range tk.Events {
+		if ev.Type != "on" || !tk.heard(ev.Track) || key(ev) == "" {
+			continue
+		}
+		if len(chord) > 0 && ev.T >= tk.Events[chord[0]].T+guideChord {
+			flush()
+		}
+		chord = append(chord, i)
+	}
+	flush()
+	return g
+}
+
+// hold stops playback short of the next note to be played by hand before
+// end, and waits for its keys. It returns where playback can go up to.
+func (g *guide) hold(events []takeEvent, from int, end float64, now time.Time) float64 {
+	for i := max(from, g.from); i < len(events) && events[i].T < end; i++ {
+		if g.keys[i] == "" {
+			continue
+		}
+		at := events[i].T
+		g.waiting = make(map[string]bool)
+		g.asked = now
+		for g.from = i; g.from < len(events) && events[g.from].T < at+guideChord; g.from++ {
+			if key := g.keys[g.from]; key != "" {
+				g.waiting[key] = true
+			}
+		}
+		return at
+	}
+	return end
+}
+
+// reset starts the guide over after a seek. The game only counts when
+// it's played from the start.
+func (g *guide) reset(from int) {
+	*g = guide{level: g.level, keys: g.keys, from: from, scored: from == 0}
+}
+
+// stepPoints is what a step is worth played wait after it lit up.
+func (g *guide) stepPoints(wait time.Duration) int {
+	late := wait.Seconds() - guideGrace/g.level.Speed
+	if late <= 0 {
+		return guidePoints
+	}
+	return max(0, int(guidePoints*(1-late/guideFade)))
+}
+
+// score is the points so far, less the wrong keys.
+func (g *guide) score() int {
+	return max(0, g.points-guidePenalty*g.mistakes)
+}
+
+// guiding reports whether the guide is waiting for a key.
+func (m model) guiding(key string) bool {
+	pl := m.replay.player
+	return pl != nil && pl.guide != nil && pl.guide.waiting[key]
+}
+
+// guideHit takes a key played along with the guide.
+func (m model) guideHit(key string, now time.Time) model {
+	pl := m.replay.player
+	if pl == nil || pl.guide == nil || len(pl.guide.waiting) == 0 {
+		return m
+	}
+	g := pl.guide
+	if !g.waiting[key] {
+		g.mistakes++
+		return m
+	}
+	delete(g.waiting, key)
+	if len(g.waiting) == 0 {
+		g.steps++
+		g.points += g.stepPoints(now.Sub(g.asked))
+	}
+	return m
+}
+
+// label names the keys being waited for, for the replay panel.
+func (g *guide) label() string {
+	var names []string
+	for _, row := range sortedRows {
+		for _, n := range row {
+			if g.waiting[n.Key] {
+				names = append(names, strings.ToUpper(n.Key))
+			}
+		}
+	}
+	return strings.Join(names, " + ")
+}
+
+// speed is how fast the take plays, which the play-along game's
+// difficulty sets.
+func (pl *takePlayer) speed() float64 {
+	if pl.guide == nil {
+		return 1
+	}
+	return pl.guide.level.Speed
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// --- HIGH SCORES ---
+//
+// The play-along game's best scores are kept in
+// ~/.config/piango/highscores.json, for each song at each difficulty.
+// When a song has been played to the end, the replay panel shows how it
+// went beside the song's high scores. Only plays from the start count; a
+// seek or a change of difficulty on the way makes it practice.
+
+type highScore struct {
+	Score int       `json:"score"`
+	Max   int       `json:"max"`
+	Date  time.Time `json:"date"`
+}
+
+func (h highScore) percent() float64 {
+	if h.Max == 0 {
+		return 0
+	}
+	return 100 * float64(h.Score) / float64(h.Max)
+}
+
+// highScores is the best score for each song, by difficulty.
+type highScores map[string]map[string]highScore
+
+// playAlongResult is how a play-along game went.
+type playAlongResult struct {
+	song     string
+	level    string
+	score    highScore
+	steps    int
+	mistakes int
+	scored   bool       // played from the start
+	best     bool       // a new high score
+	previous *highScore // the high score it had to beat
+	scores   map[string]highScore
+}
+
+func highScoresPath() string {
+	dir := configDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "highscores.json")
+}
+
+func loadHighScores(path string) (highScores, error) {
+	hs := make(highScores)
+	if path == "" {
+		return hs, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return hs, nil
+	}
+	if err != nil {
+		return hs, err
+	}
+	if err := json.Unmarshal(data, &hs); err != nil {
+		return hs, fmt.Errorf("%s: %w", path, err)
+	}
+	return hs, nil
+}
+
+func saveHighScores(path string, hs highScores) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(hs, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// finishPlayAlong scores the game once the guided take gets to its end.
+func (m model) finishPlayAlong() model {
+	pl := m.replay.player
+	if pl == nil || pl.guide == nil || !pl.guide.done || pl.guide.result != nil {
+		return m
+	}
+	g := pl.guide
+	res := &playAlongResult{
+		song:     pl.name,
+		level:    g.level.Name,
+		score:    highScore{g.score(), guidePoints * g.steps, time.Now()},
+		steps:    g.steps,
+		mistakes: g.mistakes,
+		scored:   g.scored,
+	}
+	g.result = res
+	if !g.scored || g.steps == 0 {
+		return m
+	}
+	m.practice.scored("Play-Along", res.score.Score, res.score.Max)
+
+	hs, err := loadHighScores(highScoresPath())
+	if err == nil {
+		if hs[pl.name] == nil {
+			hs[pl.name] = make(map[string]highScore)
+		}
+		if old, ok := hs[pl.name][g.level.Name]; ok {
+			res.previous = &old
+		}
+		if res.previous == nil || res.score.Score > res.previous.Score {
+			hs[pl.name][g.level.Name] = res.score
+			res.best = true
+			err = saveHighScores(highScoresPath(), hs)
+		}
+		res.scores = hs[pl.name]
+	}
+	if err != nil {
+		m.notification = fmt.Sprintf("High scores: %v", err)
+		m.notifyClearTime = time.Now().Add(4 * time.Second)
+	}
+	return m
+}
+
+// view is the results screen, shown under the finished take.
+func (r *playAlongResult) view() []string {
+	lines := []string{
+		presetTitleStyle.Render(fmt.Sprintf("--- RESULTS • %s • %s ---", r.song, r.level)),
+		instStyle.UnsetMarginBottom().Render(fmt.Sprintf("Score %d of %d (%.0f%%)  •  %d notes, %d wrong keys",
+			r.score.Score, r.score.Max, r.score.percent(), r.steps, r.mistakes)),
+	}
+	dim := presetTitleStyle.UnsetMarginTop().UnsetMarginBottom()
+	switch {
+	case !r.scored:
+		lines = append(lines, dim.Render("Practice: it didn't start from the beginning, so it isn't scored"))
+	case r.best:
+		lines = append(lines, notifyStyle.UnsetMarginBottom().UnsetPadding().Render("★ New high score!"))
+	case r.previous != nil:
+		lines = append(lines, presetTextStyle.Render(fmt.Sprintf("High score %d (%.0f%%), %s",
+			r.previous.Score, r.previous.percent(), r.previous.Date.Format("2 Jan 2006"))))
+	}
+	if r.scores != nil {
+		var table []string
+		for _, d := range difficulties {
+			best := "—"
+			if h, ok := r.scores[d.Name]; ok {
+				best = fmt.Sprintf("%d (%.0f%%)", h.Score, h.percent())
+			}
+			table = append(table, d.Name+" "+best)
+		}
+		lines = append(lines, dim.Render("Best: "+strings.Join(table, "  •  ")))
+	}
+	return lines
+}
@@ -0,0 +1,157 @@
+//go:build jack
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gopxl/beep/v2"
+	"github.com/xthexder/go-jack"
+)
+
+// --- JACK OUTPUT ---
+//
+// Built with `-tags jack` and selected with `--backend jack`. JACK drives
+// rendering from its own realtime callback, so the mixer is pulled from
+// process() instead of being pushed to a device, and the engine follows
+// whatever sample rate and buffer size the server runs at when it starts.
+//
+// process() runs on JACK's realtime thread, which mustn't wait, so it only
+// tries the lock the UI takes to change settings: a cycle that finds it
+// held plays silence rather than stall the whole graph. Voices and effects
+// work out their coefficients for the rate they were set up at, so a
+// server that changes rate mid-session is refused and piango stays silent
+// until the rate is back.
+
+type jackBackend struct {
+	mu        sync.Mutex
+	client    *jack.Client
+	ports     [2]*jack.Port
+	mixer     beep.Mixer
+	buf       [][2]float64
+	rate      uint32      // the server's rate when the engine was set up
+	wrongRate atomic.Bool // the server has moved off it since
+}
+
+func init() {
+	registerBackend("jack", &jackBackend{})
+}
+
+// Init ignores the requested rate and buffer size; the server decides both.
+func (j *jackBackend) Init(sr beep.SampleRate, bufferSize int) error {
+	client, status := jack.ClientOpen("piango", jack.NoStartServer)
+	if status != 0 {
+		return jack.StrError(status)
+	}
+	j.client = client
+
+	j.rate = client.GetSampleRate()
+	sampleRate = beep.SampleRate(j.rate)
+	j.buf = make([][2]float64, client.GetBufferSize())
+
+	for i, name := range []string{"out_l", "out_r"} {
+		j.ports[i] = client.PortRegister(name, jack.DEFAULT_AUDIO_TYPE, jack.PortIsOutput, 0)
+		if j.ports[i] == nil {
+			j.Close()
+			return fmt.Errorf("can't register the JACK port %s", name)
+		}
+	}
+
+	if code := client.SetProcessCallback(j.process); code != 0 {
+		return jack.StrError(code)
+	}
+	client.SetBufferSizeCallback(j.bufferSizeChanged)
+	client.SetSampleRateCallback(j.sampleRateChanged)
+
+	if code := client.Activate(); code != 0 {
+		return jack.StrError(code)
+	}
+
+	// Hook up to the first two physical playback ports, like most JACK
+	// clients do, so sound comes out without a patchbay.
+	playback := client.GetPorts("", jack.DEFAULT_AUDIO_TYPE, jack.PortIsPhysical|jack.PortIsInput)
+	for i, port := range j.ports {
+		if i < len(playback) {
+			client.Connect(port.GetName(), playback[i])
+		}
+	}
+	return nil
+}
+
+func (j *jackBackend) process(nframes uint32) int {
+	left := j.ports[0].GetBuffer(nframes)
+	right := j.ports[1].GetBuffer(nframes)
+
+	// A buffer too small is bufferSizeChanged not having run yet; it
+	// allocates, which this thread mustn't.
+	var samples [][2]float64
+	if !j.wrongRate.Load() && j.mu.TryLock() {
+		if int(nframes) <= len(j.buf) {
+			n, _ := j.mixer.Stream(j.buf[:nframes])
+			samples = j.buf[:n]
+		}
+		j.mu.Unlock()
+	}
+
+	for i := range left {
+		if i >= len(samples) {
+			left[i], right[i] = 0, 0
+			continue
+		}
+		left[i] = jack.AudioSample(samples[i][0])
+		right[i] = jack.AudioSample(samples[i][1])
+	}
+	return 0
+}
+
+// bufferSizeChanged runs outside the process thread, so it is the place to grow
+// the scratch buffer rather than allocating during a cycle.
+func (j *jackBackend) bufferSizeChanged(nframes uint32) int {
+	j.mu.Lock()
+	j.buf = make([][2]float64, nframes)
+	j.mu.Unlock()
+	return 0
+}
+
+// sampleRateChanged refuses any rate but the one the engine was set up
+// at, silencing process() until the server is back on it.
+func (j *jackBackend) sampleRateChanged(rate uint32) int {
+	if rate != j.rate {
+		j.wrongRate.Store(true)
+		return 1
+	}
+	j.wrongRate.Store(false)
+	return 0
+}
+
+func (j *jackBackend) Play(s ...beep.Streamer) {
+	j.mu.Lock()
+	j.mixer.Add(s...)
+	j.mu.Unlock()
+}
+
+func (j *jackBackend) Clear() {
+	j.mu.Lock()
+	j.mixer.Clear()
+	j.mu.Unlock()
+}
+
+func (j *jackBackend) Close() {
+	if j.client != nil {
+		j.client.Close()
+		j.client = nil
+	}
+}
+
+func (j *jackBackend) Latency() time.Duration {
+	if j.client == nil {
+		return 0
+	}
+	return sampleRate.D(int(j.client.GetBufferSize()))
+}
+
+func (j *jackBackend) Lock()   { j.mu.Lock() }
+func (j *jackBackend) Unlock() { j.mu.Unlock() }
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// --- JAM SESSIONS ---
+//
+// One player hosts (-host :7710) and the others join (-join host:7710).
+// Every instance sends the notes its player holds as JSON lines over TCP
+// and the host passes them on to everyone else, so each player hears the
+// whole band rendered by their own piango. Remote notes are played on the
+// instrument their player picked, unless "jam": {"instruments": {...}}
+// in the config assigns that player something else.
+
+const jamQueue = 256 // lines buffered per peer before it starts missing notes
+
+// Remote notes are held until their note_off, so a peer that strikes keys
+// and never lets go would keep taking voices. A player can hold this many
+// at once, more than ten fingers and a sustain pedal need, and the whole
+// band four times as many.
+const (
+	maxJamHeld  = 32
+	maxJamTotal = 4 * maxJamHeld
+)
+
+// The pitches a note from outside may have, about MIDI's range up to
+// the top of hearing.
+const (
+	minNoteFreq = 8.0
+	maxNoteFreq = 20000.0
+)
+
+// audibleFreq reports whether freq is a pitch worth playing: a number, in
+// range.
+func audibleFreq(freq float64) bool {
+	return freq >= minNoteFreq && freq <= maxNoteFreq
+}
+
+type jamConfig struct {
+	Host        string            `json:"host"`
+	Join        string            `json:"join"`
+	Name        string            `json:"name"`        // defaults to $USER
+	Instruments map[string]string `json:"instruments"` // player → instrument
+}
+
+// jamMessage is one line on the wire. Type is hello, note_on, note_off or
+// bye; the host fills in Player for whoever sent it.
+type jamMessage struct {
+	Player     string  `json:"player"`
+	Type       string  `json:"type"`
+	Key        string  `json:"key,omitempty"`
+	Freq       float64 `json:"freq,omitempty"`
+	Velocity   float64 `json:"velocity,omitempty"`
+	Instrument string  `json:"instrument,omitempty"`
+}
+
+type jamSession struct {
+	name        string
+	host        bool
+	ln          net.Listener
+	instruments map[string]string
+
+	mu    sync.Mutex
+	peers map[*jamPeer]bool
+	inst  string // our current instrument, sent with every note
+
+	players map[string]bool // remote players; only touched by the UI
+}
+
+type jamPeer struct {
+	conn net.Conn
+	out  chan []byte
+	name string
+}
+
+// newJamSession listens or dials as the config says; nil when neither.
+func newJamSession(cfg jamConfig) (*jamSession, error) {
+	if cfg.Host == "" && cfg.Join == "" {
+		return nil, nil
+	}
+	s := &jamSession{
+		name:        cfg.Name,
+		instruments: cfg.Instruments,
+		peers:       make(map[*jamPeer]bool),
+		players:     make(map[string]bool),
+	}
+	if s.name == "" {
+		s.name = defaultJamName()
+	}
+
+	if cfg.Host != "" {
+		ln, err := net.Listen("tcp", cfg.Host)
+		if err != nil {
+			return nil, err
+		}
+		s.host, s.ln = true, ln
+		return s, nil
+	}
+
+	conn, err := net.DialTimeout("tcp", cfg.Join, 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("jam: %w", err)
+	}
+	s.peers[s.newPeer(conn, "")] = true
+	return s, nil
+}
+
+func defaultJamName() string {
+	if u := os.Getenv("USER"); u != "" {
+		return u
+	}
+	if u := os.Getenv("USERNAME"); u != "" {
+		return u
+	}
+	return "player"
+}
+
+func (s *jamSession) newPeer(conn net.Conn, name string) *jamPeer {
+	c := &jamPeer{conn: conn, out: make(chan []byte, jamQueue), name: name}
+	go c.writeLoop()
+	return c
+}
+
+// serve starts talking to the other players; what they play is sent to p.
+func (s *jamSession) serve(p *tea.Program) {
+	if !s.host {
+		for c := range s.peers {
+			c.send(jamLine(jamMessage{Player: s.name, Type: "hello"}))
+			go s.readLoop(c, p)
+		}
+		return
+	}
+	go func() {
+		for {
+			conn, err := s.ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.readLoop(s.newPeer(conn, ""), p)
+		}
+	}()
+}
+
+// readLoop handles a peer's lines until it goes away.
+func (s *jamSession) readLoop(c *jamPeer, p *tea.Program) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.peers, c)
+		s.mu.Unlock()
+		close(c.out)
+
+		if !s.host {
+			p.Send(jamMessage{Type: "bye"}) // the host left, and took everyone with it
+		} else if c.name != "" {
+			bye := jamMessage{Player: c.name, Type: "bye"}
+			s.broadcast(jamLine(bye), nil)
+			p.Send(bye)
+		}
+	}()
+
+	sc := bufio.NewScanner(c.conn)
+	for sc.Scan() {
+		var msg jamMessage
+		if json.Unmarshal(sc.Bytes(), &msg) != nil || !msg.sane() {
+			continue
+		}
+		if !s.host {
+			p.Send(msg)
+			continue
+		}
+
+		// The host decides who's who: a peer's first line must be its hello
+		if c.name == "" {
+			if msg.Type != "hello" {
+				return
+			}
+			c.name = s.join(c, msg.Player)
+			continue
+		}
+		msg.Player = c.name
+		s.broadcast(jamLine(msg), c)
+		p.Send(msg)
+	}
+}
+
+// join admits a new peer under a name nobody else is using and introduces
+// everyone to each other.
+func (s *jamSession) join(c *jamPeer, name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	taken := map[string]bool{s.name: true}
+	for other := range s.peers {
+		taken[other.name] = true
+	}
+	if name == "" {
+		name = "player"
+	}
+	unique := name
+	for n := 2; taken[unique]; n++ {
+		unique = fmt.Sprintf("%s%d", name, n)
+	}
+
+	hello := jamLine(jamMessage{Player: unique, Type: "hello"})
+	for other := range s.peers {
+		other.send(hello)
+		c.send(jamLine(jamMessage{Player: other.name, Type: "hello"}))
+	}
+	c.send(jamLine(jamMessage{Player: s.name, Type: "hello"}))
+	s.peers[c] = true
+	return unique
+}
+
+// broadcast queues a line for every peer but from.
+func (s *jamSession) broadcast(line []byte, from *jamPeer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.peers {
+		if c != from {
+			c.send(line)
+		}
+	}
+}
+
+// emit sends our own notes to the session. Notes already coming from
+// other players are left out so they don't echo back.
+func (s *jamSession) emit(events []perfEvent) {
+	for _, ev := range events {
+		if ev.Type == "instrument" {
+			s.inst = ev.Name
+		}
+	}
+	for _, ev := range events {
+		if strings.HasPrefix(ev.Key, "jam:") {
+			continue
+		}
+		msg := jamMessage{Player: s.name, Key: ev.Key, Freq: ev.Freq}
+		switch ev.Type {
+		case "note_on":
+			msg.Type, msg.Velocity, msg.Instrument = "note_on", ev.Velocity, s.inst
+		case "note_off":
+			msg.Type = "note_off"
+		default:
+			continue
+		}
+		s.broadcast(jamLine(msg), nil)
+	}
+}
+
+// sane checks a line off the network before it's played or passed on: a
+// note needs an audible pitch, and its velocity is held to 0..1.
+func (msg *jamMessage) sane() bool {
+	if msg.Type != "note_on" {
+		return true
+	}
+	if !audibleFreq(msg.Freq) || math.IsNaN(msg.Velocity) {
+		return false
+	}
+	msg.Velocity = max(0, min(1, msg.Velocity))
+	return true
+}
+
+func jamLine(msg jamMessage) []byte {
+	data, _ := json.Marshal(msg)
+	return append(data, '\n')
+}
+
+func (c *jamPeer) send(line []byte) {
+	select {
+	case c.out <- line:
+	default:
+	}
+}
+
+func (c *jamPeer) writeLoop() {
+	for line := range c.out {
+		if _, err := c.conn.Write(line); err != nil {
+			break
+		}
+	}
+	c.conn.Close()
+}
+
+// handleJam plays another player's note, or notes their coming and going.
+func (m model) handleJam(msg jamMessage) model {
+	s := m.jam
+	prefix := "jam:" + msg.Player + ":"
+	switch msg.Type {
+	case "hello":
+		s.players[msg.Player] = true
+		m.notification = msg.Player + " joined the jam"
+		m.notifyClearTime = time.Now().Add(3 * time.Second)
+
+	case "note_on":
+		inst := findInstrument(s.instruments[msg.Player])
+		if inst == nil {
+			inst = findInstrument(msg.Instrument)
+		}
+		if inst == nil {
+			inst = instruments[currentInstID]
+		}
+		if !jamRoom(prefix, prefix+msg.Key) {
+			return m
+		}
+		holdVoiceOn(prefix+msg.Key, inst, msg.Freq, msg.Velocity, 0)
+
+	case "note_off":
+		releaseVoice(prefix + msg.Key)
+
+	case "bye":
+		if msg.Player == "" {
+			prefix = "jam:"
+			clear(s.players)
+			m.notification = "Jam session closed"
+		} else {
+			delete(s.players, msg.Player)
+			m.notification = msg.Player + " left the jam"
+		}
+		m.notifyClearTime = time.Now().Add(3 * time.Second)
+		voiceLock.Lock()
+		for k, v := range voices {
+			if strings.HasPrefix(k, prefix) {
+				v.locked = false
+				v.release()
+			}
+		}
+		voiceLock.Unlock()
+	}
+	return m
+}
+
+// jamRoom reports whether key, for the player whose voices start with
+// prefix, can be held without going over maxJamHeld or maxJamTotal. A key
+// already held is only struck again.
+func jamRoom(prefix, key string) bool {
+	voiceLock.Lock()
+	defer voiceLock.Unlock()
+
+	if v, ok := voices[key]; ok && v.locked {
+		return true
+	}
+	mine, all := 0, 0
+	for k, v := range voices {
+		if v.locked && strings.HasPrefix(k, "jam:") {
+			all++
+			if strings.HasPrefix(k, prefix) {
+				mine++
+			}
+		}
+	}
+	return mine < maxJamHeld && all < maxJamTotal
+}
+
+// label is the header entry while a session is on.
+func (s *jamSession) label() string {
+	role := "Jam"
+	if s.host {
+		role = "Jam host"
+	}
+	return fmt.Sprintf("%s: %d player(s)", role, len(s.players)+1)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// --- KEYBOARD LAYOUTS ---
+//
+// "solfege", the default, is three rows of white keys an octave apart: Q
+// to U from Do5, A to J from Do4 and Z to M from Do3. "piano" is laid out
+// like most virtual pianos instead: A to L are the white keys from Do4 to
+// Re5 and the row above holds the black keys between them, W E, T Y U and
+// O P. It has less range but every sharp and flat. Pick one with
+// "keyboard" in the config, -keyboard, or in the settings (Ctrl+O).
+//
+// Both are written for QWERTY. "keymap" in the config, or -keymap, moves
+// them to where the same keys are on another layout, so the rows stay
+// under the same fingers: AZERTY plays the home row from Q, Dvorak from A
+// O E U. Where that lands a note on punctuation the note wins over the
+// key's own command.
+
+const (
+	kbSolfege = iota
+	kbPiano
+)
+
+// keyDef is a key of a layout: the note's name, and how many semitones it
+// is from La4.
+type keyDef struct {
+	k, n string
+	s    int
+}
+
+type keyRow struct {
+	label string
+	keys  []keyDef
+	over  bool // black keys, drawn over the gaps of the next row
+}
+
+type keyboardLayout struct {
+	name string
+	rows []keyRow
+}
+
+var keyboards = []keyboardLayout{
+	{"solfege", []keyRow{
+		{"High", []keyDef{{"q", "Do", 3}, {"w", "Re", 5}, {"e", "Mi", 7}, {"r", "Fa", 8}, {"t", "Sol", 10}, {"y", "La", 12}, {"u", "Si", 14}}, false},
+		{"Mid ", []keyDef{{"a", "Do", -9}, {"s", "Re", -7}, {"d", "Mi", -5}, {"f", "Fa", -4}, {"g", "Sol", -2}, {"h", "La", 0}, {"j", "Si", 2}}, false},
+		{"Low ", []keyDef{{"z", "Do", -21}, {"x", "Re", -19}, {"c", "Mi", -17}, {"v", "Fa", -16}, {"b", "Sol", -14}, {"n", "La", -12}, {"m", "Si", -10}}, false},
+	}},
+	{"piano", []keyRow{
+		{"Black", []keyDef{{"w", "Do♯", -8}, {"e", "Re♯", -6}, {"t", "Fa♯", -3}, {"y", "Sol♯", -1}, {"u", "La♯", 1}, {"o", "Do♯", 4}, {"p", "Re♯", 6}}, true},
+		{"White", []keyDef{{"a", "Do", -9}, {"s", "Re", -7}, {"d", "Mi", -5}, {"f", "Fa", -4}, {"g", "Sol", -2}, {"h", "La", 0}, {"j", "Si", 2}, {"k", "Do", 3}, {"l", "Re", 5}}, false},
+	}},
+}
+
+// keymap is a physical layout: what its three letter rows type, key for
+// key with QWERTY's.
+type keymap struct {
+	name string
+	rows [3]string
+}
+
+var keymaps = []keymap{
+	{"qwerty", [3]string{"qwertyuiop", "asdfghjkl;", "zxcvbnm,./"}},
+	{"azerty", [3]string{"azertyuiop", "qsdfghjklm", "wxcvbn,;:!"}},
+	{"qwertz", [3]string{"qwertzuiop", "asdfghjklö", "yxcvbnm,.-"}},
+	{"dvorak", [3]string{"',.pyfgcrl", "aoeuidhtns", ";qjkxbmwvz"}},
+	{"colemak", [3]string{"qwfpgjluy;", "arstdhneio", "zxcvbkm,./"}},
+}
+
+var (
+	currentKeyboard = kbSolfege
+	currentKeymap   = 0 // QWERTY
+)
+
+// key is what types on this layout where QWERTY types qwerty.
+func (km keymap) key(qwerty string) string {
+	for r, row := range keymaps[0].rows {
+		if i := strings.Index(row, qwerty); i >= 0 {
+			return string([]rune(km.rows[r])[i])
+		}
+	}
+	return qwerty
+}
+
+// keyboardStyle looks up a "keyboard" from the config; an empty one is
+// the solfège rows.
+func keyboardStyle(name string) (int, error) {
+	names := make([]string, len(keyboards))
+	for i, kb := range keyboards {
+		names[i] = kb.name
+	}
+	return pickName("keyboard", name, names)
+}
+
+// keymapStyle looks up a "keymap" from the config; an empty one is
+// QWERTY.
+func keymapStyle(name string) (int, error) {
+	names := make([]string, len(keymaps))
+	for i, km := range keymaps {
+		names[i] = km.name
+	}
+	return pickName("keymap", name, names)
+}
+
+// pickName finds name among names, the first being the default.
+func pickName(what, name string, names []string) (int, error) {
+	if name == "" {
+		return 0, nil
+	}
+	if i := slices.IndexFunc(names, func(n string) bool { return strings.EqualFold(n, name) }); i >= 0 {
+		return i, nil
+	}
+	return 0, fmt.Errorf("unknown %s %q (%s)", what, name, strings.Join(names, ", "))
+}
+
+// setKeyboard swaps the layout. Lessons and warm-ups are written for the
+// solfège rows, so they close.
+func (m model) setKeyboard(kb int) model {
+	if m.lesson.open {
+		m = m.closeLessons()
+	}
+	if m.warmup.open {
+		m = m.closeWarmup()
+	}
+	currentKeyboard = kb
+	initNotes()
+	m.notification = "Keyboard: " + keyboards[kb].name
+	m.notifyClearTime = time.Now().Add(2 * time.Second)
+	return m
+}
+
+// needSolfege turns down a panel whose exercises are laid out on the
+// solfège rows.
+func (m model) needSolfege(what string) model {
+	m.notification = what + " need the solfege keyboard (CTRL+O to switch)"
+	m.notifyClearTime = time.Now().Add(3 * time.Second)
+	return m
+}
+
+// rowSlots lays a row out a key's width at a time from the left. A row of
+// black keys is shifted half a key right and has a key over each gap of
+// the white row below it that has one, so the gaps without, Mi-Fa and
+// Si-Do, are left empty.
+func rowSlots(row int) (slots []Note, over bool) {
+	if !keyboards[currentKeyboard].rows[row].over || row+1 >= len(sortedRows) {
+		return sortedRows[row], false
+	}
+	for _, white := range sortedRows[row+1] {
+		w, _ := midiNote(white.Freq)
+		i := slices.IndexFunc(sortedRows[row], func(n Note) bool {
+			b, _ := midiNote(n.Freq)
+			return b == w+1
+		})
+		if i >= 0 {
+			slots = append(slots, sortedRows[row][i])
+		} else {
+			slots = append(slots, Note{})
+		}
+	}
+	for len(slots) > 0 && slots[len(slots)-1].Key == "" {
+		slots = slots[:len(slots)-1]
+	}
+	return slots, true
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// --- LATENCY TEST ---
+//
+// F4 plays a click twice a second and times each one through the same
+// path a key takes, to show where the delay between striking a key and
+// hearing it comes from:
+//
+//   - input: how long the click's message waited in the UI's queue, as a
+//     key's does between the terminal and the code that plays it
+//   - callback: from the note starting to the render callback taking it
+//   - buffer: the sound queued ahead of it, which piango keeps filled
+//   - driver: what the sound card or its driver holds after that
+//
+// The terminal's own delay in passing a key on, and the speakers', are
+// outside what the program can time.
+
+const (
+	latencyEvery = 500 * time.Millisecond
+	latencyRuns  = 20 // the averages cover this many clicks
+	probeLength  = 10 * time.Millisecond
+	probeHz      = 1500
+)
+
+// driverHeld is a backend that knows how much of its latency is held
+// past its buffer by the device or driver. On other backends, whatever
+// the latency has beyond the buffer is put down to the driver.
+type driverHeld interface {
+	DriverLatency() time.Duration
+}
+
+// latencyProbe is the UI message each click starts with.
+type latencyProbe struct {
+	sent time.Time
+	gen  int
+}
+
+// latencyClick is the click's sound. heard is set under output.Lock() when
+// the callback first renders it.
+type latencyClick struct {
+	struck time.Time
+	heard  time.Time
+	t      int
+}
+
+func (c *latencyClick) Stream(samples [][2]float64) (n int, ok bool) {
+	if c.heard.IsZero() {
+		c.heard = time.Now()
+	}
+	sr := float64(sampleRate)
+	length := sampleRate.N(probeLength)
+	for i := range samples {
+		if c.t >= length {
+			return i, false
+		}
+		sec := float64(c.t) / sr
+		v := math.Sin(2*math.Pi*probeHz*sec) * math.Exp(-sec/(probeLength.Seconds()/4)) * 0.4
+		samples[i] = [2]float64{v, v}
+		c.t++
+	}
+	return len(samples), true
+}
+
+func (c *latencyClick) Err() error { return nil }
+
+// latencyRun is one click's breakdown.
+type latencyRun struct {
+	input, callback, buffer, driver time.Duration
+}
+
+func (r latencyRun) total() time.Duration {
+	return r.input + r.callback + r.buffer + r.driver
+}
+
+type latencyTest struct {
+	open    bool
+	gen     int           // so a probe left from before a reopen is dropped
+	input   time.Duration // of the click waiting to be heard
+	pending *latencyClick
+	runs    []latencyRun
+}
+
+// openLatencyTest starts the clicks.
+func (m model) openLatencyTest() (model, tea.Cmd) {
+	m.latency = latencyTest{open: true, gen: m.latency.gen + 1}
+	return m, nextLatencyProbe(m.latency.gen)
+}
+
+func nextLatencyProbe(gen int) tea.Cmd {
+	return tea.Tick(latencyEvery, func(time.Time) tea.Msg {
+		return latencyProbe{sent: time.Now(), gen: gen}
+	})
+}
+
+// handleLatencyProbe plays the next click, once the last has been heard.
+func (m model) handleLatencyProbe(p latencyProbe) (model, tea.Cmd) {
+	if !m.latency.open || p.gen != m.latency.gen {
+		return m, nil
+	}
+	now := time.Now()
+	m = m.followLatency()
+	if m.latency.pending == nil {
+		c := &latencyClick{struck: now}
+		m.latency.input, m.latency.pending = now.Sub(p.sent), c
+		playDry(c)
+	}
+	return m, nextLatencyProbe(p.gen)
+}
+
+// followLatency takes the pending click's figures once it's been heard.
+func (m model) followLatency() model {
+	t := &m.latency
+	if t.pending == nil {
+		return m
+	}
+	output.Lock()
+	heard := t.pending.heard
+	output.Unlock()
+	if heard.IsZero() {
+		return m
+	}
+
+	bufferMu.Lock()
+	buffer, held := sampleRate.D(outputBuffer), output.Latency()
+	bufferMu.Unlock()
+	buffer = min(buffer, held)
+	driver := held - buffer
+	if d, ok := output.(driverHeld); ok {
+		driver = d.DriverLatency()
+		buffer = max(0, held-driver)
+	}
+	run := latencyRun{input: t.input, callback: heard.Sub(t.pending.struck), buffer: buffer, driver: driver}
+	t.runs = append(t.runs, run)
+	if len(t.runs) > latencyRuns {
+		t.runs = t.runs[1:]
+	}
+	t.pending = nil
+	return m
+}
+
+func (m model) handleLatencyKey(msg tea.KeyMsg) (model, bool) {
+	switch msg.Type {
+	case tea.KeyF4, tea.KeyEscape:
+		m.latency = latencyTest{gen: m.latency.gen}
+	default:
+		return m, false
+	}
+	return m, true
+}
+
+func (m model) latencyView() string {
+	t := m.latency
+	lines := []string{presetTitleStyle.Render(fmt.Sprintf("--- LATENCY TEST • %d clicks ---", len(t.runs)))}
+	if len(t.runs) == 0 {
+		lines = append(lines, presetTextStyle.Render("Listening for the first click…"))
+	} else {
+		stages := []struct {
+			name string
+			of   func(latencyRun) time.Duration
+		}{
+			{"Input", func(r latencyRun) time.Duration { return r.input }},
+			{"Callback", func(r latencyRun) time.Duration { return r.callback }},
+			{"Buffer", func(r latencyRun) time.Duration { return r.buffer }},
+			{"Driver", func(r latencyRun) time.Duration { return r.driver }},
+			{"Total", latencyRun.total},
+		}
+		rows := []string{fmt.Sprintf("%-9s %9s %9s %9s", "", "Last", "Average", "Worst")}
+		for _, st := range stages {
+			var sum, worst time.Duration
+			for _, r := range t.runs {
+				sum += st.of(r)
+				worst = max(worst, st.of(r))
+			}
+			last := st.of(t.runs[len(t.runs)-1])
+			rows = append(rows, fmt.Sprintf("%-9s %9s %9s %9s", st.name,
+				fineMillis(last), fineMillis(sum/time.Duration(len(t.runs))), fineMillis(worst)))
+		}
+		lines = append(lines, presetTextStyle.Render(lipgloss.JoinVertical(lipgloss.Left, rows...)))
+	}
+	lines = append(lines,
+		presetTextStyle.Render("Not counted: the terminal passing keys on, and the speakers."),
+		helpStyle.Render("F4/ESC: Close"))
+	return visStyle.Render(lipgloss.JoinVertical(lipgloss.Center, lines...))
+}
+
+// fineMillis is millis with a decimal, for the shorter stages.
+func fineMillis(d time.Duration) string {
+	return fmt.Sprintf("%.1f ms", d.Seconds()*1000)
+}
@@ -0,0 +1,52 @@
+package main
+
+import "math"
+
+// --- LAYERS ---
+//
+// An instrument can stack a second oscillator under the first, with its
+// own level, tuning and envelope: a bell strike that dies away over a
+// sustaining pad, for example. Voices render the layer alongside the main
+// oscillator and only finish once both envelopes have.
+
+type Layer struct {
+	Osc       func() Oscillator
+	Level     float64
+	Detune    float64 // cents, relative to the note
+	AttackMs  float64
+	DecayMs   float64 // time to fall to Sustain while the key is held
+	Sustain   float64 // 0..1 of the note's velocity
+	ReleaseMs float64
+}
+
+// layerVoice is a Layer's running state inside a voice.
+type layerVoice struct {
+	*Layer
+	osc Oscillator
+	env adsr
+}
+
+func newLayerVoice(l *Layer) *layerVoice {
+	if l == nil {
+		return nil
+	}
+	return &layerVoice{Layer: l, osc: l.Osc(), env: newADSR()}
+}
+
+// restart readies a recycled voice's layer for a new note on l.
+func (lv *layerVoice) restart(l *Layer) {
+	lv.Layer, lv.env = l, newADSR()
+}
+
+// next renders one sample of the layer at the voice's current pitch and
+// velocity, moving its envelope along.
+func (lv *layerVoice) next(freq, velocity float64, releasing bool) float64 {
+	env := lv.env.next(lv.AttackMs, lv.DecayMs, lv.Sustain, lv.ReleaseMs, releasing)
+	freq *= math.Exp2(lv.Detune / 1200)
+	return lv.osc.NextSample(freq, float64(sampleRate)) * env * velocity * lv.Level
+}
+
+// silent reports whether the layer has faded out (or there is none).
+func (lv *layerVoice) silent() bool {
+	return lv == nil || lv.env.done()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"math"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// --- LAYOUT ---
+//
+// The panel fits itself to the window. The header's badges flow onto more
+// lines and the help wraps, and a panel wider than the window is wrapped
+// too. When the full layout still doesn't fit, as in an 80×24 terminal,
+// the compact one takes over: a line of key cells for each row instead of
+// boxes, a shorter visualizer with as many bars as there's room for, no
+// preset bar, and only the main keys in the help. F1 lists every key in
+// either.
+
+const compactKeyWidth = 8
+
+type layout struct {
+	compact bool
+	width   int // inside the panel's frame
+}
+
+// arrange lays the panel out for the window, full size if it fits.
+func (m model) arrange() (layout, []string) {
+	l := layout{width: m.width - panelStyle.GetHorizontalFrameSize()}
+	if l.width >= numBars*2 {
+		sections := m.sections(l)
+		if lipgloss.Height(l.frame().Render(lipgloss.JoinVertical(lipgloss.Center, sections...))) <= m.height {
+			return l, sections
+		}
+	}
+	l = layout{compact: true}
+	l.width = max(compactKeyWidth, m.width-l.frame().GetHorizontalFrameSize())
+	return l, m.sections(l)
+}
+
+// frame is the border and padding round the panel.
+func (l layout) frame() lipgloss.Style {
+	if l.compact {
+		return panelStyle.Padding(0, 1)
+	}
+	return panelStyle
+}
+
+// vis is the visualizer's style, with less room under it when compact.
+func (l layout) vis() lipgloss.Style {
+	if l.compact {
+		return visStyle.MarginBottom(1)
+	}
+	return visStyle
+}
+
+// key is a key's style in the layout: the compact one drops the box.
+func (l layout) key(s lipgloss.Style) lipgloss.Style {
+	if l.compact {
+		return s.UnsetBorderStyle().Width(compactKeyWidth).Height(1)
+	}
+	return s
+}
+
+// keyWidth is how wide a key is drawn, box and all.
+func (l layout) keyWidth() int {
+	return lipgloss.Width(l.key(keyStyle).Render("x\nx"))
+}
+
+// flow lays items out left to right with sep between them, starting a new
+// line when the next doesn't fit in width.
+func flow(items []string, sep string, width int) string {
+	var lines, line []string
+	at := 0
+	for _, item := range items {
+		w := lipgloss.Width(item)
+		if len(line) > 0 && at+len(sep)+w > width {
+			lines = append(lines, lipgloss.JoinHorizontal(lipgloss.Center, line...))
+			line, at = nil, 0
+		}
+		if len(line) > 0 {
+			line = append(line, sep)
+			at += len(sep)
+		}
+		line = append(line, item)
+		at += w
+	}
+	lines = append(lines, lipgloss.JoinHorizontal(lipgloss.Center, line...))
+	return lipgloss.JoinVertical(lipgloss.Center, lines...)
+}
+
+// fit keeps a panel within width: text is wrapped, while drawings like
+// the staff and piano roll, which wrapping would scramble, are cut off.
+func fit(s string, width int, wrap bool) string {
+	if lipgloss.Width(s) <= width {
+		return s
+	}
+	if wrap {
+		return lipgloss.NewStyle().Width(width).Render(s)
+	}
+	return lipgloss.NewStyle().MaxWidth(width).Render(s)
+}
+
+// squeeze narrows the spectrum to n bars, each the loudest of those it
+// covers.
+func squeeze(bars []float64, n int) []float64 {
+	if n >= len(bars) || n <= 0 {
+		return bars
+	}
+	out := make([]float64, n)
+	for i, v := range bars {
+		j := i * n / len(bars)
+		out[j] = math.Max(out[j], v)
+	}
+	return out
+}
+
+func (m model) handleKeysKey(msg tea.KeyMsg) (model, bool) {
+	switch msg.Type {
+	case tea.KeyF1, tea.KeyEscape:
+		m.showKeys = false
+		return m, true
+	}
+	return m, false
+}
+
+// keysView lists every key, for when the help under the keyboard is cut
+// short.
+func (m model) keysView() string {
+	width := min(numBars*2, m.width-panelStyle.GetHorizontalFrameSize())
+	return visStyle.MarginBottom(1).Render(lipgloss.JoinVertical(lipgloss.Center,
+		presetTitleStyle.UnsetMarginTop().UnsetMarginBottom().Render("--- KEYS ---"),
+		presetTextStyle.Render(flow(strings.Split(mainHelp, helpSep), " • ", width)),
+		helpStyle.UnsetMarginTop().Render("ESC/F1: Close")))
+}
@@ -0,0 +1,504 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// --- LESSONS ---
+//
+// F3 opens a course of lessons, from single notes in the right hand to
+// both hands together. Each lesson is a list of steps, the notes of a
+// step played together, on the beat of its tempo after a bar of count-in.
+// The keys to play next are lit on the keyboard, and the lesson waits for
+// them. A lesson is passed when enough steps were played cleanly and on
+// the beat, and passing one unlocks the next. Lessons are JSON: the
+// built-in course is below, and files in ~/.config/piango/lessons/ are
+// added after it, in name order. What's been passed is kept in
+// ~/.config/piango/lessons.json.
+
+// onBeat is how far from its beat a step can land and still be on time,
+// as a share of the beat.
+const onBeat = 0.25
+
+type passCriteria struct {
+	Accuracy float64 `json:"accuracy"` // share of steps played without a wrong note
+	Timing   float64 `json:"timing"`   // share of steps played on the beat
+}
+
+type lesson struct {
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Tempo       float64      `json:"tempo"` // a step a beat
+	Steps       [][]string   `json:"steps"` // notes to play together, as in "Do4"
+	Pass        passCriteria `json:"pass"`
+
+	path string
+	keys [][]string // the steps as keyboard keys
+}
+
+var builtinLessons = []lesson{
+	{
+		Name:        "First Notes",
+		Description: "Do, Re and Mi in the right hand: the A, S and D keys.",
+		Tempo:       60,
+		Steps:       [][]string{{"Do4"}, {"Re4"}, {"Mi4"}, {"Re4"}, {"Do4"}, {"Re4"}, {"Mi4"}, {"Mi4"}, {"Re4"}, {"Do4"}},
+		Pass:        passCriteria{Accuracy: 0.8, Timing: 0.4},
+	},
+	{
+		Name:        "Five Fingers",
+		Description: "Up and down the five notes under the right hand, Do to Sol.",
+		Tempo:       70,
+		Steps:       [][]string{{"Do4"}, {"Re4"}, {"Mi4"}, {"Fa4"}, {"Sol4"}, {"Fa4"}, {"Mi4"}, {"Re4"}, {"Do4"}},
+		Pass:        passCriteria{Accuracy: 0.85, Timing: 0.5},
+	},
+	{
+		Name:        "Skips",
+		Description: "Jumping over a note at a time: Do, Mi, Sol and back.",
+		Tempo:       70,
+		Steps:       [][]string{{"Do4"}, {"Mi4"}, {"Sol4"}, {"Mi4"}, {"Do4"}, {"Re4"}, {"Fa4"}, {"Re4"}, {"Mi4"}, {"Sol4"}, {"Do4"}},
+		Pass:        passCriteria{Accuracy: 0.85, Timing: 0.5},
+	},
+	{
+		Name:        "Left Hand",
+		Description: "The same five notes an octave down, on the bottom row.",
+		Tempo:       70,
+		Steps:       [][]string{{"Do3"}, {"Re3"}, {"Mi3"}, {"Fa3"}, {"Sol3"}, {"Fa3"}, {"Mi3"}, {"Re3"}, {"Do3"}},
+		Pass:        passCriteria{Accuracy: 0.85, Timing: 0.5},
+	},
+	{
+		Name:        "Hands in Octaves",
+		Description: "Both hands together, the same note on the bottom and middle rows.",
+		Tempo:       60,
+		Steps:       [][]string{{"Do3", "Do4"}, {"Re3", "Re4"}, {"Mi3", "Mi4"}, {"Fa3", "Fa4"}, {"Sol3", "Sol4"}, {"Mi3", "Mi4"}, {"Do3", "Do4"}},
+		Pass:        passCriteria{Accuracy: 0.8, Timing: 0.5},
+	},
+	{
+		Name:        "Tune Over a Bass",
+		Description: "A tune in the right hand, the left hand joining it on the first of each bar.",
+		Tempo:       80,
+		Steps: [][]string{{"Do3", "Mi4"}, {"Re4"}, {"Do4"}, {"Re4"}, {"Do3", "Mi4"}, {"Mi4"}, {"Mi4"}, {"Mi4"},
+			{"Sol3", "Re4"}, {"Re4"}, {"Re4"}, {"Re4"}, {"Do3", "Mi4"}, {"Sol4"}, {"Sol4"}, {"Sol4"}},
+		Pass: passCriteria{Accuracy: 0.8, Timing: 0.6},
+	},
+	{
+		Name:        "Chords",
+		Description: "Three-note chords in the right hand over their root in the left: Do, Fa, Sol, Do.",
+		Tempo:       60,
+		Steps: [][]string{{"Do3", "Do4", "Mi4", "Sol4"}, {"Fa3", "Fa4", "La4", "Do5"},
+			{"Sol3", "Sol4", "Si4", "Re5"}, {"Do3", "Do4", "Mi4", "Sol4"}},
+		Pass: passCriteria{Accuracy: 0.75, Timing: 0.5},
+	},
+}
+
+type lessonResult struct {
+	Accuracy float64 `json:"accuracy"`
+	Timing   float64 `json:"timing"`
+}
+
+type lessonProgress struct {
+	Passed map[string]lessonResult `json:"passed"` // by lesson name
+}
+
+type lessonMode struct {
+	open      bool
+	lessons   []lesson
+	progress  lessonProgress
+	cursor    int
+	running   bool
+	due       time.Time // when the first step is
+	beat      time.Duration
+	step      int
+	hit       map[string]bool // keys of the step played so far
+	wrong     bool            // a wrong note in this step
+	right     int             // steps played cleanly
+	onTime    int
+	metronome *Metronome
+	result    string
+}
+
+func lessonsDir() string {
+	dir := configDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "lessons")
+}
+
+func lessonProgressPath() string {
+	dir := configDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "lessons.json")
+}
+
+// readLessons reads the lesson files in dir, in name order.
+func readLessons(dir string) ([]lesson, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && filepath.Ext(e.Name()) == ".json" {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+
+	var ls []lesson
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		l := lesson{Tempo: 60, Pass: passCriteria{Accuracy: 0.8, Timing: 0.5}, path: path}
+		if err := json.Unmarshal(data, &l); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		ls = append(ls, l)
+	}
+	return ls, nil
+}
+
+// resolve checks a lesson over and finds its notes on the keyboard.
+func (l *lesson) resolve() error {
+	switch {
+	case strings.TrimSpace(l.Name) == "":
+		return errors.New("lesson has no name")
+	case len(l.Steps) == 0:
+		return fmt.Errorf("lesson %q has no steps", l.Name)
+	case l.Tempo < minBPM || l.Tempo > maxBPM:
+		return fmt.Errorf("lesson %q: tempo %g is outside %d-%d", l.Name, l.Tempo, minBPM, maxBPM)
+	}
+	l.keys = nil
+	for _, step := range l.Steps {
+		var keys []string
+		for _, name := range step {
+			key, ok := keyForNote(name)
+			if !ok {
+				return fmt.Errorf("lesson %q: %q isn't on the keyboard (Do3 to Si5)", l.Name, name)
+			}
+			keys = append(keys, key)
+		}
+		if len(keys) == 0 {
+			return fmt.Errorf("lesson %q has an empty step", l.Name)
+		}
+		l.keys = append(l.keys, keys)
+	}
+	return nil
+}
+
+// keyForNote finds a note such as "Sol3" on the keyboard, with no octave
+// shift: the bottom row is octave 3, the top row octave 5.
+func keyForNote(name string) (string, bool) {
+	for row, notes := range sortedRows {
+		for _, n := range notes {
+			if name == fmt.Sprintf("%s%d", n.Name, 5-row) {
+				return n.Key, true
+			}
+		}
+	}
+	return "", false
+}
+
+func loadLessonProgress(path string) (lessonProgress, error) {
+	p := lessonProgress{Passed: make(map[string]lessonResult)}
+	if path == "" {
+		return p, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return p, nil
+	}
+	if err != nil {
+		return p, err
+	}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return p, fmt.Errorf("%s: %w", path, err)
+	}
+	if p.Passed == nil {
+		p.Passed = make(map[string]lessonResult)
+	}
+	return p, nil
+}
+
+func saveLessonProgress(path string, p lessonProgress) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+func (m model) openLessons() model {
+	if currentKeyboard != kbSolfege {
+		return m.needSolfege("Lessons")
+	}
+	fail := func(err error) model {
+		m.notification = fmt.Sprintf("Lessons: %v", err)
+		m.notifyClearTime = time.Now().Add(4 * time.Second)
+		return m
+	}
+	user, err := readLessons(lessonsDir())
+	if err != nil {
+		return fail(err)
+	}
+	progress, err := loadLessonProgress(lessonProgressPath())
+	if err != nil {
+		return fail(err)
+	}
+	lessons := append(append([]lesson(nil), builtinLessons...), user...)
+	for i := range lessons {
+		if err := lessons[i].resolve(); err != nil {
+			if lessons[i].path != "" {
+				err = fmt.Errorf("%s: %w", lessons[i].path, err)
+			}
+			return fail(err)
+		}
+	}
+
+	m.lesson = lessonMode{open: true, lessons: lessons, progress: progress}
+	// Start at the first lesson not yet passed
+	for m.lesson.cursor < len(lessons)-1 && m.lesson.passed(m.lesson.cursor) {
+		m.lesson.cursor++
+	}
+	return m
+}
+
+func (m model) closeLessons() model {
+	m.lesson.stopMetronome()
+	m.lesson = lessonMode{}
+	return m
+}
+
+func (lm *lessonMode) passed(i int) bool {
+	_, ok := lm.progress.Passed[lm.lessons[i].Name]
+	return ok
+}
+
+// unlocked reports whether a lesson can be taken: the first always can,
+// and each after it once the one before is passed.
+func (lm *lessonMode) unlocked(i int) bool {
+	return i == 0 || lm.passed(i-1)
+}
+
+func (lm *lessonMode) start(now time.Time) {
+	l := lm.lessons[lm.cursor]
+	lm.stopMetronome()
+	lm.beat = time.Duration(60 / l.Tempo * float64(time.Second))
+	lm.due = now.Add(lm.beat * beatsInBar)
+	lm.running, lm.step, lm.right, lm.onTime, lm.result = true, 0, 0, 0, ""
+	lm.hit, lm.wrong = make(map[string]bool), false
+	lm.metronome = newMetronome(l.Tempo)
+	playDry(lm.metronome)
+}
+
+func (lm *lessonMode) stopMetronome() {
+	if lm.metronome == nil {
+		return
+	}
+	output.Lock()
+	lm.metronome.stopped = true
+	output.Unlock()
+	lm.metronome = nil
+}
+
+// expects reports whether a key is one to play next, for the keyboard.
+func (lm *lessonMode) expects(key string) bool {
+	if !lm.open || !lm.running {
+		return false
+	}
+	for _, k := range lm.lessons[lm.cursor].keys[lm.step] {
+		if k == key && !lm.hit[k] {
+			return true
+		}
+	}
+	return false
+}
+
+// lessonHit takes a key struck during a lesson.
+func (m model) lessonHit(key string, now time.Time) model {
+	lm := &m.lesson
+	if !lm.open || !lm.running {
+		return m
+	}
+	l := lm.lessons[lm.cursor]
+	if !lm.expects(key) {
+		if !lm.hit[key] {
+			lm.wrong = true
+		}
+		return m
+	}
+	lm.hit[key] = true
+	if len(lm.hit) < len(l.keys[lm.step]) {
+		return m
+	}
+
+	// The step is done; it's on time if it was finished near its beat
+	due := lm.due.Add(lm.beat * time.Duration(lm.step))
+	if off := now.Sub(due); max(off, -off) <= time.Duration(onBeat*float64(lm.beat)) {
+		lm.onTime++
+	}
+	if !lm.wrong {
+		lm.right++
+	}
+	lm.step++
+	lm.hit, lm.wrong = make(map[string]bool), false
+	if lm.step < len(l.keys) {
+		return m
+	}
+	return m.finishLesson()
+}
+
+// finishLesson marks the lesson passed or not and saves the progress.
+func (m model) finishLesson() model {
+	lm := &m.lesson
+	l := lm.lessons[lm.cursor]
+	lm.running = false
+	lm.stopMetronome()
+
+	r := lessonResult{
+		Accuracy: float64(lm.right) / float64(len(l.keys)),
+		Timing:   float64(lm.onTime) / float64(len(l.keys)),
+	}
+	score := fmt.Sprintf("%.0f%% clean, %.0f%% on the beat", r.Accuracy*100, r.Timing*100)
+	m.practice.scored("Lessons", int(100*(r.Accuracy+r.Timing)/2), 100)
+	if r.Accuracy < l.Pass.Accuracy || r.Timing < l.Pass.Timing {
+		lm.result = fmt.Sprintf("✗ Not yet: %s (needs %.0f%% and %.0f%%)", score, l.Pass.Accuracy*100, l.Pass.Timing*100)
+		return m
+	}
+
+	newly := !lm.passed(lm.cursor)
+	if best, ok := lm.progress.Passed[l.Name]; !ok || r.Accuracy+r.Timing > best.Accuracy+best.Timing {
+		lm.progress.Passed[l.Name] = r
+	}
+	lm.result = "✓ Passed: " + score
+	if newly && lm.cursor+1 < len(lm.lessons) {
+		lm.result += fmt.Sprintf(". Unlocked %q", lm.lessons[lm.cursor+1].Name)
+	}
+	if err := saveLessonProgress(lessonProgressPath(), lm.progress); err != nil {
+		m.notification = fmt.Sprintf("Lessons: %v", err)
+		m.notifyClearTime = time.Now().Add(4 * time.Second)
+	}
+	return m
+}
+
+// handleLessonKey takes the lesson list's keys; note keys fall through
+// to play.
+func (m model) handleLessonKey(msg tea.KeyMsg) (model, bool) {
+	lm := &m.lesson
+	switch msg.Type {
+	case tea.KeyF3:
+		return m.closeLessons(), true
+	case tea.KeyEscape:
+		if lm.running {
+			lm.running = false
+			lm.stopMetronome()
+			lm.result = "Stopped"
+			return m, true
+		}
+		return m.closeLessons(), true
+	case tea.KeyUp:
+		if !lm.running && lm.cursor > 0 {
+			lm.cursor--
+			lm.result = ""
+		}
+	case tea.KeyDown:
+		if !lm.running && lm.cursor < len(lm.lessons)-1 {
+			lm.cursor++
+			lm.result = ""
+		}
+	case tea.KeyEnter:
+		switch {
+		case lm.running:
+		case !lm.unlocked(lm.cursor):
+			lm.result = "Pass the lesson before it to unlock this one"
+		default:
+			lm.start(time.Now())
+		}
+	default:
+		return m, false
+	}
+	return m, true
+}
+
+func (m model) lessonsView() string {
+	lm := m.lesson
+	passed := 0
+	for i := range lm.lessons {
+		if lm.passed(i) {
+			passed++
+		}
+	}
+	title := fmt.Sprintf("--- LESSONS • %d of %d passed ---", passed, len(lm.lessons))
+	lines := []string{presetTitleStyle.Render(title)}
+	l := lm.lessons[lm.cursor]
+
+	if lm.running {
+		status := fmt.Sprintf("%s • Step %d of %d • Next: %s", l.Name, lm.step+1, len(l.Steps), strings.Join(l.Steps[lm.step], " + "))
+		if now := time.Now(); now.Before(lm.due) {
+			status = fmt.Sprintf("%s • Count-in %d", l.Name, int(lm.due.Sub(now)/lm.beat)+1)
+		}
+		lines = append(lines, instStyle.UnsetMarginBottom().Render(status),
+			presetTextStyle.Render(fmt.Sprintf("Clean %d  •  On the beat %d", lm.right, lm.onTime)),
+			helpStyle.UnsetMarginTop().Render("Play the lit keys  •  ESC: Stop"))
+		return visStyle.Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+	}
+
+	selStyle := notifyStyle.UnsetMarginBottom().UnsetPadding()
+	for i, ls := range lm.lessons {
+		mark := "  "
+		switch {
+		case lm.passed(i):
+			mark = "✓ "
+		case !lm.unlocked(i):
+			mark = "· "
+		}
+		line := fmt.Sprintf("%s%d. %s", mark, i+1, ls.Name)
+		switch {
+		case i == lm.cursor:
+			lines = append(lines, selStyle.Render(line))
+		case !lm.unlocked(i):
+			lines = append(lines, presetTitleStyle.UnsetMarginTop().UnsetMarginBottom().Render(line))
+		default:
+			lines = append(lines, presetTextStyle.Render(line))
+		}
+	}
+
+	about := fmt.Sprintf("%s %.0f BPM, passed at %.0f%% clean and %.0f%% on the beat.",
+		l.Description, l.Tempo, l.Pass.Accuracy*100, l.Pass.Timing*100)
+	if best, ok := lm.progress.Passed[l.Name]; ok {
+		about += fmt.Sprintf(" Best: %.0f%% and %.0f%%.", best.Accuracy*100, best.Timing*100)
+	}
+	lines = append(lines, "", presetTextStyle.Width(numBars*2).Render(about))
+	if lm.result != "" {
+		lines = append(lines, instStyle.UnsetMarginBottom().Render(lm.result))
+	}
+	lines = append(lines, helpStyle.UnsetMarginTop().Render("↑/↓: Pick  •  ENTER: Start  •  ESC/F3: Close"))
+	return visStyle.Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gopxl/beep/v2"
+)
+
+// --- LOW LATENCY ---
+//
+// -low-latency, or "low_latency" in the config, trades headroom for a
+// quicker response. The buffer is cut to 10 ms, or to buffer_ms if that's
+// smaller. The thread the sound is rendered on asks for a higher priority
+// the first time it runs: real-time scheduling on Linux where that's
+// allowed, or a lower nice value, and time-critical on Windows. Every
+// instrument renders a silent voice before the first key, so the first
+// note isn't the one paying for memory and code being paged in. Then the
+// latency achieved, from a note being struck to its sound leaving the
+// backend, is measured and shown. A buffer this small can underrun on a
+// busy machine; -auto-buffer lets it grow when it does.
+
+const (
+	lowLatencyBuffer = 10 * time.Millisecond
+	latencyProbes    = 5
+)
+
+// prewarmVoices renders a silent block from each instrument.
+func prewarmVoices() {
+	buf := make([][2]float64, outputBuffer)
+	for i := range instruments {
+		newVoice(instruments[i], 440, 0, false).Stream(buf)
+	}
+}
+
+// measureLatency strikes silent probes and times the longest any waits for
+// the render callback, adding what the backend holds after it. It's zero if
+// the callback never comes.
+func measureLatency() time.Duration {
+	var worst time.Duration
+	for range latencyProbes {
+		heard := make(chan time.Duration, 1)
+		start := time.Now()
+		output.Play(beep.StreamerFunc(func([][2]float64) (int, bool) {
+			select {
+			case heard <- time.Since(start):
+			default:
+			}
+			return 0, false
+		}))
+		select {
+		case lag := <-heard:
+			worst = max(worst, lag)
+		case <-time.After(time.Second):
+			return 0
+		}
+	}
+	return worst + output.Latency()
+}
+
+// lowLatency warms the engine up and reports what it achieved.
+func (m model) lowLatency() model {
+	prewarmVoices()
+	latency := measureLatency()
+	output.Lock()
+	priority := mainOut.priority
+	output.Unlock()
+	m.notification = fmt.Sprintf("Low latency: %s from key to sound (buffer %s, %s priority)",
+		millis(latency), millis(sampleRate.D(outputBuffer)), priority)
+	m.notifyClearTime = time.Now().Add(5 * time.Second)
+	return m
+}
@@ -1,40 +1,104 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"math"
-	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/gopxl/beep/v2"
-	"github.com/gopxl/beep/v2/speaker"
 )
 
-type Oscillator func(phase float64) float64
-
 type Instrument struct {
-	Name string
-	Osc  Oscillator
-}
-
-var instruments = []Instrument{
-	{Name: "Electric Piano", Osc: oscPiano},
-	{Name: "Retro Square", Osc: oscSquare},
-	{Name: "FM Metallic", Osc: oscFM},
-	{Name: "Distorted Lead", Osc: oscDistortion},
-	{Name: "Glass Bell", Osc: oscBell},
-	{Name: "Cyberpunk Crunch", Osc: oscBitcrush},
-	{Name: "Alien Ring Mod", Osc: oscAlien},
-	{Name: "Hollow Choir", Osc: oscGhost},
-	{Name: "Acid Wavefolder", Osc: oscWavefolder},
-	{Name: "808 Sub Bass", Osc: oscSubBass},
-	{Name: "PWM Pad", Osc: oscPWM},
-	{Name: "Accordion", Osc: oscAccordion},
-	{Name: "Noise", Osc: oscNoise},
+	Name  string
+	Osc   func() Oscillator
+	Patch Patch
+
+	// Sub is an optional oscillator played an octave down under Osc, at
+	// the patch's SubLevel. Patches can add one to any instrument, in
+	// which case it's a plain sine.
+	Sub func() Oscillator
+
+	// FM, when set, plays an operator patch in place of Osc
+	FM *FM
+
+	// Partials, when set, makes the instrument additive: one level per
+	// harmonic, fundamental first, played in place of Osc
+	Partials []float64
+
+	// Layer is an optional second oscillator with its own envelope
+	Layer *Layer
+
+	// Mod adds modulation routes on top of the patch's built-in ones
+	Mod []ModRoute
+
+	// Kit instruments play a drum per key instead of pitched notes
+	Kit bool
+
+	// Humanize (0..1) adds small random gain and onset variation to live
+	// notes so repeated keypresses don't sound machine-identical.
+	Humanize float64
+
+	// base is the built-in instrument a user preset was made from
+	base string
+}
+
+// instruments holds pointers so voices and the pool can keep hold of one
+// while user presets are added to the list.
+var instruments = []*Instrument{
+	{Name: "Electric Piano", Osc: wave(oscPiano), Humanize: 0.3},
+	{Name: "Retro Square", Osc: wave(oscSquare), Sub: wave(oscSquare)},
+	{Name: "FM Metallic", FM: &FM{Algorithm: fmStack, Ops: []FMOperator{
+		{Ratio: 1, Level: 1, Sustain: 1},
+		{Ratio: 3.14, Level: 2, Sustain: 1},
+	}}},
+	{Name: "Distorted Lead", Osc: wave(oscDistortion), Patch: oversampledPatch(0)},
+	{Name: "Glass Bell", Osc: wave(oscBell), Humanize: 0.2},
+	{Name: "Cyberpunk Crunch", Osc: wave(oscBitcrush), Sub: wave(oscSine)},
+	{Name: "Alien Ring Mod", Osc: wave(oscAlien)},
+	{Name: "Hollow Choir", Osc: wave(oscGhost), Humanize: 0.2},
+	{Name: "Acid Wavefolder", Osc: wave(oscWavefolder), Sub: wave(oscSine), Patch: oversampledPatch(0.5)},
+	{Name: "808 Sub Bass", Osc: wave(oscSubBass)},
+	{Name: "PWM Pad", Osc: newPWM},
+	{Name: "Accordion", Osc: wave(oscAccordion), Humanize: 0.3},
+	{Name: "Noise", Osc: newWhiteNoise},
+	{Name: "Pink Noise", Osc: newPinkNoise},
+	{Name: "Wind", Osc: newBrownNoise, Patch: windPatch(), Mod: []ModRoute{
+		{Source: "lfo1", Dest: "cutoff", Amount: 1},
+	}},
+	{Name: "Drum Kit", Osc: wave(oscSine), Kit: true},
+	{Name: "Bell Pad", Osc: wave(oscGhost), Layer: &Layer{
+		Osc: wave(oscBell), Level: 1.2, Detune: 1200,
+		AttackMs: 1, DecayMs: 900, Sustain: 0, ReleaseMs: 300,
+	}},
+	{Name: "DX Piano", Humanize: 0.3, FM: &FM{Algorithm: fmPairs, Ops: []FMOperator{
+		{Ratio: 1, Level: 1, AttackMs: 1, DecayMs: 3000, Sustain: 0.2, ReleaseMs: 300},
+		{Ratio: 14, Level: 0.6, AttackMs: 1, DecayMs: 250, ReleaseMs: 100},
+		{Ratio: 1, Detune: 3, Level: 0.8, AttackMs: 1, DecayMs: 2500, Sustain: 0.3, ReleaseMs: 300},
+		{Ratio: 1, Level: 1.5, AttackMs: 1, DecayMs: 1500, Sustain: 0.2, ReleaseMs: 300},
+	}}},
+	{Name: "FM Bell", FM: &FM{Algorithm: fmY, Feedback: 0.3, Ops: []FMOperator{
+		{Ratio: 1, Level: 1, AttackMs: 1, DecayMs: 4000, ReleaseMs: 800},
+		{Ratio: 3.5, Level: 2.5, AttackMs: 1, DecayMs: 2000, ReleaseMs: 800},
+		{Ratio: 7.07, Level: 1.2, AttackMs: 1, DecayMs: 600, ReleaseMs: 400},
+		{Ratio: 1.41, Level: 0.8, AttackMs: 1, DecayMs: 1200, ReleaseMs: 400},
+	}}},
+	{Name: "Additive Organ", Partials: []float64{
+		1, 0.8, 0.6, 0.4, 0.3, 0.2, 0, 0.15, 0, 0, 0, 0.1, 0, 0, 0, 0.05,
+	}},
+	{Name: "Layered Keys", Osc: wave(oscPiano), Humanize: 0.3, Layer: &Layer{
+		Osc: newPWM, Level: 0.5, Detune: 7,
+		AttackMs: 400, DecayMs: 1, Sustain: 1, ReleaseMs: 600,
+	}},
 }
 
 // Default mappings for the 1-0 keys to the first 10 instruments
@@ -50,6 +114,10 @@ func oscPiano(p float64) float64 {
 	return (v1 + v2 + v3) * 0.15
 }
 
+func oscSine(p float64) float64 {
+	return math.Sin(p) * 0.2
+}
+
 func oscSquare(p float64) float64 {
 	if math.Sin(p) >= 0 {
 		return 0.1
@@ -57,11 +125,6 @@ func oscSquare(p float64) float64 {
 	return -0.1
 }
 
-func oscFM(p float64) float64 {
-	modulator := math.Sin(p*3.14) * 2.0
-	return math.Sin(p+modulator) * 0.2
-}
-
 func oscDistortion(p float64) float64 {
 	val := math.Sin(p) * 5.0
 	if val > 1.0 {
@@ -112,17 +175,6 @@ func oscSubBass(p float64) float64 {
 	return math.Tanh(val) * 0.3
 }
 
-func oscPWM(p float64) float64 {
-	norm := p / (2 * math.Pi)
-	saw1 := 2.0*norm - 1.0
-
-	p2 := math.Mod(p+1.5, 2*math.Pi)
-	norm2 := p2 / (2 * math.Pi)
-	saw2 := 2.0*norm2 - 1.0
-
-	return (saw1 - saw2) * 0.1
-}
-
 func oscAccordion(p float64) float64 {
 	v1 := math.Sin(p)
 	v2 := math.Sin(p*2.0) * 0.5
@@ -132,74 +184,266 @@ func oscAccordion(p float64) float64 {
 	return (v1 + v2 + v3 + v4 + v5) * 0.15
 }
 
-func oscNoise(p float64) float64 {
-	rand.NewSource(int64(p))
-	return (rand.Float64()*2.0 - 1.0) * 0.1
-}
-
 var (
-	mixer         = &beep.Mixer{}
 	sampleRate    = beep.SampleRate(44100)
 	voiceLock     sync.Mutex
 	voices        = make(map[string]*ActiveVoice)
 	currentInstID = 0
+	lastNoteFreq  float64 // where the next portamento slide starts, under voiceLock
+	vibratoOn     = false // read by voices, changed under output.Lock()
 )
 
 type ActiveVoice struct {
 	streamer *SynthStreamer
 	lastSeen time.Time
 	staccato bool
+	freq     float64
+	locked   bool // held on purpose, the watchdog leaves it alone
+	released bool // sent a release, see voicebank.go
+
+	inst *Instrument // what the streamer was built for, see pool.go
+	sent uint64      // the queue's tail after its last command
+	seen uint64      // the pool's last sweep that found it in voices
 }
 
 type SynthStreamer struct {
-	freq       float64
-	phase      float64
-	vol        float64
-	osc        Oscillator
-	decaySpeed float64
-	releasing  bool
-	finished   bool
+	freq      float64
+	target    float64
+	glide     float64
+	oscs      [maxUnison]Oscillator
+	sub       Oscillator
+	fm        *fmVoice
+	layer     *layerVoice
+	drum      *drumHit // set for kit instruments, replaces the oscillators
+	hit       drumHit  // where drum points, so a hit needn't be allocated
+	crush     crusher
+	filter    svf
+	aa        [maxUnison]decimator
+	cutoff    float64 // follows the patch cutoff, smoothed
+	mod       modulator
+	subLevel  float64
+	bend      float64 // semitones, follows pitchBend
+	vol       float64
+	velocity  float64 // envelope peak, 0..1
+	delay     int     // samples of silence before the note starts
+	patch     *Patch  // shared with the instrument so edits apply live
+	level     float64
+	staccato  bool
+	releasing bool
+	choked    bool    // fading out, see declick.go
+	fade      float64 // its gain meanwhile
+	fadeStep  float64 // per sample
+	finished  bool
+	ended     atomic.Bool // finished, for the UI's side of voicebank.go
+	struck    time.Time   // when its key was played, until it's first streamed
+}
+
+// newVoice builds a streamer for inst. Callers start it in bank.
+func newVoice(inst *Instrument, freq, velocity float64, staccato bool) *SynthStreamer {
+	s := &SynthStreamer{}
+	s.setup(inst, freq, velocity, staccato)
+	return s
+}
+
+// setup readies s for a note on inst. A voice recycled from an earlier
+// note on the same instrument keeps its oscillators, which carry on from
+// where they stopped, and its FM operators, layer and routes; whatever it
+// lacks is built.
+func (s *SynthStreamer) setup(inst *Instrument, freq, velocity float64, staccato bool) {
+	oscs, sub, fm, layer, mod := s.oscs, s.sub, s.fm, s.layer, s.mod
+	*s = SynthStreamer{
+		freq:     freq,
+		target:   freq,
+		velocity: velocity,
+		sub:      sub,
+		mod:      mod,
+		patch:    &inst.Patch,
+		level:    inst.Patch.Level,
+		cutoff:   inst.Patch.Cutoff,
+		staccato: staccato,
+	}
+	if s.sub == nil {
+		s.sub = newSub(inst)
+	}
+	if inst.FM != nil {
+		if s.fm = fm; s.fm == nil {
+			s.fm = newFMVoice(inst.FM)
+		} else {
+			s.fm.restart(inst.FM)
+		}
+	}
+	if inst.Layer != nil {
+		if s.layer = layer; s.layer == nil {
+			s.layer = newLayerVoice(inst.Layer)
+		} else {
+			s.layer.restart(inst.Layer)
+		}
+	}
+	s.mod.restart(inst)
+	if inst.FM == nil {
+		if s.oscs = oscs; s.oscs[0] == nil {
+			s.oscs = newUnisonOscs(inst)
+		}
+	}
+}
+
+// portamento makes s slide in from the last note played when the
+// instrument has a glide time. Call with voiceLock held.
+func portamento(inst *Instrument, s *SynthStreamer) {
+	if inst.Patch.GlideMs > 0 && lastNoteFreq > 0 {
+		s.freq = lastNoteFreq
+		s.glide = glideCoef(time.Duration(inst.Patch.GlideMs * float64(time.Millisecond)))
+	}
+	lastNoteFreq = s.target
 }
 
 func (s *SynthStreamer) Stream(samples [][2]float64) (n int, ok bool) {
-	const twoPi = 2 * math.Pi
-	step := s.freq * twoPi / float64(sampleRate)
+	if !s.struck.IsZero() {
+		mainOut.load.noteLag = time.Since(s.struck)
+		s.struck = time.Time{}
+	}
+	if s.drum != nil {
+		return s.streamDrum(samples)
+	}
+
+	sr := float64(sampleRate)
+
+	// Envelope steps are scaled with the peak so times don't depend on velocity
+	p := s.patch
+	attackStep := s.velocity / math.Max(math.Max(p.AttackMs, declickMs)/1000*sr, 1)
+	release := p.ReleaseMs
+	if s.staccato {
+		release = p.StaccatoMs
+	}
+	decayStep := s.velocity / math.Max(math.Max(release, declickMs)/1000*sr, 1)
+	smooth := glideCoef(paramSmoothing)
 
-	attackSpeed := 0.1
+	rates := newModRates(p)
+	bendSmooth := glideCoef(bendGlide)
+	unison := newUnisonSpread(p)
+	basePan := pitchPan(s.freq)
+	panL, panR := panGains(basePan)
 
 	for i := range samples {
-		raw := s.osc(s.phase)
+		if s.delay > 0 {
+			s.delay--
+			samples[i] = [2]float64{}
+			continue
+		}
+
+		if s.glide > 0 && s.freq != s.target {
+			s.freq += (s.target - s.freq) * s.glide
+		}
+
+		mod := s.mod.next(s, p, &rates)
+
+		// Modulation and pitch bend, both in cents
+		s.bend += (pitchBend - s.bend) * bendSmooth
+		cents := mod.cents + s.bend*100
+
+		freq := s.freq
+		if cents != 0 {
+			freq *= math.Exp2(cents / 1200)
+		}
+
+		rawL, rawR := s.oscillate(freq, &unison)
+
+		var layer float64
+		if s.layer != nil {
+			layer = s.layer.next(freq, s.velocity, s.releasing)
+		}
 
 		if s.releasing {
-			s.vol -= s.decaySpeed
-			if s.vol <= 0 {
-				s.vol = 0
+			s.vol = math.Max(s.vol-decayStep, 0)
+			if s.vol <= 0 && s.layer.silent() {
 				s.finished = true
 				return i, false
 			}
 		} else {
-			if s.vol < 1.0 {
-				s.vol += attackSpeed
+			if s.vol < s.velocity {
+				s.vol = math.Min(s.vol+attackStep, s.velocity)
 			}
 		}
 
-		final := raw * s.vol
-		samples[i][0] = final
-		samples[i][1] = final
+		s.level += (p.Level - s.level) * smooth
+		s.subLevel += (p.SubLevel - s.subLevel) * smooth
+		s.cutoff += (p.Cutoff - s.cutoff) * smooth
 
-		s.phase += step
-		if s.phase >= twoPi {
-			s.phase -= twoPi
+		gain := s.level * mod.amp
+		l, r := panL, panR
+		if mod.pan != 0 {
+			l, r = panGains(math.Max(-1, math.Min(1, basePan+mod.pan)))
+		}
+		out := [2]float64{
+			(rawL*s.vol + layer) * gain * l,
+			(rawR*s.vol + layer) * gain * r,
+		}
+		cutoff := s.cutoff
+		if mod.octaves != 0 {
+			cutoff *= math.Exp2(mod.octaves)
+		}
+		if cutoff < filterMaxHz {
+			out = s.filter.lowpass(out, cutoff, p.Resonance)
+		}
+		samples[i] = s.crush.process(out, p.CrushBits, p.Decimate)
+		if s.faded(&samples[i]) {
+			return i, false
+		}
+	}
+	return len(samples), true
+}
+
+// glideCoef turns a glide time into the per-sample smoothing factor Stream
+// uses to move freq towards target.
+func glideCoef(d time.Duration) float64 {
+	if d <= 0 {
+		return 1
+	}
+	return 1 - math.Exp(-1/(d.Seconds()*float64(sampleRate)))
+}
+
+// streamDrum plays a one-shot hit through the patch level.
+func (s *SynthStreamer) streamDrum(samples [][2]float64) (n int, ok bool) {
+	panL, panR := panGains(pitchPan(s.freq))
+	for i := range samples {
+		if s.delay > 0 {
+			s.delay--
+			samples[i] = [2]float64{}
+			continue
+		}
+		v, more := s.drum.next()
+		if !more {
+			s.finished = true
+			return i, false
+		}
+		v *= s.velocity * s.patch.Level
+		samples[i] = s.crush.process([2]float64{v * panL, v * panR}, s.patch.CrushBits, s.patch.Decimate)
+		if s.faded(&samples[i]) {
+			return i, false
 		}
 	}
 	return len(samples), true
 }
 
 func (s *SynthStreamer) Err() error { return nil }
-func (s *SynthStreamer) Stop()      { s.releasing = true }
-func (s *SynthStreamer) Sustain()   { s.releasing = false; s.finished = false }
 
-func updateVoice(key string, freq float64, staccato bool) {
+// Stop releases the note. Drum hits are one-shots and play out anyway.
+func (s *SynthStreamer) Stop() {
+	if s.drum == nil {
+		s.releasing = true
+	}
+}
+
+func (s *SynthStreamer) Sustain() {
+	if s.drum == nil {
+		s.releasing = false
+		s.finished = false
+	}
+}
+
+// updateVoice starts a note, or keeps it sustained if key is only a
+// terminal auto-repeat. It reports whether a new note was struck.
+func updateVoice(key string, freq float64, staccato bool, velocity float64) bool {
 	voiceLock.Lock()
 	defer voiceLock.Unlock()
 
@@ -210,21 +454,37 @@ func updateVoice(key string, freq float64, staccato bool) {
 		if delta < 75*time.Millisecond {
 			v.lastSeen = now
 			v.staccato = staccato
-			v.streamer.Sustain()
-			return
+			v.sustain()
+			return false
 		}
-		v.streamer.Stop()
+		v.choke()
 	}
 
-	decay := 0.001
-	if staccato {
-		decay = 0.05
+	inst := keyInstrument(key)
+	if held := monoVoice(key, inst, freq); held != nil {
+		held.lastSeen = now
+		held.staccato = staccato
+		voices[key] = held
+		recordStrike(key, freq, velocity)
+		return true
 	}
 
-	inst := instruments[currentInstID]
-	s := &SynthStreamer{freq: freq, vol: 0, osc: inst.Osc, decaySpeed: decay}
-	voices[key] = &ActiveVoice{streamer: s, lastSeen: now, staccato: staccato}
-	mixer.Add(s)
+	velocity, delay := humanize(inst, velocity)
+
+	v := pool.get(inst, freq, velocity, staccato)
+	s := v.streamer
+	s.delay = delay
+	s.struck = now
+	if inst.Kit {
+		s.setDrum(key)
+	} else {
+		portamento(inst, s)
+	}
+	v.lastSeen, v.staccato, v.freq = now, staccato, freq
+	voices[key] = v
+	v.start()
+	recordStrike(key, freq, velocity)
+	return true
 }
 
 func checkWatchdog() {
@@ -234,51 +494,60 @@ func checkWatchdog() {
 	now := time.Now()
 
 	for k, v := range voices {
+		if v.locked {
+			continue
+		}
+
 		threshold := 600 * time.Millisecond
 		if v.staccato {
 			threshold = 100 * time.Millisecond
 		}
 
 		if now.Sub(v.lastSeen) > threshold {
-			v.streamer.Stop()
-			if v.streamer.finished {
+			v.release()
+			if v.finished() {
 				delete(voices, k)
 			}
 		}
 	}
+	pool.sweep()
 }
 
 type Note struct {
 	Key, Name string
 	Freq      float64
+	Row       int
 }
 
 var noteMap = map[string]Note{}
-var sortedRows [3][]Note
 
+// noteKey is the keyboard key behind a voice key: remote players and SSH
+// sessions prefix theirs ("jam:alex:a", "ssh:2:a") to keep them apart.
+func noteKey(key string) string {
+	return key[strings.LastIndex(key, ":")+1:]
+}
+
+var sortedRows [][]Note
+
+// initNotes builds the keys of the current keyboard layout, see
+// keyboard.go.
 func initNotes() {
 	getFreq := func(n int) float64 {
 		return 440.0 * math.Pow(2.0, float64(n)/12.0)
 	}
 
-	rows := [][]struct {
-		k, n string
-		s    int
-	}{
-		{{"q", "Do", 3}, {"w", "Re", 5}, {"e", "Mi", 7}, {"r", "Fa", 8}, {"t", "Sol", 10}, {"y", "La", 12}, {"u", "Si", 14}},
-		{{"a", "Do", -9}, {"s", "Re", -7}, {"d", "Mi", -5}, {"f", "Fa", -4}, {"g", "Sol", -2}, {"h", "La", 0}, {"j", "Si", 2}},
-		{{"z", "Do", -21}, {"x", "Re", -19}, {"c", "Mi", -17}, {"v", "Fa", -16}, {"b", "Sol", -14}, {"n", "La", -12}, {"m", "Si", -10}},
-	}
-
-	for i, rowData := range rows {
+	notes := make(map[string]Note)
+	var rows [][]Note
+	for i, rowData := range keyboards[currentKeyboard].rows {
 		var r []Note
-		for _, d := range rowData {
-			n := Note{d.k, d.n, getFreq(d.s)}
-			noteMap[d.k] = n
+		for _, d := range rowData.keys {
+			n := Note{keymaps[currentKeymap].key(d.k), d.n, getFreq(d.s), i}
+			notes[n.Key] = n
 			r = append(r, n)
 		}
-		sortedRows[i] = r
+		rows = append(rows, r)
 	}
+	noteMap, sortedRows = notes, rows
 }
 
 // --- 4. TUI VISUALS & LOGIC ---
@@ -292,18 +561,83 @@ type model struct {
 	height          int
 	spectrum        []float64
 	octaveShift     int
+	ambName         string
+	ambVolume       float64
+	volume          float64
+	theremin        theremin
+	vibrato         bool
+	bend            bender
+	playMode        int
+	velocity        *velocityTracker
+	mouseKey        string
+	editor          instrumentEditor
+	warmup          warmupMode
+	ear             earTrainer
+	scale           scalePanel
+	stats           statsPanel
+	latency         latencyTest
+	lesson          lessonMode
+	transposing     bool // the keyboard plays in currentKey's signature
+	drums           drumPanel
+	settings        settingsPanel
+	partials        partialsEditor
+	savePrompt      presetPrompt
+	browser         instrumentBrowser
+	drumsPlaying    bool
+	bpm             float64 // the transport's, for the header
+	click           bool    // the metronome is on
+	taps            []time.Time
+	count           *countIn // before a recording starts
+	chord           string   // the name of the chord held, see chords.go
+	history         editHistory
+	cfgWatch        *configWatcher
+	presetWatch     *presetWatcher
+	events          *perfTracker
+	voicePrefix     string // set for SSH sessions, see ssh.go
+	screenReader    bool   // plain text instead of the panel, see access.go
+	vis             visualizerMode
+	graphics        int // how the spectrum is drawn, see graphics.go
+	meters          levelMeters
+	status          engineStatus
+	showKeys        bool // the F1 list, see layout.go
+	recording       bool
+	replay          replayPanel
+	staff           staffPanel
+	roll            pianoRoll
+	tracks          tracksPanel
+	journal         *sessionJournal // local session only
+	practice        *practiceLog    // local session only
+	restore         *sessionState   // left by the last run, until answered
+	learn           *midiLearn
+	jam             *jamSession
 	notification    string
 	notifyClearTime time.Time
 }
 
 const numBars = 42
 
-func initialModel() model {
+// mainHelp lists the keys under the keyboard, wrapped to the panel; the
+// compact layout shows compactHelp instead and F1 shows the lot.
+const (
+	helpSep     = "  •  "
+	mainHelp    = "TAB: Instruments  •  1-0: Load  •  SHIFT+1-0: Save  •  L/R: Octave  •  SHIFT+KEY: Fast End  •  \\: Ambience  •  [/]: Amb Vol  •  -/+: Volume  •  `: Theremin  •  ': Velocity  •  \": Mono/Legato  •  /: Vibrato  •  ,/.: Bend  •  {/}: Cutoff  •  ?: Resonance  •  ;: Theme  •  CTRL+E: Edit Instrument  •  CTRL+W: Warm-up  •  CTRL+Q: Ear Training  •  CTRL+U: Scales  •  F1: All Keys  •  F2: Stats  •  F3: Lessons  •  F4: Latency  •  CTRL+D: Drums  •  CTRL+B: Tap Tempo  •  CTRL+K: Metronome  •  CTRL+V: Meters  •  CTRL+O: Settings  •  CTRL+A: Partials  •  CTRL+S: Save Preset  •  CTRL+R: Record  •  CTRL+F: Replay  •  CTRL+Z/CTRL+Y: Undo/Redo  •  CTRL+G: Piano Roll  •  CTRL+T: Tracks  •  CTRL+N: Staff"
+	compactHelp = "F1: All Keys  •  TAB: Instruments  •  1-0: Presets  •  L/R: Octave  •  -/+: Volume  •  ESC: Quit"
+)
+
+func initialModel(cfg Config) model {
+	vis, _ := visualizerStyle(cfg.Visualizer) // checked in main
 	return model{
-		activeKeys:  make(map[string]bool),
-		instName:    instruments[0].Name,
-		spectrum:    make([]float64, numBars),
-		octaveShift: 0,
+		activeKeys:   make(map[string]bool),
+		instName:     instruments[0].Name,
+		spectrum:     make([]float64, numBars),
+		octaveShift:  0,
+		ambName:      ambience.Name(),
+		ambVolume:    ambience.volume,
+		volume:       mainOut.volume,
+		bpm:          transport.bpm,
+		velocity:     newVelocityTracker(cfg.RowVelocity),
+		screenReader: cfg.ScreenReader,
+		vis:          visualizerMode{style: vis},
 	}
 }
 
@@ -341,37 +675,69 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case TickMsg:
 		checkWatchdog()
 
-		// Clear notification timer
 		now := time.Now()
+		if m.cfgWatch != nil && m.cfgWatch.poll(now) {
+			m = m.reloadThemes()
+		}
+		if m.presetWatch != nil && m.presetWatch.poll(now) {
+			m = m.reloadPresets()
+		}
+		m = m.releaseBend(now)
+		if m.replay.player != nil {
+			m.replay.player.advance(now)
+			m = m.finishPlayAlong()
+		}
+		if m.tracks.player != nil {
+			m.tracks.player.advance(now)
+		}
+		if m.ear.player != nil {
+			m.ear.player.advance(now)
+		}
+		if err := m.practice.poll(now); err != nil {
+			m.notification = fmt.Sprintf("Practice stats: %v", err)
+			m.notifyClearTime = now.Add(4 * time.Second)
+		}
+		if m.journal != nil && m.restore == nil {
+			if err := m.journal.poll(m, now); err != nil {
+				m.notification = fmt.Sprintf("Session journal: %v", err)
+				m.notifyClearTime = now.Add(4 * time.Second)
+			}
+		}
+
+		// Clear notification timer
 		if m.notification != "" && now.After(m.notifyClearTime) {
 			m.notification = ""
 		}
 
 		voiceLock.Lock()
 		newActive := make(map[string]bool)
+		held := make(map[string]heldNote)
 
 		for i := range m.spectrum {
 			m.spectrum[i] *= 0.82
 		}
 
+		sounding := 0
 		for k, v := range voices {
-			if !v.streamer.finished {
-				newActive[k] = true
-				if note, ok := noteMap[k]; ok {
-					shiftedFreq := note.Freq * math.Pow(2.0, float64(m.octaveShift))
-
-					b1 := freqToBucket(shiftedFreq)
-					m.spectrum[b1] = 1.0
-
-					if b2 := freqToBucket(shiftedFreq * 2.0); b2 < numBars {
-						m.spectrum[b2] += 0.5
-					}
-					if b3 := freqToBucket(shiftedFreq * 3.0); b3 < numBars {
-						m.spectrum[b3] += 0.25
-					}
-					if b4 := freqToBucket(shiftedFreq * 4.0); b4 < numBars {
-						m.spectrum[b4] += 0.1
-					}
+			if !v.finished() && !v.released {
+				held[k] = heldNote{v.freq, v.streamer.velocity}
+			}
+			if !v.finished() {
+				sounding++
+				newActive[noteKey(k)] = true
+				shiftedFreq := v.freq
+
+				b1 := freqToBucket(shiftedFreq)
+				m.spectrum[b1] = 1.0
+
+				if b2 := freqToBucket(shiftedFreq * 2.0); b2 < numBars {
+					m.spectrum[b2] += 0.5
+				}
+				if b3 := freqToBucket(shiftedFreq * 3.0); b3 < numBars {
+					m.spectrum[b3] += 0.25
+				}
+				if b4 := freqToBucket(shiftedFreq * 4.0); b4 < numBars {
+					m.spectrum[b4] += 0.1
 				}
 			}
 		}
@@ -382,58 +748,300 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 		}
 
+		recordReleases()
+		if m.roll.live {
+			m.roll.followRecording()
+		}
+		if m.staff.open {
+			m.staff.update(held, now)
+		}
+		m.chord = heldChord(held)
+		m.vis.follow(held, now)
+		inst := currentInstID
+		m.recording = rec.on // another session may have started or stopped a take
 		voiceLock.Unlock()
+		if m.meters.show || m.settings.open {
+			m.meters.follow(now)
+		}
+		m.status.follow(sounding, now)
+		if autoBuffer {
+			if note, ok := growBuffer(m.status.underruns); ok {
+				m.notification = note
+				m.notifyClearTime = now.Add(3 * time.Second)
+			}
+		}
 		m.activeKeys = newActive
+		m.instName = instruments[inst].Name // another session may have switched it
+		output.Lock()
+		m.volume = mainOut.volume // so is the volume, by a knob or the settings panel
+		m.bpm, m.click = transport.bpm, transport.click != nil
+		output.Unlock()
+		if m.events != nil {
+			m.events.update(held, inst, m.spectrum)
+		}
+		if m.count != nil {
+			m = m.followCountIn(now)
+		}
 		return m, tick()
 
 	case tea.KeyMsg:
+		if m.restore != nil {
+			return m.handleRestoreKey(msg)
+		}
+		if m.showKeys {
+			if km, ok := m.handleKeysKey(msg); ok {
+				return km, nil
+			}
+		}
+		if m.savePrompt.open {
+			return m.handlePresetPromptKey(msg), nil
+		}
+		if m.browser.open {
+			return m.handleBrowserKey(msg), nil
+		}
+		if m.editor.open {
+			if em, ok := m.handleEditorKey(msg); ok {
+				return em, nil
+			}
+		}
+		if m.warmup.open {
+			if wm, ok := m.handleWarmupKey(msg); ok {
+				return wm, nil
+			}
+		}
+		if m.ear.open {
+			if em, ok := m.handleEarKey(msg); ok {
+				return em, nil
+			}
+		}
+		if m.scale.open {
+			if sm, ok := m.handleScaleKey(msg); ok {
+				return sm, nil
+			}
+		}
+		if m.stats.open {
+			if sm, ok := m.handleStatsKey(msg); ok {
+				return sm, nil
+			}
+		}
+		if m.latency.open {
+			if lm, ok := m.handleLatencyKey(msg); ok {
+				return lm, nil
+			}
+		}
+		if m.lesson.open {
+			if lm, ok := m.handleLessonKey(msg); ok {
+				return lm, nil
+			}
+		}
+		if m.drums.open {
+			if dm, ok := m.handleDrumKey(msg); ok {
+				return dm, nil
+			}
+		}
+		if m.settings.open {
+			if sm, ok := m.handleSettingsKey(msg); ok {
+				return sm, nil
+			}
+		}
+		if m.partials.open {
+			if pm, ok := m.handlePartialsKey(msg); ok {
+				return pm, nil
+			}
+		}
+		if m.tracks.open {
+			if tm, ok := m.handleTracksKey(msg); ok {
+				return tm, nil
+			}
+		}
+		if m.roll.open {
+			if rm, ok := m.handlePianoRollKey(msg); ok {
+				return rm, nil
+			}
+		}
+		if m.replay.open {
+			if rm, cmd, ok := m.handleReplayKey(msg); ok {
+				return rm, cmd
+			}
+		}
+		if m.staff.open {
+			if sm, ok := m.handleStaffKey(msg); ok {
+				return sm, nil
+			}
+		}
+
 		switch msg.Type {
-		case tea.KeyCtrlC, tea.KeyEscape:
-			return m, tea.Quit
+		case tea.KeyCtrlC:
+			return m.quit(), tea.Quit
+
+		case tea.KeyEscape:
+			m.journal.discard()
+			return m.quit(), tea.Quit
 
 		case tea.KeySpace:
-			speaker.Clear()
-			mixer = &beep.Mixer{}
-			speaker.Play(mixer)
-			voiceLock.Lock()
-			voices = make(map[string]*ActiveVoice)
-			voiceLock.Unlock()
+			return m.panic(), nil
+
+		case tea.KeyCtrlE:
+			m.editor.open = true
 			return m, nil
 
-		case tea.KeyTab:
-			voiceLock.Lock()
-			currentInstID++
-			if currentInstID >= len(instruments) {
-				currentInstID = 0
-			}
-			m.instName = instruments[currentInstID].Name
-			voiceLock.Unlock()
+		case tea.KeyCtrlW:
+			return m.openWarmup(), nil
+
+		case tea.KeyCtrlQ:
+			return m.openEarTraining(), nil
+
+		case tea.KeyCtrlU:
+			m.scale.open = true
 			return m, nil
 
-		case tea.KeyShiftTab:
-			voiceLock.Lock()
-			currentInstID--
-			if currentInstID < 0 {
-				currentInstID = len(instruments) - 1
-			}
-			m.instName = instruments[currentInstID].Name
-			voiceLock.Unlock()
+		case tea.KeyF1:
+			m.showKeys = true
 			return m, nil
 
-		case tea.KeyLeft:
-			if m.octaveShift > -2 {
-				m.octaveShift--
-			}
+		case tea.KeyF2:
+			m.stats.open = true
+			return m, nil
+
+		case tea.KeyF3:
+			return m.openLessons(), nil
+
+		case tea.KeyF4:
+			return m.openLatencyTest()
+
+		case tea.KeyCtrlD:
+			m.drums.open = true
+			return m, nil
+
+		case tea.KeyCtrlP:
+			return m.toggleDrumMachine(), nil
+
+		case tea.KeyCtrlO:
+			m.settings.open = true
+			m.meters.restart()
+			return m, nil
+
+		case tea.KeyCtrlA:
+			return m.openPartials(), nil
+
+		case tea.KeyCtrlR:
+			return m.toggleRecording(), nil
+
+		case tea.KeyCtrlZ:
+			return m.undo(), nil
+
+		case tea.KeyCtrlY:
+			return m.redo(), nil
+
+		case tea.KeyCtrlF:
+			return m.openReplay(), nil
+
+		case tea.KeyCtrlN:
+			return m.toggleStaff(), nil
+
+		case tea.KeyCtrlG:
+			return m.openPianoRoll(), nil
+
+		case tea.KeyCtrlT:
+			return m.openTracks(), nil
+
+		case tea.KeyCtrlB:
+			return m.tapTempo(time.Now()), nil
+
+		case tea.KeyCtrlK:
+			return m.toggleClick(), nil
+
+		case tea.KeyCtrlV:
+			return m.toggleMeters(), nil
+
+		case tea.KeyCtrlS:
+			m.savePrompt = presetPrompt{open: true, name: instruments[currentInstID].Name}
 			return m, nil
 
+		case tea.KeyTab, tea.KeyShiftTab:
+			return m.openBrowser(), nil
+
+		case tea.KeyLeft:
+			return m.shiftOctave(-1), nil
+
 		case tea.KeyRight:
-			if m.octaveShift < 2 {
-				m.octaveShift++
+			return m.shiftOctave(1), nil
+
+		case tea.KeyUp, tea.KeyDown:
+			if !m.theremin.active {
+				return m, nil
+			}
+			if msg.Type == tea.KeyUp {
+				m.theremin.pos += 0.25
+			} else {
+				m.theremin.pos -= 0.25
+			}
+			m.theremin.clamp(m.octaveShift)
+			if m.theremin.locked {
+				thereminPlay(&m.theremin)
 			}
 			return m, nil
 		}
 
 		input := msg.String()
+		if _, ok := noteMap[input]; ok { // a keymap can put notes on punctuation
+			return m.playKey(input), nil
+		}
+
+		switch input {
+		case "`":
+			return m.toggleTheremin(), nil
+
+		case "'":
+			m.velocity.nextMode()
+			return m, nil
+
+		case "\"":
+			return m.cyclePlayMode(), nil
+
+		case "/":
+			return m.toggleVibrato(), nil
+
+		case "{":
+			return m.adjustInstrument(cutoffParam, -1), nil
+
+		case "}":
+			return m.adjustInstrument(cutoffParam, 1), nil
+
+		case "?":
+			return m.cycleResonance(), nil
+
+		case ",":
+			return m.bendPitch(-1), nil
+
+		case ".":
+			return m.bendPitch(1), nil
+
+		case ";":
+			return m.cycleTheme(), nil
+
+		case "~":
+			m.theremin.snap = (m.theremin.snap + 1) % len(thereminSnaps)
+			if m.theremin.locked {
+				thereminPlay(&m.theremin)
+			}
+			return m, nil
+
+		case "\\":
+			return m.nextAmbience(), nil
+
+		case "-":
+			return m.adjustVolume(-volumeStep), nil
+
+		case "=", "+":
+			return m.adjustVolume(volumeStep), nil
+
+		case "[":
+			return m.adjustAmbience(-0.1), nil
+
+		case "]":
+			return m.adjustAmbience(0.1), nil
+		}
 
 		// 1. Handle Shift+Number for SAVING presets (! @ # $ % ^ & * ( ))
 		shiftedNumbers := map[string]string{
@@ -453,93 +1061,283 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 		// 2. Handle 1-0 for LOADING presets
 		if len(input) == 1 && input[0] >= '0' && input[0] <= '9' {
-			voiceLock.Lock()
-			if id, ok := presets[input]; ok && id < len(instruments) {
-				currentInstID = id
-				m.instName = instruments[currentInstID].Name
-			}
-			voiceLock.Unlock()
-
-			m.notification = fmt.Sprintf("Loaded Preset %s", input)
-			m.notifyClearTime = time.Now().Add(1 * time.Second)
-			return m, nil
+			return m.loadPreset(input), nil
 		}
 
 		// 3. Handle Note playing
-		lowerInput := strings.ToLower(input)
-		// Staccato applies only if we held Shift AND it's an alphabetical character
-		isStaccato := false
-		if len(input) == 1 && input[0] >= 'A' && input[0] <= 'Z' {
-			isStaccato = true
+		return m.playKey(input), nil
+
+	case tea.MouseMsg:
+		return m.handleMouse(msg), nil
+
+	case actionMsg:
+		if fn, ok := actions[string(msg)]; ok {
+			return fn(m), nil
 		}
 
-		if note, ok := noteMap[lowerInput]; ok {
-			shiftedFreq := note.Freq * math.Pow(2.0, float64(m.octaveShift))
-			updateVoice(lowerInput, shiftedFreq, isStaccato)
+	case oscMessage:
+		return m.handleOSC(msg), nil
+
+	case apiCall:
+		m, err := m.runRemote(msg.msg)
+		msg.reply <- err
+		return m, nil
+
+	case apiStateQuery:
+		msg <- m.apiState()
+
+	case jamMessage:
+		return m.handleJam(msg), nil
+
+	case midiCC:
+		return m.handleCC(msg), nil
+
+	case latencyProbe:
+		return m.handleLatencyProbe(msg)
+
+	case exportDone:
+		m.notification = "Exported " + filepath.Base(msg.path)
+		if msg.err != nil {
+			m.notification = fmt.Sprintf("Export: %v", msg.err)
 		}
+		m.notifyClearTime = time.Now().Add(4 * time.Second)
 	}
 	return m, nil
 }
 
+// playKey plays the note on a typed key, if it's one.
+func (m model) playKey(input string) model {
+	lowerInput := strings.ToLower(input)
+	// Staccato applies only if we held Shift AND it's a letter
+	isStaccato := lowerInput != input && utf8.RuneCountInString(input) == 1
+
+	note, ok := noteMap[lowerInput]
+	if !ok {
+		return m
+	}
+	shiftedFreq := m.noteFreq(note)
+	if m.theremin.active {
+		m.theremin.pos = 12 * math.Log2(shiftedFreq/440.0)
+		thereminPlay(&m.theremin)
+		return m
+	}
+	if updateVoice(m.voicePrefix+lowerInput, shiftedFreq, isStaccato, m.velocity.strike(note)) {
+		m = m.warmupHit(lowerInput)
+		m = m.earHit(shiftedFreq)
+		m = m.lessonHit(lowerInput, time.Now())
+		m = m.guideHit(lowerInput, time.Now())
+		m.practice.noted(instruments[currentInstID].Name, time.Now())
+	}
+	return m
+}
+
+// panic fades out everything sounding. The drum machine stops but lets
+// its last hits ring, and sources played beside the bus are dropped.
+func (m model) panic() model {
+	output.Lock()
+	drumMachine.playing = false
+	mainOut.reset()
+	output.Unlock()
+	voiceLock.Lock()
+	bank.silence()
+	voices = make(map[string]*ActiveVoice)
+	voiceLock.Unlock()
+	m.theremin.locked = false
+	m.mouseKey = ""
+	m.drumsPlaying = false
+	return m
+}
+
+func (m model) cycleInstrument(delta int) model {
+	voiceLock.Lock()
+	currentInstID = (currentInstID + delta + len(instruments)) % len(instruments)
+	m.instName = instruments[currentInstID].Name
+	voiceLock.Unlock()
+	return m
+}
+
+func (m model) shiftOctave(delta int) model {
+	from := m.shiftSemis()
+	m.octaveShift += delta
+	if m.octaveShift < -2 {
+		m.octaveShift = -2
+	} else if m.octaveShift > 2 {
+		m.octaveShift = 2
+	}
+	m.theremin.clamp(m.octaveShift)
+	return m.followHeld(from)
+}
+
+func (m model) loadPreset(key string) model {
+	voiceLock.Lock()
+	if id, ok := presets[key]; ok && id < len(instruments) {
+		currentInstID = id
+		m.instName = instruments[currentInstID].Name
+	}
+	voiceLock.Unlock()
+
+	m.notification = fmt.Sprintf("Loaded Preset %s", key)
+	m.notifyClearTime = time.Now().Add(1 * time.Second)
+	return m
+}
+
+func (m model) toggleTheremin() model {
+	m.theremin.active = !m.theremin.active
+	if !m.theremin.active && m.theremin.locked {
+		thereminRelease(&m.theremin)
+	}
+	return m
+}
+
+// cycleResonance steps the resonance up, wrapping back to none at the top
+func (m model) cycleResonance() model {
+	inst := instruments[currentInstID]
+	if from := inst.Patch.Resonance; from >= resonanceParam.Max {
+		output.Lock()
+		inst.Patch.Resonance = resonanceParam.Min
+		output.Unlock()
+		return m.instrumentEdited(resonanceParam, from, resonanceParam.Min)
+	}
+	return m.adjustInstrument(resonanceParam, 1)
+}
+
+func (m model) toggleVibrato() model {
+	output.Lock()
+	vibratoOn = !vibratoOn
+	m.vibrato = vibratoOn
+	output.Unlock()
+	return m
+}
+
+func (m model) nextAmbience() model {
+	output.Lock()
+	ambience.Next()
+	m.ambName = ambience.Name()
+	output.Unlock()
+	return m
+}
+
+func (m model) adjustVolume(delta float64) model {
+	output.Lock()
+	v := mainOut.volume + delta
+	mainOut.volume = math.Round(max(0, min(maxVolume, v))/volumeStep) * volumeStep
+	m.volume = mainOut.volume
+	output.Unlock()
+	return m
+}
+
+func (m model) adjustAmbience(delta float64) model {
+	output.Lock()
+	ambience.AdjustVolume(delta)
+	m.ambVolume = ambience.volume
+	output.Unlock()
+	return m
+}
+
 var (
+	panelStyle       lipgloss.Style
+	titleStyle       lipgloss.Style
+	instStyle        lipgloss.Style
+	notifyStyle      lipgloss.Style
+	visStyle         lipgloss.Style
+	waveColor        lipgloss.Style
+	keyStyle         lipgloss.Style
+	activeKeyStyle   lipgloss.Style
+	scaleKeyStyle    lipgloss.Style // notes of the key, while the scale panel is open
+	tonicKeyStyle    lipgloss.Style
+	guideKeyStyle    lipgloss.Style // keys a lesson or the play-along guide is waiting for
+	rowLabelStyle    lipgloss.Style
+	presetTitleStyle lipgloss.Style
+	presetTextStyle  lipgloss.Style
+	helpStyle        lipgloss.Style
+)
+
+// applyTheme rebuilds every style from the theme's palette
+func applyTheme(t Theme) {
 	panelStyle = lipgloss.NewStyle().
-			Padding(1, 3).
-			Border(lipgloss.ThickBorder()).
-			BorderForeground(lipgloss.Color("#444444"))
+		Padding(1, 3).
+		Border(lipgloss.ThickBorder()).
+		BorderForeground(lipgloss.Color(t.Border))
 
 	titleStyle = lipgloss.NewStyle().
-			Bold(true).
-			Foreground(lipgloss.Color("#FFFFFF")).
-			MarginBottom(1).
-			Padding(0, 2).
-			Border(lipgloss.RoundedBorder()).
-			BorderForeground(lipgloss.Color("#00E6C3"))
+		Bold(true).
+		Foreground(lipgloss.Color(t.Title)).
+		MarginBottom(1).
+		Padding(0, 2).
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(lipgloss.Color(t.Accent))
 
 	instStyle = lipgloss.NewStyle().
-			Foreground(lipgloss.Color("#00E6C3")).
-			Background(lipgloss.Color("#111111")).
-			Padding(0, 1).
-			MarginBottom(1)
+		Foreground(lipgloss.Color(t.Accent)).
+		Background(lipgloss.Color(t.Surface)).
+		Padding(0, 1).
+		MarginBottom(1)
 
 	notifyStyle = lipgloss.NewStyle().
-			Foreground(lipgloss.Color("#111111")).
-			Background(lipgloss.Color("#50FA7B")).
-			Bold(true).
-			Padding(0, 1).
-			MarginBottom(1)
+		Foreground(lipgloss.Color(t.Surface)).
+		Background(lipgloss.Color(t.Notify)).
+		Bold(true).
+		Padding(0, 1).
+		MarginBottom(1)
 
 	visStyle = lipgloss.NewStyle().
-			MarginBottom(2)
+		MarginBottom(2)
 
 	waveColor = lipgloss.NewStyle().
-			Foreground(lipgloss.Color("#00E6C3"))
+		Foreground(lipgloss.Color(t.Visualizer))
 
 	keyStyle = lipgloss.NewStyle().
-			Border(lipgloss.RoundedBorder()).
-			BorderForeground(lipgloss.Color("#333333")).
-			Foreground(lipgloss.Color("#AAAAAA")).
-			Width(7).
-			Height(3).
-			Align(lipgloss.Center)
-
-	activeKeyStyle = keyStyle.Copy().
-			BorderForeground(lipgloss.Color("#00E6C3")).
-			Foreground(lipgloss.Color("#000000")).
-			Background(lipgloss.Color("#00E6C3")).
-			Bold(true)
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(lipgloss.Color(t.KeyBorder)).
+		Foreground(lipgloss.Color(t.KeyText)).
+		Width(7).
+		Height(3).
+		Align(lipgloss.Center)
+
+	// Struck and waiting keys change shape too, so no one has to tell
+	// them apart by color alone
+	activeKeyStyle = keyStyle.
+		Border(lipgloss.ThickBorder()).
+		BorderForeground(lipgloss.Color(t.ActiveKey)).
+		Foreground(lipgloss.Color(t.ActiveText)).
+		Background(lipgloss.Color(t.ActiveKey)).
+		Bold(true)
+
+	scaleKeyStyle = keyStyle.
+		BorderForeground(lipgloss.Color(t.Accent)).
+		Foreground(lipgloss.Color(t.Accent))
+
+	tonicKeyStyle = scaleKeyStyle.
+		BorderForeground(lipgloss.Color(t.Notify)).
+		Foreground(lipgloss.Color(t.Notify)).
+		Bold(true)
+
+	guideKeyStyle = keyStyle.
+		Border(lipgloss.DoubleBorder()).
+		BorderForeground(lipgloss.Color(t.Notify)).
+		Foreground(lipgloss.Color(t.Surface)).
+		Background(lipgloss.Color(t.Notify)).
+		Bold(true)
+
+	rowLabelStyle = lipgloss.NewStyle().
+		Foreground(lipgloss.Color(t.Muted)).
+		Width(6).
+		Align(lipgloss.Right).
+		MarginRight(2).
+		MarginTop(1)
 
 	presetTitleStyle = lipgloss.NewStyle().
-				Foreground(lipgloss.Color("#6272A4")).
-				MarginTop(1).
-				MarginBottom(1)
+		Foreground(lipgloss.Color(t.Muted)).
+		MarginTop(1).
+		MarginBottom(1)
 
 	presetTextStyle = lipgloss.NewStyle().
-			Foreground(lipgloss.Color("#8BE9FD"))
+		Foreground(lipgloss.Color(t.Text))
 
 	helpStyle = lipgloss.NewStyle().
-			Foreground(lipgloss.Color("#666666")).
-			MarginTop(2)
-)
+		Foreground(lipgloss.Color(t.Help)).
+		MarginTop(2)
+}
 
 // Helper function to render presets properly truncated
 func formatPreset(key string) string {
@@ -553,11 +1351,35 @@ func formatPreset(key string) string {
 	return fmt.Sprintf("[%s] %-12s", key, name)
 }
 
+// volumeBar draws a 0..1 level as a small five-segment slider
+func volumeBar(v float64) string {
+	filled := int(math.Round(v * 5))
+	return strings.Repeat("▮", filled) + strings.Repeat("▯", 5-filled)
+}
+
 func (m model) View() string {
 	if m.width == 0 {
 		return "Initializing..."
 	}
+	if m.screenReader {
+		return m.screenReaderView()
+	}
 
+	l, sections := m.arrange()
+	panel := l.frame().Render(lipgloss.JoinVertical(lipgloss.Center, sections...))
+
+	return lipgloss.Place(m.width, m.height, lipgloss.Center, lipgloss.Center, panel)
+}
+
+// sections renders the panel contents top to bottom: header, visualizer,
+// keyboard, preset bar and help, the preset bar left out of the compact
+// layout. Mouse hit-testing measures the same blocks.
+func (m model) sections(l layout) []string {
+	title, inst, notify := titleStyle, instStyle, notifyStyle
+	if l.compact {
+		title = title.UnsetBorderStyle().UnsetMarginBottom()
+		inst, notify = inst.UnsetMarginBottom(), notify.UnsetMarginBottom()
+	}
 	octStr := fmt.Sprintf("%+d", m.octaveShift)
 	if m.octaveShift == 0 {
 		octStr = " 0"
@@ -565,23 +1387,176 @@ func (m model) View() string {
 
 	// Dynamic Header Content
 	headerItems := []string{
-		titleStyle.Render("🎹 PIANGO"),
-		"   ",
-		instStyle.Render("Preset: " + m.instName),
-		"   ",
-		instStyle.Render("Octave: " + octStr),
+		title.Render("🎹 PIANGO"),
+		inst.Render("Preset: " + m.instName),
+		inst.Render("Octave: " + octStr),
+		inst.Render("Velocity: " + m.velocity.label()),
+		inst.Render("Ambience: " + m.ambName + " " + volumeBar(m.ambVolume)),
+		inst.Render(fmt.Sprintf("Vol: %s %3.0f%%", volumeBar(m.volume/maxVolume), m.volume*100)),
+		inst.Render(fmt.Sprintf("Tempo: %.0f", m.bpm)),
+	}
+	if m.click {
+		headerItems = append(headerItems, inst.Render("Click ♩"))
+	}
+	if m.chord != "" && !m.ear.open { // it would give the answer away
+		headerItems = append(headerItems, inst.Render("Chord: "+m.chord))
+	}
+	if m.drumsPlaying {
+		headerItems = append(headerItems, inst.Render("Drums ▶"))
+	}
+	if m.playMode != playPoly {
+		headerItems = append(headerItems, inst.Render("Mode: "+playModeNames[m.playMode]))
+	}
+	if m.vibrato {
+		headerItems = append(headerItems, inst.Render("Vibrato"))
+	}
+	if p := instruments[currentInstID].Patch; p.Cutoff < filterMaxHz {
+		headerItems = append(headerItems,
+			inst.Render(fmt.Sprintf("Filter: %s Q%.2f", cutoffParam.format(p.Cutoff), p.Resonance)))
+	}
+	if m.bend.dir != 0 {
+		headerItems = append(headerItems, inst.Render(fmt.Sprintf("Bend: %+.0f", float64(m.bend.dir)*bendRange)))
+	}
+	if m.transposing {
+		headerItems = append(headerItems,
+			inst.Render(fmt.Sprintf("Key: %s %+d", musicKey{currentKey.signature(), 0}.names()[0], currentKey.transposition())))
+	}
+	if m.theremin.active {
+		headerItems = append(headerItems,
+			inst.Render(fmt.Sprintf("Theremin: %s [%s]", m.theremin.label(), thereminSnaps[m.theremin.snap].Name)))
+	}
+	if m.count != nil {
+		headerItems = append(headerItems, notify.Render("○ "+m.count.label(time.Now())))
+	}
+	if time.Since(m.status.lastUnderrun) < underrunShown {
+		headerItems = append(headerItems, notify.Render("⚠ Underrun"))
+	}
+	if m.recording {
+		headerItems = append(headerItems, notify.Render("● REC"))
+	}
+	if m.jam != nil {
+		headerItems = append(headerItems, inst.Render(m.jam.label()))
 	}
 	if m.notification != "" {
-		headerItems = append(headerItems, "   ", notifyStyle.Render(m.notification))
+		headerItems = append(headerItems, notify.Render(m.notification))
 	}
 
-	header := lipgloss.JoinHorizontal(lipgloss.Center, headerItems...)
+	header := flow(headerItems, "   ", l.width)
+	if m.meters.show {
+		header = lipgloss.JoinVertical(lipgloss.Center, header, m.meters.view(metersWidth(l.width), time.Now()))
+	}
+
+	keyboard := lipgloss.JoinVertical(lipgloss.Left, m.keyboardRows(l)...)
+
+	// Presets Bottom Bar
+	var presetItems1, presetItems2 []string
+	keys1 := []string{"1", "2", "3", "4", "5"}
+	keys2 := []string{"6", "7", "8", "9", "0"}
+
+	for _, k := range keys1 {
+		presetItems1 = append(presetItems1, formatPreset(k))
+	}
+	for _, k := range keys2 {
+		presetItems2 = append(presetItems2, formatPreset(k))
+	}
+
+	presetBar := lipgloss.JoinVertical(lipgloss.Center,
+		presetTitleStyle.Render("--- SAVED PRESETS ---"),
+		presetTextStyle.Render(strings.Join(presetItems1, "   ")),
+		presetTextStyle.Render(strings.Join(presetItems2, "   ")),
+	)
+
+	help := helpStyle.Render(flow(strings.Split(mainHelp, helpSep), helpSep, l.width))
+	blocks := []string{header, keyboard, presetBar, help, m.status.view(l)}
+	if l.compact {
+		help = helpStyle.UnsetMarginTop().Render(flow(strings.Split(compactHelp, helpSep), helpSep, l.width))
+		blocks = []string{header, keyboard, help, m.status.view(l)}
+	}
 
+	visualizer := m.panel()
+	switch {
+	case visualizer != "":
+		visualizer = fit(visualizer, l.width, !m.roll.open)
+	case m.staff.open:
+		// The staff takes what height the rest of the panel leaves
+		rest := lipgloss.JoinVertical(lipgloss.Center, blocks...)
+		visualizer = fit(m.staffView(m.height-lipgloss.Height(rest)-l.frame().GetVerticalFrameSize()), l.width, false)
+	default:
+		return slices.Insert(blocks, 1, m.visualizerView(l))
+	}
+	if m.graphics == gfxKitty {
+		visualizer = kittyClear + visualizer // the spectrum's image is under it
+	}
+	return slices.Insert(blocks, 1, visualizer)
+}
+
+// panel renders whichever panel is open in the visualizer's place, or ""
+// when none is. The staff is left to sections, as it fits itself to the
+// room the rest leaves.
+func (m model) panel() string {
+	switch {
+	case m.restore != nil:
+		return m.restoreView()
+	case m.showKeys:
+		return m.keysView()
+	case m.savePrompt.open:
+		return m.presetPromptView()
+	case m.browser.open:
+		return m.browserView()
+	case m.editor.open:
+		return m.editorView()
+	case m.warmup.open:
+		return m.warmupView()
+	case m.ear.open:
+		return m.earTrainingView()
+	case m.scale.open:
+		return m.scaleView()
+	case m.stats.open:
+		return m.statsView()
+	case m.latency.open:
+		return m.latencyView()
+	case m.lesson.open:
+		return m.lessonsView()
+	case m.drums.open:
+		return m.drumMachineView()
+	case m.settings.open:
+		return m.settingsView()
+	case m.partials.open && instruments[currentInstID].Partials != nil:
+		return m.partialsView()
+	case m.roll.open:
+		return m.pianoRollView()
+	case m.tracks.open:
+		return m.tracksView()
+	case m.replay.open:
+		return m.replayView()
+	}
+	return ""
+}
+
+// visualizerView draws the spectrum, mirrored about its middle line: three
+// rows each way, or one in the compact layout, with as many bars as fit.
+func (m model) visualizerView(l layout) string {
+	switch m.vis.style {
+	case visSpectrum:
+		if m.graphics != gfxText {
+			if v, ok := m.graphicsView(l); ok {
+				return v
+			}
+		}
+	case visBraille:
+		return m.brailleView(l)
+	case visNotes, visMeter:
+		return m.stillView(l)
+	}
+	half, bars := 3, m.spectrum
+	if l.compact {
+		half, bars = 1, squeeze(m.spectrum, l.width/2)
+	}
 	var visLines []string
-	for r := 3; r >= -3; r-- {
+	for r := half; r >= -half; r-- {
 		line := ""
-		for _, val := range m.spectrum {
-			h := val * 3.0
+		for _, val := range bars {
+			h := val * float64(half)
 			absR := float64(math.Abs(float64(r)))
 
 			if r == 0 {
@@ -611,68 +1586,360 @@ func (m model) View() string {
 		}
 		visLines = append(visLines, waveColor.Render(line))
 	}
-	visualizer := visStyle.Render(strings.Join(visLines, "\n"))
+	return l.vis().Render(strings.Join(visLines, "\n"))
+}
 
+// keyboardRows draws the keys as boxes, or in the compact layout as a line
+// of cells each.
+func (m model) keyboardRows(l layout) []string {
 	var rowsStr []string
-	rowLabels := []string{"High", "Mid ", "Low "}
+	keyWidth := l.keyWidth()
 
-	for i, rowNotes := range sortedRows {
+	for i := range sortedRows {
 		var renderedKeys []string
 
-		label := lipgloss.NewStyle().
-			Foreground(lipgloss.Color("#6272A4")).
-			Width(6).
-			Align(lipgloss.Right).
-			MarginRight(2).
-			MarginTop(1).
-			Render(fmt.Sprintf("\n%s", rowLabels[i]))
-
+		rowLabel := keyboards[currentKeyboard].rows[i].label
+		label := rowLabelStyle.Render(fmt.Sprintf("\n%s", rowLabel))
+		if l.compact {
+			label = rowLabelStyle.UnsetMarginTop().Render(rowLabel)
+		}
 		renderedKeys = append(renderedKeys, label)
 
-		for _, n := range rowNotes {
-			keyContent := fmt.Sprintf("%s\n%s", n.Name, strings.ToUpper(n.Key))
-			if m.activeKeys[n.Key] {
-				renderedKeys = append(renderedKeys, activeKeyStyle.Render(keyContent))
-			} else {
-				renderedKeys = append(renderedKeys, keyStyle.Render(keyContent))
+		slots, over := rowSlots(i)
+		if over {
+			renderedKeys = append(renderedKeys, strings.Repeat(" ", (keyWidth+1)/2))
+		}
+		col := 0
+		for _, n := range slots {
+			if n.Key == "" {
+				renderedKeys = append(renderedKeys, strings.Repeat(" ", keyWidth))
+				continue
+			}
+			midi, _ := midiNote(m.noteFreq(n))
+			name := n.Name
+			switch {
+			case rowInstrument(i).Kit:
+				name = drumNames[col%len(drumNames)]
+			case m.transposing && currentKeyboard == kbSolfege:
+				name = musicKey{currentKey.signature(), 0}.names()[col]
+			case m.transposing:
+				name = noteName(int(midi) % 12)
+			}
+			col++
+			keyContent := fmt.Sprintf("%s\n%s", name, strings.ToUpper(n.Key))
+			active, waiting := keyContent+"\n●", keyContent
+			if l.compact {
+				keyContent = strings.ToUpper(n.Key) + " " + name
+				active, waiting = "●"+keyContent, "○"+keyContent
+			}
+			switch {
+			case m.activeKeys[n.Key]:
+				renderedKeys = append(renderedKeys, l.key(activeKeyStyle).Render(active))
+			case m.lesson.expects(n.Key), m.guiding(n.Key):
+				renderedKeys = append(renderedKeys, l.key(guideKeyStyle).Render(waiting))
+			case m.scale.open && int(midi)%12 == currentKey.root:
+				renderedKeys = append(renderedKeys, l.key(tonicKeyStyle).Render(keyContent))
+			case m.scale.open && currentKey.has(int(midi)):
+				renderedKeys = append(renderedKeys, l.key(scaleKeyStyle).Render(keyContent))
+			default:
+				renderedKeys = append(renderedKeys, l.key(keyStyle).Render(keyContent))
 			}
 		}
 		rowsStr = append(rowsStr, lipgloss.JoinHorizontal(lipgloss.Top, renderedKeys...))
 	}
-	keyboard := lipgloss.JoinVertical(lipgloss.Left, rowsStr...)
-
-	// Presets Bottom Bar
-	var presetItems1, presetItems2 []string
-	keys1 := []string{"1", "2", "3", "4", "5"}
-	keys2 := []string{"6", "7", "8", "9", "0"}
+	return rowsStr
+}
 
-	for _, k := range keys1 {
-		presetItems1 = append(presetItems1, formatPreset(k))
+// setupEngine loads the instruments and applies the sound settings of
+// the config, everything short of opening the audio device.
+func setupEngine(cfg Config) error {
+	if err := setSampleRate(cfg.SampleRate); err != nil {
+		return err
 	}
-	for _, k := range keys2 {
-		presetItems2 = append(presetItems2, formatPreset(k))
+	if err := loadPartials(partialsPath()); err != nil {
+		return err
+	}
+	if err := loadUserPresets(presetsDir()); err != nil {
+		return err
+	}
+	applyHumanizeConfig(cfg.Humanize)
+	if err := applyModulationConfig(cfg.Modulation); err != nil {
+		return err
+	}
+	if err := applyRowConfig(cfg.Rows); err != nil {
+		return err
 	}
+	master.tremolo = cfg.Tremolo
+	master.eq = cfg.EQ
+	master.compressor = cfg.Compressor
+	master.phaser = cfg.Phaser
+	master.flanger = cfg.Flanger
+	transport.setBPM(cfg.BPM)
+	transport.swing = max(50, min(75, cfg.Swing))
+	transport.countIn = max(0, min(maxCountIn, math.Round(cfg.CountIn)))
+	panByPitch = cfg.PitchPan
+	followShift = cfg.FollowShift
+	mainOut.volume = max(0, min(maxVolume, cfg.Volume))
+	mainOut.gain = mainOut.volume
+	return nil
+}
 
-	presetBar := lipgloss.JoinVertical(lipgloss.Center,
-		presetTitleStyle.Render("--- SAVED PRESETS ---"),
-		presetTextStyle.Render(strings.Join(presetItems1, "   ")),
-		presetTextStyle.Render(strings.Join(presetItems2, "   ")),
-	)
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "render" {
+		if err := renderCommand(os.Args[2:]); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	help := helpStyle.Render("TAB/S-TAB: Inst  •  1-0: Load  •  SHIFT+1-0: Save  •  L/R: Octave  •  SHIFT+KEY: Fast End")
+	configPath := flag.String("config", defaultConfigPath(), "path to config.json")
+	mappingsPath := flag.String("mappings", defaultMappingsPath(), "path to mappings.json")
+	backend := flag.String("backend", "", "audio output ("+strings.Join(backendNames(), ", ")+")")
+	jamHost := flag.String("host", "", "host a jam session on this address")
+	jamJoin := flag.String("join", "", "join the jam session at this address")
+	serve := flag.String("serve", "", "serve piango over SSH on this address")
+	midiOut := flag.String("midi-out", "", "mirror notes to this MIDI port (\"list\" shows them)")
+	midiIn := flag.String("midi-in", "", "play from this MIDI port (\"list\" shows them)")
+	replay := flag.String("replay", "", "play back this take, MIDI file or MusicXML score")
+	screenReader := flag.Bool("screen-reader", false, "show plain status lines for screen readers instead of the panel")
+	keyboard := flag.String("keyboard", "", "key layout (solfege, piano)")
+	lowLatency := flag.Bool("low-latency", false, "small buffer, higher audio priority, warmed-up voices")
+	wavetables := flag.Bool("wavetables", false, "play waveforms from precomputed tables, for slow machines")
+	autoBuf := flag.Bool("auto-buffer", false, "double the audio buffer after an underrun")
+	keymapName := flag.String("keymap", "", "physical keyboard layout (qwerty, azerty, qwertz, dvorak, colemak)")
+	rate := flag.Int("rate", 0, "sample rate in Hz (44100, 48000, 88200, 96000)")
+	flag.Parse()
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *backend != "" {
+		cfg.Backend = *backend
+	}
+	if *jamHost != "" || *jamJoin != "" {
+		cfg.Jam.Host, cfg.Jam.Join = *jamHost, *jamJoin
+	}
+	if *serve != "" {
+		cfg.SSH.Listen = *serve
+	}
+	if *midiOut == "list" {
+		listMIDIPorts(midiPorts())
+		return
+	}
+	if *midiOut != "" {
+		cfg.MIDIOut.Port = *midiOut
+	}
+	if *midiIn == "list" {
+		listMIDIPorts(midiInPorts())
+		return
+	}
+	if *midiIn != "" {
+		cfg.MIDIIn.Port = *midiIn
+	}
+	if *screenReader {
+		cfg.ScreenReader = true
+	}
+	if *autoBuf {
+		cfg.AutoBuffer = true
+	}
+	if *lowLatency {
+		cfg.LowLatency = true
+	}
+	if *wavetables {
+		cfg.Wavetables = true
+	}
+	if *keyboard != "" {
+		cfg.Keyboard = *keyboard
+	}
+	if *keymapName != "" {
+		cfg.Keymap = *keymapName
+	}
+	if *rate != 0 {
+		cfg.SampleRate = *rate
+	}
+	if err := setupEngine(cfg); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
 
-	ui := lipgloss.JoinVertical(lipgloss.Center, header, visualizer, keyboard, presetBar, help)
-	panel := panelStyle.Render(ui)
+	themeList, err := buildThemes(cfg.Themes)
+	if err == nil {
+		err = setThemes(themeList, cfg.Theme)
+	}
+	if err == nil {
+		_, err = visualizerStyle(cfg.Visualizer)
+	}
+	if err == nil {
+		currentKeyboard, err = keyboardStyle(cfg.Keyboard)
+	}
+	if err == nil {
+		currentKeymap, err = keymapStyle(cfg.Keymap)
+	}
+	var graphics int
+	if err == nil {
+		graphics, err = graphicsMode(cfg.Graphics)
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
 
-	return lipgloss.Place(m.width, m.height, lipgloss.Center, lipgloss.Center, panel)
-}
+	mappings, err := loadMappings(*mappingsPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
 
-func main() {
-	speaker.Init(sampleRate, sampleRate.N(50*time.Millisecond))
-	speaker.Play(mixer)
+	b, ok := backends[cfg.Backend]
+	if !ok {
+		fmt.Printf("Error: unknown backend %q\n", cfg.Backend)
+		os.Exit(1)
+	}
+	output = b
+	if cfg.Wavetables {
+		buildWavetables()
+	}
+	if cfg.LowLatency {
+		cfg.BufferMs = min(cfg.BufferMs, int(lowLatencyBuffer/time.Millisecond))
+	}
+	outputBuffer = sampleRate.N(time.Duration(cfg.BufferMs) * time.Millisecond)
+	if err := output.Init(sampleRate, outputBuffer); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer output.Close()
+	autoBuffer = cfg.AutoBuffer
+	mainOut.underruns.restart(output.Latency())
+	mainOut.raise = cfg.LowLatency
+
+	ambience.loadAmbienceFiles(ambienceDir())
+	mainOut.reset()
+	output.Play(mainOut)
 	initNotes()
 
-	p := tea.NewProgram(initialModel(), tea.WithAltScreen())
+	m := initialModel(cfg)
+	m.graphics = graphics // not for SSH visitors, whose terminals may differ
+	if cfg.LowLatency {
+		m = m.lowLatency()
+	}
+	m.cfgWatch = newConfigWatcher(*configPath)
+	m.presetWatch = newPresetWatcher(presetsDir())
+	m.journal = newSessionJournal(sessionPath())
+	if m.practice, err = loadPracticeLog(statsPath(), time.Now()); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if m.restore, err = loadSession(sessionPath()); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *replay != "" {
+		tk, err := loadPerformance(*replay)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		m = m.openReplay()
+		m.replay.player = newTakePlayer(strings.TrimSuffix(filepath.Base(*replay), ".json"), tk)
+	}
+	if cfg.MIDIIn.Port != "" || cfg.RTPMIDI.Listen != "" {
+		m.learn = newMIDILearn(cfg.MIDIIn, *configPath)
+	}
+	var sinks []eventSink
+	if cfg.OSC.Send != "" {
+		out, err := newOSCSender(cfg.OSC.Send)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		sinks = append(sinks, out)
+	}
+	if cfg.MIDIOut.Port != "" {
+		out, err := newMIDISender(cfg.MIDIOut)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer out.port.Close()
+		sinks = append(sinks, out)
+	}
+	rtp, err := listenRTPMIDI(cfg.RTPMIDI)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if rtp != nil {
+		defer rtp.Close()
+		sinks = append(sinks, midiSenderOn(rtp, cfg.RTPMIDI.Channel))
+	}
+	var hub *wsHub
+	if cfg.WebSocket != "" {
+		if hub, err = listenWebSocket(cfg.WebSocket, cfg.Origins); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		sinks = append(sinks, hub)
+	}
+	jam, err := newJamSession(cfg.Jam)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if jam != nil {
+		m.jam = jam
+		sinks = append(sinks, jam)
+	}
+	if len(sinks) > 0 {
+		m.events = newPerfTracker(sinks...)
+	}
+
+	var root tea.Model = m
+	if cfg.SSH.Listen != "" {
+		root = sharedModel{m: m} // takes turns with the SSH sessions, see ssh.go
+	}
+	p := tea.NewProgram(root, tea.WithAltScreen(), tea.WithMouseAllMotion())
+	triggers, err := startMappings(p, mappings, cfg.Origins)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := startOSC(p, cfg.OSC, triggers); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if hub != nil {
+		hub.serve(p)
+	}
+	if err := startHTTPAPI(p, cfg.HTTPAPI, cfg.Origins); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if jam != nil {
+		jam.serve(p)
+	}
+	if err := startSSH(cfg.SSH, cfg); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	midiInput, err := startMIDIInput(p, cfg.MIDIIn, triggers)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if midiInput != nil {
+		defer midiInput.Close()
+	}
+	if rtp != nil {
+		rtp.serve(p, cfg.RTPMIDI.Connect, triggers)
+		// Without Bonjour (port 5353 taken, no multicast) the session can
+		// still be reached by address, so that's not fatal
+		if ad, err := advertiseRTPMIDI(rtp.name, rtp.port()); err == nil {
+			defer ad.Close()
+		}
+	}
 	if _, err := p.Run(); err != nil {
 		fmt.Printf("Error: %v", err)
 	}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// --- EXTERNAL CONTROL MAPPINGS ---
+//
+// Stream decks and macro pads fire "triggers" (an HTTP path, a line on a
+// TCP socket, an OSC address, a MIDI note or controller). mappings.json
+// binds trigger names to named actions, which are delivered to the TUI as
+// actionMsg so they run on the same goroutine as keyboard input. A MIDI
+// note or OSC address bound to an action does only that: it isn't played
+// or taken as a remote command as well.
+//
+// HTTP triggers must be POSTs, and requests from web pages are checked
+// against "origins" as for the WebSocket, so a page the user happens to
+// visit can't fire them.
+
+type actionMsg string
+
+// actions are the internal operations a trigger can be bound to.
+var actions = map[string]func(m model) model{
+	"panic":           func(m model) model { return m.panic() },
+	"next_instrument": func(m model) model { return m.cycleInstrument(1) },
+	"prev_instrument": func(m model) model { return m.cycleInstrument(-1) },
+	"octave_up":       func(m model) model { return m.shiftOctave(1) },
+	"octave_down":     func(m model) model { return m.shiftOctave(-1) },
+	"ambience_next":   func(m model) model { return m.nextAmbience() },
+	"ambience_up":     func(m model) model { return m.adjustAmbience(0.1) },
+	"ambience_down":   func(m model) model { return m.adjustAmbience(-0.1) },
+	"theremin":        func(m model) model { return m.toggleTheremin() },
+	"vibrato":         func(m model) model { return m.toggleVibrato() },
+	"play_mode":       func(m model) model { return m.cyclePlayMode() },
+	"cutoff_up":       func(m model) model { return m.adjustInstrument(cutoffParam, 1) },
+	"cutoff_down":     func(m model) model { return m.adjustInstrument(cutoffParam, -1) },
+	"resonance":       func(m model) model { return m.cycleResonance() },
+	"drums":           func(m model) model { return m.toggleDrumMachine() },
+	"tempo_up":        func(m model) model { return m.adjustTempo(2) },
+	"tempo_down":      func(m model) model { return m.adjustTempo(-2) },
+	"volume_up":       func(m model) model { return m.adjustVolume(volumeStep) },
+	"volume_down":     func(m model) model { return m.adjustVolume(-volumeStep) },
+	"theme_next":      func(m model) model { return m.cycleTheme() },
+	"record":          func(m model) model { return m.toggleRecording() },
+	"undo":            func(m model) model { return m.undo() },
+	"redo":            func(m model) model { return m.redo() },
+	"velocity_mode": func(m model) model {
+		m.velocity.nextMode()
+		return m
+	},
+}
+
+func init() {
+	// preset_1 ... preset_0 recall the saved preset slots
+	for _, key := range []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "0"} {
+		actions["preset_"+key] = func(m model) model { return m.loadPreset(key) }
+	}
+	// bypass_eq, bypass_compressor, ... switch a master effect out and back
+	for i, name := range masterEffects {
+		actions["bypass_"+name] = func(m model) model { return m.toggleBypass(i) }
+	}
+}
+
+// snapshot is a state a single trigger recalls, snapshot_NAME for the
+// snapshot called NAME. Whatever it leaves out stays as it is.
+type snapshot struct {
+	Instrument string   `json:"instrument"`
+	Octave     *int     `json:"octave"`
+	Volume     *float64 `json:"volume"`
+	Bypass     []string `json:"bypass"` // the master effects to switch out; the rest go back in
+}
+
+type Binding struct {
+	Trigger string `json:"trigger"`
+	Action  string `json:"action"`
+}
+
+// Mappings is the contents of ~/.config/piango/mappings.json.
+type Mappings struct {
+	Socket    string              `json:"socket"` // TCP address for line commands
+	HTTP      string              `json:"http"`   // address for the HTTP trigger server
+	Snapshots map[string]snapshot `json:"snapshots"`
+	Bindings  []Binding           `json:"bindings"`
+}
+
+func defaultMappingsPath() string {
+	dir := configDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "mappings.json")
+}
+
+// loadMappings reads and validates the mappings file. A missing file
+// means no external control.
+func loadMappings(path string) (Mappings, error) {
+	var mp Mappings
+	if path == "" {
+		return mp, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return mp, nil
+	}
+	if err != nil {
+		return mp, err
+	}
+	if err := json.Unmarshal(data, &mp); err != nil {
+		return mp, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for name, snap := range mp.Snapshots {
+		if err := snap.check(); err != nil {
+			return mp, fmt.Errorf("%s: snapshot %q: %w", path, name, err)
+		}
+		actions["snapshot_"+name] = func(m model) model { return m.recallSnapshot(name, snap) }
+	}
+	for _, b := range mp.Bindings {
+		kind, rest, ok := strings.Cut(b.Trigger, ":")
+		valid := ok && (kind == "socket" || kind == "http" ||
+			kind == "osc" && strings.HasPrefix(rest, "/") ||
+			kind == "midi" && validMIDITrigger(rest))
+		if !valid {
+			return mp, fmt.Errorf("%s: unsupported trigger %q", path, b.Trigger)
+		}
+		if _, ok := actions[b.Action]; !ok {
+			return mp, fmt.Errorf("%s: unknown action %q for %q", path, b.Action, b.Trigger)
+		}
+	}
+	return mp, nil
+}
+
+func (s snapshot) check() error {
+	if s.Instrument != "" && slices.IndexFunc(instruments, func(inst *Instrument) bool { return inst.Name == s.Instrument }) < 0 {
+		return fmt.Errorf("unknown instrument %q", s.Instrument)
+	}
+	if s.Octave != nil && (*s.Octave < -2 || *s.Octave > 2) {
+		return fmt.Errorf("octave %d is outside -2..2", *s.Octave)
+	}
+	if s.Volume != nil && (*s.Volume < 0 || *s.Volume > maxVolume) {
+		return fmt.Errorf("volume %g is outside 0..%g", *s.Volume, maxVolume)
+	}
+	for _, fx := range s.Bypass {
+		if !slices.Contains(masterEffects[:], fx) {
+			return fmt.Errorf("unknown effect %q", fx)
+		}
+	}
+	return nil
+}
+
+// recallSnapshot sets what the snapshot names and leaves the rest.
+func (m model) recallSnapshot(name string, s snapshot) model {
+	if s.Instrument != "" {
+		voiceLock.Lock()
+		if id := slices.IndexFunc(instruments, func(inst *Instrument) bool { return inst.Name == s.Instrument }); id >= 0 {
+			currentInstID = id
+			m.instName = instruments[currentInstID].Name
+		}
+		voiceLock.Unlock()
+	}
+	if s.Octave != nil {
+		m = m.shiftOctave(*s.Octave - m.octaveShift)
+	}
+	output.Lock()
+	if s.Volume != nil {
+		mainOut.volume = *s.Volume
+		m.volume = mainOut.volume
+	}
+	if s.Bypass != nil {
+		for i, fx := range masterEffects {
+			master.bypass[i] = slices.Contains(s.Bypass, fx)
+		}
+	}
+	output.Unlock()
+
+	m.notification = fmt.Sprintf("Snapshot %s", name)
+	m.notifyClearTime = time.Now().Add(1 * time.Second)
+	return m
+}
+
+// toggleBypass switches master effect i out of the chain, or back in.
+func (m model) toggleBypass(i int) model {
+	output.Lock()
+	master.bypass[i] = !master.bypass[i]
+	off := master.bypass[i]
+	output.Unlock()
+
+	state := "on"
+	if off {
+		state = "bypassed"
+	}
+	m.notification = fmt.Sprintf("Master %s %s", masterEffects[i], state)
+	m.notifyClearTime = time.Now().Add(1 * time.Second)
+	return m
+}
+
+// validMIDITrigger checks the part after "midi:": note:N or cc:N, N being
+// 0-127 on any channel.
+func validMIDITrigger(s string) bool {
+	kind, num, _ := strings.Cut(s, ":")
+	n, err := strconv.Atoi(num)
+	return (kind == "note" || kind == "cc") && err == nil && n >= 0 && n < 128
+}
+
+// triggerRouter resolves triggers to actions and forwards them to the
+// running program. Safe to use from any goroutine; a nil router has no
+// bindings.
+type triggerRouter struct {
+	program  *tea.Program
+	bindings map[string]string
+
+	mu     sync.Mutex
+	ccHigh [128]bool // controllers past halfway, so a held button fires once
+}
+
+func (r *triggerRouter) fire(trigger string) bool {
+	if r == nil {
+		return false
+	}
+	action, ok := r.bindings[trigger]
+	if !ok {
+		return false
+	}
+	r.program.Send(actionMsg(action))
+	return true
+}
+
+func (r *triggerRouter) bound(trigger string) bool {
+	if r == nil {
+		return false
+	}
+	_, ok := r.bindings[trigger]
+	return ok
+}
+
+// fireOSC takes an OSC message bound to an action, reporting whether it
+// was. Buttons send 1 when pressed and 0 when let go, so a first argument
+// of 0 is swallowed without firing.
+func (r *triggerRouter) fireOSC(msg oscMessage) bool {
+	trigger := "osc:" + msg.Address
+	if !r.bound(trigger) {
+		return false
+	}
+	if len(msg.Args) > 0 {
+		if v, ok := oscNumber(msg.Args[0]); ok && v == 0 {
+			return true
+		}
+	}
+	return r.fire(trigger)
+}
+
+// fireMIDI takes a note or control change bound to an action, reporting
+// whether it was. A note fires when struck; a controller when it rises
+// past halfway, as a pad or button does when pressed.
+func (r *triggerRouter) fireMIDI(msg []byte) bool {
+	if len(msg) < 3 {
+		return false
+	}
+	switch msg[0] & 0xF0 {
+	case 0x80, 0x90:
+		trigger := fmt.Sprintf("midi:note:%d", msg[1])
+		if !r.bound(trigger) {
+			return false
+		}
+		if msg[0]&0xF0 == 0x90 && msg[2] > 0 {
+			r.fire(trigger)
+		}
+		return true
+	case 0xB0:
+		trigger := fmt.Sprintf("midi:cc:%d", msg[1])
+		if !r.bound(trigger) {
+			return false
+		}
+		high := msg[2] >= 64
+		r.mu.Lock()
+		rose := high && !r.ccHigh[msg[1]&0x7F]
+		r.ccHigh[msg[1]&0x7F] = high
+		r.mu.Unlock()
+		if rose {
+			r.fire(trigger)
+		}
+		return true
+	}
+	return false
+}
+
+// startMappings opens the listeners the mappings file asks for. The
+// router it returns is for the OSC and MIDI inputs to check first.
+func startMappings(p *tea.Program, mp Mappings, origins []string) (*triggerRouter, error) {
+	r := &triggerRouter{program: p, bindings: make(map[string]string)}
+	for _, b := range mp.Bindings {
+		r.bindings[b.Trigger] = b.Action
+	}
+
+	if mp.Socket != "" {
+		ln, err := net.Listen("tcp", mp.Socket)
+		if err != nil {
+			return nil, err
+		}
+		go serveSocketTriggers(ln, r)
+	}
+
+	if mp.HTTP != "" {
+		ln, err := net.Listen("tcp", mp.HTTP)
+		if err != nil {
+			return nil, err
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc("POST /", func(w http.ResponseWriter, req *http.Request) {
+			if !originAllowed(req, origins) {
+				http.Error(w, "origin not allowed", http.StatusForbidden)
+				return
+			}
+			if !r.fire("http:" + req.URL.Path) {
+				http.NotFound(w, req)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+		go http.Serve(ln, mux)
+	}
+	return r, nil
+}
+
+// serveSocketTriggers accepts connections that send one trigger name per
+// line, answering "ok" or "unknown" to each.
+func serveSocketTriggers(ln net.Listener, r *triggerRouter) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			sc := bufio.NewScanner(conn)
+			for sc.Scan() {
+				cmd := strings.TrimSpace(sc.Text())
+				if cmd == "" {
+					continue
+				}
+				if r.fire("socket:" + cmd) {
+					fmt.Fprintln(conn, "ok")
+				} else {
+					fmt.Fprintln(conn, "unknown")
+				}
+			}
+		}()
+	}
+}
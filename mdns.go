@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// --- BONJOUR ---
+//
+// Just enough of an mDNS responder to announce the RTP-MIDI session as an
+// _apple-midi._udp service, so Audio MIDI Setup and iOS apps list it
+// without anyone typing an address. It answers questions about the
+// service, the instance and the host name, and says so once at startup.
+
+const (
+	mdnsPTR = 12
+	mdnsTXT = 16
+	mdnsSRV = 33
+	mdnsA   = 1
+	mdnsAny = 255
+
+	mdnsFlush = 0x8000 // cache-flush bit on the class of records only we own
+)
+
+var (
+	mdnsGroup   = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+	mdnsService = []string{"_apple-midi", "_udp", "local"}
+)
+
+type mdnsAdvert struct {
+	conn     *net.UDPConn
+	instance []string
+	host     []string
+	port     int
+}
+
+// advertiseRTPMIDI announces name on port and keeps answering queries
+// until Close.
+func advertiseRTPMIDI(name string, port int) (*mdnsAdvert, error) {
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	host, _, _ = strings.Cut(host, ".")
+	if host == "" {
+		host = "piango"
+	}
+	a := &mdnsAdvert{
+		conn:     conn,
+		instance: append([]string{name}, mdnsService...),
+		host:     []string{host, "local"},
+		port:     port,
+	}
+
+	go a.serve()
+	go func() {
+		for range 2 {
+			conn.WriteToUDP(a.response(0, mdnsPTR, mdnsService), mdnsGroup)
+			time.Sleep(time.Second)
+		}
+	}()
+	return a, nil
+}
+
+func (a *mdnsAdvert) Close() error { return a.conn.Close() }
+
+func (a *mdnsAdvert) serve() {
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := a.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		msg := buf[:n]
+		if n < 12 || msg[2]&0x80 != 0 { // too short, or a response
+			continue
+		}
+		id := binary.BigEndian.Uint16(msg)
+		off := 12
+		for range binary.BigEndian.Uint16(msg[4:]) {
+			name, next, ok := mdnsReadName(msg, off)
+			if !ok || next+4 > n {
+				break
+			}
+			qtype := binary.BigEndian.Uint16(msg[next:])
+			off = next + 4
+
+			resp := a.response(id, qtype, name)
+			if resp == nil {
+				continue
+			}
+			a.conn.WriteToUDP(resp, mdnsGroup)
+			if from.Port != mdnsGroup.Port { // a one-shot resolver wants it directly
+				a.conn.WriteToUDP(resp, from)
+			}
+		}
+	}
+}
+
+// response answers one question, or is nil if it isn't about us.
+func (a *mdnsAdvert) response(id, qtype uint16, name []string) []byte {
+	want := func(t uint16) bool { return qtype == t || qtype == mdnsAny }
+	var answers, extra [][]byte
+	switch {
+	case mdnsSameName(name, mdnsService) && want(mdnsPTR):
+		answers = append(answers, a.ptr())
+		extra = append(extra, a.srv(), a.txt())
+		extra = append(extra, a.addrs()...)
+	case mdnsSameName(name, a.instance) && (want(mdnsSRV) || want(mdnsTXT)):
+		answers = append(answers, a.srv(), a.txt())
+		extra = append(extra, a.addrs()...)
+	case mdnsSameName(name, a.host) && want(mdnsA):
+		answers = append(answers, a.addrs()...)
+	default:
+		return nil
+	}
+
+	b := binary.BigEndian.AppendUint16(nil, id)
+	b = binary.BigEndian.AppendUint16(b, 0x8400) // authoritative response
+	b = binary.BigEndian.AppendUint16(b, 0)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(answers)))
+	b = binary.BigEndian.AppendUint16(b, 0)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(extra)))
+	for _, r := range append(answers, extra...) {
+		b = append(b, r...)
+	}
+	return b
+}
+
+func (a *mdnsAdvert) ptr() []byte {
+	return mdnsRecord(mdnsService, mdnsPTR, 1, 4500, mdnsName(a.instance))
+}
+
+func (a *mdnsAdvert) srv() []byte {
+	data := []byte{0, 0, 0, 0} // priority, weight
+	data = binary.BigEndian.AppendUint16(data, uint16(a.port))
+	return mdnsRecord(a.instance, mdnsSRV, 1|mdnsFlush, 120, append(data, mdnsName(a.host)...))
+}
+
+func (a *mdnsAdvert) txt() []byte {
+	return mdnsRecord(a.instance, mdnsTXT, 1|mdnsFlush, 4500, []byte{0})
+}
+
+// addrs is an A record for every IPv4 address the machine has.
+func (a *mdnsAdvert) addrs() [][]byte {
+	var records [][]byte
+	ifaddrs, _ := net.InterfaceAddrs()
+	for _, addr := range ifaddrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.IsLoopback() || ipnet.IP.To4() == nil {
+			continue
+		}
+		records = append(records, mdnsRecord(a.host, mdnsA, 1|mdnsFlush, 120, ipnet.IP.To4()))
+	}
+	return records
+}
+
+func mdnsRecord(name []string, rtype, class uint16, ttl uint32, data []byte) []byte {
+	b := mdnsName(name)
+	b = binary.BigEndian.AppendUint16(b, rtype)
+	b = binary.BigEndian.AppendUint16(b, class)
+	b = binary.BigEndian.AppendUint32(b, ttl)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(data)))
+	return append(b, data...)
+}
+
+// mdnsName writes labels uncompressed.
+func mdnsName(labels []string) []byte {
+	var b []byte
+	for _, l := range labels {
+		b = append(append(b, byte(len(l))), l...)
+	}
+	return append(b, 0)
+}
+
+// mdnsReadName reads a possibly compressed name, returning its labels and
+// where the data after it starts.
+func mdnsReadName(msg []byte, off int) ([]string, int, bool) {
+	var labels []string
+	next := -1
+	for jumps := 0; off < len(msg); {
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return labels, next, true
+		case n&0xC0 == 0xC0:
+			if off+1 >= len(msg) || jumps > 10 {
+				return nil, 0, false
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+			jumps++
+		default:
+			if off+1+n > len(msg) {
+				return nil, 0, false
+			}
+			labels = append(labels, string(msg[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+	return nil, 0, false
+}
+
+// mdnsSameName compares names the DNS way, ignoring case.
+func mdnsSameName(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !strings.EqualFold(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
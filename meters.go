@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// --- LEVEL METERS ---
+//
+// Ctrl+V shows a meter for each channel under the header, and the
+// settings (Ctrl+O) always have them. They read the output's samples after
+// the master volume, on their way into the limiter. The bar is the level,
+// averaged over about 300 ms like a VU meter, and the tick the peak, held
+// for a moment before it falls back. LIMIT lights while the limiter is
+// bending the peaks and CLIP when they went past full scale: the limiter
+// rounds them off, but what's recorded at that level sounds squashed. The
+// scale runs from -48 dB, below which a take is too quiet, to +6.
+
+const (
+	meterFloor   = -48.0 // dB
+	meterCeiling = 6.0
+	meterWidth   = 40
+	meterVU      = 0.3 // seconds the level is averaged over
+	meterHold    = 1500 * time.Millisecond
+	meterFall    = 20.0 // dB a second, once the hold is over
+)
+
+// levelTap gathers the output since the meters last looked.
+type levelTap struct {
+	peak [2]float64
+	sum  [2]float64 // of squares
+	n    int
+}
+
+func (t *levelTap) take(l, r float64) {
+	t.peak[0] = max(t.peak[0], math.Abs(l))
+	t.peak[1] = max(t.peak[1], math.Abs(r))
+	t.sum[0] += l * l
+	t.sum[1] += r * r
+	t.n++
+}
+
+type levelMeters struct {
+	show      bool
+	level     [2]float64 // dB
+	peak      [2]float64 // dB, the held peak
+	heldUntil [2]time.Time
+	limiting  time.Time // until when LIMIT stays lit
+	clipped   time.Time
+	last      time.Time
+}
+
+func (m model) toggleMeters() model {
+	m.meters.show = !m.meters.show
+	m.meters.restart()
+	return m
+}
+
+// restart drops what played while the meters weren't shown.
+func (lm *levelMeters) restart() {
+	output.Lock()
+	mainOut.tap = levelTap{}
+	output.Unlock()
+	*lm = levelMeters{show: lm.show}
+}
+
+// follow takes what was played since the last tick.
+func (lm *levelMeters) follow(now time.Time) {
+	output.Lock()
+	tap := mainOut.tap
+	mainOut.tap = levelTap{}
+	output.Unlock()
+
+	dt := min(now.Sub(lm.last).Seconds(), 1)
+	if lm.last.IsZero() {
+		dt = 1
+		lm.level = [2]float64{meterFloor, meterFloor}
+		lm.peak = lm.level
+	}
+	lm.last = now
+	for c := range 2 {
+		level := meterFloor
+		if tap.n > 0 {
+			level = toDB(math.Sqrt(tap.sum[c] / float64(tap.n)))
+		}
+		lm.level[c] += (level - lm.level[c]) * (1 - math.Exp(-dt/meterVU))
+
+		peak := toDB(tap.peak[c])
+		if peak >= lm.peak[c] {
+			lm.peak[c], lm.heldUntil[c] = peak, now.Add(meterHold)
+		} else if now.After(lm.heldUntil[c]) {
+			lm.peak[c] = max(peak, lm.peak[c]-meterFall*dt)
+		}
+		if tap.peak[c] > limitKnee {
+			lm.limiting = now.Add(meterHold)
+		}
+		if tap.peak[c] > 1 {
+			lm.clipped = now.Add(2 * meterHold)
+		}
+	}
+}
+
+// toDB is a level in dB from full scale, no lower than the meters go.
+func toDB(v float64) float64 {
+	return max(meterFloor, 20*math.Log10(max(v, 1e-9)))
+}
+
+// view draws the two meters width characters wide, the lights beside.
+func (lm levelMeters) view(width int, now time.Time) string {
+	dim := presetTitleStyle.UnsetMarginTop().UnsetMarginBottom()
+	over := notifyStyle.UnsetMarginBottom().UnsetPadding()
+	hot := lipgloss.NewStyle().Foreground(notifyStyle.GetBackground())
+	tickStyle := lipgloss.NewStyle().Foreground(instStyle.GetForeground())
+	at := func(db float64) int {
+		return int(math.Round((db - meterFloor) / (meterCeiling - meterFloor) * float64(width)))
+	}
+	zero := at(0)
+
+	var rows []string
+	for c, name := range []string{"L", "R"} {
+		filled, tick := at(lm.level[c]), min(at(lm.peak[c]), width-1)
+		var bar strings.Builder
+		for i := range width {
+			switch {
+			case i == tick && lm.peak[c] > meterFloor:
+				bar.WriteString(tickStyle.Render("│"))
+			case i < filled && i >= zero:
+				bar.WriteString(hot.Render("█"))
+			case i < filled:
+				bar.WriteString(waveColor.Render("█"))
+			case i == zero:
+				bar.WriteString(dim.Render("┊"))
+			default:
+				bar.WriteString(helpStyle.UnsetMarginTop().Render("░"))
+			}
+		}
+		readout := "  -inf"
+		if lm.peak[c] > meterFloor {
+			readout = fmt.Sprintf("%+6.1f", lm.peak[c])
+		}
+		rows = append(rows, fmt.Sprintf("%s %s %s dB", dim.Render(name), bar.String(), readout))
+	}
+
+	light := func(name string, until time.Time) string {
+		if now.Before(until) {
+			return over.Render(name)
+		}
+		return dim.Render(name)
+	}
+	lights := lipgloss.JoinVertical(lipgloss.Left, light("LIMIT", lm.limiting), light("CLIP", lm.clipped))
+	return lipgloss.JoinHorizontal(lipgloss.Top, lipgloss.JoinVertical(lipgloss.Left, rows...), "  ", lights)
+}
+
+// metersWidth fits the meters' bars in width, with their labels and
+// lights.
+func metersWidth(width int) int {
+	return max(10, min(meterWidth, width-20))
+}
@@ -0,0 +1,69 @@
+package main
+
+import "math"
+
+// --- METRONOME ---
+
+// Metronome clicks on every beat, accenting the first beat of the bar.
+// It is played beside the instrument bus with playDry; set stopped to let
+// it drain out of the mixer. Fields are changed under output.Lock().
+type Metronome struct {
+	bpm         float64 // 0 follows the transport
+	beatsPerBar int
+	volume      float64
+	stopped     bool
+
+	pos   int // samples into the current beat
+	beat  int
+	phase float64
+}
+
+const clickLength = 0.03 // seconds
+
+func newMetronome(bpm float64) *Metronome {
+	return &Metronome{bpm: bpm, beatsPerBar: 4, volume: 0.3}
+}
+
+func (mt *Metronome) Stream(samples [][2]float64) (n int, ok bool) {
+	if mt.stopped {
+		return 0, false
+	}
+
+	sr := float64(sampleRate)
+	bpm := mt.bpm
+	if bpm <= 0 {
+		bpm = transport.bpm
+	}
+	beatLen := int(sr * 60 / bpm)
+	clickLen := int(sr * clickLength)
+
+	freq := 1000.0
+	if mt.beat == 0 {
+		freq = 1600.0
+	}
+
+	for i := range samples {
+		var v float64
+		if mt.pos < clickLen {
+			env := 1 - float64(mt.pos)/float64(clickLen)
+			v = math.Sin(mt.phase) * env * env * mt.volume
+			mt.phase += freq * 2 * math.Pi / sr
+		}
+		samples[i][0] = v
+		samples[i][1] = v
+
+		mt.pos++
+		if mt.pos >= beatLen {
+			mt.pos = 0
+			mt.phase = 0
+			mt.beat = (mt.beat + 1) % mt.beatsPerBar
+			freq = 1000.0
+			if mt.beat == 0 {
+				freq = 1600.0
+			}
+		}
+	}
+	return len(samples), true
+}
+
+func (mt *Metronome) Err() error { return nil }
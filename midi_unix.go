@@ -0,0 +1,78 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Raw MIDI device files: ALSA's /dev/snd/midiCxDy on Linux, /dev/umidiN.N
+// on the BSDs. A port is listed as its path, plus the card's name where
+// /proc/asound knows it. The same devices are read for input.
+
+func midiPorts() []string {
+	var ports []string
+	for _, pattern := range []string{"/dev/snd/midiC*D*", "/dev/midi*", "/dev/umidi*"} {
+		paths, _ := filepath.Glob(pattern)
+		for _, path := range paths {
+			var card, dev int
+			if _, err := fmt.Sscanf(filepath.Base(path), "midiC%dD%d", &card, &dev); err == nil {
+				if id, err := os.ReadFile(fmt.Sprintf("/proc/asound/card%d/id", card)); err == nil {
+					path += " (" + strings.TrimSpace(string(id)) + ")"
+				}
+			}
+			ports = append(ports, path)
+		}
+	}
+	return ports
+}
+
+type rawMIDI struct {
+	f *os.File
+}
+
+func openMIDIDevice(_ int, name string) (midiPort, error) {
+	path, _, _ := strings.Cut(name, " (")
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("midi out: %w", err)
+	}
+	return &rawMIDI{f: f}, nil
+}
+
+func (r *rawMIDI) send(msg []byte) error {
+	_, err := r.f.Write(msg)
+	return err
+}
+
+func (r *rawMIDI) Close() error { return r.f.Close() }
+
+func midiInPorts() []string { return midiPorts() }
+
+// openMIDIInput reads the device until it's closed, handing fn each
+// complete message.
+func openMIDIInput(_ int, name string, fn func(msg []byte)) (io.Closer, error) {
+	path, _, _ := strings.Cut(name, " (")
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("midi in: %w", err)
+	}
+	go func() {
+		var p midiParser
+		buf := make([]byte, 256)
+		for {
+			n, err := f.Read(buf)
+			for _, b := range buf[:n] {
+				p.feed(b, fn)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return f, nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"syscall"
+	"unsafe"
+)
+
+// The Windows multimedia API, called straight from winmm.dll so no cgo is
+// needed. Short messages are packed little-endian into one DWORD.
+
+var (
+	winmm                 = syscall.NewLazyDLL("winmm.dll")
+	procMidiOutGetNumDevs = winmm.NewProc("midiOutGetNumDevs")
+	procMidiOutGetDevCaps = winmm.NewProc("midiOutGetDevCapsW")
+	procMidiOutOpen       = winmm.NewProc("midiOutOpen")
+	procMidiOutShortMsg   = winmm.NewProc("midiOutShortMsg")
+	procMidiOutClose      = winmm.NewProc("midiOutClose")
+	procMidiInGetNumDevs  = winmm.NewProc("midiInGetNumDevs")
+	procMidiInGetDevCaps  = winmm.NewProc("midiInGetDevCapsW")
+	procMidiInOpen        = winmm.NewProc("midiInOpen")
+	procMidiInStart       = winmm.NewProc("midiInStart")
+	procMidiInStop        = winmm.NewProc("midiInStop")
+	procMidiInClose       = winmm.NewProc("midiInClose")
+)
+
+const (
+	callbackFunction = 0x30000
+	mimData          = 0x3C3 // a short message arrived
+)
+
+// midiOutCaps is MIDIOUTCAPSW.
+type midiOutCaps struct {
+	Mid, Pid      uint16
+	DriverVersion uint32
+	Pname         [32]uint16
+	Technology    uint16
+	Voices        uint16
+	Notes         uint16
+	ChannelMask   uint16
+	Support       uint32
+}
+
+func midiPorts() []string {
+	if winmm.Load() != nil {
+		return nil
+	}
+	n, _, _ := procMidiOutGetNumDevs.Call()
+	var ports []string
+	for i := uintptr(0); i < n; i++ {
+		var caps midiOutCaps
+		r, _, _ := procMidiOutGetDevCaps.Call(i, uintptr(unsafe.Pointer(&caps)), unsafe.Sizeof(caps))
+		if r != 0 {
+			ports = append(ports, fmt.Sprintf("MIDI out %d", i))
+			continue
+		}
+		ports = append(ports, syscall.UTF16ToString(caps.Pname[:]))
+	}
+	return ports
+}
+
+// midiInCaps is MIDIINCAPSW.
+type midiInCaps struct {
+	Mid, Pid      uint16
+	DriverVersion uint32
+	Pname         [32]uint16
+	Support       uint32
+}
+
+func midiInPorts() []string {
+	if winmm.Load() != nil {
+		return nil
+	}
+	n, _, _ := procMidiInGetNumDevs.Call()
+	var ports []string
+	for i := uintptr(0); i < n; i++ {
+		var caps midiInCaps
+		r, _, _ := procMidiInGetDevCaps.Call(i, uintptr(unsafe.Pointer(&caps)), unsafe.Sizeof(caps))
+		if r != 0 {
+			ports = append(ports, fmt.Sprintf("MIDI in %d", i))
+			continue
+		}
+		ports = append(ports, syscall.UTF16ToString(caps.Pname[:]))
+	}
+	return ports
+}
+
+type winMIDIIn struct {
+	handle uintptr
+}
+
+// openMIDIInput has winmm call back with each short message, unpacked
+// back into bytes for fn. SysEx is ignored.
+func openMIDIInput(id int, name string, fn func(msg []byte)) (io.Closer, error) {
+	cb := syscall.NewCallback(func(h, msg, instance, param1, param2 uintptr) uintptr {
+		if msg != mimData {
+			return 0
+		}
+		status := byte(param1)
+		b := []byte{status, byte(param1 >> 8), byte(param1 >> 16)}
+		fn(b[:midiMessageLen(status)])
+		return 0
+	})
+	var h uintptr
+	if r, _, _ := procMidiInOpen.Call(uintptr(unsafe.Pointer(&h)), uintptr(id), cb, 0, callbackFunction); r != 0 {
+		return nil, fmt.Errorf("midi in: can't open %s (error %d)", name, r)
+	}
+	procMidiInStart.Call(h)
+	return &winMIDIIn{handle: h}, nil
+}
+
+func (w *winMIDIIn) Close() error {
+	procMidiInStop.Call(w.handle)
+	procMidiInClose.Call(w.handle)
+	return nil
+}
+
+type winMIDI struct {
+	handle uintptr
+}
+
+func openMIDIDevice(id int, name string) (midiPort, error) {
+	var h uintptr
+	if r, _, _ := procMidiOutOpen.Call(uintptr(unsafe.Pointer(&h)), uintptr(id), 0, 0, 0); r != 0 {
+		return nil, fmt.Errorf("midi out: can't open %s (error %d)", name, r)
+	}
+	return &winMIDI{handle: h}, nil
+}
+
+func (w *winMIDI) send(msg []byte) error {
+	var packed uint32
+	for i, b := range msg {
+		packed |= uint32(b) << (8 * i)
+	}
+	if r, _, _ := procMidiOutShortMsg.Call(w.handle, uintptr(packed)); r != 0 {
+		return fmt.Errorf("midi out: error %d", r)
+	}
+	return nil
+}
+
+func (w *winMIDI) Close() error {
+	procMidiOutClose.Call(w.handle)
+	return nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+)
+
+// --- MIDI FILES ---
+//
+// Standard MIDI Files (format 0 and 1) read into a take, so `piango
+// render` can play them like a recorded session. Notes from every track
+// and channel are kept, each channel's notes apart; tempo changes are
+// followed, everything else is skipped.
+
+const defaultTempo = 500000 // µs per quarter note, 120 BPM
+
+type smfNote struct {
+	tick     int64
+	on       bool
+	channel  byte
+	note     byte
+	velocity byte
+}
+
+type smfTempo struct {
+	tick  int64
+	usPQN int
+}
+
+// loadMIDIFile reads a .mid into a take.
+func loadMIDIFile(path string) (take, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return take{}, err
+	}
+	tk, err := parseMIDIFile(data)
+	if err != nil {
+		return take{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return tk, nil
+}
+
+func parseMIDIFile(data []byte) (take, error) {
+	if len(data) < 14 || string(data[:4]) != "MThd" {
+		return take{}, errors.New("not a MIDI file")
+	}
+	hdrLen := int(binary.BigEndian.Uint32(data[4:]))
+	if hdrLen < 6 || 8+hdrLen > len(data) {
+		return take{}, errors.New("bad MIDI header")
+	}
+	format := binary.BigEndian.Uint16(data[8:])
+	if format > 1 {
+		return take{}, fmt.Errorf("MIDI format %d isn't supported", format)
+	}
+	division := int16(binary.BigEndian.Uint16(data[12:]))
+	if division == 0 {
+		return take{}, errors.New("bad MIDI time division")
+	}
+
+	var notes []smfNote
+	tempos := []smfTempo{{0, defaultTempo}}
+	for off := 8 + hdrLen; off+8 <= len(data); {
+		id, size := string(data[off:off+4]), int(binary.BigEndian.Uint32(data[off+4:]))
+		off += 8
+		if size < 0 || off+size > len(data) {
+			return take{}, errors.New("MIDI chunk overruns the file")
+		}
+		if id == "MTrk" {
+			if err := parseMIDITrack(data[off:off+size], &notes, &tempos); err != nil {
+				return take{}, err
+			}
+		}
+		off += size
+	}
+
+	// Ticks to seconds, through the tempo map
+	sort.SliceStable(tempos, func(i, j int) bool { return tempos[i].tick < tempos[j].tick })
+	seconds := func(tick int64) float64 {
+		if division < 0 { // SMPTE: frames per second times ticks per frame
+			fps := float64(-(division >> 8))
+			if fps == 29 {
+				fps = 29.97
+			}
+			return float64(tick) / (fps * float64(division&0xFF))
+		}
+		var t float64
+		for i, tp := range tempos {
+			end := tick
+			if i+1 < len(tempos) && tempos[i+1].tick < tick {
+				end = tempos[i+1].tick
+			}
+			if end > tp.tick {
+				t += float64(end-tp.tick) * float64(tp.usPQN) / 1e6 / float64(division)
+			}
+		}
+		return t
+	}
+
+	sort.SliceStable(notes, func(i, j int) bool { return notes[i].tick < notes[j].tick })
+	tk := take{Version: takeVersion}
+	if division > 0 {
+		usPQN := defaultTempo
+		for _, tp := range tempos {
+			if tp.tick == 0 {
+				usPQN = tp.usPQN
+			}
+		}
+		tk.BPM = math.Round(60e6 / float64(usPQN))
+	}
+	for _, n := range notes {
+		ev := takeEvent{T: seconds(n.tick), Key: fmt.Sprintf("ch%d:%d", n.channel+1, n.note)}
+		if n.on {
+			ev.Type = "on"
+			ev.Freq = 440 * math.Exp2((float64(n.note)-69)/12)
+			ev.Velocity = float64(n.velocity) / 127
+		} else {
+			ev.Type = "off"
+		}
+		tk.Events = append(tk.Events, ev)
+		tk.Length = ev.T
+	}
+	return tk, nil
+}
+
+func parseMIDITrack(trk []byte, notes *[]smfNote, tempos *[]smfTempo) error {
+	var (
+		tick   int64
+		status byte
+		i      int
+	)
+	vlq := func() (int, error) {
+		v := 0
+		for n := 0; n < 4; n++ {
+			if i >= len(trk) {
+				return 0, errors.New("MIDI track ends mid-event")
+			}
+			b := trk[i]
+			i++
+			v = v<<7 | int(b&0x7F)
+			if b&0x80 == 0 {
+				return v, nil
+			}
+		}
+		return 0, errors.New("bad MIDI variable-length number")
+	}
+
+	for i < len(trk) {
+		delta, err := vlq()
+		if err != nil {
+			return err
+		}
+		tick += int64(delta)
+		if i >= len(trk) {
+			return errors.New("MIDI track ends mid-event")
+		}
+
+		switch b := trk[i]; {
+		case b == 0xFF: // meta event
+			if i+2 > len(trk) {
+				return errors.New("MIDI track ends mid-event")
+			}
+			kind := trk[i+1]
+			i += 2
+			n, err := vlq()
+			if err != nil {
+				return err
+			}
+			if i+n > len(trk) {
+				return errors.New("MIDI meta event overruns the track")
+			}
+			if kind == 0x51 && n == 3 {
+				us := int(trk[i])<<16 | int(trk[i+1])<<8 | int(trk[i+2])
+				if us > 0 {
+					*tempos = append(*tempos, smfTempo{tick, us})
+				}
+			}
+			if kind == 0x2F { // end of track
+				return nil
+			}
+			i += n
+			continue
+		case b == 0xF0 || b == 0xF7: // SysEx
+			i++
+			n, err := vlq()
+			if err != nil {
+				return err
+			}
+			i += n
+			continue
+		case b&0x80 != 0:
+			status = b
+			i++
+		}
+		if status == 0 {
+			return errors.New("MIDI data without a status byte")
+		}
+
+		n := midiMessageLen(status) - 1
+		if i+n > len(trk) {
+			return errors.New("MIDI track ends mid-event")
+		}
+		msg := trk[i : i+n]
+		i += n
+		switch status & 0xF0 {
+		case 0x90, 0x80:
+			on := status&0xF0 == 0x90 && msg[1] > 0
+			*notes = append(*notes, smfNote{tick, on, status & 0x0F, msg[0], msg[1]})
+		}
+	}
+	return nil
+}
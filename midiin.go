@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// --- MIDI INPUT & LEARN ---
+//
+// With "midi_in": {"port": "..."} a MIDI controller plays piango: notes go
+// through the same path as OSC notes, and control changes turn whatever
+// they're bound to. Bindings are made by MIDI learn: with a slider
+// selected in the instrument editor or the settings panel, CTRL+L arms it
+// and the next knob moved is bound to it. Bindings are written back to
+// the config file under "midi_in" → "cc".
+
+type midiInConfig struct {
+	Port string            `json:"port"`
+	CC   map[string]string `json:"cc"` // controller number → control name
+}
+
+// midiCC is a control change, delivered to the TUI.
+type midiCC struct {
+	cc, value int
+}
+
+// midiMessageLen is how many bytes a message with this status byte has.
+func midiMessageLen(status byte) int {
+	switch {
+	case status < 0xC0, status >= 0xE0 && status < 0xF0, status == 0xF2:
+		return 3
+	case status < 0xE0, status == 0xF1, status == 0xF3:
+		return 2
+	}
+	return 1
+}
+
+// midiParser splits a raw byte stream into messages, following running
+// status and skipping SysEx.
+type midiParser struct {
+	status byte // running status, 0 when there is none
+	msg    []byte
+	sysex  bool
+}
+
+// feed takes the next byte; fn gets each message as it completes and
+// mustn't keep the slice.
+func (p *midiParser) feed(b byte, fn func(msg []byte)) {
+	switch {
+	case b >= 0xF8: // realtime messages can land in the middle of others
+		fn([]byte{b})
+		return
+	case b == 0xF0:
+		p.sysex = true
+		return
+	case b == 0xF7:
+		p.sysex = false
+		return
+	case b&0x80 != 0:
+		p.sysex = false
+		p.msg = append(p.msg[:0], b)
+		p.status = b
+		if b >= 0xF0 {
+			p.status = 0
+		}
+	case p.sysex:
+		return
+	default:
+		if len(p.msg) == 0 {
+			if p.status == 0 {
+				return
+			}
+			p.msg = append(p.msg, p.status)
+		}
+		p.msg = append(p.msg, b)
+	}
+	if len(p.msg) == midiMessageLen(p.msg[0]) {
+		fn(p.msg)
+		p.msg = p.msg[:0]
+	}
+}
+
+// startMIDIInput opens the port if the config names one.
+func startMIDIInput(p *tea.Program, cfg midiInConfig, triggers *triggerRouter) (io.Closer, error) {
+	if cfg.Port == "" {
+		return nil, nil
+	}
+	ports := midiInPorts()
+	i, err := findMIDIPort(ports, cfg.Port)
+	if err != nil {
+		return nil, fmt.Errorf("midi in: %w (see --midi-in list)", err)
+	}
+	return openMIDIInput(i, ports[i], midiDispatch(p, triggers))
+}
+
+// midiDispatch hands incoming messages to the TUI: notes go the way OSC
+// notes do, control changes to MIDI learn, and those bound in the
+// mappings fire their actions instead.
+func midiDispatch(p *tea.Program, triggers *triggerRouter) func(msg []byte) {
+	return func(msg []byte) {
+		if triggers.fireMIDI(msg) {
+			return
+		}
+		switch msg[0] & 0xF0 {
+		case 0x80, 0x90:
+			vel := float64(msg[2]) / 127
+			if msg[0]&0xF0 == 0x80 {
+				vel = 0
+			}
+			p.Send(oscMessage{Address: "/piango/note", Args: []any{float64(msg[1]), vel}})
+		case 0xB0:
+			p.Send(midiCC{cc: int(msg[1]), value: int(msg[2])})
+		}
+	}
+}
+
+// findControl looks up something a knob can turn by the name OSC uses
+// for it: a slider of the current instrument, or a master setting.
+func findControl(name string) (patchParam, func() *float64, bool) {
+	for _, row := range instrumentRows(instruments[currentInstID]) {
+		if row.title == "" && paramName(row.param) == name {
+			pp := row.param
+			return pp, func() *float64 { return pp.Field(instruments[currentInstID]) }, true
+		}
+	}
+	for _, st := range settings {
+		if paramName(st.patchParam) == name {
+			return st.patchParam, st.Value, true
+		}
+	}
+	return patchParam{}, nil, false
+}
+
+// midiLearn holds the bindings and the control waiting for a knob.
+type midiLearn struct {
+	cfg        midiInConfig
+	configPath string
+	armed      string
+}
+
+func newMIDILearn(cfg midiInConfig, configPath string) *midiLearn {
+	if cfg.CC == nil {
+		cfg.CC = make(map[string]string)
+	}
+	return &midiLearn{cfg: cfg, configPath: configPath}
+}
+
+// toggleLearn arms pp, or disarms if it's already waiting.
+func (m model) toggleLearn(pp patchParam) model {
+	if m.learn == nil {
+		m.notification = "MIDI learn needs a MIDI input"
+		m.notifyClearTime = time.Now().Add(2 * time.Second)
+		return m
+	}
+	if m.learn.armed != "" {
+		m.learn.armed = ""
+		m.notification = ""
+		return m
+	}
+	m.learn.armed = paramName(pp)
+	m.notification = "MIDI learn: move a control for " + pp.Name
+	m.notifyClearTime = time.Now().Add(time.Hour)
+	return m
+}
+
+// handleCC binds the controller if learn is armed, otherwise moves what
+// it's bound to.
+func (m model) handleCC(msg midiCC) model {
+	l := m.learn
+	if l == nil {
+		return m
+	}
+	cc := strconv.Itoa(msg.cc)
+
+	if l.armed != "" {
+		for k, name := range l.cfg.CC {
+			if name == l.armed {
+				delete(l.cfg.CC, k) // one knob per control
+			}
+		}
+		l.cfg.CC[cc] = l.armed
+		m.notification = fmt.Sprintf("CC %s → %s", cc, l.armed)
+		if err := saveConfigKey(l.configPath, "midi_in", l.cfg); err != nil {
+			m.notification = fmt.Sprintf("MIDI learn: %v", err)
+		}
+		m.notifyClearTime = time.Now().Add(2 * time.Second)
+		l.armed = ""
+		return m
+	}
+
+	name, ok := l.cfg.CC[cc]
+	if !ok {
+		return m
+	}
+	pp, field, ok := findControl(name)
+	if !ok {
+		return m // bound to a slider the current instrument doesn't have
+	}
+	output.Lock()
+	*field() = pp.at(float64(msg.value) / 127)
+	output.Unlock()
+	return m
+}
+
+// saveConfigKey replaces one top-level key of the config file, leaving the
+// rest of it as it was.
+func saveConfigKey(path, key string, v any) error {
+	if path == "" {
+		return errors.New("no config file")
+	}
+	fields := make(map[string]json.RawMessage)
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	fields[key] = raw
+	data, err = json.MarshalIndent(fields, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// --- MIDI OUTPUT ---
+//
+// With "midi_out": {"port": "..."} every note played is mirrored as MIDI
+// note on/off messages, so piango can drive a hardware synth while its
+// own TUI and visualizer carry on. Ports are whatever the platform offers
+// (see midi_unix.go, midi_windows.go); `--midi-out list` prints them.
+
+type midiOutConfig struct {
+	Port    string `json:"port"`
+	Channel int    `json:"channel"` // 1-16, 1 when unset
+}
+
+// midiPort is an open output; messages are written whole.
+type midiPort interface {
+	send(msg []byte) error
+	Close() error
+}
+
+// midiNote is the nearest MIDI note to freq, and whether it's in range.
+func midiNote(freq float64) (byte, bool) {
+	n := math.Round(69 + 12*math.Log2(freq/440))
+	if n < 0 || n > 127 || math.IsNaN(n) {
+		return 0, false
+	}
+	return byte(n), true
+}
+
+// findMIDIPort picks a port by its name, a unique part of it or its
+// number in the list.
+func findMIDIPort(ports []string, name string) (int, error) {
+	if i, err := strconv.Atoi(name); err == nil && i >= 0 && i < len(ports) {
+		return i, nil
+	}
+
+	found := -1
+	for i, p := range ports {
+		if p == name {
+			return i, nil
+		}
+		if strings.Contains(strings.ToLower(p), strings.ToLower(name)) {
+			if found >= 0 {
+				return -1, fmt.Errorf("%q matches more than one port", name)
+			}
+			found = i
+		}
+	}
+	if found < 0 {
+		return -1, fmt.Errorf("no port %q", name)
+	}
+	return found, nil
+}
+
+func openMIDIPort(name string) (midiPort, error) {
+	ports := midiPorts()
+	i, err := findMIDIPort(ports, name)
+	if err != nil {
+		return nil, fmt.Errorf("midi out: %w (see --midi-out list)", err)
+	}
+	return openMIDIDevice(i, ports[i])
+}
+
+// midiSender is the event sink that mirrors notes to a port.
+type midiSender struct {
+	port    midiPort
+	channel byte
+	notes   map[string]byte // key → note number sent for it
+}
+
+func newMIDISender(cfg midiOutConfig) (*midiSender, error) {
+	port, err := openMIDIPort(cfg.Port)
+	if err != nil {
+		return nil, err
+	}
+	return midiSenderOn(port, cfg.Channel), nil
+}
+
+// midiSenderOn mirrors notes to an already open port on channel 1-16.
+func midiSenderOn(port midiPort, channel int) *midiSender {
+	ch := max(1, min(16, channel))
+	return &midiSender{port: port, channel: byte(ch - 1), notes: make(map[string]byte)}
+}
+
+// emit sends a message per note. Like the OSC sender it drops write
+// errors: an unplugged synth shouldn't stop the show.
+func (s *midiSender) emit(events []perfEvent) {
+	for _, ev := range events {
+		switch ev.Type {
+		case "note_on":
+			n, ok := midiNote(ev.Freq)
+			if !ok {
+				continue
+			}
+			vel := byte(max(1, min(127, math.Round(ev.Velocity*127))))
+			s.port.send([]byte{0x90 | s.channel, n, vel})
+			s.notes[ev.Key] = n
+		case "note_off":
+			if n, ok := s.notes[ev.Key]; ok {
+				s.port.send([]byte{0x80 | s.channel, n, 0})
+				delete(s.notes, ev.Key)
+			}
+		}
+	}
+}
+
+// listMIDIPorts is --midi-out list and --midi-in list.
+func listMIDIPorts(ports []string) {
+	if len(ports) == 0 {
+		fmt.Println("No MIDI ports found")
+		return
+	}
+	for i, p := range ports {
+		fmt.Printf("%d: %s\n", i, p)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import "math"
+
+// --- PHASER & FLANGER ---
+//
+// Both sweep a comb of notches through the sound with an LFO: the phaser
+// with a chain of all-pass filters, the flanger with a short delay. The
+// right channel's LFO runs a quarter cycle behind the left for width. A
+// Mix of 0 bypasses either.
+
+const (
+	phaserStages = 4
+	phaserMinHz  = 200.0
+	phaserMaxHz  = 3200.0
+
+	flangerMinMs = 1.0
+	flangerMaxMs = 6.0
+)
+
+type phaser struct {
+	Rate     float64 `json:"rate"` // Hz
+	Depth    float64 `json:"depth"`
+	Feedback float64 `json:"feedback"`
+	Mix      float64 `json:"mix"`
+
+	phase float64
+	state [2][phaserStages]float64
+	last  [2]float64
+}
+
+func (ph *phaser) Process(samples [][2]float64) {
+	if ph.Mix <= 0 {
+		return
+	}
+	sr := float64(sampleRate)
+	step := ph.Rate * 2 * math.Pi / sr
+
+	for i := range samples {
+		for ch := 0; ch < 2; ch++ {
+			lfo := 0.5 + 0.5*math.Sin(ph.phase-float64(ch)*math.Pi/2)
+			freq := phaserMinHz * math.Pow(phaserMaxHz/phaserMinHz, lfo*ph.Depth)
+			t := math.Tan(math.Pi * freq / sr)
+			a := (1 - t) / (1 + t)
+
+			x := samples[i][ch]
+			y := x + ph.last[ch]*ph.Feedback
+			for s := range ph.state[ch] {
+				z := &ph.state[ch][s]
+				out := a*y + *z
+				*z = flush(y - a*out)
+				y = out
+			}
+			ph.last[ch] = flush(y)
+			samples[i][ch] = x*(1-ph.Mix/2) + y*ph.Mix/2
+		}
+		ph.phase = math.Mod(ph.phase+step, 2*math.Pi)
+	}
+}
+
+type flanger struct {
+	Rate     float64 `json:"rate"` // Hz
+	Depth    float64 `json:"depth"`
+	Feedback float64 `json:"feedback"`
+	Mix      float64 `json:"mix"`
+
+	phase float64
+	delay [2][]float64
+	pos   int
+}
+
+func (fl *flanger) Process(samples [][2]float64) {
+	if fl.Mix <= 0 {
+		return
+	}
+	sr := float64(sampleRate)
+	size := int(flangerMaxMs/1000*sr) + 2
+	if len(fl.delay[0]) != size {
+		fl.delay = [2][]float64{make([]float64, size), make([]float64, size)}
+		fl.pos = 0
+	}
+	step := fl.Rate * 2 * math.Pi / sr
+
+	for i := range samples {
+		for ch := 0; ch < 2; ch++ {
+			lfo := 0.5 + 0.5*math.Sin(fl.phase-float64(ch)*math.Pi/2)
+			ms := flangerMinMs + (flangerMaxMs-flangerMinMs)*lfo*fl.Depth
+			d := ms / 1000 * sr
+
+			// Linear interpolation between the two taps around d
+			buf := fl.delay[ch]
+			read := float64(fl.pos) - d
+			for read < 0 {
+				read += float64(size)
+			}
+			j := int(read)
+			frac := read - float64(j)
+			wet := buf[j%size]*(1-frac) + buf[(j+1)%size]*frac
+
+			x := samples[i][ch]
+			buf[fl.pos] = flush(x + wet*fl.Feedback)
+			samples[i][ch] = x*(1-fl.Mix/2) + wet*fl.Mix/2
+		}
+		fl.pos = (fl.pos + 1) % size
+		fl.phase = math.Mod(fl.phase+step, 2*math.Pi)
+	}
+}
+
+func defaultPhaser() phaser   { return phaser{Rate: 0.5, Depth: 0.8, Feedback: 0.5} }
+func defaultFlanger() flanger { return flanger{Rate: 0.25, Depth: 0.7, Feedback: 0.4} }
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// --- MODULATION MATRIX ---
+//
+// All of a voice's modulation runs through one place: each route reads a
+// source every sample and pushes a destination by its amount. Vibrato
+// (LFO1 → pitch), tremolo (LFO2 → amp) and the filter envelope (env →
+// cutoff) are built-in routes driven by the patch; instruments can add
+// their own in the config.
+//
+// Sources: lfo1 and lfo2 swing -1..1, env (the filter envelope) and
+// velocity run 0..1, key is octaves away from A4. Destinations: pitch in
+// cents, cutoff in octaves, pan (-1 left .. 1 right), and amp, which only
+// ever turns the level down: it's untouched when the source is at 1 and
+// drops by amount for every unit below.
+
+const (
+	modLFO1 = iota
+	modLFO2
+	modEnv
+	modVelocity
+	modKey
+)
+
+var modSourceNames = []string{"lfo1", "lfo2", "env", "velocity", "key"}
+
+const (
+	destPitch = iota
+	destCutoff
+	destAmp
+	destPan
+)
+
+var modDestNames = []string{"pitch", "cutoff", "amp", "pan"}
+
+type ModRoute struct {
+	Source string  `json:"source"`
+	Dest   string  `json:"dest"`
+	Amount float64 `json:"amount"`
+}
+
+type modRoute struct {
+	src, dest int
+	amount    float64
+}
+
+func parseRoute(r ModRoute) (modRoute, error) {
+	mr := modRoute{src: -1, dest: -1, amount: r.Amount}
+	for i, name := range modSourceNames {
+		if r.Source == name {
+			mr.src = i
+		}
+	}
+	for i, name := range modDestNames {
+		if r.Dest == name {
+			mr.dest = i
+		}
+	}
+	if mr.src < 0 {
+		return mr, fmt.Errorf("unknown modulation source %q", r.Source)
+	}
+	if mr.dest < 0 {
+		return mr, fmt.Errorf("unknown modulation destination %q", r.Dest)
+	}
+	return mr, nil
+}
+
+// applyModulationConfig adds the config's routes to the instruments they
+// name.
+func applyModulationConfig(routes map[string][]ModRoute) error {
+	for name, rs := range routes {
+		inst := findInstrument(name)
+		if inst == nil {
+			return fmt.Errorf("modulation: unknown instrument %q", name)
+		}
+		for _, r := range rs {
+			if _, err := parseRoute(r); err != nil {
+				return fmt.Errorf("modulation for %s: %w", name, err)
+			}
+		}
+		inst.Mod = append(inst.Mod, rs...)
+	}
+	return nil
+}
+
+func findInstrument(name string) *Instrument {
+	for i := range instruments {
+		if instruments[i].Name == name {
+			return instruments[i]
+		}
+	}
+	return nil
+}
+
+// modulator is a voice's modulation state.
+type modulator struct {
+	routes     []modRoute
+	lfo1, lfo2 float64 // phases
+	env        adsr
+	vibDepth   float64 // eased towards the patch so toggling doesn't jump
+	trDepth    float64
+}
+
+// restart readies md for a new note on inst, reusing its routes' room.
+func (md *modulator) restart(inst *Instrument) {
+	*md = modulator{env: newADSR(), routes: md.routes[:0]}
+	for _, r := range inst.Mod {
+		if mr, err := parseRoute(r); err == nil {
+			md.routes = append(md.routes, mr)
+		}
+	}
+}
+
+// modulation is what the routes add up to for one sample.
+type modulation struct {
+	cents, octaves, pan float64
+	amp                 float64 // gain factor
+}
+
+// modRates holds the per-block values next needs.
+type modRates struct {
+	lfo1Step, lfo2Step float64
+	vibTarget          float64
+	vibSmooth, smooth  float64
+}
+
+func newModRates(p *Patch) modRates {
+	r := modRates{
+		lfo1Step:  p.LFO1Rate * 2 * math.Pi / float64(sampleRate),
+		lfo2Step:  p.LFO2Rate * 2 * math.Pi / float64(sampleRate),
+		vibSmooth: glideCoef(vibratoFade),
+		smooth:    glideCoef(paramSmoothing),
+	}
+	if vibratoOn {
+		r.vibTarget = p.VibratoDepth
+	}
+	return r
+}
+
+// next advances the sources one sample and sums the routes.
+func (md *modulator) next(s *SynthStreamer, p *Patch, r *modRates) modulation {
+	var src [modKey + 1]float64
+	src[modLFO1] = math.Sin(md.lfo1)
+	src[modLFO2] = math.Sin(md.lfo2)
+	src[modEnv] = md.env.next(p.FilterAttackMs, p.FilterDecayMs, p.FilterSustain, p.FilterReleaseMs, s.releasing)
+	src[modVelocity] = s.velocity
+	src[modKey] = math.Log2(s.freq / 440)
+
+	md.lfo1 = math.Mod(md.lfo1+r.lfo1Step, 2*math.Pi)
+	md.lfo2 = math.Mod(md.lfo2+r.lfo2Step, 2*math.Pi)
+	md.vibDepth += (r.vibTarget - md.vibDepth) * r.vibSmooth
+	md.trDepth += (p.TremoloDepth - md.trDepth) * r.smooth
+
+	out := modulation{amp: 1}
+	apply := func(dest int, v, amount float64) {
+		switch dest {
+		case destPitch:
+			out.cents += v * amount
+		case destCutoff:
+			out.octaves += v * amount
+		case destAmp:
+			out.amp *= math.Max(0, 1+amount*(v-1))
+		case destPan:
+			out.pan += v * amount
+		}
+	}
+
+	// The patch's own routes
+	apply(destPitch, src[modLFO1], md.vibDepth)
+	apply(destAmp, src[modLFO2], md.trDepth/2)
+	apply(destCutoff, src[modEnv], p.FilterEnv)
+
+	for _, rt := range md.routes {
+		apply(rt.dest, src[rt.src], rt.amount)
+	}
+	return out
+}
+
+// vibratoFade eases vibrato in and out when it's toggled on held notes.
+const vibratoFade = 150 * time.Millisecond
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// --- MOUSE ---
+//
+// Clicking a key holds its note until the button is released; dragging
+// across the keyboard slides from key to key. Voices held by the mouse
+// are locked so the watchdog doesn't cut them for lack of key repeats.
+
+func (m model) handleMouse(msg tea.MouseMsg) model {
+	if m.theremin.active {
+		// Horizontal position across the window sweeps the theremin range
+		if msg.Action == tea.MouseActionMotion && m.width > 1 {
+			lo, hi := m.theremin.bounds(m.octaveShift)
+			m.theremin.pos = lo + float64(msg.X)/float64(m.width-1)*(hi-lo)
+			if m.theremin.locked {
+				thereminPlay(&m.theremin)
+			}
+		}
+		return m
+	}
+
+	switch {
+	case msg.Button == tea.MouseButtonLeft &&
+		(msg.Action == tea.MouseActionPress || msg.Action == tea.MouseActionMotion):
+		note, ok := m.keyAt(msg.X, msg.Y)
+		if ok && note.Key == m.mouseKey {
+			return m
+		}
+		if m.mouseKey != "" {
+			releaseVoice(m.voicePrefix + m.mouseKey)
+			m.mouseKey = ""
+		}
+		if ok {
+			freq := m.noteFreq(note)
+			holdVoice(m.voicePrefix+note.Key, freq, m.velocity.strike(note))
+			m.mouseKey = note.Key
+			m = m.warmupHit(note.Key)
+			m = m.lessonHit(note.Key, time.Now())
+			m = m.guideHit(note.Key, time.Now())
+			m.practice.noted(instruments[currentInstID].Name, time.Now())
+		}
+
+	case msg.Action == tea.MouseActionRelease:
+		if m.mouseKey != "" {
+			releaseVoice(m.voicePrefix + m.mouseKey)
+			m.mouseKey = ""
+		}
+	}
+	return m
+}
+
+// keyAt maps a screen cell to the key drawn there by retracing how View
+// lays the panel out: Place centers it, the panel adds border and padding,
+// JoinVertical centers each section, and the keyboard rows are a label
+// followed by equal-width key cells, a row of black keys shifted half a
+// key to the right.
+func (m model) keyAt(x, y int) (Note, bool) {
+	if m.screenReader { // no keyboard drawn to click on
+		return Note{}, false
+	}
+	l, sections := m.arrange()
+	ui := lipgloss.JoinVertical(lipgloss.Center, sections...)
+	panel := l.frame().Render(ui)
+
+	x -= centerOffset(m.width, lipgloss.Width(panel)) +
+		l.frame().GetBorderLeftSize() + l.frame().GetPaddingLeft()
+	y -= centerOffset(m.height, lipgloss.Height(panel)) +
+		l.frame().GetBorderTopSize() + l.frame().GetPaddingTop()
+
+	keyboard := sections[2]
+	if w := lipgloss.Width(ui) - lipgloss.Width(keyboard); w > 0 {
+		x -= int(math.Round(float64(w) * 0.5))
+	}
+	y -= lipgloss.Height(sections[0]) + lipgloss.Height(sections[1])
+
+	rows := m.keyboardRows(l)
+	rowHeight := lipgloss.Height(rows[0])
+	labelWidth := lipgloss.Width(rowLabelStyle.Render("\nHigh"))
+	keyWidth := l.keyWidth()
+
+	x -= labelWidth
+	if x < 0 || y < 0 || y/rowHeight >= len(sortedRows) {
+		return Note{}, false
+	}
+	slots, over := rowSlots(y / rowHeight)
+	if over {
+		x -= (keyWidth + 1) / 2 // black keys sit between the white ones
+	}
+	if col := x / keyWidth; x >= 0 && col < len(slots) && slots[col].Key != "" {
+		return slots[col], true
+	}
+	return Note{}, false
+}
+
+// centerOffset mirrors lipgloss.Place's rounding for a centered block.
+func centerOffset(outer, inner int) int {
+	gap := outer - inner
+	if gap <= 0 {
+		return 0
+	}
+	return gap - int(math.Round(float64(gap)*0.5))
+}
+
+func holdVoice(key string, freq, velocity float64) {
+	voiceLock.Lock()
+	defer voiceLock.Unlock()
+
+	if v, ok := voices[key]; ok {
+		v.release()
+	}
+
+	inst := keyInstrument(key)
+	if held := monoVoice(key, inst, freq); held != nil {
+		held.lastSeen = time.Now()
+		held.locked = true
+		voices[key] = held
+		recordStrike(key, freq, velocity)
+		return
+	}
+
+	velocity, delay := humanize(inst, velocity)
+	v := pool.get(inst, freq, velocity, false)
+	s := v.streamer
+	s.delay = delay
+	if inst.Kit {
+		s.setDrum(key)
+	} else {
+		portamento(inst, s)
+	}
+	v.lastSeen, v.freq, v.locked = time.Now(), freq, true
+	voices[key] = v
+	v.start()
+	recordStrike(key, freq, velocity)
+}
+
+// holdVoiceOn is holdVoice on a given instrument, for notes that aren't
+// the local player's: a jam partner's or a replay's. Play modes are left
+// to whoever struck them first. delay is in samples.
+func holdVoiceOn(key string, inst *Instrument, freq, velocity float64, delay int) {
+	voiceLock.Lock()
+	defer voiceLock.Unlock()
+
+	if v, ok := voices[key]; ok {
+		v.release()
+	}
+	v := pool.get(inst, freq, velocity, false)
+	v.streamer.delay = delay
+	if inst.Kit {
+		v.streamer.setDrum(key)
+	}
+	v.lastSeen, v.freq, v.locked = time.Now(), freq, true
+	voices[key] = v
+	v.start()
+	recordStrike(key, freq, velocity)
+}
+
+func releaseVoice(key string) {
+	voiceLock.Lock()
+	defer voiceLock.Unlock()
+
+	if v, ok := voices[key]; ok {
+		v.locked = false
+		v.release()
+	}
+}
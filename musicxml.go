@@ -0,0 +1,280 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// --- MUSICXML ---
+//
+// Scores exported from MuseScore and friends, as .musicxml, .xml or the
+// zipped .mxl, read into a take so they play, render and can be
+// practised like a recording. Only the first part's top staff is read:
+// enough for a melody, with its chords, ties and tempo marks. Notes that
+// fall on the keyboard get its keys, so they light up where they're
+// played.
+
+const scoreVelocity = 0.8
+
+var stepSemitones = map[string]int{"C": 0, "D": 2, "E": 4, "F": 5, "G": 7, "A": 9, "B": 11}
+
+type mxNote struct {
+	Chord *struct{} `xml:"chord"`
+	Rest  *struct{} `xml:"rest"`
+	Grace *struct{} `xml:"grace"`
+	Pitch *struct {
+		Step   string  `xml:"step"`
+		Alter  float64 `xml:"alter"`
+		Octave int     `xml:"octave"`
+	} `xml:"pitch"`
+	Duration int `xml:"duration"`
+	Staff    int `xml:"staff"`
+	Ties     []struct {
+		Type string `xml:"type,attr"`
+	} `xml:"tie"`
+}
+
+// midi is the note's MIDI number.
+func (n mxNote) midi() int {
+	return (n.Pitch.Octave+1)*12 + stepSemitones[strings.ToUpper(n.Pitch.Step)] + int(math.Round(n.Pitch.Alter))
+}
+
+func (n mxNote) tie(kind string) bool {
+	for _, t := range n.Ties {
+		if t.Type == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// scoreNote is a note in quarter notes from the start.
+type scoreNote struct {
+	start, length float64
+	midi          int
+}
+
+type scoreTempo struct {
+	at  float64 // quarter notes
+	bpm float64
+}
+
+func loadScore(p string) (take, error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return take{}, err
+	}
+	if strings.EqualFold(filepath.Ext(p), ".mxl") {
+		if data, err = unzipScore(data); err != nil {
+			return take{}, fmt.Errorf("%s: %w", p, err)
+		}
+	}
+	tk, err := parseMusicXML(data)
+	if err != nil {
+		return take{}, fmt.Errorf("%s: %w", p, err)
+	}
+	return tk, nil
+}
+
+// unzipScore finds the score in an .mxl through its container file.
+func unzipScore(data []byte) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	read := func(name string) ([]byte, error) {
+		f, err := zr.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return io.ReadAll(f)
+	}
+
+	root := ""
+	if c, err := read("META-INF/container.xml"); err == nil {
+		var container struct {
+			Rootfiles []struct {
+				Path string `xml:"full-path,attr"`
+			} `xml:"rootfiles>rootfile"`
+		}
+		if xml.Unmarshal(c, &container) == nil && len(container.Rootfiles) > 0 {
+			root = container.Rootfiles[0].Path
+		}
+	}
+	if root == "" {
+		for _, f := range zr.File {
+			if !strings.HasPrefix(f.Name, "META-INF/") && (path.Ext(f.Name) == ".xml" || path.Ext(f.Name) == ".musicxml") {
+				root = f.Name
+				break
+			}
+		}
+	}
+	if root == "" {
+		return nil, errors.New("no score in the archive")
+	}
+	return read(root)
+}
+
+func parseMusicXML(data []byte) (take, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = false // DOCTYPEs and entities are common in exported files
+
+	var (
+		notes     []scoreNote
+		tempos    []scoreTempo
+		divisions = 1.0
+		pos, last float64             // quarter notes: now, and the start of the last note for chords
+		tied      = make(map[int]int) // MIDI number → index of a note waiting for its tie to end
+		part      string              // id of the part being read, the first one
+		score     bool
+	)
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return take{}, err
+		}
+		switch el := tok.(type) {
+		case xml.StartElement:
+			switch el.Name.Local {
+			case "score-partwise":
+				score = true
+			case "score-timewise":
+				return take{}, errors.New("timewise scores aren't supported")
+			case "part":
+				id := ""
+				for _, a := range el.Attr {
+					if a.Name.Local == "id" {
+						id = a.Value
+					}
+				}
+				if part != "" && id != part {
+					d.Skip() // only the first part is read
+					continue
+				}
+				part = id
+			case "divisions":
+				var v float64
+				if err := d.DecodeElement(&v, &el); err == nil && v > 0 {
+					divisions = v
+				}
+			case "sound":
+				for _, a := range el.Attr {
+					var bpm float64
+					if a.Name.Local != "tempo" {
+						continue
+					}
+					if _, err := fmt.Sscan(a.Value, &bpm); err == nil && bpm > 0 {
+						tempos = append(tempos, scoreTempo{pos, bpm})
+					}
+				}
+			case "backup", "forward":
+				var mv struct {
+					Duration float64 `xml:"duration"`
+				}
+				if err := d.DecodeElement(&mv, &el); err != nil {
+					return take{}, err
+				}
+				if el.Name.Local == "backup" {
+					pos = max(0, pos-mv.Duration/divisions)
+				} else {
+					pos += mv.Duration / divisions
+				}
+			case "note":
+				var n mxNote
+				if err := d.DecodeElement(&n, &el); err != nil {
+					return take{}, err
+				}
+				if n.Grace != nil {
+					continue
+				}
+				length := float64(n.Duration) / divisions
+				start := pos
+				if n.Chord != nil {
+					start = last
+				} else {
+					pos += length
+				}
+				last = start
+				if n.Rest != nil || n.Pitch == nil || n.Staff > 1 {
+					continue
+				}
+
+				midi := n.midi()
+				if i, ok := tied[midi]; ok && n.tie("stop") {
+					notes[i].length = start + length - notes[i].start
+					if !n.tie("start") {
+						delete(tied, midi)
+					}
+					continue
+				}
+				notes = append(notes, scoreNote{start, length, midi})
+				if n.tie("start") {
+					tied[midi] = len(notes) - 1
+				}
+			}
+		}
+	}
+	if !score {
+		return take{}, errors.New("not a MusicXML score")
+	}
+	return scoreTake(notes, tempos), nil
+}
+
+// scoreTake lays the notes out in seconds through the tempo marks.
+func scoreTake(notes []scoreNote, tempos []scoreTempo) take {
+	sort.SliceStable(tempos, func(i, j int) bool { return tempos[i].at < tempos[j].at })
+	if len(tempos) == 0 || tempos[0].at > 0 {
+		tempos = append([]scoreTempo{{0, 120}}, tempos...)
+	}
+	seconds := func(q float64) float64 {
+		var t float64
+		for i, tp := range tempos {
+			end := q
+			if i+1 < len(tempos) && tempos[i+1].at < q {
+				end = tempos[i+1].at
+			}
+			if end > tp.at {
+				t += (end - tp.at) * 60 / tp.bpm
+			}
+		}
+		return t
+	}
+
+	tk := take{Version: takeVersion, BPM: tempos[0].bpm}
+	for _, n := range notes {
+		freq := 440 * math.Exp2(float64(n.midi-69)/12)
+		key := scoreKey(freq, n.midi)
+		on, off := seconds(n.start), seconds(n.start+n.length)
+		tk.Events = append(tk.Events,
+			takeEvent{T: on, Type: "on", Key: key, Freq: freq, Velocity: scoreVelocity},
+			takeEvent{T: off, Type: "off", Key: key})
+		tk.Length = max(tk.Length, off)
+	}
+	sortEvents(tk.Events)
+	return tk
+}
+
+// scoreKey is the keyboard key that plays freq with no octave shift, or a
+// name of its own for notes the keyboard doesn't have.
+func scoreKey(freq float64, midi int) string {
+	for key, n := range noteMap {
+		if math.Abs(12*math.Log2(n.Freq/freq)) < 0.1 {
+			return key
+		}
+	}
+	return fmt.Sprintf("note%d", midi)
+}
@@ -0,0 +1,63 @@
+package main
+
+import "math/rand"
+
+// --- COLOURED NOISE ---
+//
+// White noise with its highs rolled off: pink falls 3 dB an octave, which
+// sounds even across the range, and brown falls 6 dB, a low rumble like
+// wind or surf. Both are filters over white noise, so each voice keeps its
+// own.
+
+// whiteNoise is a fresh random value every sample.
+type whiteNoise struct{}
+
+func newWhiteNoise() Oscillator { return whiteNoise{} }
+
+func (whiteNoise) NextSample(freq, sampleRate float64) float64 {
+	return (rand.Float64()*2.0 - 1.0) * 0.1
+}
+
+// pinkNoise uses Paul Kellett's three-pole approximation, its poles moved
+// for the sample rate (see samplerate.go).
+type pinkNoise struct {
+	b0, b1, b2 float64
+	p0, p1, p2 float64
+}
+
+func newPinkNoise() Oscillator {
+	return &pinkNoise{p0: decayAt(0.99765), p1: decayAt(0.963), p2: decayAt(0.57)}
+}
+
+func (n *pinkNoise) NextSample(freq, sampleRate float64) float64 {
+	white := rand.Float64()*2 - 1
+	n.b0 = n.p0*n.b0 + white*0.0990460
+	n.b1 = n.p1*n.b1 + white*0.2965164
+	n.b2 = n.p2*n.b2 + white*1.0526913
+	return (n.b0 + n.b1 + n.b2 + white*0.1848) * 0.035
+}
+
+// brownNoise is a leaky integrator, so it wanders without drifting off.
+type brownNoise struct {
+	last float64
+	leak float64
+}
+
+func newBrownNoise() Oscillator { return &brownNoise{leak: decayAt(1 / 1.02)} }
+
+func (n *brownNoise) NextSample(freq, sampleRate float64) float64 {
+	white := rand.Float64()*2 - 1
+	n.last = (n.last + 0.02*white) * n.leak
+	return n.last
+}
+
+// windPatch sweeps brown noise through a slowly moving filter.
+func windPatch() Patch {
+	p := defaultPatch()
+	p.AttackMs = 400
+	p.ReleaseMs = 1200
+	p.Cutoff = 800
+	p.Resonance = 0.6
+	p.LFO1Rate = 0.3
+	return p
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// --- STAFF NOTATION ---
+//
+// CTRL+N swaps the visualizer for a grand staff that writes out what's
+// being played: each chord a column, newest on the right, the notes still
+// sounding lit. Rows are steps of the scale, so a note sits on a line or
+// in a space just as it's printed, sharps before it and ledger lines
+// where it needs them. When the terminal is too short for both staves,
+// only the one the last chord was on is drawn.
+
+const (
+	staffLabelWidth = 8
+	staffCellWidth  = 4
+	staffColumns    = (numBars*2 - staffLabelWidth) / staffCellWidth
+	staffChordGap   = 80 * time.Millisecond // notes struck closer together share a column
+
+	// Rows, as steps of the scale counted from Do in octave 0 (see
+	// staffStep). Middle Do, the ledger line between the staves, is 28.
+	staffTop     = 41 // Si5, above the treble staff's ledger line
+	staffBottom  = 17 // Fa2, below the bass staff
+	staffMiddle  = 28
+	trebleBottom = 30 // Mi4, the treble staff's bottom line
+	trebleTop    = 38 // Fa5
+	bassBottom   = 18 // Sol2
+	bassTop      = 26 // La3
+)
+
+var (
+	solfege     = []string{"Do", "Re", "Mi", "Fa", "Sol", "La", "Si"}
+	pitchSteps  = [12]int{0, 0, 1, 1, 2, 3, 3, 4, 4, 5, 5, 6}
+	pitchSharps = [12]bool{1: true, 3: true, 6: true, 8: true, 10: true}
+)
+
+type staffChord struct {
+	at    time.Time
+	notes []int // MIDI numbers
+}
+
+type staffPanel struct {
+	open     bool
+	struck   map[string]int // voice → MIDI note it was sounding at the last tick
+	sounding map[int]bool
+	chords   []staffChord
+}
+
+// staffStep is where a MIDI note sits on the staff, spelled with sharps.
+func staffStep(n int) (step int, sharp bool) {
+	return (n/12-1)*7 + pitchSteps[n%12], pitchSharps[n%12]
+}
+
+// noteName is the solfège name of a note of the scale, 0 being Do, spelled
+// with sharps.
+func noteName(pc int) string {
+	if pitchSharps[pc] {
+		return solfege[pitchSteps[pc]] + "♯"
+	}
+	return solfege[pitchSteps[pc]]
+}
+
+// pitchName is a note's name with its octave, as in Do♯4.
+func pitchName(n int) string {
+	return fmt.Sprintf("%s%d", noteName(n%12), n/12-1)
+}
+
+func (m model) toggleStaff() model {
+	m.staff = staffPanel{open: !m.staff.open}
+	return m
+}
+
+// handleStaffKey closes the staff; every other key still plays.
+func (m model) handleStaffKey(msg tea.KeyMsg) (model, bool) {
+	switch msg.Type {
+	case tea.KeyCtrlN, tea.KeyEscape:
+		m.staff.open = false
+		return m, true
+	}
+	return m, false
+}
+
+// update follows the voices held at this tick: a note that wasn't
+// sounding before joins the newest chord, or starts a new one.
+func (s *staffPanel) update(held map[string]heldNote, now time.Time) {
+	if s.struck == nil {
+		s.struck = make(map[string]int)
+	}
+	kit := instruments[currentInstID].Kit
+	struck := make(map[string]int, len(held))
+	s.sounding = make(map[int]bool, len(held))
+	for key, h := range held {
+		n, ok := midiNote(h.freq)
+		if !ok || kit {
+			continue
+		}
+		struck[key] = int(n)
+		s.sounding[int(n)] = true
+		if prev, ok := s.struck[key]; ok && prev == int(n) {
+			continue
+		}
+
+		last := len(s.chords) - 1
+		if last < 0 || now.Sub(s.chords[last].at) > staffChordGap {
+			s.chords = append(s.chords, staffChord{at: now})
+			if len(s.chords) > staffColumns {
+				s.chords = s.chords[1:]
+			}
+			last = len(s.chords) - 1
+		}
+		if !slices.Contains(s.chords[last].notes, int(n)) {
+			s.chords[last].notes = append(s.chords[last].notes, int(n))
+		}
+	}
+	s.struck = struck
+}
+
+// staffView draws the staff in at most rows lines.
+func (m model) staffView(rows int) string {
+	s := m.staff
+	top, bottom := staffTop, staffBottom
+	if staffTop-staffBottom+4 > rows {
+		top, bottom = staffTop, staffMiddle-1
+		if n := len(s.chords); n > 0 && s.chords[n-1].low() {
+			top, bottom = staffMiddle+1, staffBottom
+		}
+	}
+
+	lineStyle := waveColor
+	headStyle := presetTextStyle
+	litStyle := notifyStyle.UnsetMarginBottom().UnsetPadding()
+	labelStyle := rowLabelStyle.UnsetMarginTop().UnsetMarginRight().Width(staffLabelWidth - 1)
+
+	var lines []string
+	for step := top; step >= bottom; step-- {
+		staffLine := step%2 == 0 &&
+			(step >= trebleBottom && step <= trebleTop || step >= bassBottom && step <= bassTop)
+		label := ""
+		switch step {
+		case trebleBottom + 4:
+			label = "treble"
+		case bassBottom + 4:
+			label = "bass"
+		case staffMiddle:
+			label = "Do4"
+		}
+
+		var b strings.Builder
+		b.WriteString(labelStyle.Render(label) + " ")
+		for _, c := range s.chords {
+			fill := " "
+			if staffLine || c.ledger(step) {
+				fill = "─"
+			}
+			acc, head, lit := fill, "", false
+			for _, n := range c.notes {
+				at, sharp := staffStep(n)
+				switch {
+				case at > top && step == top:
+					head = "↑"
+				case at < bottom && step == bottom:
+					head = "↓"
+				case at == step:
+					head = "●"
+				default:
+					continue
+				}
+				if sharp {
+					acc = "♯"
+				}
+				lit = lit || s.sounding[n]
+			}
+			if head == "" {
+				b.WriteString(lineStyle.Render(strings.Repeat(fill, staffCellWidth)))
+				continue
+			}
+			style := headStyle
+			if lit {
+				style = litStyle
+			}
+			b.WriteString(lineStyle.Render(fill) + style.Render(acc+head) + lineStyle.Render(fill))
+		}
+		if empty := staffColumns - len(s.chords); empty > 0 {
+			fill := " "
+			if staffLine {
+				fill = "─"
+			}
+			b.WriteString(lineStyle.Render(strings.Repeat(fill, empty*staffCellWidth)))
+		}
+		lines = append(lines, b.String())
+	}
+
+	var names []string
+	for _, n := range slices.Sorted(maps.Keys(s.sounding)) {
+		names = append(names, pitchName(n))
+	}
+	status := strings.Join(names, "  ")
+	if status == "" {
+		status = "Play something to see it written out"
+	}
+	lines = append(lines, presetTextStyle.Render(fmt.Sprintf("%-40s", status))+
+		helpStyle.UnsetMarginTop().Render("CTRL+N/ESC: Close"))
+	return visStyle.Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
+
+// ledger reports whether any of the chord's notes needs a ledger line at
+// step: middle Do, or the lines above and below the grand staff.
+func (c staffChord) ledger(step int) bool {
+	if step%2 != 0 {
+		return false
+	}
+	for _, n := range c.notes {
+		at, _ := staffStep(n)
+		switch {
+		case step == staffMiddle && at == staffMiddle,
+			step > trebleTop && at >= step,
+			step < bassBottom && at <= step:
+			return true
+		}
+	}
+	return false
+}
+
+// low reports whether the chord sits mostly below middle Do.
+func (c staffChord) low() bool {
+	sum := 0
+	for _, n := range c.notes {
+		at, _ := staffStep(n)
+		sum += at
+	}
+	return len(c.notes) > 0 && sum < staffMiddle*len(c.notes)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/gopxl/beep/v2"
+)
+
+// tap passes the output stage through and keeps the loudest sample it
+// has seen, and whether any wasn't a number, for the test to read under
+// output.Lock().
+type tap struct {
+	s    beep.Streamer
+	peak float64
+	bad  bool
+}
+
+func (t *tap) Stream(samples [][2]float64) (int, bool) {
+	n, ok := t.s.Stream(samples)
+	if !finite(samples[:n]) {
+		t.bad = true
+	}
+	for _, s := range samples[:n] {
+		t.peak = max(t.peak, math.Abs(s[0]), math.Abs(s[1]))
+	}
+	return n, ok
+}
+
+func (t *tap) Err() error { return nil }
+
+// listen clears the tap, waits and returns what it heard meanwhile.
+func (t *tap) listen(d time.Duration) (peak float64, bad bool) {
+	output.Lock()
+	t.peak, t.bad = 0, false
+	output.Unlock()
+	time.Sleep(d)
+	output.Lock()
+	defer output.Unlock()
+	return t.peak, t.bad
+}
+
+// settle waits for the output to fall silent, failing if it takes more
+// than a few seconds or puts out anything that isn't a number.
+func (t *tap) settle(tb testing.TB, when string) {
+	tb.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		peak, bad := t.listen(100 * time.Millisecond)
+		if bad {
+			tb.Fatalf("a sample that isn't a number %s", when)
+		}
+		if peak == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			tb.Fatalf("still sounding %g %s", peak, when)
+		}
+	}
+}
+
+func TestNullBackendNote(t *testing.T) {
+	saved := output
+	nb := &nullBackend{}
+	output = nb
+	defer func() { output = saved }()
+	if err := nb.Init(sampleRate, sampleRate.N(10*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	defer nb.Close()
+	initNotes()
+	mainOut.reset()
+	out := &tap{s: mainOut}
+	nb.Play(out)
+
+	voiceLock.Lock()
+	bank.silence()
+	voiceLock.Unlock()
+	out.settle(t, "before the note")
+
+	holdVoice("test", 440, 1)
+	peak, bad := out.listen(200 * time.Millisecond)
+	if bad || peak == 0 {
+		t.Fatalf("holding a note: peak %g, bad %v", peak, bad)
+	}
+
+	releaseVoice("test")
+	out.settle(t, "after the release")
+
+	voiceLock.Lock()
+	delete(voices, "test")
+	voiceLock.Unlock()
+}
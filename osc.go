@@ -0,0 +1,361 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// --- OSC ---
+//
+// With "osc": {"listen": ":9000"} in the config, piango takes Open Sound
+// Control messages over UDP, so TouchOSC, SuperCollider or Max can play
+// it. Packets are decoded on the listener's goroutine and handed to the
+// TUI with Send, so they're handled one at a time beside keyboard input.
+// With "send" set, it also reports what's being played (see oscSender).
+//
+//	/piango/note <note> [velocity]   MIDI note number or keyboard key;
+//	                                 velocity 0..1, 0 releases the note
+//	/piango/instrument <index|name>
+//	/piango/param <name> <value>     an instrument editor slider, e.g.
+//	                                 cutoff, flt_env, sub_level; or volume
+//	/piango/action <name>            any mappings.json action
+
+type oscConfig struct {
+	Listen string `json:"listen"` // UDP address
+	Send   string `json:"send"`   // UDP host:port for outgoing events
+}
+
+type oscMessage struct {
+	Address string
+	Args    []any // int32, float32, float64, int64, string or bool
+}
+
+// parseOSC decodes a packet: a single message or a bundle of them.
+func parseOSC(b []byte) ([]oscMessage, error) {
+	if strings.HasPrefix(string(b), "#bundle\x00") {
+		if len(b) < 16 {
+			return nil, errors.New("osc: truncated bundle")
+		}
+		b = b[16:] // tag and timetag; messages are played on arrival
+		var msgs []oscMessage
+		for len(b) > 0 {
+			if len(b) < 4 {
+				return nil, errors.New("osc: truncated bundle")
+			}
+			size := int(binary.BigEndian.Uint32(b))
+			b = b[4:]
+			if size < 0 || size > len(b) {
+				return nil, errors.New("osc: truncated bundle")
+			}
+			inner, err := parseOSC(b[:size])
+			if err != nil {
+				return nil, err
+			}
+			msgs = append(msgs, inner...)
+			b = b[size:]
+		}
+		return msgs, nil
+	}
+
+	addr, b, err := oscString(b)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(addr, "/") {
+		return nil, fmt.Errorf("osc: bad address %q", addr)
+	}
+	msg := oscMessage{Address: addr}
+	if len(b) == 0 {
+		return []oscMessage{msg}, nil // old senders skip the type tags
+	}
+
+	tags, b, err := oscString(b)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(tags, ",") {
+		return nil, errors.New("osc: missing type tags")
+	}
+	for _, t := range tags[1:] {
+		need := 0
+		switch t {
+		case 'i', 'f':
+			need = 4
+		case 'h', 'd':
+			need = 8
+		}
+		if len(b) < need {
+			return nil, errors.New("osc: truncated arguments")
+		}
+		switch t {
+		case 'i':
+			msg.Args = append(msg.Args, int32(binary.BigEndian.Uint32(b)))
+		case 'f':
+			msg.Args = append(msg.Args, math.Float32frombits(binary.BigEndian.Uint32(b)))
+		case 'h':
+			msg.Args = append(msg.Args, int64(binary.BigEndian.Uint64(b)))
+		case 'd':
+			msg.Args = append(msg.Args, math.Float64frombits(binary.BigEndian.Uint64(b)))
+		case 's':
+			var s string
+			if s, b, err = oscString(b); err != nil {
+				return nil, err
+			}
+			msg.Args = append(msg.Args, s)
+		case 'T', 'F':
+			msg.Args = append(msg.Args, t == 'T')
+		default:
+			return nil, fmt.Errorf("osc: unsupported type %q", t)
+		}
+		b = b[need:]
+	}
+	return []oscMessage{msg}, nil
+}
+
+// oscString reads a NUL-terminated string padded to four bytes.
+func oscString(b []byte) (string, []byte, error) {
+	end := strings.IndexByte(string(b), 0)
+	if end < 0 {
+		return "", nil, errors.New("osc: unterminated string")
+	}
+	padded := (end + 4) &^ 3
+	if padded > len(b) {
+		padded = len(b)
+	}
+	return string(b[:end]), b[padded:], nil
+}
+
+// oscNumber reads a numeric argument as a float.
+func oscNumber(arg any) (float64, bool) {
+	switch v := arg.(type) {
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// startOSC opens the UDP listener if the config asks for one. Addresses
+// bound in the mappings fire their actions instead.
+func startOSC(p *tea.Program, cfg oscConfig, triggers *triggerRouter) error {
+	if cfg.Listen == "" {
+		return nil
+	}
+	conn, err := net.ListenPacket("udp", cfg.Listen)
+	if err != nil {
+		return err
+	}
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			msgs, err := parseOSC(buf[:n])
+			if err != nil {
+				continue // not for us, or garbled
+			}
+			for _, msg := range msgs {
+				if !triggers.fireOSC(msg) {
+					p.Send(msg)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// paramName is how OSC refers to an editor slider: "Flt Env" is flt_env.
+func paramName(pp patchParam) string {
+	return strings.ReplaceAll(strings.ToLower(pp.Name), " ", "_")
+}
+
+// handleOSC carries out a message on the TUI goroutine, showing what was
+// wrong with it if it can't be.
+func (m model) handleOSC(msg oscMessage) model {
+	m, err := m.runRemote(msg)
+	if err != nil {
+		m.notification = "OSC: " + err.Error()
+		m.notifyClearTime = time.Now().Add(2 * time.Second)
+	}
+	return m
+}
+
+// runRemote carries out a message from any of the remote control APIs.
+func (m model) runRemote(msg oscMessage) (model, error) {
+	fail := func(format string, args ...any) (model, error) {
+		return m, fmt.Errorf(format, args...)
+	}
+	arg := func(i int) any {
+		if i < len(msg.Args) {
+			return msg.Args[i]
+		}
+		return nil
+	}
+
+	switch msg.Address {
+	case "/piango/note":
+		velocity := 1.0
+		if v, ok := oscNumber(arg(1)); ok {
+			if math.IsNaN(v) {
+				return fail("velocity %v isn't a number", v)
+			}
+			velocity = max(0, min(1, v))
+		}
+		key, freq := "", 0.0
+		if name, ok := arg(0).(string); ok {
+			n, found := noteMap[strings.ToLower(name)]
+			if !found {
+				return fail("no key %q", name)
+			}
+			key, freq = n.Key, m.noteFreq(n)
+		} else if num, ok := oscNumber(arg(0)); ok {
+			freq = 440 * math.Exp2((num-69)/12)
+			if !audibleFreq(freq) {
+				return fail("note %v is out of range", num)
+			}
+			key = "osc:" + strconv.Itoa(int(num))
+		} else {
+			return fail("/piango/note needs a note")
+		}
+		if velocity == 0 {
+			releaseVoice(key)
+		} else {
+			holdVoice(key, freq, velocity)
+		}
+		return m, nil
+
+	case "/piango/instrument":
+		id := -1
+		if name, ok := arg(0).(string); ok {
+			if findInstrument(name) != nil {
+				id = indexOfInstrument(name)
+			}
+		} else if num, ok := oscNumber(arg(0)); ok && int(num) >= 0 && int(num) < len(instruments) {
+			id = int(num)
+		}
+		if id < 0 {
+			return fail("no instrument %v", arg(0))
+		}
+		voiceLock.Lock()
+		currentInstID = id
+		m.instName = instruments[id].Name
+		voiceLock.Unlock()
+		return m, nil
+
+	case "/piango/param":
+		name, _ := arg(0).(string)
+		v, ok := oscNumber(arg(1))
+		if !ok {
+			return fail("/piango/param needs a name and a value")
+		}
+		pp, field, found := findControl(name)
+		if found {
+			output.Lock()
+			*field() = max(pp.Min, min(pp.Max, v))
+			output.Unlock()
+			return m, nil
+		}
+		return fail("no param %q", name)
+
+	case "/piango/action":
+		name, _ := arg(0).(string)
+		fn, ok := actions[name]
+		if !ok {
+			return fail("no action %q", name)
+		}
+		return fn(m), nil
+	}
+	return fail("unknown address %s", msg.Address)
+}
+
+// encodeOSC builds a message. Arguments may be int, float64 or string.
+func encodeOSC(addr string, args ...any) []byte {
+	pad := func(b []byte, s string) []byte {
+		b = append(b, s...)
+		return append(b, make([]byte, 4-len(s)%4)...)
+	}
+
+	tags := ","
+	for _, a := range args {
+		switch a.(type) {
+		case int:
+			tags += "i"
+		case float64:
+			tags += "f"
+		case string:
+			tags += "s"
+		}
+	}
+	b := pad(pad(nil, addr), tags)
+	for _, a := range args {
+		switch v := a.(type) {
+		case int:
+			b = binary.BigEndian.AppendUint32(b, uint32(int32(v)))
+		case float64:
+			b = binary.BigEndian.AppendUint32(b, math.Float32bits(float32(v)))
+		case string:
+			b = pad(b, v)
+		}
+	}
+	return b
+}
+
+// oscSender forwards performance events (see events.go) to an external
+// visualizer or lighting rig:
+//
+//	/piango/note_on <key> <freq> <velocity>
+//	/piango/note_off <key> <freq>
+//	/piango/instrument <index> <name>
+//	/piango/spectrum <bar> ...        one float per visualizer bar, 0..1
+type oscSender struct {
+	conn net.Conn
+}
+
+func newOSCSender(addr string) (*oscSender, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &oscSender{conn: conn}, nil
+}
+
+// emit writes a message per event. Nobody listening isn't an error worth
+// surfacing, so write errors are dropped.
+func (o *oscSender) emit(events []perfEvent) {
+	for _, ev := range events {
+		var args []any
+		switch ev.Type {
+		case "note_on":
+			args = []any{ev.Key, ev.Freq, ev.Velocity}
+		case "note_off":
+			args = []any{ev.Key, ev.Freq}
+		case "instrument":
+			args = []any{ev.Index, ev.Name}
+		case "spectrum":
+			for _, v := range ev.Bars {
+				args = append(args, v)
+			}
+		}
+		o.conn.Write(encodeOSC("/piango/"+ev.Type, args...))
+	}
+}
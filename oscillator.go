@@ -0,0 +1,86 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+)
+
+// --- OSCILLATORS ---
+//
+// An Oscillator is a voice's sound source. Every voice builds its own from
+// the instrument, one per unison copy, so an oscillator can keep whatever
+// state it needs from one sample to the next: a phase, a noise filter, a
+// modulation LFO or a physical model.
+
+type Oscillator interface {
+	// NextSample returns the next sample of a note at freq Hz.
+	NextSample(freq, sampleRate float64) float64
+}
+
+// Waveform is a stateless single-cycle shape, phase in radians.
+type Waveform func(phase float64) float64
+
+// wave plays a Waveform as an oscillator, from its table if the
+// wavetables have been built (see wavetable.go).
+func wave(w Waveform) func() Oscillator {
+	t := &waveTable{shape: w}
+	waveTables = append(waveTables, t)
+	return func() Oscillator {
+		if t.points != nil {
+			return &tableOsc{points: t.points}
+		}
+		return &phaseOsc{shape: w}
+	}
+}
+
+type phaseOsc struct {
+	shape Waveform
+	phase float64
+}
+
+func (o *phaseOsc) NextSample(freq, sampleRate float64) float64 {
+	v := o.shape(o.phase)
+	o.phase = advancePhase(o.phase, freq, sampleRate)
+	return v
+}
+
+func (o *phaseOsc) scatter() { o.phase = rand.Float64() * 2 * math.Pi }
+
+// scatterer is implemented by oscillators with a phase. Unison copies
+// start scattered so they don't comb against each other at the attack.
+type scatterer interface {
+	scatter()
+}
+
+func advancePhase(phase, freq, sampleRate float64) float64 {
+	phase += 2 * math.Pi * freq / sampleRate
+	if phase >= 2*math.Pi {
+		phase = math.Mod(phase, 2*math.Pi)
+	}
+	return phase
+}
+
+// pwmOsc subtracts two saws a varying distance apart, which leaves a pulse
+// whose width is swept by a slow LFO.
+type pwmOsc struct {
+	phase, lfo float64
+}
+
+const pwmRate = 0.4 // Hz
+
+func newPWM() Oscillator { return &pwmOsc{} }
+
+func (o *pwmOsc) NextSample(freq, sampleRate float64) float64 {
+	offset := 1.5 + 1.2*math.Sin(o.lfo)
+	saw1 := o.phase/math.Pi - 1
+	saw2 := math.Mod(o.phase+offset, 2*math.Pi)/math.Pi - 1
+
+	o.phase = advancePhase(o.phase, freq, sampleRate)
+	o.lfo = advancePhase(o.lfo, pwmRate, sampleRate)
+	return (saw1 - saw2) * 0.1
+}
+
+func (o *pwmOsc) scatter() {
+	o.phase = rand.Float64() * 2 * math.Pi
+	o.lfo = rand.Float64() * 2 * math.Pi
+}
@@ -0,0 +1,76 @@
+package main
+
+// --- OVERSAMPLING ---
+//
+// Waveforms that clip or fold, like the Distorted Lead's and the Acid
+// Wavefolder's, have harmonics far past what the sample rate can hold,
+// and those fold back down as inharmonic whistles. A patch's Oversample
+// runs its oscillators at 2 or 4 times the rate and filters each one
+// down before keeping every 2nd or 4th sample, so they fold back much
+// quieter. It costs that many times the oscillator work plus the filter,
+// so it's per instrument and can be turned back to 1 on a slow machine.
+// FM instruments and the sub oscillator aren't affected.
+
+const maxOversample = 4
+
+// decimateCutoff is where the filter starts, as a fraction of the output
+// rate, leaving it room to fall before the Nyquist frequency.
+const decimateCutoff = 0.4
+
+// butterworthQ are the stages of an eighth-order Butterworth low-pass.
+var butterworthQ = [4]float64{0.5098, 0.6013, 0.9000, 2.5629}
+
+// oversampling is the patch's factor: 1, 2 or 4.
+func oversampling(p *Patch) int {
+	switch {
+	case p.Oversample >= 3:
+		return 4
+	case p.Oversample >= 1.5:
+		return 2
+	}
+	return 1
+}
+
+// decimator is one oscillator's anti-aliasing filter. The zero value is
+// designed on first use.
+type decimator struct {
+	factor int
+	stages [4]biquad
+}
+
+// design sets the filter for factor. The biquads work out their
+// coefficients against sampleRate, so the cutoff is divided by the factor
+// instead of the rate multiplied.
+func (d *decimator) design(factor int) {
+	d.factor = factor
+	for k := range d.stages {
+		d.stages[k].set(biquadLowpass, decimateCutoff*float64(sampleRate)/float64(factor), butterworthQ[k], 0)
+		d.stages[k].z = [2][2]float64{}
+	}
+}
+
+// next runs osc factor times at the raised rate and returns the last
+// sample through the filter.
+func (d *decimator) next(osc Oscillator, freq, sr float64, factor int) float64 {
+	if d.factor != factor {
+		d.design(factor)
+	}
+	var y float64
+	for range factor {
+		y = osc.NextSample(freq, sr*float64(factor))
+		for k := range d.stages {
+			y = d.stages[k].process(y, 0)
+		}
+	}
+	return y
+}
+
+// oversampledPatch is the default patch with the oscillators run at twice
+// the rate, for the built-in waveforms that alias, and the sub at
+// subLevel.
+func oversampledPatch(subLevel float64) Patch {
+	p := defaultPatch()
+	p.Oversample = 2
+	p.SubLevel = subLevel
+	return p
+}
@@ -0,0 +1,32 @@
+package main
+
+import "math"
+
+// --- STEREO PANNING ---
+//
+// Like sitting at a piano, low notes come from the left and high notes
+// from the right. The spread covers the three keyboard octaves; notes
+// shifted beyond them stay at the edge.
+
+const (
+	panCenter = 370.0 // Hz, the middle of the keyboard
+	panWidth  = 0.6   // how far the edges of the keyboard go, 0..1
+)
+
+// panByPitch is set from the config before audio starts.
+var panByPitch = true
+
+// pitchPan places freq in the stereo field, -1 (left) to 1 (right).
+func pitchPan(freq float64) float64 {
+	if !panByPitch || freq <= 0 {
+		return 0
+	}
+	pos := math.Log2(freq/panCenter) / 1.5
+	return math.Max(-1, math.Min(1, pos)) * panWidth
+}
+
+// panGains is an equal-power pan, scaled so the centre is at unity.
+func panGains(pos float64) (l, r float64) {
+	angle := (pos + 1) * math.Pi / 4
+	return math.Cos(angle) * math.Sqrt2, math.Sin(angle) * math.Sqrt2
+}
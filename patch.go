@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// --- PATCH PARAMETERS ---
+
+// Patch holds an instrument's tweakable sound parameters. Voices keep a
+// pointer to their instrument's Patch and read it while rendering, so
+// edits reach sounding notes; level-type values are de-zippered in Stream.
+type Patch struct {
+	Level      float64 `json:"level"`
+	AttackMs   float64 `json:"attack_ms"`
+	ReleaseMs  float64 `json:"release_ms"`
+	StaccatoMs float64 `json:"staccato_ms"` // release of Shift-played notes
+
+	// Unison copies per note, their detune in cents and stereo width 0..1
+	Unison float64 `json:"unison"`
+	Detune float64 `json:"detune"`
+	Spread float64 `json:"spread"`
+
+	// Level of the sub oscillator an octave below, 0 is off
+	SubLevel float64 `json:"sub_level"`
+
+	// Resonant low-pass per voice; fully open at 20 kHz
+	Cutoff    float64 `json:"cutoff"` // Hz
+	Resonance float64 `json:"resonance"`
+
+	// Filter envelope: how many octaves it opens the cutoff at its peak,
+	// and its shape
+	FilterEnv       float64 `json:"filter_env"`
+	FilterAttackMs  float64 `json:"filter_attack_ms"`
+	FilterDecayMs   float64 `json:"filter_decay_ms"`
+	FilterSustain   float64 `json:"filter_sustain"`
+	FilterReleaseMs float64 `json:"filter_release_ms"`
+
+	// Bitcrusher insert: bit depth (16 is clean) and how many samples
+	// each held value lasts (1 is clean)
+	CrushBits float64 `json:"crush_bits"`
+	Decimate  float64 `json:"decimate"`
+
+	// Oscillators run at 1, 2 or 4 times the sample rate, so hard
+	// waveforms alias less
+	Oversample float64 `json:"oversample"`
+
+	// Portamento: new notes slide from the previous one, 0 is off
+	GlideMs float64 `json:"glide_ms"`
+
+	// The modulation matrix's LFOs, in Hz
+	LFO1Rate float64 `json:"lfo1_rate"`
+	LFO2Rate float64 `json:"lfo2_rate"`
+
+	// Vibrato is LFO1 on pitch, heard while it's switched on with /
+	VibratoDepth float64 `json:"vibrato_depth"` // cents
+
+	// Tremolo is LFO2 on the level, off while the depth is 0
+	TremoloDepth float64 `json:"tremolo_depth"`
+}
+
+// paramSmoothing is how quickly live edits glide to their new value.
+const paramSmoothing = 10 * time.Millisecond
+
+func defaultPatch() Patch {
+	return Patch{
+		Level:      1.0,
+		AttackMs:   0.25,
+		ReleaseMs:  23,
+		StaccatoMs: 0.5,
+
+		Cutoff:    filterMaxHz,
+		Resonance: 0.2,
+
+		FilterAttackMs:  1,
+		FilterDecayMs:   300,
+		FilterReleaseMs: 200,
+
+		CrushBits: 16,
+		Decimate:  1,
+
+		Oversample: 1,
+
+		Unison: 1,
+		Detune: 12,
+		Spread: 0.5,
+
+		LFO1Rate:     5.5,
+		LFO2Rate:     4,
+		VibratoDepth: 20,
+	}
+}
+
+func init() {
+	// Built-in instruments don't spell out a patch; give them the default,
+	// with their declared sub oscillator switched on
+	for i := range instruments {
+		if instruments[i].Patch == (Patch{}) {
+			instruments[i].Patch = defaultPatch()
+			if instruments[i].Sub != nil {
+				instruments[i].Patch.SubLevel = 0.5
+			}
+		}
+	}
+}
+
+// patchParam describes one editable value for the patch editor. Log
+// params move by a ratio per step instead of a fixed amount, which suits
+// times that span several orders of magnitude.
+type patchParam struct {
+	Name     string
+	Unit     string
+	Min, Max float64
+	Step     float64
+	Log      bool
+	Field    func(inst *Instrument) *float64
+}
+
+// The filter params also have their own performance keys
+var (
+	cutoffParam = patchParam{Name: "Cutoff", Unit: "Hz", Min: filterMinHz, Max: filterMaxHz, Step: 1.12, Log: true,
+		Field: func(inst *Instrument) *float64 { return &inst.Patch.Cutoff }}
+	resonanceParam = patchParam{Name: "Resonance", Min: 0, Max: 1, Step: 0.05,
+		Field: func(inst *Instrument) *float64 { return &inst.Patch.Resonance }}
+)
+
+var patchParams = []patchParam{
+	{Name: "Level", Min: 0, Max: 2, Step: 0.05,
+		Field: func(inst *Instrument) *float64 { return &inst.Patch.Level }},
+	{Name: "Attack", Unit: "ms", Min: 0.1, Max: 3000, Step: 1.25, Log: true,
+		Field: func(inst *Instrument) *float64 { return &inst.Patch.AttackMs }},
+	{Name: "Release", Unit: "ms", Min: 0.1, Max: 5000, Step: 1.25, Log: true,
+		Field: func(inst *Instrument) *float64 { return &inst.Patch.ReleaseMs }},
+	{Name: "Staccato", Unit: "ms", Min: 0.1, Max: 1000, Step: 1.25, Log: true,
+		Field: func(inst *Instrument) *float64 { return &inst.Patch.StaccatoMs }},
+	{Name: "Unison", Min: 1, Max: maxUnison, Step: 1,
+		Field: func(inst *Instrument) *float64 { return &inst.Patch.Unison }},
+	{Name: "Detune", Unit: "ct", Min: 0, Max: 50, Step: 1,
+		Field: func(inst *Instrument) *float64 { return &inst.Patch.Detune }},
+	{Name: "Spread", Min: 0, Max: 1, Step: 0.05,
+		Field: func(inst *Instrument) *float64 { return &inst.Patch.Spread }},
+	{Name: "Sub Level", Min: 0, Max: 1, Step: 0.05,
+		Field: func(inst *Instrument) *float64 { return &inst.Patch.SubLevel }},
+	cutoffParam,
+	resonanceParam,
+	{Name: "Flt Env", Unit: "oct", Min: 0, Max: 8, Step: 0.25,
+		Field: func(inst *Instrument) *float64 { return &inst.Patch.FilterEnv }},
+	{Name: "Flt Att", Unit: "ms", Min: 0.1, Max: 3000, Step: 1.25, Log: true,
+		Field: func(inst *Instrument) *float64 { return &inst.Patch.FilterAttackMs }},
+	{Name: "Flt Dec", Unit: "ms", Min: 0.1, Max: 5000, Step: 1.25, Log: true,
+		Field: func(inst *Instrument) *float64 { return &inst.Patch.FilterDecayMs }},
+	{Name: "Flt Sus", Min: 0, Max: 1, Step: 0.05,
+		Field: func(inst *Instrument) *float64 { return &inst.Patch.FilterSustain }},
+	{Name: "Flt Rel", Unit: "ms", Min: 0.1, Max: 5000, Step: 1.25, Log: true,
+		Field: func(inst *Instrument) *float64 { return &inst.Patch.FilterReleaseMs }},
+	{Name: "Bits", Min: 1, Max: 16, Step: 1,
+		Field: func(inst *Instrument) *float64 { return &inst.Patch.CrushBits }},
+	{Name: "Decimate", Min: 1, Max: 32, Step: 1,
+		Field: func(inst *Instrument) *float64 { return &inst.Patch.Decimate }},
+	{Name: "Oversample", Unit: "×", Min: 1, Max: maxOversample, Step: 2, Log: true,
+		Field: func(inst *Instrument) *float64 { return &inst.Patch.Oversample }},
+	{Name: "Glide", Unit: "ms", Min: 0, Max: 1000, Step: 10,
+		Field: func(inst *Instrument) *float64 { return &inst.Patch.GlideMs }},
+	{Name: "LFO1 Rate", Unit: "Hz", Min: 0.05, Max: 20, Step: 0.25,
+		Field: func(inst *Instrument) *float64 { return &inst.Patch.LFO1Rate }},
+	{Name: "Vib Depth", Unit: "ct", Min: 0, Max: 100, Step: 5,
+		Field: func(inst *Instrument) *float64 { return &inst.Patch.VibratoDepth }},
+	{Name: "LFO2 Rate", Unit: "Hz", Min: 0.05, Max: 20, Step: 0.25,
+		Field: func(inst *Instrument) *float64 { return &inst.Patch.LFO2Rate }},
+	{Name: "Trem Dep", Min: 0, Max: 1, Step: 0.05,
+		Field: func(inst *Instrument) *float64 { return &inst.Patch.TremoloDepth }},
+	{Name: "Humanize", Min: 0, Max: 1, Step: 0.05,
+		Field: func(inst *Instrument) *float64 { return &inst.Humanize }},
+}
+
+// adjust moves v one step up (dir 1) or down (dir -1) within the range.
+func (pp patchParam) adjust(v float64, dir int) float64 {
+	if pp.Log {
+		v = math.Max(v, pp.Min)
+		if dir > 0 {
+			v *= pp.Step
+		} else {
+			v /= pp.Step
+		}
+	} else {
+		v += float64(dir) * pp.Step
+		v = math.Round(v/pp.Step) * pp.Step
+	}
+	return math.Max(pp.Min, math.Min(pp.Max, v))
+}
+
+// position maps v onto 0..1 for drawing the slider.
+func (pp patchParam) position(v float64) float64 {
+	if pp.Log {
+		return math.Log(v/pp.Min) / math.Log(pp.Max/pp.Min)
+	}
+	return (v - pp.Min) / (pp.Max - pp.Min)
+}
+
+// at is the value at pos along the slider, the inverse of position.
+func (pp patchParam) at(pos float64) float64 {
+	pos = max(0, min(1, pos))
+	if pp.Log {
+		return pp.Min * math.Pow(pp.Max/pp.Min, pos)
+	}
+	v := pp.Min + pos*(pp.Max-pp.Min)
+	if pp.Step >= 1 {
+		v = math.Round(v/pp.Step) * pp.Step // counts stay whole
+	}
+	return v
+}
+
+func (pp patchParam) format(v float64) string {
+	switch {
+	case pp.Unit == "" && pp.Step >= 1:
+		return fmt.Sprintf("%.0f", v)
+	case pp.Unit == "":
+		return fmt.Sprintf("%.2f", v)
+	case v < 10:
+		return fmt.Sprintf("%.1f %s", v, pp.Unit)
+	default:
+		return fmt.Sprintf("%.0f %s", v, pp.Unit)
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// --- PIANO ROLL ---
+//
+// CTRL+G lays a take out as a piano roll: a row per pitch, time running
+// right against a grid of beats and bars at the take's tempo. While a
+// take is being recorded the roll follows it as it grows; otherwise it
+// opens on the newest take, or on the one picked in the replay list. The
+// arrows move a cursor from note to note and DELETE removes the one it's
+// on; ENTER and < > set the take's quantize (see quantize.go), swung by
+// the swing setting, drawn as it'll be heard. The take is saved after
+// every edit, and CTRL+Z takes edits back (see undo.go).
+
+const (
+	rollRows      = 12
+	rollLabel     = 7
+	rollCols      = numBars*2 - rollLabel
+	rollCellBeats = 0.25 // a cell is a sixteenth
+	rollBarBeats  = 4
+)
+
+type pianoRoll struct {
+	open   bool
+	live   bool // following the take being recorded
+	name   string
+	path   string // empty for the live take
+	take   take
+	notes  []takeNote // by start
+	pitch  []int      // MIDI numbers of notes
+	cursor int
+}
+
+func newPianoRoll(name, path string, tk take) pianoRoll {
+	r := pianoRoll{open: true, name: name, path: path}
+	r.load(tk)
+	return r
+}
+
+func (r *pianoRoll) load(tk take) {
+	r.take = tk
+	r.notes = r.notes[:0]
+	r.pitch = r.pitch[:0]
+	for _, n := range tk.spans() {
+		if p, ok := midiNote(n.Freq); ok {
+			r.notes = append(r.notes, n)
+			r.pitch = append(r.pitch, int(p))
+		}
+	}
+	r.cursor = max(0, min(r.cursor, len(r.notes)-1))
+}
+
+// openPianoRoll follows the take being recorded, or opens the newest.
+func (m model) openPianoRoll() model {
+	voiceLock.Lock()
+	live, tk := rec.on, rec.snapshot()
+	voiceLock.Unlock()
+	if live {
+		m.roll = newPianoRoll("Recording", "", tk)
+		m.roll.live = true
+		m.roll.cursor = len(m.roll.notes) - 1
+		return m
+	}
+
+	var takes []takeFile
+	var err error
+	if dir := takesDir(); dir != "" {
+		takes, err = listTakes(dir)
+	}
+	m.notifyClearTime = time.Now().Add(3 * time.Second)
+	switch {
+	case err != nil:
+		m.notification = fmt.Sprintf("Piano roll: %v", err)
+	case len(takes) == 0:
+		m.notification = "No takes yet. CTRL+R records one."
+	default:
+		m.roll = newPianoRoll(takes[0].name, takes[0].path, takes[0].take)
+	}
+	return m
+}
+
+// followRecording keeps a live roll up with the take, and closes it if
+// another session stopped the recording. Callers hold voiceLock.
+func (r *pianoRoll) followRecording() {
+	if !rec.on {
+		*r = pianoRoll{}
+		return
+	}
+	end := r.cursor == len(r.notes)-1
+	r.load(rec.snapshot())
+	if end {
+		r.cursor = len(r.notes) - 1
+	}
+}
+
+// recordingSaved swaps a live roll for the take just saved at path.
+func (m model) recordingSaved(path string, tk take) model {
+	if m.roll.open && m.roll.live {
+		cursor := m.roll.cursor
+		m.roll = newPianoRoll(strings.TrimSuffix(filepath.Base(path), ".json"), path, tk)
+		m.roll.cursor = cursor
+	}
+	return m
+}
+
+func (m model) closePianoRoll() model {
+	m.roll = pianoRoll{}
+	return m
+}
+
+// rollEdited saves the take after an edit, one that takes it back to
+// before. An imported MIDI file or score is saved as a new take beside
+// it rather than rewritten, and the roll goes on editing that.
+func (m model) rollEdited(name string, before take) model {
+	r := &m.roll
+	tk := r.take
+	tk.Events = nil
+	tk.setSpans(r.notes)
+	r.take = tk
+
+	var err error
+	if strings.EqualFold(filepath.Ext(r.path), ".json") {
+		err = writeTake(r.path, tk)
+	} else if r.path, err = saveTake(takesDir(), tk); err == nil {
+		r.name = strings.TrimSuffix(filepath.Base(r.path), ".json")
+		m.notification = "Saved as " + r.name
+		m.notifyClearTime = time.Now().Add(3 * time.Second)
+	}
+	if err != nil {
+		m.notification = fmt.Sprintf("Piano roll: %v", err)
+		m.notifyClearTime = time.Now().Add(3 * time.Second)
+		return m
+	}
+	after := tk.clone()
+	return m.takeEdited(name, r.path, &before, &after)
+}
+
+// handlePianoRollKey takes the roll's keys and lets the rest through to
+// play.
+func (m model) handlePianoRollKey(msg tea.KeyMsg) (model, bool) {
+	r := &m.roll
+	switch msg.Type {
+	case tea.KeyCtrlG, tea.KeyEscape:
+		return m.closePianoRoll(), true
+	case tea.KeyLeft:
+		r.cursor = max(0, r.cursor-1)
+		return m, true
+	case tea.KeyRight:
+		r.cursor = max(0, min(len(r.notes)-1, r.cursor+1))
+		return m, true
+	case tea.KeyHome:
+		r.cursor = 0
+		return m, true
+	case tea.KeyEnd:
+		r.cursor = max(0, len(r.notes)-1)
+		return m, true
+	case tea.KeyUp, tea.KeyDown:
+		r.cursor = r.nearest(msg.Type == tea.KeyUp)
+		return m, true
+	case tea.KeyEnter:
+		if r.live {
+			return m.notifyLiveRoll(), true
+		}
+		before := r.take.clone()
+		q := &r.take.Quantize
+		q.Grid = quantizeGrids[(slices.Index(quantizeGrids, q.Grid)+1)%len(quantizeGrids)]
+		q.Strength = q.strength()
+		q.Swing = liveSwing()
+		return m.rollEdited("quantize "+q.gridName(), before), true
+	case tea.KeyDelete, tea.KeyBackspace:
+		if r.live {
+			return m.notifyLiveRoll(), true
+		}
+		if len(r.notes) > 0 {
+			before, name := r.take.clone(), "deleting "+pitchName(r.pitch[r.cursor])
+			r.notes = slices.Delete(r.notes, r.cursor, r.cursor+1)
+			r.pitch = slices.Delete(r.pitch, r.cursor, r.cursor+1)
+			r.cursor = max(0, min(r.cursor, len(r.notes)-1))
+			return m.rollEdited(name, before), true
+		}
+		return m, true
+	}
+	switch msg.String() {
+	case "<", ">":
+		if r.live {
+			return m.notifyLiveRoll(), true
+		}
+		d := quantizeStep
+		if msg.String() == "<" {
+			d = -d
+		}
+		before := r.take.clone()
+		q := &r.take.Quantize
+		q.Strength = math.Round(max(quantizeStep, min(1, q.strength()+d))*100) / 100
+		q.Swing = liveSwing()
+		return m.rollEdited(fmt.Sprintf("quantize strength %.0f%%", q.Strength*100), before), true
+	}
+	return m, false
+}
+
+func (m model) notifyLiveRoll() model {
+	m.notification = "Stop recording to edit"
+	m.notifyClearTime = time.Now().Add(2 * time.Second)
+	return m
+}
+
+// nearest is the note a step above or below the cursor's in pitch,
+// the closest in time among those on that pitch.
+func (r *pianoRoll) nearest(up bool) int {
+	if len(r.notes) == 0 {
+		return 0
+	}
+	cur := r.pitch[r.cursor]
+	best, bestStep, bestGap := r.cursor, math.MaxInt, math.Inf(1)
+	for i, p := range r.pitch {
+		step := p - cur
+		if !up {
+			step = -step
+		}
+		gap := math.Abs(r.notes[i].On - r.notes[r.cursor].On)
+		if step > 0 && (step < bestStep || step == bestStep && gap < bestGap) {
+			best, bestStep, bestGap = i, step, gap
+		}
+	}
+	return best
+}
+
+func (r pianoRoll) cellSeconds() float64 {
+	return r.take.beatSeconds() * rollCellBeats
+}
+
+// shown is a note where it's drawn: quantized, if the take is.
+func (r pianoRoll) shown(i int) takeNote {
+	return r.take.Quantize.apply(r.notes[i], r.take.beatSeconds())
+}
+
+func (m model) pianoRollView() string {
+	r := m.roll
+	title := fmt.Sprintf("--- PIANO ROLL: %s ---", r.name)
+	if r.live {
+		title = "--- PIANO ROLL: ● RECORDING ---"
+	}
+	lines := []string{presetTitleStyle.Render(title)}
+	if len(r.notes) == 0 {
+		lines = append(lines, presetTextStyle.Render("  No notes yet."), helpStyle.Render("ESC/CTRL+G: Close"))
+		return visStyle.Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+	}
+
+	// The view follows the cursor: a third of the way in across, and
+	// centered up and down within the take's range
+	cell := r.cellSeconds()
+	sel := r.shown(r.cursor)
+	left := max(0, int(sel.On/cell)-rollCols/3)
+	if r.live {
+		left = max(0, int(r.take.Length/cell)-rollCols*2/3)
+	}
+	lo, hi := slices.Min(r.pitch), slices.Max(r.pitch)
+	if hi-lo+1 > rollRows {
+		lo = max(lo, min(hi-rollRows+1, r.pitch[r.cursor]-rollRows/2))
+	} else {
+		lo = max(0, (lo+hi+1)/2-rollRows/2)
+	}
+	hi = lo + rollRows - 1
+
+	grid := helpStyle.UnsetMarginTop()
+	noteStyle := presetTextStyle
+	selStyle := notifyStyle.UnsetMarginBottom().UnsetPadding()
+	labelStyle := rowLabelStyle.UnsetMarginTop().UnsetMarginRight().Width(rollLabel - 1)
+
+	// Bar numbers above the grid
+	barCells := int(rollBarBeats / rollCellBeats)
+	ruler := []rune(strings.Repeat(" ", rollCols))
+	for c := range rollCols {
+		if (left+c)%barCells == 0 {
+			for i, d := range fmt.Sprint((left+c)/barCells + 1) {
+				if c+i < rollCols {
+					ruler[c+i] = d
+				}
+			}
+		}
+	}
+	lines = append(lines, strings.Repeat(" ", rollLabel)+grid.Render(string(ruler)))
+
+	start, end := float64(left)*cell, float64(left+rollCols)*cell
+	for p := hi; p >= lo; p-- {
+		var row []takeNote // notes on this pitch in view
+		var rowIdx []int
+		for i := range r.notes {
+			if n := r.shown(i); r.pitch[i] == p && n.On < end && n.Off >= start {
+				row, rowIdx = append(row, n), append(rowIdx, i)
+			}
+		}
+
+		var b strings.Builder
+		b.WriteString(labelStyle.Render(pitchName(p)) + " ")
+		for c := range rollCols {
+			from, to := float64(left+c)*cell, float64(left+c+1)*cell
+			glyph, style := "", noteStyle
+			for j, n := range row {
+				if n.On >= to || max(n.Off, n.On+cell/2) <= from {
+					continue
+				}
+				glyph = "█"
+				if n.On >= from {
+					glyph = "▐" // a note's first cell, to part it from one before
+				}
+				if rowIdx[j] == r.cursor {
+					style = selStyle
+				}
+			}
+			if glyph == "" {
+				switch col := left + c; {
+				case col%barCells == 0:
+					glyph = "│"
+				case col%int(1/rollCellBeats) == 0:
+					glyph = "╎"
+				default:
+					glyph = " "
+				}
+				style = grid
+			}
+			b.WriteString(style.Render(glyph))
+		}
+		lines = append(lines, b.String())
+	}
+
+	beats := sel.On / cell * rollCellBeats
+	status := fmt.Sprintf("%d/%d  %s  bar %d beat %.2f  %.2fs  velocity %.0f%%",
+		r.cursor+1, len(r.notes), pitchName(r.pitch[r.cursor]),
+		int(beats/rollBarBeats)+1, math.Mod(beats, rollBarBeats)+1, sel.Off-sel.On, sel.Velocity*100)
+	if q := r.take.Quantize; q.Grid > 0 {
+		status += fmt.Sprintf("  •  quantize %s at %.0f%%", q.gridName(), q.strength()*100)
+		if q.Swing > 50 {
+			status += fmt.Sprintf(", swing %.0f%%", q.Swing)
+		}
+	}
+	help := "←/→: Note  •  ↑/↓: Pitch  •  HOME/END: First/Last  •  DEL: Delete  •  ENTER: Quantize  •  </>: Strength  •  ESC/CTRL+G: Close"
+	if r.live {
+		help = "←/→: Note  •  ↑/↓: Pitch  •  ESC/CTRL+G: Close"
+	}
+	lines = append(lines, "", instStyle.UnsetMarginBottom().Render(status), helpStyle.UnsetMarginTop().Render(help))
+	return visStyle.Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
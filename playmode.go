@@ -0,0 +1,66 @@
+package main
+
+import "time"
+
+// --- PLAY MODES ---
+//
+// Poly lets every key sound on its own. Mono keeps a single voice: a new
+// key retriggers it from the start of the envelope. Legato also keeps one
+// voice, but a key struck while the previous note still sounds takes the
+// voice over and only changes its pitch, gliding if the patch has a glide
+// time.
+
+const (
+	playPoly = iota
+	playMono
+	playLegato
+)
+
+var playModeNames = []string{"Poly", "Mono", "Legato"}
+
+// playMode is read by the voice manager under voiceLock.
+var playMode = playPoly
+
+func (m model) cyclePlayMode() model {
+	voiceLock.Lock()
+	playMode = (playMode + 1) % len(playModeNames)
+	m.playMode = playMode
+	voiceLock.Unlock()
+	return m
+}
+
+// monoVoice silences every other voice of inst before key starts a note
+// on it, so rows on their own instruments (see rows.go) keep apart. In
+// legato mode it returns the still-sounding voice, retuned to freq, for
+// key to take over; otherwise nil. Call with voiceLock held.
+func monoVoice(key string, inst *Instrument, freq float64) *ActiveVoice {
+	if playMode == playPoly || inst.Kit {
+		return nil
+	}
+
+	var held *ActiveVoice
+	for k, v := range voices {
+		if k == key || k == thereminKey || v.inst != inst {
+			continue
+		}
+		if playMode == playLegato && held == nil && !v.released {
+			held = v
+			delete(voices, k)
+			continue
+		}
+		v.locked = false
+		v.choke()
+	}
+	if held == nil {
+		return nil
+	}
+
+	glide := 0.0
+	if ms := inst.Patch.GlideMs; ms > 0 {
+		glide = glideCoef(time.Duration(ms * float64(time.Millisecond)))
+	}
+	held.retune(freq, glide)
+	held.freq = freq
+	lastNoteFreq = freq
+	return held
+}
@@ -0,0 +1,55 @@
+package main
+
+// --- VOICE POOL ---
+//
+// Notes are played from voices that have been played before, so striking
+// one allocates nothing once the engine has warmed up. A voice goes back
+// to the pool when nothing has any more use for it: it has finished, it's
+// gone from voices, and the callback has taken every command that names
+// it off the queue (see voicebank.go). It's kept for the instrument it was
+// built for, whose oscillators it still has. All of it is under voiceLock.
+
+type voicePool struct {
+	free  map[*Instrument][]*ActiveVoice
+	live  []*ActiveVoice // handed out
+	epoch uint64         // of the last sweep
+}
+
+var pool = &voicePool{free: make(map[*Instrument][]*ActiveVoice)}
+
+// get is a voice for a note on inst, recycled if one is free. Callers fill
+// in the rest of the ActiveVoice.
+func (p *voicePool) get(inst *Instrument, freq, velocity float64, staccato bool) *ActiveVoice {
+	var v *ActiveVoice
+	if free := p.free[inst]; len(free) > 0 {
+		v = free[len(free)-1]
+		free[len(free)-1] = nil
+		p.free[inst] = free[:len(free)-1]
+		v.streamer.setup(inst, freq, velocity, staccato)
+	} else {
+		v = &ActiveVoice{streamer: newVoice(inst, freq, velocity, staccato)}
+	}
+	*v = ActiveVoice{streamer: v.streamer, inst: inst}
+	p.live = append(p.live, v)
+	return v
+}
+
+// sweep takes back the voices nothing has any more use for.
+func (p *voicePool) sweep() {
+	p.epoch++
+	for _, v := range voices {
+		v.seen = p.epoch
+	}
+	taken := bank.queue.head.Load()
+	for i := 0; i < len(p.live); {
+		v := p.live[i]
+		if v.seen == p.epoch || !v.finished() || v.sent > taken {
+			i++
+			continue
+		}
+		last := len(p.live) - 1
+		p.live[i], p.live[last] = p.live[last], nil
+		p.live = p.live[:last]
+		p.free[v.inst] = append(p.free[v.inst], v)
+	}
+}
@@ -0,0 +1,111 @@
+//go:build portaudio
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gopxl/beep/v2"
+	"github.com/gordonklaus/portaudio"
+)
+
+// --- PORTAUDIO OUTPUT ---
+//
+// Built with `-tags portaudio` and selected with `--backend portaudio`.
+// Opens the default output device with a callback stream.
+
+type portaudioBackend struct {
+	mu     sync.Mutex
+	stream *portaudio.Stream
+	mixer  beep.Mixer
+	buf    [][2]float64
+}
+
+func init() {
+	registerBackend("portaudio", &portaudioBackend{})
+}
+
+func (p *portaudioBackend) Init(sr beep.SampleRate, bufferSize int) error {
+	if err := portaudio.Initialize(); err != nil {
+		return err
+	}
+
+	p.buf = make([][2]float64, bufferSize)
+	stream, err := portaudio.OpenDefaultStream(0, 2, float64(sr), bufferSize, p.process)
+	if err != nil {
+		portaudio.Terminate()
+		return err
+	}
+	p.stream = stream
+	return stream.Start()
+}
+
+// Resize reopens the stream with the new buffer; the mixer carries on.
+func (p *portaudioBackend) Resize(bufferSize int) error {
+	if p.stream != nil {
+		p.stream.Close()
+	}
+	stream, err := portaudio.OpenDefaultStream(0, 2, float64(sampleRate), bufferSize, p.process)
+	if err != nil {
+		p.stream = nil
+		portaudio.Terminate()
+		return err
+	}
+	p.stream = stream
+	return stream.Start()
+}
+
+func (p *portaudioBackend) process(out [][]float32) {
+	frames := len(out[0])
+
+	p.mu.Lock()
+	if frames > len(p.buf) {
+		p.buf = make([][2]float64, frames)
+	}
+	samples := p.buf[:frames]
+	n, _ := p.mixer.Stream(samples)
+	p.mu.Unlock()
+
+	for i := range samples {
+		if i >= n {
+			out[0][i], out[1][i] = 0, 0
+			continue
+		}
+		out[0][i] = float32(samples[i][0])
+		out[1][i] = float32(samples[i][1])
+	}
+}
+
+func (p *portaudioBackend) Play(s ...beep.Streamer) {
+	p.mu.Lock()
+	p.mixer.Add(s...)
+	p.mu.Unlock()
+}
+
+func (p *portaudioBackend) Clear() {
+	p.mu.Lock()
+	p.mixer.Clear()
+	p.mu.Unlock()
+}
+
+func (p *portaudioBackend) Close() {
+	if p.stream != nil {
+		p.stream.Close()
+		p.stream = nil
+		portaudio.Terminate()
+	}
+}
+
+func (p *portaudioBackend) Latency() time.Duration {
+	if p.stream == nil {
+		return 0
+	}
+	if info := p.stream.Info(); info != nil {
+		return info.OutputLatency
+	}
+	return 0
+}
+
+func (p *portaudioBackend) Lock()   { p.mu.Lock() }
+func (p *portaudioBackend) Unlock() { p.mu.Unlock() }
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// --- USER PRESETS ---
+//
+// Ctrl+S saves the current instrument, tweaks and all, under a new name in
+// ~/.config/piango/presets/<name>.json. Every file there is added to the
+// instrument list at startup. Oscillators are code, so a preset names the
+// built-in instrument it's based on and stores everything that's data:
+// the patch, modulation routes, partials, FM operators and humanize.
+
+const maxPresetName = 24
+
+type userPreset struct {
+	Name       string     `json:"name"`
+	Base       string     `json:"base"`
+	Patch      Patch      `json:"patch"`
+	Humanize   float64    `json:"humanize"`
+	Modulation []ModRoute `json:"modulation,omitempty"`
+	Partials   []float64  `json:"partials,omitempty"`
+	FM         *FM        `json:"fm,omitempty"`
+
+	path string // the file it was read from
+}
+
+func presetsDir() string {
+	dir := configDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "presets")
+}
+
+// presetFile turns a preset name into a file name: "Warm Pad 2" is
+// warm-pad-2.json.
+func presetFile(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == ' ', r == '-', r == '_':
+			b.WriteRune('-')
+		}
+	}
+	return b.String() + ".json"
+}
+
+// readUserPresets parses every preset in dir, in file name order. A
+// missing directory is fine.
+func readUserPresets(dir string) ([]userPreset, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && filepath.Ext(e.Name()) == ".json" {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+
+	var ups []userPreset
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		// Fields missing from the file keep their defaults
+		up := userPreset{Patch: defaultPatch(), path: path}
+		if err := json.Unmarshal(data, &up); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		ups = append(ups, up)
+	}
+	return ups, nil
+}
+
+// loadUserPresets adds every preset in dir to the instrument list. Hold
+// voiceLock and output.Lock() once the audio is running.
+func loadUserPresets(dir string) error {
+	ups, err := readUserPresets(dir)
+	if err != nil {
+		return err
+	}
+	for _, up := range ups {
+		if err := addUserPreset(up); err != nil {
+			return fmt.Errorf("%s: %w", up.path, err)
+		}
+	}
+	return nil
+}
+
+// addUserPreset appends up to the instrument list, or updates the preset
+// of the same name in place so its sounding voices carry on with the new
+// settings.
+func addUserPreset(up userPreset) error {
+	inst, err := up.instrument()
+	if err != nil {
+		return err
+	}
+	existing := findInstrument(up.Name)
+	if existing == nil {
+		instruments = append(instruments, &inst)
+		return nil
+	}
+	if existing.base == "" {
+		return fmt.Errorf("there's already a built-in instrument called %q", up.Name)
+	}
+	existing.Patch = inst.Patch
+	existing.Humanize = inst.Humanize
+	existing.Mod = inst.Mod
+	copy(existing.Partials, inst.Partials)
+	existing.FM = inst.FM
+	return nil
+}
+
+// instrument builds the preset on top of its base instrument.
+func (up userPreset) instrument() (Instrument, error) {
+	if strings.TrimSpace(up.Name) == "" {
+		return Instrument{}, errors.New("preset has no name")
+	}
+	base := findInstrument(up.Base)
+	if base == nil {
+		return Instrument{}, fmt.Errorf("unknown base instrument %q", up.Base)
+	}
+	for _, r := range up.Modulation {
+		if _, err := parseRoute(r); err != nil {
+			return Instrument{}, err
+		}
+	}
+
+	inst := base.clone()
+	inst.Name = up.Name
+	inst.base = base.baseName()
+	inst.Patch = up.Patch
+	inst.Humanize = up.Humanize
+	inst.Mod = up.Modulation
+	if up.Partials != nil && inst.Partials != nil {
+		for k := range inst.Partials {
+			inst.Partials[k] = 0
+			if k < len(up.Partials) {
+				inst.Partials[k] = max(0, min(1, up.Partials[k]))
+			}
+		}
+	}
+	if up.FM != nil && inst.FM != nil {
+		if len(up.FM.Ops) == 0 || len(up.FM.Ops) > maxOperators ||
+			up.FM.Algorithm < 0 || up.FM.Algorithm >= len(fmAlgorithms) {
+			return Instrument{}, errors.New("fm needs 1-4 operators and a known algorithm")
+		}
+		inst.FM = up.FM
+	}
+	return inst, nil
+}
+
+// clone copies inst deeply enough that editing the copy leaves the
+// original alone.
+func (inst *Instrument) clone() Instrument {
+	c := *inst
+	c.Partials = append([]float64(nil), inst.Partials...)
+	c.Mod = append([]ModRoute(nil), inst.Mod...)
+	if inst.FM != nil {
+		fm := *inst.FM
+		fm.Ops = append([]FMOperator(nil), inst.FM.Ops...)
+		c.FM = &fm
+	}
+	return c
+}
+
+// baseName is the built-in instrument inst's sound comes from.
+func (inst *Instrument) baseName() string {
+	if inst.base != "" {
+		return inst.base
+	}
+	return inst.Name
+}
+
+func saveUserPreset(dir string, up userPreset) error {
+	if dir == "" {
+		return errors.New("no config directory")
+	}
+	data, err := json.MarshalIndent(up, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, presetFile(up.Name)), data, 0o644)
+}
+
+type presetPrompt struct {
+	open bool
+	name string
+}
+
+// handlePresetPromptKey takes every key while the prompt is open, since
+// the name is typed on the note keys.
+func (m model) handlePresetPromptKey(msg tea.KeyMsg) model {
+	p := &m.savePrompt
+	switch msg.Type {
+	case tea.KeyEscape, tea.KeyCtrlS:
+		p.open = false
+	case tea.KeyEnter:
+		m = m.saveCurrentAs(strings.TrimSpace(p.name))
+	case tea.KeyBackspace:
+		if r := []rune(p.name); len(r) > 0 {
+			p.name = string(r[:len(r)-1])
+		}
+	case tea.KeySpace:
+		p.name += " "
+	case tea.KeyRunes:
+		p.name += string(msg.Runes)
+	}
+	if r := []rune(p.name); len(r) > maxPresetName {
+		p.name = string(r[:maxPresetName])
+	}
+	return m
+}
+
+// saveCurrentAs writes the current instrument out as a preset and adds it
+// to the list, or updates it if there's already a preset of that name.
+func (m model) saveCurrentAs(name string) model {
+	notify := func(msg string) model {
+		m.notification = msg
+		m.notifyClearTime = time.Now().Add(2 * time.Second)
+		return m
+	}
+	if name == "" || presetFile(name) == ".json" {
+		return notify("Preset needs a name")
+	}
+
+	cur := instruments[currentInstID]
+	existing := findInstrument(name)
+	if existing != nil && existing.base == "" {
+		return notify(fmt.Sprintf("%s is a built-in instrument", name))
+	}
+
+	output.Lock()
+	up := userPreset{
+		Name:       name,
+		Base:       cur.baseName(),
+		Patch:      cur.Patch,
+		Humanize:   cur.Humanize,
+		Modulation: append([]ModRoute(nil), cur.Mod...),
+		Partials:   append([]float64(nil), cur.Partials...),
+	}
+	if cur.FM != nil {
+		fm := *cur.FM
+		fm.Ops = append([]FMOperator(nil), cur.FM.Ops...)
+		up.FM = &fm
+	}
+	output.Unlock()
+	if len(up.Partials) == 0 {
+		up.Partials = nil
+	}
+
+	if err := saveUserPreset(presetsDir(), up); err != nil {
+		return notify(fmt.Sprintf("Save failed: %v", err))
+	}
+
+	voiceLock.Lock()
+	output.Lock()
+	err := addUserPreset(up)
+	currentInstID = indexOfInstrument(name)
+	output.Unlock()
+	voiceLock.Unlock()
+	if err != nil {
+		return notify(fmt.Sprintf("Save failed: %v", err))
+	}
+	// The watcher doesn't need to reload what was just saved
+	m.presetWatch.sync()
+
+	m.instName = name
+	m.savePrompt.open = false
+	return notify("Saved preset " + name)
+}
+
+// presetWatcher notices files being added to, changed in or removed from
+// the presets directory, so presets edited by hand are picked up without a
+// restart.
+type presetWatcher struct {
+	dir       string
+	sig       string
+	lastCheck time.Time
+}
+
+func newPresetWatcher(dir string) *presetWatcher {
+	w := &presetWatcher{dir: dir}
+	w.sync()
+	return w
+}
+
+// signature sums up the directory listing: names, sizes and times.
+func (w *presetWatcher) signature() string {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return ""
+	}
+	var b strings.Builder
+	for _, e := range entries {
+		if fi, err := e.Info(); err == nil {
+			fmt.Fprintf(&b, "%s %d %d\n", e.Name(), fi.Size(), fi.ModTime().UnixNano())
+		}
+	}
+	return b.String()
+}
+
+func (w *presetWatcher) sync() {
+	if w != nil && w.dir != "" {
+		w.sig = w.signature()
+	}
+}
+
+// poll lists the directory at most once a second and reports whether it
+// changed since the last call.
+func (w *presetWatcher) poll(now time.Time) bool {
+	if w.dir == "" || now.Sub(w.lastCheck) < time.Second {
+		return false
+	}
+	w.lastCheck = now
+
+	sig := w.signature()
+	if sig == w.sig {
+		return false
+	}
+	w.sig = sig
+	return true
+}
+
+// reloadPresets re-reads the presets directory after it changed. New
+// presets join the list and changed ones are updated in place, sounding
+// voices and all; presets whose files were deleted stay until restart.
+func (m model) reloadPresets() model {
+	ups, err := readUserPresets(m.presetWatch.dir)
+	if err == nil {
+		voiceLock.Lock()
+		output.Lock()
+		for _, up := range ups {
+			if err = addUserPreset(up); err != nil {
+				err = fmt.Errorf("%s: %w", filepath.Base(up.path), err)
+				break
+			}
+		}
+		output.Unlock()
+		voiceLock.Unlock()
+	}
+
+	if err != nil {
+		m.notification = "Presets: " + err.Error()
+		m.notifyClearTime = time.Now().Add(4 * time.Second)
+		return m
+	}
+	m.notification = "Presets reloaded"
+	m.notifyClearTime = time.Now().Add(1 * time.Second)
+	return m
+}
+
+func indexOfInstrument(name string) int {
+	for i := range instruments {
+		if instruments[i].Name == name {
+			return i
+		}
+	}
+	return currentInstID
+}
+
+func (m model) presetPromptView() string {
+	lines := []string{
+		presetTitleStyle.Render("--- SAVE PRESET: " + instruments[currentInstID].Name + " ---"),
+		"",
+		instStyle.UnsetMarginBottom().Render("Name: " + m.savePrompt.name + "█"),
+		"",
+		presetTextStyle.Render("Saved to " + filepath.Join(presetsDir(), presetFile(m.savePrompt.name))),
+		helpStyle.Render("ENTER: Save  •  ESC: Cancel"),
+	}
+	return visStyle.Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
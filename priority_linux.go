@@ -0,0 +1,29 @@
+package main
+
+import (
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// raisePriority keeps the calling goroutine on its thread and asks for
+// real-time scheduling for it, or failing that a lower nice value. A thread
+// that's real-time already, like JACK's, is left alone.
+func raisePriority() string {
+	runtime.LockOSThread()
+	tid := unix.Gettid()
+	if attr, err := unix.SchedGetAttr(tid, 0); err == nil {
+		switch attr.Policy {
+		case unix.SCHED_FIFO, unix.SCHED_RR, unix.SCHED_DEADLINE:
+			return "real-time"
+		}
+	}
+	attr := unix.SchedAttr{Policy: unix.SCHED_FIFO, Priority: 10}
+	if unix.SchedSetAttr(tid, &attr, 0) == nil {
+		return "real-time"
+	}
+	if unix.Setpriority(unix.PRIO_PROCESS, tid, -10) == nil {
+		return "raised"
+	}
+	return "normal"
+}
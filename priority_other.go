@@ -0,0 +1,10 @@
+//go:build !linux && !windows
+
+package main
+
+// raisePriority leaves the thread as it is: on macOS the sound is played
+// from Core Audio's own real-time thread, and a process can't raise its
+// threads one by one elsewhere without extra rights.
+func raisePriority() string {
+	return "normal"
+}
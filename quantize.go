@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// --- QUANTIZE ---
+//
+// A take can be quantized: each note's start is pulled toward the nearest
+// quarter, eighth or sixteenth at the take's tempo, all the way or part of
+// it, and the note moves whole so it keeps its length. The setting is kept
+// with the take and applied when it's played, exported or drawn, never to
+// the events themselves, so turning it off gives back the notes as they
+// were recorded. The grid swings by the global swing at the moment the
+// setting was last changed: every other line is pushed later, the same
+// as the drum machine's off-beat steps.
+
+const quantizeStep = 0.1 // strength change per key press
+
+// quantizeGrids are the grids ENTER in the piano roll cycles through, in
+// beats. Zero is off.
+var quantizeGrids = []float64{0, 1, 0.5, 0.25}
+
+type quantize struct {
+	Grid     float64 `json:"grid"`            // in beats; 0 is off
+	Strength float64 `json:"strength"`        // how far notes move to the grid, 0-1
+	Swing    float64 `json:"swing,omitempty"` // percent of a pair of grid steps the first takes; 50 or 0 is straight
+}
+
+// gridName is the grid as a note value, as in 1/8.
+func (q quantize) gridName() string {
+	if q.Grid <= 0 {
+		return "off"
+	}
+	return fmt.Sprintf("1/%g", 4/q.Grid)
+}
+
+// liveSwing is the swing set now, for a quantize setting to take up.
+func liveSwing() float64 {
+	output.Lock()
+	defer output.Unlock()
+	return transport.swing
+}
+
+// strength is how far notes are moved, full strength if it was never set.
+func (q quantize) strength() float64 {
+	if q.Strength <= 0 {
+		return 1
+	}
+	return min(1, q.Strength)
+}
+
+// apply moves a note toward the grid, beat seconds long.
+func (q quantize) apply(n takeNote, beat float64) takeNote {
+	if q.Grid <= 0 {
+		return n
+	}
+	// Grid lines come in pairs, the second one late by the swing
+	pair := 2 * q.Grid * beat
+	swing := 0.5
+	if q.Swing > 50 {
+		swing = min(q.Swing, 75) / 100
+	}
+	from := math.Floor(n.On/pair) * pair
+	to := from
+	for _, line := range []float64{from + pair*swing, from + pair} {
+		if math.Abs(line-n.On) < math.Abs(to-n.On) {
+			to = line
+		}
+	}
+	shift := (to - n.On) * q.strength()
+	n.On += shift
+	n.Off += shift
+	return n
+}
+
+// performed is the take as it's heard, quantized if that's on.
+func (tk take) performed() take {
+	if tk.Quantize.Grid <= 0 {
+		return tk
+	}
+	notes := tk.spans()
+	for i, n := range notes {
+		notes[i] = tk.Quantize.apply(n, tk.beatSeconds())
+	}
+	tk.Events = nil
+	tk.setSpans(notes)
+	for _, ev := range tk.Events {
+		tk.Length = max(tk.Length, ev.T)
+	}
+	return tk
+}
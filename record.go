@@ -0,0 +1,675 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// --- RECORDING & REPLAY ---
+//
+// CTRL+R starts and stops a take: every note struck, by the keyboard, the
+// mouse, a controller or a jam partner, is written down with the moment it
+// started and ended, and saved to ~/.config/piango/takes as JSON. CTRL+F
+// lists the takes and plays one back through whatever instrument is
+// selected, so a phrase can be heard again on another sound; SPACE pauses
+// and the arrows seek. `-replay file.json` opens the panel already playing.
+
+const (
+	takeVersion  = 1
+	replayPrefix = "replay:" // voice keys of notes being played back
+	replayRows   = 8
+	replaySeek   = 5.0 // seconds the arrows move
+)
+
+type takeEvent struct {
+	T        float64 `json:"t"`    // seconds from the start of the take
+	Type     string  `json:"type"` // "on" or "off"
+	Key      string  `json:"key"`
+	Freq     float64 `json:"freq,omitempty"`
+	Velocity float64 `json:"velocity,omitempty"`
+	Track    int     `json:"track,omitempty"` // index into the take's tracks
+}
+
+// voiceKey tells the same key apart on different tracks.
+func (ev takeEvent) voiceKey() string {
+	if ev.Track == 0 {
+		return ev.Key
+	}
+	return fmt.Sprintf("t%d:%s", ev.Track, ev.Key)
+}
+
+type take struct {
+	Version    int         `json:"version"`
+	Recorded   time.Time   `json:"recorded"`
+	Instrument string      `json:"instrument"`    // what it was played on
+	BPM        float64     `json:"bpm,omitempty"` // the transport's tempo at the time
+	Length     float64     `json:"length"`
+	Tracks     []takeTrack `json:"tracks,omitempty"` // see tracks.go; none for a plain take
+	Quantize   quantize    `json:"quantize,omitzero"`
+	Events     []takeEvent `json:"events"`
+}
+
+// beatSeconds is the length of a beat at the take's tempo, or at 120 BPM
+// for a take that doesn't know it.
+func (tk take) beatSeconds() float64 {
+	if tk.BPM <= 0 {
+		return 60.0 / 120
+	}
+	return 60 / tk.BPM
+}
+
+type takeTrack struct {
+	Name       string `json:"name"`
+	Instrument string `json:"instrument"`
+	Mute       bool   `json:"mute,omitempty"`
+	Solo       bool   `json:"solo,omitempty"`
+}
+
+// heard reports whether a track is in the mix: when any track is soloed
+// only those are, otherwise every one that isn't muted. A plain take's
+// notes are always heard.
+func (tk take) heard(track int) bool {
+	if track >= len(tk.Tracks) {
+		return true
+	}
+	for _, t := range tk.Tracks {
+		if t.Solo {
+			return tk.Tracks[track].Solo
+		}
+	}
+	return !tk.Tracks[track].Mute
+}
+
+// trackInstrument is what a track plays on. A plain take, or a track whose
+// instrument has gone, plays on fallback.
+func (tk take) trackInstrument(track int, fallback *Instrument) *Instrument {
+	if track < len(tk.Tracks) {
+		if inst := findInstrument(tk.Tracks[track].Instrument); inst != nil {
+			return inst
+		}
+	}
+	return fallback
+}
+
+// clone copies tk deeply enough that editing the copy leaves it alone.
+func (tk take) clone() take {
+	tk.Events = slices.Clone(tk.Events)
+	tk.Tracks = slices.Clone(tk.Tracks)
+	return tk
+}
+
+func (tk take) notes() int {
+	n := 0
+	for _, ev := range tk.Events {
+		if ev.Type == "on" {
+			n++
+		}
+	}
+	return n
+}
+
+// takeNote is a note of a take with its on and off paired up.
+type takeNote struct {
+	On, Off  float64
+	Key      string
+	Freq     float64
+	Velocity float64
+	Track    int
+}
+
+// spans pairs each note-on with the off that ends it, in the order the
+// notes start. A key struck again before its off ends the earlier note
+// there, and one never let go ends with the take.
+func (tk take) spans() []takeNote {
+	var notes []takeNote
+	open := make(map[string]int)
+	for _, ev := range tk.Events {
+		key := ev.voiceKey()
+		if i, ok := open[key]; ok {
+			notes[i].Off = ev.T
+			delete(open, key)
+		}
+		if ev.Type == "on" {
+			open[key] = len(notes)
+			notes = append(notes, takeNote{On: ev.T, Off: tk.Length, Key: ev.Key, Freq: ev.Freq, Velocity: ev.Velocity, Track: ev.Track})
+		}
+	}
+	return notes
+}
+
+// setSpans rewrites the take's events from notes.
+func (tk *take) setSpans(notes []takeNote) {
+	tk.Events = tk.Events[:0]
+	for _, n := range notes {
+		tk.Events = append(tk.Events,
+			takeEvent{T: n.On, Type: "on", Key: n.Key, Freq: n.Freq, Velocity: n.Velocity, Track: n.Track},
+			takeEvent{T: n.Off, Type: "off", Key: n.Key, Track: n.Track})
+	}
+	sortEvents(tk.Events)
+}
+
+// sortEvents puts events in time order, a note that's let go before one
+// struck at the same moment, so a repeated note ends before it's struck
+// again.
+func sortEvents(events []takeEvent) {
+	sort.SliceStable(events, func(i, j int) bool {
+		a, b := events[i], events[j]
+		if a.T != b.T {
+			return a.T < b.T
+		}
+		return a.Type == "off" && b.Type == "on"
+	})
+}
+
+// recorder is the take in progress. It lives under voiceLock, since notes
+// are struck from wherever voices are started.
+type recorder struct {
+	on    bool
+	start time.Time
+	take  take
+	held  map[string]bool
+	track int // the track armed in the tracks panel
+}
+
+var rec recorder
+
+// recordStrike notes a voice being started. Callers hold voiceLock.
+func recordStrike(key string, freq, velocity float64) {
+	if !rec.on || strings.HasPrefix(key, replayPrefix) {
+		return
+	}
+	rec.take.Events = append(rec.take.Events, takeEvent{
+		T: time.Since(rec.start).Seconds(), Type: "on", Key: key, Freq: freq, Velocity: velocity, Track: rec.track,
+	})
+	rec.held[key] = true
+}
+
+// recordReleases notes the held voices that have let go, checked every
+// tick. Callers hold voiceLock.
+func recordReleases() {
+	if !rec.on {
+		return
+	}
+	for key := range rec.held {
+		if v, ok := voices[key]; ok && !v.released && !v.finished() {
+			continue
+		}
+		rec.take.Events = append(rec.take.Events, takeEvent{T: time.Since(rec.start).Seconds(), Type: "off", Key: key, Track: rec.track})
+		delete(rec.held, key)
+	}
+}
+
+// snapshot copies the take so far, with the notes still held running to
+// now. Callers hold voiceLock.
+func (r *recorder) snapshot() take {
+	tk := r.take
+	tk.Events = slices.Clone(tk.Events)
+	tk.Length = time.Since(r.start).Seconds()
+	return tk
+}
+
+// finish ends the take, letting go of anything still held.
+func (r *recorder) finish() take {
+	end := time.Since(r.start).Seconds()
+	for key := range r.held {
+		r.take.Events = append(r.take.Events, takeEvent{T: end, Type: "off", Key: key, Track: r.track})
+	}
+	r.take.Length = end
+	r.on = false
+	r.held = nil
+	return r.take
+}
+
+func takesDir() string {
+	dir := configDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "takes")
+}
+
+func saveTake(dir string, tk take) (string, error) {
+	if dir == "" {
+		return "", errors.New("no config directory")
+	}
+	data, err := json.MarshalIndent(tk, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, tk.Recorded.Format("2006-01-02_150405")+".json")
+	return path, os.WriteFile(path, data, 0o644)
+}
+
+// writeTake saves tk over the take at path.
+func writeTake(path string, tk take) error {
+	data, err := json.MarshalIndent(tk, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+func loadTake(path string) (take, error) {
+	var tk take
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return tk, err
+	}
+	if err := json.Unmarshal(data, &tk); err != nil {
+		return tk, fmt.Errorf("%s: %w", path, err)
+	}
+	if tk.Version > takeVersion {
+		return tk, fmt.Errorf("%s: take version %d is newer than this piango", path, tk.Version)
+	}
+	sort.SliceStable(tk.Events, func(i, j int) bool { return tk.Events[i].T < tk.Events[j].T })
+	if n := len(tk.Events); n > 0 {
+		tk.Length = max(tk.Length, tk.Events[n-1].T)
+	}
+	return tk, nil
+}
+
+// toggleRecording counts in a take, or stops and saves the one being
+// recorded. Pressed during the count-in, it calls the take off.
+func (m model) toggleRecording() model {
+	if m.count != nil {
+		return m.cancelCountIn()
+	}
+	voiceLock.Lock()
+	on := rec.on
+	voiceLock.Unlock()
+	if !on {
+		return m.startCountIn(false)
+	}
+
+	voiceLock.Lock()
+	tk := rec.finish()
+	voiceLock.Unlock()
+
+	m.recording = false
+	m.notifyClearTime = time.Now().Add(3 * time.Second)
+	if len(tk.Events) == 0 {
+		m.notification = "Nothing recorded"
+		return m
+	}
+	path, err := saveTake(takesDir(), tk)
+	if err != nil {
+		m.notification = fmt.Sprintf("Recording: %v", err)
+		return m
+	}
+	m.notification = "Saved take " + strings.TrimSuffix(filepath.Base(path), ".json")
+	return m.takeEdited("recording", path, nil, &tk).recordingSaved(path, tk)
+}
+
+// startRecording starts a take with its clock at now.
+func (m model) startRecording(now time.Time) model {
+	output.Lock()
+	bpm := transport.bpm
+	output.Unlock()
+
+	voiceLock.Lock()
+	if rec.on {
+		voiceLock.Unlock()
+		return m
+	}
+	rec = recorder{
+		on:    true,
+		start: now,
+		take:  take{Version: takeVersion, Recorded: now, Instrument: instruments[currentInstID].Name, BPM: bpm},
+		held:  make(map[string]bool),
+	}
+	voiceLock.Unlock()
+	m.recording = true
+	m.notification = "Recording"
+	m.notifyClearTime = time.Now().Add(time.Second)
+	return m
+}
+
+// takePlayer plays a take back in time with the tick.
+type takePlayer struct {
+	name    string
+	take    take
+	pos     float64 // seconds into the take
+	on, off int     // next events to look at for note-ons and note-offs
+	struck  map[string]float64
+	playing bool
+	last    time.Time // zero until the first tick after starting
+	guide   *guide    // playing along, see guide.go
+}
+
+func newTakePlayer(name string, tk take) *takePlayer {
+	return &takePlayer{name: name, take: tk.performed(), struck: make(map[string]float64), playing: true}
+}
+
+// advance plays what falls between the last tick and now. Note-ons are
+// scheduled ahead to their exact sample; note-offs are taken a tick later
+// so that a short note keeps its length.
+func (pl *takePlayer) advance(now time.Time) {
+	if !pl.playing {
+		return
+	}
+	if pl.last.IsZero() {
+		pl.last = now
+		return
+	}
+	events := pl.take.Events
+	if pl.guide != nil && len(pl.guide.waiting) > 0 {
+		pl.last = now
+		return
+	}
+	end := pl.pos + now.Sub(pl.last).Seconds()*pl.speed()
+	pl.last = now
+	if pl.guide != nil {
+		end = pl.guide.hold(events, pl.on, end, now)
+	}
+	inst := instruments[currentInstID]
+
+	for ; pl.off < len(events) && events[pl.off].T < pl.pos; pl.off++ {
+		ev := events[pl.off]
+		if ev.Type == "off" && pl.struck[ev.voiceKey()] <= ev.T { // not struck again since
+			releaseVoice(replayPrefix + ev.voiceKey())
+		}
+	}
+	for ; pl.on < len(events) && events[pl.on].T < end; pl.on++ {
+		ev := events[pl.on]
+		if ev.Type != "on" || !pl.take.heard(ev.Track) || pl.guide != nil && pl.guide.keys[pl.on] != "" {
+			continue
+		}
+		delay := sampleRate.N(time.Duration((ev.T - pl.pos) / pl.speed() * float64(time.Second)))
+		holdVoiceOn(replayPrefix+ev.voiceKey(), pl.take.trackInstrument(ev.Track, inst), ev.Freq, ev.Velocity, delay)
+		pl.struck[ev.voiceKey()] = ev.T
+	}
+
+	pl.pos = end
+	if pl.pos >= pl.take.Length {
+		pl.pos = pl.take.Length
+		if pl.guide != nil {
+			pl.guide.done = true
+		}
+		pl.pause()
+	}
+}
+
+func (pl *takePlayer) pause() {
+	pl.playing = false
+	releaseReplay()
+}
+
+func (pl *takePlayer) resume() {
+	if pl.pos >= pl.take.Length {
+		pl.seek(0)
+	}
+	pl.playing = true
+	pl.last = time.Time{}
+}
+
+// seek jumps to t seconds, silencing what was sounding.
+func (pl *takePlayer) seek(t float64) {
+	releaseReplay()
+	pl.pos = max(0, min(pl.take.Length, t))
+	events := pl.take.Events
+	pl.on = sort.Search(len(events), func(i int) bool { return events[i].T >= pl.pos })
+	pl.off = pl.on
+	clear(pl.struck)
+	if pl.guide != nil {
+		pl.guide.reset(pl.on)
+	}
+	pl.last = time.Time{}
+}
+
+// releaseReplay lets go of every note being played back.
+func releaseReplay() {
+	voiceLock.Lock()
+	defer voiceLock.Unlock()
+	for k, v := range voices {
+		if strings.HasPrefix(k, replayPrefix) {
+			v.locked = false
+			v.release()
+		}
+	}
+}
+
+type takeFile struct {
+	name string
+	path string
+	take take
+}
+
+type replayPanel struct {
+	open   bool
+	takes  []takeFile // newest first
+	cursor int
+	player *takePlayer
+	guide  int // the play-along difficulty + 1, 0 when it's off; see guide.go
+
+	exporting bool // choosing a format for the selected take, see export.go
+	format    int
+}
+
+// listTakes reads every take in dir, along with any MIDI files and
+// scores dropped in beside them. Files that can't be read are left out
+// rather than keeping the panel from opening.
+func listTakes(dir string) ([]takeFile, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var takes []takeFile
+	for _, e := range entries {
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		if e.IsDir() || !slices.Contains([]string{".json", ".mid", ".midi", ".musicxml", ".mxl", ".xml"}, ext) {
+			continue
+		}
+		tk, err := loadPerformance(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		name := e.Name()
+		if ext == ".json" {
+			name = strings.TrimSuffix(name, ext)
+		}
+		if tk.Recorded.IsZero() { // imported: sort it by when it arrived
+			if info, err := e.Info(); err == nil {
+				tk.Recorded = info.ModTime()
+			}
+		}
+		takes = append(takes, takeFile{name, filepath.Join(dir, e.Name()), tk})
+	}
+	sort.Slice(takes, func(i, j int) bool { return takes[i].take.Recorded.After(takes[j].take.Recorded) })
+	return takes, nil
+}
+
+func (m model) openReplay() model {
+	var takes []takeFile
+	var err error
+	if dir := takesDir(); dir != "" {
+		takes, err = listTakes(dir)
+	}
+	if err != nil {
+		m.notification = fmt.Sprintf("Replay: %v", err)
+		m.notifyClearTime = time.Now().Add(4 * time.Second)
+		return m
+	}
+	m.replay.open = true
+	m.replay.takes = takes
+	m.replay.cursor = 0
+	return m
+}
+
+func (m model) closeReplay() model {
+	if m.replay.player != nil {
+		m.replay.player.pause()
+	}
+	m.replay = replayPanel{}
+	return m
+}
+
+// handleReplayKey takes the panel's keys and lets the rest through, so
+// the player can play along.
+func (m model) handleReplayKey(msg tea.KeyMsg) (model, tea.Cmd, bool) {
+	r := &m.replay
+	pl := r.player
+	if r.exporting {
+		m, cmd := m.handleExportKey(msg)
+		return m, cmd, true
+	}
+	switch msg.Type {
+	case tea.KeyCtrlF, tea.KeyEscape:
+		return m.closeReplay(), nil, true
+	case tea.KeyUp:
+		if len(r.takes) > 0 {
+			r.cursor = (r.cursor - 1 + len(r.takes)) % len(r.takes)
+		}
+		return m, nil, true
+	case tea.KeyDown:
+		if len(r.takes) > 0 {
+			r.cursor = (r.cursor + 1) % len(r.takes)
+		}
+		return m, nil, true
+	case tea.KeyEnter:
+		if len(r.takes) > 0 {
+			if pl != nil {
+				pl.pause()
+			}
+			tf := r.takes[r.cursor]
+			r.player = newTakePlayer(tf.name, tf.take)
+			if r.guide > 0 {
+				r.player.guide = m.newGuide(r.player.take, difficulties[r.guide-1])
+			}
+		}
+		return m, nil, true
+	case tea.KeyTab:
+		r.guide = (r.guide + 1) % (len(difficulties) + 1)
+		if pl != nil {
+			pl.guide = nil
+			if r.guide > 0 {
+				pl.guide = m.newGuide(pl.take, difficulties[r.guide-1])
+				pl.guide.reset(pl.on)
+			}
+		}
+		return m, nil, true
+	case tea.KeyCtrlX:
+		if len(r.takes) > 0 {
+			r.exporting = true
+		}
+		return m, nil, true
+	case tea.KeyCtrlR: // overdub the selected take
+		if len(r.takes) == 0 || m.recording {
+			return m, nil, false
+		}
+		tf := r.takes[r.cursor]
+		m = m.closeReplay().openTracksOn(tf)
+		m.tracks.overdub = true
+		return m.startCountIn(true), nil, true
+	case tea.KeyCtrlG, tea.KeyCtrlT:
+		if len(r.takes) > 0 {
+			tf := r.takes[r.cursor]
+			m = m.closeReplay()
+			if msg.Type == tea.KeyCtrlG {
+				m.roll = newPianoRoll(tf.name, tf.path, tf.take)
+			} else {
+				m = m.openTracksOn(tf)
+			}
+		}
+		return m, nil, true
+	case tea.KeySpace:
+		if pl != nil {
+			if pl.playing {
+				pl.pause()
+			} else {
+				pl.resume()
+			}
+		}
+		return m, nil, true
+	case tea.KeyLeft, tea.KeyRight:
+		if pl == nil {
+			return m, nil, false
+		}
+		d := replaySeek
+		if msg.Type == tea.KeyLeft {
+			d = -d
+		}
+		pl.seek(pl.pos + d)
+		return m, nil, true
+	}
+	return m, nil, false
+}
+
+// clock formats seconds as m:ss.
+func clock(sec float64) string {
+	s := int(sec)
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+func (m model) replayView() string {
+	r := m.replay
+	title := fmt.Sprintf("--- REPLAY (%d) ---", len(r.takes))
+	if r.guide > 0 {
+		title = fmt.Sprintf("--- REPLAY (%d) • PLAY ALONG: %s ---", len(r.takes), strings.ToUpper(difficulties[r.guide-1].Name))
+	}
+	lines := []string{presetTitleStyle.Render(title)}
+	if len(r.takes) == 0 && r.player == nil {
+		lines = append(lines, presetTextStyle.Render("  No takes yet. CTRL+R records one."))
+	}
+
+	top := max(0, min(r.cursor-replayRows/2, len(r.takes)-replayRows))
+	for i := top; i < min(top+replayRows, len(r.takes)); i++ {
+		tf := r.takes[i]
+		cursor := "  "
+		nameStyle := presetTextStyle
+		if i == r.cursor {
+			cursor = "▶ "
+			nameStyle = instStyle.UnsetMarginBottom()
+		}
+		lines = append(lines, fmt.Sprintf("%s%s %s", cursor,
+			nameStyle.Render(fmt.Sprintf("%-18s", tf.name)),
+			helpStyle.UnsetMarginTop().Render(fmt.Sprintf("%5s  %3d notes  %s",
+				clock(tf.take.Length), tf.take.notes(), tf.take.Instrument))))
+	}
+
+	if r.exporting {
+		lines = append(lines, m.exportView()...)
+		return visStyle.Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+	}
+
+	help := "↑/↓: Select  •  ENTER: Play  •  TAB: Play Along Off / Easy / Medium / Hard  •  CTRL+X: Export  •  CTRL+R: Overdub  •  CTRL+G: Piano Roll  •  CTRL+T: Tracks  •  ESC/CTRL+F: Close"
+	if pl := r.player; pl != nil {
+		const width = 30
+		filled := 0
+		if pl.take.Length > 0 {
+			filled = int(pl.pos / pl.take.Length * width)
+		}
+		state := "❚❚"
+		if pl.playing {
+			state = "▶"
+		}
+		lines = append(lines, "", instStyle.UnsetMarginBottom().Render(fmt.Sprintf("%s %s  %s%s  %s / %s",
+			state, pl.name, strings.Repeat("█", filled), strings.Repeat("░", width-filled),
+			clock(pl.pos), clock(pl.take.Length))))
+		if g := pl.guide; g != nil && g.result != nil {
+			lines = append(lines, g.result.view()...)
+		} else if g != nil && len(g.waiting) > 0 {
+			lines = append(lines, notifyStyle.UnsetMarginBottom().UnsetPadding().Render(
+				fmt.Sprintf("Play %s  •  %d points", g.label(), g.score())))
+		}
+		help = "↑/↓: Select  •  ENTER: Play  •  TAB: Play Along Off / Easy / Medium / Hard  •  SPACE: Pause  •  ←/→: Seek  •  CTRL+X: Export  •  CTRL+R: Overdub  •  CTRL+G: Piano Roll  •  CTRL+T: Tracks  •  ESC/CTRL+F: Close"
+	}
+	lines = append(lines, helpStyle.Render(help))
+
+	return visStyle.Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gopxl/beep/v2"
+)
+
+// --- OFFLINE RENDERING ---
+//
+// Turns a take into audio without the audio device: the notes' voices are
+// mixed on a private mixer and run through a copy of the master bus and
+// the output stage's volume and limiter, as fast as the CPU allows. The
+// clock is the count of samples rendered, not the speaker, so what's
+// playing live is left alone, and `piango render` never opens a device
+// at all.
+
+const (
+	renderBlock   = 512
+	renderMaxTail = 10 // seconds allowed for the last notes to ring out
+)
+
+// renderTake plays tk on inst through bus at volume, or each track on its
+// own instrument. Every event lands on its exact sample.
+func renderTake(tk take, inst *Instrument, bus *masterBus, volume float64) [][2]float64 {
+	var (
+		mix  beep.Mixer
+		out  [][2]float64
+		held = make(map[string]*SynthStreamer)
+	)
+	stream := func(n int) {
+		for n > 0 {
+			block := make([][2]float64, min(n, renderBlock))
+			mix.Stream(block)
+			for _, fx := range bus.effects() {
+				fx.Process(block)
+			}
+			for i := range block {
+				block[i][0] = softLimit(block[i][0] * volume)
+				block[i][1] = softLimit(block[i][1] * volume)
+			}
+			out = append(out, block...)
+			n -= len(block)
+		}
+	}
+	at := func(t float64) int { return int(t * float64(sampleRate)) }
+
+	tk = tk.performed()
+	for _, ev := range tk.Events {
+		stream(at(ev.T) - len(out))
+		key := ev.voiceKey()
+		switch ev.Type {
+		case "on":
+			if !tk.heard(ev.Track) {
+				continue
+			}
+			if v, ok := held[key]; ok {
+				v.Choke()
+			}
+			inst := tk.trackInstrument(ev.Track, inst)
+			s := newVoice(inst, ev.Freq, ev.Velocity, false)
+			if inst.Kit {
+				s.setDrum(ev.Key)
+			}
+			held[key] = s
+			mix.Add(s)
+		case "off":
+			if v, ok := held[key]; ok {
+				v.Stop()
+				delete(held, key)
+			}
+		}
+	}
+	stream(at(tk.Length) - len(out))
+
+	for _, v := range held {
+		v.Stop()
+	}
+	limit := len(out) + at(renderMaxTail)
+	for mix.Len() > 0 && len(out) < limit {
+		stream(renderBlock)
+	}
+	return out
+}
+
+// liveRenderSetup copies what a render needs from the live engine: the
+// current instrument, the master bus settings and the volume.
+func liveRenderSetup() (*Instrument, *masterBus, float64) {
+	output.Lock()
+	defer output.Unlock()
+	inst := *instruments[currentInstID]
+	bus := *master
+	return &inst, &bus, mainOut.volume
+}
+
+// loadPerformance reads anything that plays like a take: a take itself,
+// a MIDI file or a MusicXML score.
+func loadPerformance(path string) (take, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mid", ".midi", ".smf":
+		return loadMIDIFile(path)
+	case ".musicxml", ".mxl", ".xml":
+		return loadScore(path)
+	}
+	return loadTake(path)
+}
+
+// renderCommand is `piango render input -o output`: a MIDI file or a
+// take rendered straight to an audio file, the format picked by the
+// output's extension. The audio device is never opened.
+func renderCommand(args []string) error {
+	fs := flag.NewFlagSet("render", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath(), "path to config.json")
+	outPath := fs.String("o", "", "audio file to write (.wav, .flac, .ogg or .mp3)")
+	instName := fs.String("instrument", "", "instrument to play it on (default: the take's own, or the first)")
+	rate := fs.Int("rate", 0, "sample rate in Hz (default: the config's)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: piango render [flags] input.mid|input.musicxml|take.json")
+		fs.PrintDefaults()
+	}
+
+	// Flags may come before or after the input
+	var inputs []string
+	for {
+		fs.Parse(args)
+		if fs.NArg() == 0 {
+			break
+		}
+		inputs = append(inputs, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(inputs) != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	input := inputs[0]
+
+	tk, err := loadPerformance(input)
+	if err != nil {
+		return err
+	}
+
+	out := *outPath
+	if out == "" {
+		out = strings.TrimSuffix(input, filepath.Ext(input)) + ".wav"
+	}
+	var format *exportFormat
+	for i, f := range exportFormats {
+		if strings.EqualFold(filepath.Ext(out), f.Ext) {
+			format = &exportFormats[i]
+		}
+	}
+	if format == nil {
+		return fmt.Errorf("can't tell the format of %s; use .wav, .flac, .ogg or .mp3", out)
+	}
+	if format.ready != nil {
+		if err := format.ready(); err != nil {
+			return err
+		}
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	if *rate != 0 {
+		cfg.SampleRate = *rate
+	}
+	if err := setupEngine(cfg); err != nil {
+		return err
+	}
+	initNotes()
+
+	name := *instName
+	if name == "" {
+		name = tk.Instrument
+	}
+	if name != "" {
+		if findInstrument(name) == nil {
+			return fmt.Errorf("no instrument %q", name)
+		}
+		currentInstID = indexOfInstrument(name)
+	}
+
+	inst, bus, volume := liveRenderSetup()
+	samples := renderTake(tk, inst, bus, volume)
+	tags := exportTags{
+		Title:      strings.TrimSuffix(filepath.Base(input), filepath.Ext(input)),
+		Instrument: inst.Name,
+		BPM:        tk.BPM,
+	}
+	if err := format.write(out, samples, tags); err != nil {
+		return err
+	}
+	fmt.Printf("Rendered %s on %s to %s\n", clock(float64(len(samples))/float64(sampleRate)), inst.Name, out)
+	return nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// --- HTTP API ---
+//
+// With "http_api": "127.0.0.1:7703" in the config, plain HTTP requests can
+// drive piango from scripts and home-automation rules. POST bodies are the
+// same JSON as WebSocket commands without the "cmd" field, which comes
+// from the path instead:
+//
+//	POST /api/note        {"note": 60, "velocity": 0.8, "duration_ms": 500}
+//	POST /api/instrument  {"name": "Wind"}
+//	POST /api/param       {"name": "cutoff", "value": 800}
+//	POST /api/action      {"name": "panic"}
+//	GET  /api/state
+//
+// A note with duration_ms is released by itself, otherwise it's held until
+// a velocity 0 request for the same note. Requests are carried out on the
+// TUI goroutine and answer 204, or 400 with {"error": ...} when they fail.
+//
+// POSTs must say Content-Type: application/json (415 otherwise), which a
+// web page can't send to another site without the browser asking first,
+// and requests from pages are checked against "origins" as for the
+// WebSocket.
+
+const apiTimeout = 2 * time.Second // how long a request waits for the UI
+
+// apiCall is a command waiting for the UI to run it and report back.
+type apiCall struct {
+	msg   oscMessage
+	reply chan error
+}
+
+// apiStateQuery asks the UI for a snapshot of its state.
+type apiStateQuery chan apiState
+
+type apiState struct {
+	Instrument  string    `json:"instrument"`
+	Index       int       `json:"index"`
+	Instruments []string  `json:"instruments"`
+	Octave      int       `json:"octave"`
+	Volume      float64   `json:"volume"`
+	PlayMode    string    `json:"play_mode"`
+	Drums       bool      `json:"drums"`
+	Recording   bool      `json:"recording"`
+	Held        []apiNote `json:"held"`
+}
+
+type apiNote struct {
+	Key      string  `json:"key"`
+	Freq     float64 `json:"freq"`
+	Velocity float64 `json:"velocity"`
+}
+
+// apiState is read on the TUI goroutine, so the model is consistent.
+func (m model) apiState() apiState {
+	voiceLock.Lock()
+	st := apiState{
+		Instrument: instruments[currentInstID].Name,
+		Index:      currentInstID,
+		Octave:     m.octaveShift,
+		Volume:     m.volume,
+		PlayMode:   playModeNames[m.playMode],
+		Drums:      m.drumsPlaying,
+		Recording:  m.recording,
+		Held:       []apiNote{},
+	}
+	for _, inst := range instruments {
+		st.Instruments = append(st.Instruments, inst.Name)
+	}
+	for k, v := range voices {
+		if !v.finished() && !v.released {
+			st.Held = append(st.Held, apiNote{k, v.freq, v.streamer.velocity})
+		}
+	}
+	voiceLock.Unlock()
+
+	sort.Slice(st.Held, func(i, j int) bool { return st.Held[i].Freq < st.Held[j].Freq })
+	return st
+}
+
+// startHTTPAPI listens on addr and sends requests to p.
+func startHTTPAPI(p *tea.Program, addr string, origins []string) error {
+	if addr == "" {
+		return nil
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/state", func(w http.ResponseWriter, req *http.Request) {
+		if !originAllowed(req, origins) {
+			apiError(w, http.StatusForbidden, errors.New("origin not allowed"))
+			return
+		}
+		q := make(apiStateQuery, 1)
+		p.Send(q)
+		select {
+		case st := <-q:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(st)
+		case <-time.After(apiTimeout):
+			apiError(w, http.StatusServiceUnavailable, errors.New("no answer from the UI"))
+		}
+	})
+	mux.HandleFunc("POST /api/{cmd}", func(w http.ResponseWriter, req *http.Request) {
+		var body struct {
+			wsCommand
+			DurationMs float64 `json:"duration_ms"`
+		}
+		if !originAllowed(req, origins) {
+			apiError(w, http.StatusForbidden, errors.New("origin not allowed"))
+			return
+		}
+		if mt, _, err := mime.ParseMediaType(req.Header.Get("Content-Type")); err != nil || mt != "application/json" {
+			apiError(w, http.StatusUnsupportedMediaType, errors.New("expected Content-Type: application/json"))
+			return
+		}
+		req.Body = http.MaxBytesReader(w, req.Body, wsMaxMessage)
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+		body.Cmd = req.PathValue("cmd")
+		msg, err := body.message()
+		if err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		call := apiCall{msg: msg, reply: make(chan error, 1)}
+		p.Send(call)
+		select {
+		case err := <-call.reply:
+			if err != nil {
+				apiError(w, http.StatusBadRequest, err)
+				return
+			}
+		case <-time.After(apiTimeout):
+			apiError(w, http.StatusServiceUnavailable, errors.New("no answer from the UI"))
+			return
+		}
+
+		if body.Cmd == "note" && body.DurationMs > 0 {
+			off := oscMessage{Address: msg.Address, Args: []any{msg.Args[0], 0.0}}
+			time.AfterFunc(time.Duration(body.DurationMs*float64(time.Millisecond)), func() { p.Send(off) })
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	go http.Serve(ln, mux)
+	return nil
+}
+
+func apiError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
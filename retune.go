@@ -0,0 +1,54 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// --- HELD NOTES ON A SHIFT ---
+//
+// Normally an octave shift or a transposition only moves the notes struck
+// after it. With follow_shift on (or Held set to Glide in the settings)
+// the notes still sounding from the keyboard glide to their new pitches
+// as well, so a chord can be carried up an octave without restriking it.
+// Drums stay put, as does the theremin, which keeps to its own range.
+
+const shiftGlide = 60 * time.Millisecond
+
+// followShift is whether held notes move with the keyboard.
+var followShift bool
+
+// shiftSemis is how far the keyboard is moved from where it's written, in
+// semitones.
+func (m model) shiftSemis() int {
+	semis := 12 * m.octaveShift
+	if m.transposing {
+		semis += currentKey.transposition()
+	}
+	return semis
+}
+
+// followHeld glides the keyboard's sounding notes by however far the
+// keyboard has moved since it was from semitones.
+func (m model) followHeld(from int) model {
+	to := m.shiftSemis()
+	if !followShift || to == from {
+		return m
+	}
+	ratio := math.Exp2(float64(to-from) / 12)
+	glide := glideCoef(shiftGlide)
+
+	voiceLock.Lock()
+	defer voiceLock.Unlock()
+	moved := make(map[*ActiveVoice]bool) // a mono voice is under every key it took over
+	for key := range noteMap {
+		v, ok := voices[m.voicePrefix+key]
+		if !ok || moved[v] || v.inst.Kit || v.finished() {
+			continue
+		}
+		moved[v] = true
+		v.freq *= ratio
+		v.retune(v.freq, glide)
+	}
+	return m
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// --- PER-ROW SETUP ---
+//
+// "rows" in the config gives each key row an octave offset of its own and,
+// optionally, its own instrument, so the bottom row can play a bass an
+// octave down while the two above stay on the piano. Rows are counted from
+// the top, as in row_velocity: High, Mid and Low on the solfège layout,
+// Black and White on the piano one. A row without an instrument plays
+// whichever is picked, and follows TAB; one with an instrument keeps it.
+
+// rowConfig is one row's entry in the config.
+type rowConfig struct {
+	Octave     int    `json:"octave"`     // on top of the octave shift
+	Instrument string `json:"instrument"` // by name; empty for the current one
+}
+
+const maxRowOctave = 3
+
+var (
+	rowOctaves [3]int
+	rowInsts   = [3]int{-1, -1, -1} // indexes into instruments, -1 for the current one
+)
+
+// applyRowConfig checks the rows against the instrument list, so it comes
+// after the user presets are loaded.
+func applyRowConfig(rows [3]rowConfig) error {
+	for i, r := range rows {
+		if r.Octave < -maxRowOctave || r.Octave > maxRowOctave {
+			return fmt.Errorf("rows: octave %d is out of range (-%d to %d)", r.Octave, maxRowOctave, maxRowOctave)
+		}
+		rowOctaves[i], rowInsts[i] = r.Octave, -1
+		if r.Instrument == "" {
+			continue
+		}
+		rowInsts[i] = slices.IndexFunc(instruments, func(inst *Instrument) bool { return inst.Name == r.Instrument })
+		if rowInsts[i] < 0 {
+			return fmt.Errorf("rows: unknown instrument %q", r.Instrument)
+		}
+	}
+	return nil
+}
+
+// rowInstrument is what keys on the row play.
+func rowInstrument(row int) *Instrument {
+	if id := rowInsts[row]; id >= 0 && id < len(instruments) {
+		return instruments[id]
+	}
+	return instruments[currentInstID]
+}
+
+// keyInstrument is rowInstrument for a voice key, the current instrument
+// for one that isn't on the keyboard.
+func keyInstrument(key string) *Instrument {
+	if n, ok := noteMap[noteKey(key)]; ok {
+		return rowInstrument(n.Row)
+	}
+	return instruments[currentInstID]
+}
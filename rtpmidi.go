@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// --- RTP-MIDI ---
+//
+// AppleMIDI network sessions, so an iPad or another computer on the LAN
+// can play piango and hear it play back without a cable. With
+// "rtp_midi": {"listen": ":5004"} piango answers invitations on that
+// control port and the data port after it, and advertises itself over
+// Bonjour (see mdns.go) so it shows up in Audio MIDI Setup and iOS apps.
+// "connect" lists sessions for piango to invite itself. Incoming notes
+// and knobs are handled like a MIDI input; what's played goes out to
+// every participant like a MIDI output.
+
+const (
+	rtpMIDIPayload = 0x61
+	rtpMIDIVersion = 2
+	rtpSyncEvery   = 10 * time.Second // clock sync interval for sessions we started
+)
+
+type rtpMIDIConfig struct {
+	Listen  string   `json:"listen"`
+	Name    string   `json:"name"`    // session name, "piango" when unset
+	Connect []string `json:"connect"` // host:port control ports to invite
+	Channel int      `json:"channel"` // for outgoing notes, 1-16
+}
+
+// rtpPeer is a participant, keyed by its SSRC.
+type rtpPeer struct {
+	name      string
+	ctrl      *net.UDPAddr
+	data      *net.UDPAddr
+	initiated bool // we invited them, so we drive the clock sync
+}
+
+type rtpMIDISession struct {
+	name    string
+	ssrc    uint32
+	start   time.Time
+	control *net.UDPConn
+	data    *net.UDPConn
+
+	mu      sync.Mutex
+	peers   map[uint32]*rtpPeer
+	pending map[uint32]*rtpPeer // invited, waiting for the data port's OK
+	seq     uint16
+}
+
+// listenRTPMIDI opens the control and data ports; nil when not configured.
+func listenRTPMIDI(cfg rtpMIDIConfig) (*rtpMIDISession, error) {
+	if cfg.Listen == "" {
+		return nil, nil
+	}
+	addr, err := net.ResolveUDPAddr("udp", cfg.Listen)
+	if err != nil {
+		return nil, err
+	}
+	control, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	addr.Port = control.LocalAddr().(*net.UDPAddr).Port + 1
+	data, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		control.Close()
+		return nil, err
+	}
+
+	s := &rtpMIDISession{
+		name:    cfg.Name,
+		ssrc:    rand.Uint32(),
+		start:   time.Now(),
+		control: control,
+		data:    data,
+		peers:   make(map[uint32]*rtpPeer),
+		pending: make(map[uint32]*rtpPeer),
+	}
+	if s.name == "" {
+		s.name = "piango"
+	}
+	return s, nil
+}
+
+// serve answers both ports, hands incoming MIDI to p and invites the
+// configured sessions.
+func (s *rtpMIDISession) serve(p *tea.Program, connect []string, triggers *triggerRouter) {
+	dispatch := midiDispatch(p, triggers)
+	go s.readLoop(s.control, nil)
+	go s.readLoop(s.data, dispatch)
+	for _, target := range connect {
+		if addr, err := net.ResolveUDPAddr("udp", target); err == nil {
+			s.invite(addr)
+		}
+	}
+	go s.syncLoop()
+}
+
+// now is the session clock in the 100µs units AppleMIDI uses.
+func (s *rtpMIDISession) now() uint64 {
+	return uint64(time.Since(s.start) / (100 * time.Microsecond))
+}
+
+func (s *rtpMIDISession) readLoop(conn *net.UDPConn, dispatch func([]byte)) {
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		pkt := buf[:n]
+		if n >= 4 && pkt[0] == 0xFF && pkt[1] == 0xFF {
+			s.command(conn, from, pkt)
+		} else if dispatch != nil {
+			parseRTPMIDI(pkt, dispatch)
+		}
+	}
+}
+
+// command handles the AppleMIDI session protocol.
+func (s *rtpMIDISession) command(conn *net.UDPConn, from *net.UDPAddr, pkt []byte) {
+	isData := conn == s.data
+	switch string(pkt[2:4]) {
+	case "IN": // invitation, on the control port first and then the data port
+		if len(pkt) < 16 {
+			return
+		}
+		token, ssrc := binary.BigEndian.Uint32(pkt[8:]), binary.BigEndian.Uint32(pkt[12:])
+		name := string(bytes.TrimRight(pkt[16:], "\x00"))
+		s.mu.Lock()
+		peer := s.peers[ssrc]
+		if peer == nil {
+			peer = &rtpPeer{name: name}
+			s.peers[ssrc] = peer
+		}
+		if isData {
+			peer.data = from
+		} else {
+			peer.ctrl = from
+		}
+		s.mu.Unlock()
+		conn.WriteToUDP(s.sessionPacket("OK", token), from)
+
+	case "OK": // our invitation accepted
+		if len(pkt) < 16 {
+			return
+		}
+		token, ssrc := binary.BigEndian.Uint32(pkt[8:]), binary.BigEndian.Uint32(pkt[12:])
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		peer := s.pending[token]
+		if peer == nil {
+			return
+		}
+		if !isData {
+			// Now the data port, one above the peer's control port
+			peer.ctrl = from
+			peer.data = &net.UDPAddr{IP: from.IP, Port: from.Port + 1, Zone: from.Zone}
+			peer.name = string(bytes.TrimRight(pkt[16:], "\x00"))
+			s.data.WriteToUDP(s.sessionPacket("IN", token), peer.data)
+			return
+		}
+		delete(s.pending, token)
+		s.peers[ssrc] = peer
+		s.data.WriteToUDP(s.syncPacket(0, s.now(), 0, 0), peer.data)
+
+	case "BY":
+		if len(pkt) < 16 {
+			return
+		}
+		s.mu.Lock()
+		delete(s.peers, binary.BigEndian.Uint32(pkt[12:]))
+		s.mu.Unlock()
+
+	case "CK": // clock sync: answer the step that's ours
+		if len(pkt) < 36 {
+			return
+		}
+		count := pkt[8]
+		ts := [3]uint64{
+			binary.BigEndian.Uint64(pkt[12:]),
+			binary.BigEndian.Uint64(pkt[20:]),
+			binary.BigEndian.Uint64(pkt[28:]),
+		}
+		switch count {
+		case 0:
+			conn.WriteToUDP(s.syncPacket(1, ts[0], s.now(), 0), from)
+		case 1:
+			conn.WriteToUDP(s.syncPacket(2, ts[0], ts[1], s.now()), from)
+		}
+	}
+}
+
+// invite starts a session with the control port at addr.
+func (s *rtpMIDISession) invite(addr *net.UDPAddr) {
+	token := rand.Uint32()
+	s.mu.Lock()
+	s.pending[token] = &rtpPeer{initiated: true}
+	s.mu.Unlock()
+	s.control.WriteToUDP(s.sessionPacket("IN", token), addr)
+}
+
+// syncLoop keeps the clocks of the sessions we started in step; the
+// other side drops a session that stops syncing.
+func (s *rtpMIDISession) syncLoop() {
+	for range time.Tick(rtpSyncEvery) {
+		s.mu.Lock()
+		for _, peer := range s.peers {
+			if peer.initiated && peer.data != nil {
+				s.data.WriteToUDP(s.syncPacket(0, s.now(), 0, 0), peer.data)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *rtpMIDISession) sessionPacket(cmd string, token uint32) []byte {
+	b := append([]byte{0xFF, 0xFF}, cmd...)
+	b = binary.BigEndian.AppendUint32(b, rtpMIDIVersion)
+	b = binary.BigEndian.AppendUint32(b, token)
+	b = binary.BigEndian.AppendUint32(b, s.ssrc)
+	if cmd != "BY" {
+		b = append(append(b, s.name...), 0)
+	}
+	return b
+}
+
+func (s *rtpMIDISession) syncPacket(count byte, t1, t2, t3 uint64) []byte {
+	b := []byte{0xFF, 0xFF, 'C', 'K'}
+	b = binary.BigEndian.AppendUint32(b, s.ssrc)
+	b = append(b, count, 0, 0, 0)
+	b = binary.BigEndian.AppendUint64(b, t1)
+	b = binary.BigEndian.AppendUint64(b, t2)
+	return binary.BigEndian.AppendUint64(b, t3)
+}
+
+// send makes it a midiPort: each message goes to every participant as
+// its own RTP packet, with no recovery journal.
+func (s *rtpMIDISession) send(msg []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.peers) == 0 {
+		return nil
+	}
+
+	s.seq++
+	b := []byte{0x80, rtpMIDIPayload}
+	b = binary.BigEndian.AppendUint16(b, s.seq)
+	b = binary.BigEndian.AppendUint32(b, uint32(s.now()))
+	b = binary.BigEndian.AppendUint32(b, s.ssrc)
+	if len(msg) < 16 {
+		b = append(b, byte(len(msg)))
+	} else {
+		b = append(b, 0x80|byte(len(msg)>>8&0x0F), byte(len(msg)))
+	}
+	b = append(b, msg...)
+
+	var err error
+	for _, peer := range s.peers {
+		if peer.data == nil {
+			continue
+		}
+		if _, werr := s.data.WriteToUDP(b, peer.data); werr != nil {
+			err = werr
+		}
+	}
+	return err
+}
+
+// Close says goodbye to everyone.
+func (s *rtpMIDISession) Close() error {
+	s.mu.Lock()
+	for _, peer := range s.peers {
+		if peer.ctrl != nil {
+			s.control.WriteToUDP(s.sessionPacket("BY", 0), peer.ctrl)
+		}
+	}
+	s.mu.Unlock()
+	s.data.Close()
+	return s.control.Close()
+}
+
+// port is the session's control port, for the Bonjour advertisement.
+func (s *rtpMIDISession) port() int {
+	return s.control.LocalAddr().(*net.UDPAddr).Port
+}
+
+// parseRTPMIDI hands each MIDI message of an RTP-MIDI packet to fn. The
+// recovery journal after the command list is ignored.
+func parseRTPMIDI(pkt []byte, fn func([]byte)) error {
+	if len(pkt) < 13 || pkt[0]&0xC0 != 0x80 || pkt[1]&0x7F != rtpMIDIPayload {
+		return errors.New("rtp-midi: not an RTP-MIDI packet")
+	}
+	pkt = pkt[12:]
+	flags := pkt[0]
+	length, off := int(flags&0x0F), 1
+	if flags&0x80 != 0 {
+		if len(pkt) < 2 {
+			return errors.New("rtp-midi: short header")
+		}
+		length, off = int(flags&0x0F)<<8|int(pkt[1]), 2
+	}
+	if off+length > len(pkt) {
+		return errors.New("rtp-midi: command list overruns the packet")
+	}
+	list := pkt[off : off+length]
+
+	var status byte
+	for i, first := 0, true; i < len(list); first = false {
+		// Every command but the first has a delta time, the first only
+		// when the Z flag says so
+		if !first || flags&0x20 != 0 {
+			for j := 0; j < 4 && i < len(list); j++ {
+				b := list[i]
+				i++
+				if b&0x80 == 0 {
+					break
+				}
+			}
+		}
+		if i >= len(list) {
+			break
+		}
+
+		if list[i]&0x80 != 0 {
+			b := list[i]
+			i++
+			switch {
+			case b == 0xF0: // SysEx: skip to its end
+				for i < len(list) && list[i] != 0xF7 && list[i] != 0xF0 && list[i] != 0xF4 {
+					i++
+				}
+				i++
+				continue
+			case b >= 0xF8:
+				fn([]byte{b})
+				continue
+			case b >= 0xF0:
+				status = 0
+				n := midiMessageLen(b) - 1
+				i += n
+				continue
+			}
+			status = b
+		}
+		if status == 0 {
+			return errors.New("rtp-midi: data without a status byte")
+		}
+		n := midiMessageLen(status) - 1
+		if i+n > len(list) {
+			return errors.New("rtp-midi: truncated command")
+		}
+		fn(append([]byte{status}, list[i:i+n]...))
+		i += n
+	}
+	return nil
+}
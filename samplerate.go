@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/gopxl/beep/v2"
+)
+
+// --- SAMPLE RATE ---
+//
+// The engine runs at 44.1 kHz unless "sample_rate" in the config, or
+// -rate, picks another. Many systems mix at 48 kHz and would otherwise
+// resample everything piango plays. Times, pitches and filter settings
+// are all worked out from sampleRate as they're used. A few sounds, the
+// ambience layers and coloured noise among them, were tuned as constants
+// applied once a sample at 44.1 kHz; they go through the helpers here so
+// they keep their character at any rate. JACK runs at the server's rate
+// whatever is asked for.
+
+// tunedRate is the rate the per-sample constants were tuned at.
+const tunedRate = 44100
+
+var sampleRates = []int{44100, 48000, 88200, 96000}
+
+// setSampleRate switches the engine to hz, before the backend is opened.
+// 0 keeps the default.
+func setSampleRate(hz int) error {
+	if hz == 0 {
+		return nil
+	}
+	for _, r := range sampleRates {
+		if r == hz {
+			sampleRate = beep.SampleRate(hz)
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported sample rate %d (use 44100, 48000, 88200 or 96000)", hz)
+}
+
+// decayAt converts a factor applied every sample at tunedRate to the one
+// that decays as fast at sampleRate.
+func decayAt(d float64) float64 {
+	return math.Pow(d, tunedRate/float64(sampleRate))
+}
+
+// smoothAt does the same for a one-pole smoothing coefficient, as in
+// y += (x - y) * c.
+func smoothAt(c float64) float64 {
+	return 1 - decayAt(1-c)
+}
+
+// chanceAt converts a per-sample probability, so events come as often.
+func chanceAt(p float64) float64 {
+	return p * tunedRate / float64(sampleRate)
+}
@@ -0,0 +1,66 @@
+package main
+
+import "math"
+
+// --- DENORMALS AND NaN ---
+//
+// A release tail or a feedback loop dying away drifts down into denormal
+// floats, which many CPUs work through far more slowly, so the recursive
+// states in the filters and effects flush anything that small to zero.
+// A NaN or Inf is worse: once it reaches a filter's state it stays there
+// and silences everything after it. A voice that makes one is dropped on
+// the spot, and the output stage zeroes any that still get through and
+// clears the master effects, so a single bad sample costs a click rather
+// than the rest of the session.
+
+// denormalFloor is well above the denormal range and far below anything
+// audible.
+const denormalFloor = 1e-30
+
+// flush returns x, or 0 when it's small enough to be heading for a
+// denormal.
+func flush(x float64) float64 {
+	if math.Abs(x) < denormalFloor {
+		return 0
+	}
+	return x
+}
+
+// finite reports whether every sample in the block is a number.
+func finite(samples [][2]float64) bool {
+	for i := range samples {
+		// x-x is 0 for any finite x and NaN for NaN and ±Inf.
+		if samples[i][0]-samples[i][0] != 0 || samples[i][1]-samples[i][1] != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// sanitize zeroes the samples that aren't numbers and reports how many
+// there were.
+func sanitize(samples [][2]float64) int {
+	bad := 0
+	for i := range samples {
+		for ch := range samples[i] {
+			if x := samples[i][ch]; x-x != 0 {
+				samples[i][ch] = 0
+				bad++
+			}
+		}
+	}
+	return bad
+}
+
+// clear drops the effects' running state, along with anything stuck in it.
+func (b *masterBus) clear() {
+	b.dc = dcBlocker{}
+	for i := range b.eq.bands {
+		b.eq.bands[i].z = [2][2]float64{}
+	}
+	b.compressor.reduction = 0
+	b.phaser.state, b.phaser.last = [2][phaserStages]float64{}, [2]float64{}
+	for ch := range b.flanger.delay {
+		clear(b.flanger.delay[ch])
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// --- SCALES ---
+//
+// CTRL+U opens a reference for the key being played in: a root and a
+// scale or mode, picked with the arrows. Its notes light up on the
+// keyboard rows, the tonic brighter, and the panel spells them out with
+// their degrees and the steps between them. The key is also what the
+// theremin snaps to: its scale snaps are counted from the key's root,
+// and the Key snap keeps to the key's own scale.
+
+type scale struct {
+	name  string
+	steps []int // semitones above the root
+}
+
+var scales = []scale{
+	{"Major", []int{0, 2, 4, 5, 7, 9, 11}},
+	{"Dorian", []int{0, 2, 3, 5, 7, 9, 10}},
+	{"Phrygian", []int{0, 1, 3, 5, 7, 8, 10}},
+	{"Lydian", []int{0, 2, 4, 6, 7, 9, 11}},
+	{"Mixolydian", []int{0, 2, 4, 5, 7, 9, 10}},
+	{"Minor", []int{0, 2, 3, 5, 7, 8, 10}},
+	{"Locrian", []int{0, 1, 3, 5, 6, 8, 10}},
+	{"Harmonic Minor", []int{0, 2, 3, 5, 7, 8, 11}},
+	{"Melodic Minor", []int{0, 2, 3, 5, 7, 9, 11}},
+	{"Major Pentatonic", []int{0, 2, 4, 7, 9}},
+	{"Minor Pentatonic", []int{0, 3, 5, 7, 10}},
+	{"Blues", []int{0, 3, 5, 6, 7, 10}},
+}
+
+var (
+	naturals     = [7]int{0, 2, 4, 5, 7, 9, 11} // Do to Si
+	degreeLabels = [12]string{"1", "♭2", "2", "♭3", "3", "4", "♭5", "5", "♭6", "6", "♭7", "7"}
+	stepNames    = map[int]string{1: "H", 2: "W", 3: "W+H"} // half and whole steps
+)
+
+// musicKey is a root, as semitones above Do, and an index into scales.
+type musicKey struct {
+	root, scale int
+}
+
+type scalePanel struct {
+	open   bool
+	circle bool // showing the circle of fifths
+}
+
+// currentKey is the key picked in the scale panel. It's only touched from
+// the UI loop.
+var currentKey musicKey
+
+func (k musicKey) steps() []int {
+	return scales[k.scale].steps
+}
+
+// has reports whether a MIDI note is in the key.
+func (k musicKey) has(n int) bool {
+	for _, s := range k.steps() {
+		if (n-k.root+120)%12 == s {
+			return true
+		}
+	}
+	return false
+}
+
+// names spells the key's notes from the root. A seven-note scale uses
+// each letter once, with the root spelled whichever way needs fewer
+// sharps and flats; other scales are spelled with sharps.
+func (k musicKey) names() []string {
+	steps := k.steps()
+	if len(steps) != 7 {
+		var out []string
+		for _, s := range steps {
+			out = append(out, noteName((k.root+s)%12))
+		}
+		return out
+	}
+
+	letters := []int{pitchSteps[k.root]}
+	if pitchSharps[k.root] {
+		letters = append(letters, (pitchSteps[k.root]+1)%7) // the flat spelling
+	}
+	var best []string
+	bestCount := 0
+	for _, letter := range letters {
+		var out []string
+		count := 0
+		for i, s := range steps {
+			l := (letter + i) % 7
+			acc := ((k.root+s-naturals[l])%12+18)%12 - 6 // -6 to 5, in practice -2 to 2
+			out = append(out, solfege[l]+accidental(acc))
+			count += max(acc, -acc)
+		}
+		if best == nil || count < bestCount {
+			best, bestCount = out, count
+		}
+	}
+	return best
+}
+
+func accidental(n int) string {
+	if n < 0 {
+		return strings.Repeat("♭", -n)
+	}
+	return strings.Repeat("♯", n)
+}
+
+// degrees labels the key's notes against the major scale, as in ♭3.
+func (k musicKey) degrees() []string {
+	var out []string
+	for _, s := range k.steps() {
+		out = append(out, degreeLabels[s])
+	}
+	return out
+}
+
+func (k musicKey) String() string {
+	return k.names()[0] + " " + scales[k.scale].name
+}
+
+// handleScaleKey takes the panel's arrows; every other key still plays.
+func (m model) handleScaleKey(msg tea.KeyMsg) (model, bool) {
+	from := m.shiftSemis()
+	switch msg.Type {
+	case tea.KeyCtrlU, tea.KeyEscape:
+		m.scale.open = false
+	case tea.KeyTab:
+		m.scale.circle = !m.scale.circle
+	case tea.KeyEnter:
+		m.transposing = !m.transposing
+	case tea.KeyLeft, tea.KeyRight:
+		dir := 1
+		if msg.Type == tea.KeyLeft {
+			dir = -1
+		}
+		if m.scale.circle {
+			stepFifths(dir)
+		} else {
+			currentKey.root = (currentKey.root + dir + 12) % 12
+		}
+	case tea.KeyUp, tea.KeyDown:
+		switch {
+		case m.scale.circle:
+			crossRing()
+		case msg.Type == tea.KeyUp:
+			currentKey.scale = (currentKey.scale + len(scales) - 1) % len(scales)
+		default:
+			currentKey.scale = (currentKey.scale + 1) % len(scales)
+		}
+	default:
+		return m, false
+	}
+	m = m.followHeld(from)
+	if m.theremin.locked {
+		thereminPlay(&m.theremin)
+	}
+	return m, true
+}
+
+func (m model) scaleView() string {
+	if m.scale.circle {
+		return m.circleView()
+	}
+	k := currentKey
+	lines := []string{presetTitleStyle.Render(fmt.Sprintf("--- SCALE • %s ---", k))}
+
+	names, degrees := k.names(), k.degrees()
+	var notes, degs []string
+	for i := range names {
+		notes = append(notes, fmt.Sprintf("%-6s", names[i]))
+		degs = append(degs, fmt.Sprintf("%-6s", degrees[i]))
+	}
+	var gaps []string
+	steps := k.steps()
+	for i, s := range steps {
+		next := 12
+		if i+1 < len(steps) {
+			next = steps[i+1]
+		}
+		gaps = append(gaps, fmt.Sprintf("%-6s", stepNames[next-s]))
+	}
+	lines = append(lines,
+		presetTextStyle.Bold(true).Render(strings.Join(notes, " ")),
+		presetTextStyle.Render(strings.Join(degs, " ")),
+		presetTextStyle.Render("   "+strings.Join(gaps, " ")))
+
+	lines = append(lines, helpStyle.UnsetMarginTop().Render("←/→: Root  •  ↑/↓: Scale  •  ENTER: Transpose  •  TAB: Circle of Fifths  •  ESC/CTRL+U: Close"))
+	return visStyle.Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
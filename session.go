@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// --- SESSION JOURNAL ---
+//
+// While piango runs it keeps ~/.config/piango/session.json up to date
+// with what would hurt to lose: the instrument and octave, the master
+// settings, the tempo and drum pattern, the take being recorded, and the
+// song in the tracks panel. It's rewritten every couple of seconds when
+// something changed, and removed when piango is quit with ESC. If it's
+// still there at the next start, piango crashed or was stopped with
+// CTRL+C, and offers to bring the session back.
+
+const journalEvery = 2 * time.Second
+
+type sessionState struct {
+	Saved      time.Time               `json:"saved"`
+	Instrument string                  `json:"instrument"`
+	Octave     int                     `json:"octave"`
+	BPM        float64                 `json:"bpm"`
+	Settings   map[string]float64      `json:"settings"` // by OSC name
+	Pattern    [][patternSteps]float64 `json:"pattern"`  // per drum machine track
+	Take       *take                   `json:"take,omitempty"`
+	Song       *sessionSong            `json:"song,omitempty"`
+}
+
+// sessionSong is the tracks panel's song. The song is saved with the takes
+// after every change, but a pass being recorded onto a track isn't until
+// it's done: then it's the journal's Take, with Recording set.
+type sessionSong struct {
+	Take      take   `json:"take"`
+	Path      string `json:"path,omitempty"`
+	Armed     int    `json:"armed"`
+	Overdub   bool   `json:"overdub,omitempty"`
+	Recording bool   `json:"recording,omitempty"`
+}
+
+type sessionJournal struct {
+	path string
+	last []byte // the state last written, less its time
+	next time.Time
+}
+
+func sessionPath() string {
+	dir := configDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "session.json")
+}
+
+func newSessionJournal(path string) *sessionJournal {
+	if path == "" {
+		return nil
+	}
+	return &sessionJournal{path: path}
+}
+
+// loadSession reads a journal left behind, or nil if there's none.
+func loadSession(path string) (*sessionState, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var st sessionState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &st, nil
+}
+
+// sessionState gathers what the journal keeps.
+func (m model) sessionState() sessionState {
+	st := sessionState{Octave: m.octaveShift, Settings: make(map[string]float64)}
+	if t := m.tracks; len(t.song.Tracks) > 0 {
+		st.Song = &sessionSong{Take: t.song, Path: t.path, Armed: t.armed, Overdub: t.overdub, Recording: t.recording}
+	}
+
+	voiceLock.Lock()
+	st.Instrument = instruments[currentInstID].Name
+	if rec.on {
+		tk := rec.snapshot()
+		st.Take = &tk
+	}
+	voiceLock.Unlock()
+
+	output.Lock()
+	st.BPM = transport.bpm
+	st.Pattern = slices.Clone(drumMachine.pattern)
+	for _, s := range settings {
+		st.Settings[paramName(s.patchParam)] = *s.Value()
+	}
+	output.Unlock()
+	return st
+}
+
+// poll writes the journal if it's due and the session has changed since.
+// The file is replaced whole, so a crash mid-write leaves the last one.
+func (j *sessionJournal) poll(m model, now time.Time) error {
+	if now.Before(j.next) {
+		return nil
+	}
+	j.next = now.Add(journalEvery)
+
+	st := m.sessionState()
+	state, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(state, j.last) {
+		return nil
+	}
+	st.Saved = now
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(j.path), 0o755); err != nil {
+		return err
+	}
+	tmp := j.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, j.path); err != nil {
+		return err
+	}
+	j.last = state
+	return nil
+}
+
+// discard removes the journal on a deliberate quit.
+func (j *sessionJournal) discard() {
+	if j != nil {
+		os.Remove(j.path)
+	}
+}
+
+// restoreSession puts a journaled session back. A take that was being
+// recorded is saved with the others, where CTRL+F finds it, or put on its
+// track if it was a pass in the tracks panel.
+func (m model) restoreSession(st *sessionState) model {
+	voiceLock.Lock()
+	for i, inst := range instruments {
+		if inst.Name == st.Instrument {
+			currentInstID = i
+			m.instName = inst.Name
+		}
+	}
+	voiceLock.Unlock()
+	m = m.shiftOctave(st.Octave - m.octaveShift)
+
+	output.Lock()
+	if st.BPM > 0 {
+		transport.setBPM(st.BPM)
+	}
+	if len(st.Pattern) == len(drumMachine.pattern) {
+		copy(drumMachine.pattern, st.Pattern)
+	}
+	for _, s := range settings {
+		if v, ok := st.Settings[paramName(s.patchParam)]; ok {
+			*s.Value() = max(s.Min, min(s.Max, v))
+		}
+	}
+	m.volume, m.bpm = mainOut.volume, transport.bpm
+	output.Unlock()
+
+	m.notification = "Session restored"
+	m.notifyClearTime = time.Now().Add(3 * time.Second)
+	if sg := st.Song; sg != nil && sg.Armed >= 0 && sg.Armed < len(sg.Take.Tracks) {
+		m.tracks = tracksPanel{song: sg.Take, path: sg.Path, armed: sg.Armed, overdub: sg.Overdub}
+		if sg.Recording && st.Take != nil && len(st.Take.Events) > 0 {
+			m = m.addPass(*st.Take)
+			if !strings.HasPrefix(m.notification, "Tracks:") { // saving failed
+				m.notification = "Session restored, the pass is on " + sg.Take.Tracks[sg.Armed].Name
+			}
+			return m
+		}
+	}
+	if st.Take != nil && len(st.Take.Events) > 0 {
+		path, err := saveTake(takesDir(), *st.Take)
+		if err != nil {
+			m.notification = fmt.Sprintf("Restore: %v", err)
+		} else {
+			m.notification = "Session restored, take saved as " + filepath.Base(path)
+		}
+	}
+	return m
+}
+
+// handleRestoreKey answers the restore question; nothing else happens
+// until it's answered.
+func (m model) handleRestoreKey(msg tea.KeyMsg) (model, tea.Cmd) {
+	switch msg.String() {
+	case "y", "Y", "enter":
+		m = m.restoreSession(m.restore)
+		m.restore = nil
+	case "n", "N", "esc":
+		m.restore = nil
+	case "ctrl+c":
+		return m.quit(), tea.Quit
+	}
+	return m, nil
+}
+
+func (m model) restoreView() string {
+	st := m.restore
+	details := fmt.Sprintf("%s, octave %+d, %.0f BPM", st.Instrument, st.Octave, st.BPM)
+	if st.Song != nil {
+		details += fmt.Sprintf(", a song with %d tracks", len(st.Song.Take.Tracks))
+	}
+	if st.Take != nil {
+		kind := "take"
+		if st.Song != nil && st.Song.Recording {
+			kind = "pass"
+		}
+		details += fmt.Sprintf(", a %s %s with %d notes", clock(st.Take.Length), kind, st.Take.notes())
+	}
+	lines := []string{
+		presetTitleStyle.Render("--- RESTORE SESSION ---"),
+		instStyle.UnsetMarginBottom().Render("piango didn't close normally at " + st.Saved.Format("15:04 on Jan 2")),
+		presetTextStyle.Render(details),
+		helpStyle.Render("Y/ENTER: Restore  •  N/ESC: Start fresh"),
+	}
+	return visStyle.Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// --- SETTINGS ---
+//
+// Ctrl+O swaps the visualizer for the master volume and bus settings,
+// drawn and driven like the patch editor. Values start from the config
+// file. Below the sliders, ←/→ step the audio buffer through its sizes
+// (see buffer.go), pick the keyboard layout and choose whether held notes
+// follow an octave shift (see retune.go).
+
+// setting is a master bus value; the slider range and formatting come
+// from the embedded patchParam, whose Field is unused.
+type setting struct {
+	patchParam
+	Value func() *float64
+}
+
+var settings = []setting{
+	{patchParam{Name: "Volume", Min: 0, Max: maxVolume, Step: volumeStep},
+		func() *float64 { return &mainOut.volume }},
+	{patchParam{Name: "EQ Low", Unit: "dB", Min: -12, Max: 12, Step: 1},
+		func() *float64 { return &master.eq.Low }},
+	{patchParam{Name: "EQ Mid", Unit: "dB", Min: -12, Max: 12, Step: 1},
+		func() *float64 { return &master.eq.Mid }},
+	{patchParam{Name: "EQ High", Unit: "dB", Min: -12, Max: 12, Step: 1},
+		func() *float64 { return &master.eq.High }},
+	{patchParam{Name: "Comp Thr", Unit: "dB", Min: -40, Max: 0, Step: 1},
+		func() *float64 { return &master.compressor.ThresholdDB }},
+	{patchParam{Name: "Ratio", Min: 1, Max: 20, Step: 0.5},
+		func() *float64 { return &master.compressor.Ratio }},
+	{patchParam{Name: "Comp Att", Unit: "ms", Min: 0.1, Max: 200, Step: 1.25, Log: true},
+		func() *float64 { return &master.compressor.AttackMs }},
+	{patchParam{Name: "Comp Rel", Unit: "ms", Min: 10, Max: 2000, Step: 1.25, Log: true},
+		func() *float64 { return &master.compressor.ReleaseMs }},
+	{patchParam{Name: "Makeup", Unit: "dB", Min: 0, Max: 24, Step: 1},
+		func() *float64 { return &master.compressor.MakeupDB }},
+	{patchParam{Name: "Phsr Rate", Unit: "Hz", Min: 0.05, Max: 5, Step: 1.25, Log: true},
+		func() *float64 { return &master.phaser.Rate }},
+	{patchParam{Name: "Phsr Dep", Min: 0, Max: 1, Step: 0.05},
+		func() *float64 { return &master.phaser.Depth }},
+	{patchParam{Name: "Phsr Fb", Min: 0, Max: 0.9, Step: 0.05},
+		func() *float64 { return &master.phaser.Feedback }},
+	{patchParam{Name: "Phsr Mix", Min: 0, Max: 1, Step: 0.05},
+		func() *float64 { return &master.phaser.Mix }},
+	{patchParam{Name: "Flng Rate", Unit: "Hz", Min: 0.05, Max: 5, Step: 1.25, Log: true},
+		func() *float64 { return &master.flanger.Rate }},
+	{patchParam{Name: "Flng Dep", Min: 0, Max: 1, Step: 0.05},
+		func() *float64 { return &master.flanger.Depth }},
+	{patchParam{Name: "Flng Fb", Min: 0, Max: 0.9, Step: 0.05},
+		func() *float64 { return &master.flanger.Feedback }},
+	{patchParam{Name: "Flng Mix", Min: 0, Max: 1, Step: 0.05},
+		func() *float64 { return &master.flanger.Mix }},
+	{patchParam{Name: "Trem Rate", Unit: "Hz", Min: 0.5, Max: 20, Step: 0.25},
+		func() *float64 { return &master.tremolo.Rate }},
+	{patchParam{Name: "Trem Amt", Min: 0, Max: 1, Step: 0.05},
+		func() *float64 { return &master.tremolo.Depth }},
+	{patchParam{Name: "Tempo", Unit: "BPM", Min: minBPM, Max: maxBPM, Step: 1},
+		func() *float64 { return &transport.bpm }},
+	{patchParam{Name: "Swing", Unit: "%", Min: 50, Max: 75, Step: 1},
+		func() *float64 { return &transport.swing }},
+	{patchParam{Name: "Count-In", Min: 0, Max: maxCountIn, Step: 1},
+		func() *float64 { return &transport.countIn }},
+}
+
+// The rows after the sliders.
+var (
+	bufferRow   = len(settings)
+	keyboardRow = len(settings) + 1
+	heldRow     = len(settings) + 2
+	settingRows = len(settings) + 3
+)
+
+type settingsPanel struct {
+	open   bool
+	cursor int
+}
+
+func (m model) handleSettingsKey(msg tea.KeyMsg) (model, bool) {
+	p := &m.settings
+	switch msg.Type {
+	case tea.KeyCtrlO, tea.KeyEscape:
+		p.open = false
+	case tea.KeyUp:
+		p.cursor = (p.cursor - 1 + settingRows) % settingRows
+	case tea.KeyDown:
+		p.cursor = (p.cursor + 1) % settingRows
+	case tea.KeyLeft, tea.KeyRight:
+		dir := 1
+		if msg.Type == tea.KeyLeft {
+			dir = -1
+		}
+		switch p.cursor {
+		case bufferRow:
+			return m.stepBuffer(dir), true
+		case keyboardRow:
+			return m.setKeyboard((currentKeyboard + dir + len(keyboards)) % len(keyboards)), true
+		case heldRow:
+			followShift = !followShift
+			return m, true
+		}
+		st := settings[p.cursor]
+		output.Lock()
+		v := st.Value()
+		*v = st.adjust(*v, dir)
+		output.Unlock()
+	case tea.KeyCtrlL:
+		if p.cursor >= len(settings) {
+			return m, true
+		}
+		return m.toggleLearn(settings[p.cursor].patchParam), true
+	default:
+		return m, false
+	}
+	return m, true
+}
+
+func (m model) settingsView() string {
+	lines := []string{presetTitleStyle.Render("--- MASTER SETTINGS ---")}
+	for i, st := range settings {
+		lines = append(lines, sliderLine(st.patchParam, *st.Value(), i == m.settings.cursor))
+	}
+	output.Lock()
+	reduction := master.compressor.reduction
+	output.Unlock()
+	lines = append(lines, presetTextStyle.Render(fmt.Sprintf("  Gain reduction: %.1f dB", reduction)))
+	lines = append(lines, "", m.meters.view(meterWidth, time.Now()), "")
+
+	lines = append(lines, m.settingsRow(bufferRow, "Buffer")+" "+presetTextStyle.Render(bufferView()))
+
+	var kbs []string
+	for _, kb := range keyboards {
+		kbs = append(kbs, kb.name)
+	}
+	lines = append(lines, m.settingsRow(keyboardRow, "Keyboard")+" "+settingChoices(kbs, currentKeyboard))
+	held := 0
+	if followShift {
+		held = 1
+	}
+	lines = append(lines, m.settingsRow(heldRow, "Held")+" "+settingChoices([]string{"Stay", "Glide"}, held))
+
+	lines = append(lines, helpStyle.Render("↑/↓: Select  •  ←/→: Adjust  •  CTRL+L: MIDI Learn  •  ESC/CTRL+O: Close"))
+
+	return visStyle.Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
+
+// settingsRow is the name of one of the rows after the sliders, marked
+// when it's selected.
+func (m model) settingsRow(row int, name string) string {
+	cursor, nameStyle := "  ", presetTextStyle
+	if m.settings.cursor == row {
+		cursor, nameStyle = "▶ ", instStyle.UnsetMarginBottom()
+	}
+	return cursor + nameStyle.Render(fmt.Sprintf("%-9s", name))
+}
+
+// settingChoices lists a row's choices with the current one picked out.
+func settingChoices(names []string, current int) string {
+	var choices []string
+	for i, name := range names {
+		if i == current {
+			choices = append(choices, notifyStyle.UnsetMarginBottom().UnsetPadding().Render(name))
+		} else {
+			choices = append(choices, presetTextStyle.Render(name))
+		}
+	}
+	return strings.Join(choices, " / ")
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/ssh"
+	"github.com/charmbracelet/wish"
+	"github.com/charmbracelet/wish/activeterm"
+	bm "github.com/charmbracelet/wish/bubbletea"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// --- SSH SERVER ---
+//
+// With "ssh": {"listen": ":23234"} (or -serve :23234) friends can
+// `ssh -p 23234 host` and get a TUI of their own. Everyone plays the same
+// engine, so the sound comes out of the server's speakers like several
+// people at one piano: the instrument and effects are shared, but each
+// session's notes are voices of its own. To hear the session on their own
+// machine, players can -join a jam the server hosts, where the SSH notes
+// are relayed with everyone else's.
+//
+// Only the keys in authorized_keys may play. With "watchers" on, anyone
+// else is let in too, but can only watch: their keys and mouse do nothing
+// but leave.
+//
+// Every session is a tea.Program of its own, running Update and View on
+// its own goroutine, and the UI state they share (the presets, themes,
+// key map, instrument list, takes and config file) was written for one.
+// So while the server is up, every UI, the local one included, runs its
+// Update and View under uiMu, one at a time.
+
+type sshConfig struct {
+	Listen         string `json:"listen"`
+	HostKey        string `json:"host_key"`        // created on first run if missing
+	AuthorizedKeys string `json:"authorized_keys"` // who may play; ssh_authorized_keys in the config dir by default
+	Watchers       bool   `json:"watchers"`        // let anyone else in to watch
+}
+
+var sshSessions atomic.Int64
+
+var uiMu sync.Mutex
+
+// sharedModel runs a model under uiMu. A watcher's takes no keys but the
+// ones to leave, and leaves without saving anything.
+type sharedModel struct {
+	m     model
+	watch bool
+}
+
+func (s sharedModel) Init() tea.Cmd { return s.m.Init() }
+
+func (s sharedModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if s.watch {
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			if msg.Type == tea.KeyCtrlC || msg.Type == tea.KeyEscape {
+				return s, tea.Quit
+			}
+			return s, nil
+		case tea.MouseMsg:
+			return s, nil
+		}
+	}
+	uiMu.Lock()
+	defer uiMu.Unlock()
+	next, cmd := s.m.Update(msg)
+	s.m = next.(model)
+	return s, cmd
+}
+
+func (s sharedModel) View() string {
+	uiMu.Lock()
+	defer uiMu.Unlock()
+	return s.m.View()
+}
+
+// startSSH serves a fresh model to every connection.
+func startSSH(cfg sshConfig, appCfg Config) error {
+	if cfg.Listen == "" {
+		return nil
+	}
+	keyPath := cfg.HostKey
+	if keyPath == "" {
+		keyPath = filepath.Join(configDir(), "ssh_host_ed25519")
+	}
+	authPath := cfg.AuthorizedKeys
+	if authPath == "" {
+		authPath = filepath.Join(configDir(), "ssh_authorized_keys")
+	}
+	if _, err := os.Stat(authPath); err != nil && !cfg.Watchers {
+		return fmt.Errorf("ssh: nobody could play, %s isn't there (set \"watchers\" to let people in to watch)", authPath)
+	}
+
+	opts := []ssh.Option{
+		wish.WithHostKeyPath(keyPath),
+		wish.WithPublicKeyAuth(func(_ ssh.Context, key ssh.PublicKey) bool {
+			return authorizedKey(authPath, key)
+		}),
+		wish.WithMiddleware(
+			bm.Middleware(func(sess ssh.Session) (tea.Model, []tea.ProgramOption) {
+				// Only a listed key gets through public key auth.
+				watch := sess.PublicKey() == nil
+				return newSSHModel(appCfg, sshSessions.Add(1), watch), []tea.ProgramOption{
+					tea.WithAltScreen(), tea.WithMouseAllMotion(),
+				}
+			}),
+			activeterm.Middleware(),
+		),
+	}
+	if cfg.Watchers {
+		opts = append(opts, wish.WithKeyboardInteractiveAuth(func(ssh.Context, gossh.KeyboardInteractiveChallenge) bool {
+			return true
+		}))
+	}
+	srv, err := wish.NewServer(opts...)
+	if err != nil {
+		return fmt.Errorf("ssh: %w", err)
+	}
+	ln, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		return err
+	}
+	go srv.Serve(ln)
+	return nil
+}
+
+// authorizedKey reports whether key is listed in the authorized_keys file
+// at path. It's read afresh each time, so keys can be added while serving.
+func authorizedKey(path string, key ssh.PublicKey) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		listed, _, _, _, err := ssh.ParseAuthorizedKey(line)
+		if err == nil && ssh.KeysEqual(key, listed) {
+			return true
+		}
+	}
+	return false
+}
+
+// newSSHModel is a session's UI. The event outputs, file watchers and jam
+// session stay with the local one, which sees the session's notes anyway.
+func newSSHModel(cfg Config, id int64, watch bool) sharedModel {
+	uiMu.Lock()
+	defer uiMu.Unlock()
+	m := initialModel(cfg)
+	m.voicePrefix = fmt.Sprintf("ssh:%d:", id)
+	return sharedModel{m: m, watch: watch}
+}
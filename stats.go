@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// --- PRACTICE STATS ---
+//
+// Every session is logged to ~/.config/piango/stats.json: how long was
+// spent playing, how many notes were struck on the keyboard or with the
+// mouse and on which instruments, and how the practice games went. Time
+// played only counts while notes keep coming, so leaving piango open
+// doesn't add to it. F2 opens the stats screen: totals, a chart of the
+// last two weeks, and the games' scores. The log is saved every half
+// minute while it changes, and when piango quits.
+
+const (
+	practiceGap   = 10 * time.Second // a longer pause isn't counted as playing
+	practiceEvery = 30 * time.Second
+	chartDays     = 14
+	chartHeight   = 6
+)
+
+var chartMetrics = []string{"Minutes", "Notes", "Game Scores"}
+
+// gameScore adds up a practice game's results: each play scored out of
+// some maximum, so any game reads as a percentage.
+type gameScore struct {
+	Plays int `json:"plays"`
+	Score int `json:"score"`
+	Max   int `json:"max"`
+}
+
+func (g gameScore) percent() float64 {
+	if g.Max == 0 {
+		return 0
+	}
+	return 100 * float64(g.Score) / float64(g.Max)
+}
+
+func (g *gameScore) add(o gameScore) {
+	g.Plays += o.Plays
+	g.Score += o.Score
+	g.Max += o.Max
+}
+
+type practiceSession struct {
+	Start       time.Time            `json:"start"`
+	Played      float64              `json:"played"` // seconds
+	Notes       int                  `json:"notes"`
+	Instruments map[string]int       `json:"instruments"` // notes on each
+	Games       map[string]gameScore `json:"games"`
+}
+
+func (s *practiceSession) empty() bool {
+	return s.Notes == 0 && len(s.Games) == 0
+}
+
+type practiceLog struct {
+	path     string
+	sessions []practiceSession // before this one
+	current  practiceSession
+	lastNote time.Time
+	dirty    bool
+	next     time.Time
+}
+
+type statsPanel struct {
+	open   bool
+	metric int // index into chartMetrics
+}
+
+func statsPath() string {
+	dir := configDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "stats.json")
+}
+
+// loadPracticeLog reads the sessions logged so far and starts a new one.
+func loadPracticeLog(path string, now time.Time) (*practiceLog, error) {
+	l := &practiceLog{path: path, current: newPracticeSession(now)}
+	if path == "" {
+		return l, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &l.sessions); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return l, nil
+}
+
+func newPracticeSession(now time.Time) practiceSession {
+	return practiceSession{Start: now, Instruments: make(map[string]int), Games: make(map[string]gameScore)}
+}
+
+// noted counts a note struck on an instrument.
+func (l *practiceLog) noted(inst string, now time.Time) {
+	if l == nil {
+		return
+	}
+	s := &l.current
+	if gap := now.Sub(l.lastNote); gap < practiceGap {
+		s.Played += gap.Seconds()
+	}
+	l.lastNote = now
+	s.Notes++
+	s.Instruments[inst]++
+	l.dirty = true
+}
+
+// scored adds a result of a practice game.
+func (l *practiceLog) scored(game string, score, outOf int) {
+	if l == nil {
+		return
+	}
+	g := l.current.Games[game]
+	g.add(gameScore{1, score, outOf})
+	l.current.Games[game] = g
+	l.dirty = true
+}
+
+// poll saves the log every so often while it changes.
+func (l *practiceLog) poll(now time.Time) error {
+	if l == nil || now.Before(l.next) {
+		return nil
+	}
+	l.next = now.Add(practiceEvery)
+	return l.save()
+}
+
+func (l *practiceLog) save() error {
+	if l == nil || !l.dirty || l.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(l.all(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o755); err != nil {
+		return err
+	}
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		return err
+	}
+	l.dirty = false
+	return nil
+}
+
+// all is every session logged, this one included once something's been
+// played.
+func (l *practiceLog) all() []practiceSession {
+	if l.current.empty() {
+		return l.sessions
+	}
+	return append(slices.Clip(l.sessions), l.current)
+}
+
+// quit saves the log on the way out.
+func (m model) quit() model {
+	if err := m.practice.save(); err != nil {
+		fmt.Fprintf(os.Stderr, "Practice stats: %v\n", err)
+	}
+	return m
+}
+
+func (m model) handleStatsKey(msg tea.KeyMsg) (model, bool) {
+	switch msg.Type {
+	case tea.KeyF2, tea.KeyEscape:
+		m.stats.open = false
+	case tea.KeyTab:
+		m.stats.metric = (m.stats.metric + 1) % len(chartMetrics)
+	default:
+		return m, false
+	}
+	return m, true
+}
+
+// duration writes seconds as hours and minutes.
+func duration(secs float64) string {
+	d := time.Duration(secs) * time.Second
+	if d < time.Hour {
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	}
+	return fmt.Sprintf("%dh %02dm", int(d.Hours()), int(d.Minutes())%60)
+}
+
+// barChart draws values as columns of block characters, an eighth of a
+// row at a time, with a label under each. A value of top fills the
+// height; a top of 0 scales to the largest value.
+func barChart(values []float64, labels []string, height int, top float64) []string {
+	if top == 0 {
+		for _, v := range values {
+			top = max(top, v)
+		}
+	}
+	blocks := []rune(" ▁▂▃▄▅▆▇█")
+	var rows []string
+	for r := height - 1; r >= 0; r-- {
+		var b strings.Builder
+		for _, v := range values {
+			eighths := 0
+			if top > 0 {
+				eighths = int(math.Round(v/top*float64(height*8))) - r*8
+			}
+			b.WriteString(strings.Repeat(string(blocks[max(0, min(8, eighths))]), 2) + " ")
+		}
+		rows = append(rows, b.String())
+	}
+	var under strings.Builder
+	for _, l := range labels {
+		under.WriteString(fmt.Sprintf("%-3s", l))
+	}
+	return append(rows, under.String())
+}
+
+func (m model) statsView() string {
+	var sessions []practiceSession
+	if m.practice != nil {
+		sessions = m.practice.all()
+	}
+	total := newPracticeSession(time.Time{})
+	for _, s := range sessions {
+		total.Played += s.Played
+		total.Notes += s.Notes
+		for inst, n := range s.Instruments {
+			total.Instruments[inst] += n
+		}
+		for game, g := range s.Games {
+			t := total.Games[game]
+			t.add(g)
+			total.Games[game] = t
+		}
+	}
+
+	title := fmt.Sprintf("--- PRACTICE STATS • %d sessions • %s played ---", len(sessions), duration(total.Played))
+	lines := []string{presetTitleStyle.Render(title)}
+
+	if m.practice != nil {
+		s := m.practice.current
+		lines = append(lines, instStyle.UnsetMarginBottom().Render(
+			fmt.Sprintf("This session: %s, %d notes", duration(s.Played), s.Notes)))
+	}
+	summary := fmt.Sprintf("All time: %d notes", total.Notes)
+	if total.Notes > 0 {
+		fav, most := "", 0
+		for inst, n := range total.Instruments {
+			if n > most || n == most && inst < fav {
+				fav, most = inst, n
+			}
+		}
+		summary += fmt.Sprintf("  •  Most played: %s (%.0f%%)", fav, 100*float64(most)/float64(total.Notes))
+	}
+	lines = append(lines, presetTextStyle.Render(summary))
+
+	// The last two weeks, a column a day
+	today := time.Now()
+	values := make([]float64, chartDays)
+	labels := make([]string, chartDays)
+	games := make([]gameScore, chartDays)
+	for i := range chartDays {
+		labels[i] = today.AddDate(0, 0, i-chartDays+1).Format("02")
+	}
+	for _, s := range sessions {
+		days := int(math.Round(dayStart(today).Sub(dayStart(s.Start)).Hours() / 24))
+		i := chartDays - 1 - days
+		if i < 0 || i >= chartDays {
+			continue
+		}
+		switch chartMetrics[m.stats.metric] {
+		case "Minutes":
+			values[i] += s.Played / 60
+		case "Notes":
+			values[i] += float64(s.Notes)
+		default:
+			for _, g := range s.Games {
+				games[i].add(g)
+			}
+			values[i] = games[i].percent()
+		}
+	}
+	top := slices.Max(values)
+	lines = append(lines, presetTitleStyle.Render(fmt.Sprintf("%s a day, up to %.3g", chartMetrics[m.stats.metric], top)))
+	for _, row := range barChart(values, labels, chartHeight, 0) {
+		lines = append(lines, waveColor.Render(row))
+	}
+
+	var scores []string
+	for _, game := range slices.Sorted(maps.Keys(total.Games)) {
+		g := total.Games[game]
+		scores = append(scores, fmt.Sprintf("%s %.0f%% over %d", game, g.percent(), g.Plays))
+	}
+	if len(scores) == 0 {
+		scores = append(scores, "No games played yet")
+	}
+	lines = append(lines, presetTextStyle.Render(strings.Join(scores, "  •  ")),
+		helpStyle.UnsetMarginTop().Render("TAB: Chart Minutes / Notes / Game Scores  •  ESC/F2: Close"))
+	return visStyle.Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
+
+func dayStart(t time.Time) time.Time {
+	y, mo, d := t.Date()
+	return time.Date(y, mo, d, 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// --- STATUS BAR ---
+//
+// The line at the foot of the panel is the engine at a glance: the voices
+// sounding, how much of each buffer's worth of time the audio callback
+// spends filling it, the buffer's size, and the latency. That is measured
+// from the last key played: how long its note waited for the callback to
+// pick it up, plus what the backend holds before it reaches the speakers.
+// Underruns, when the backend ran out of sound, are counted after. It's
+// redrawn twice a second so the figures can be read.
+
+const statusEvery = 500 * time.Millisecond
+
+// outputBuffer is the backend's buffer in frames, as opened.
+var outputBuffer int
+
+// engineLoad is kept by the render callback, under output.Lock().
+type engineLoad struct {
+	busy    time.Duration // spent in the callback
+	audio   time.Duration // of sound it made meanwhile
+	noteLag time.Duration // from the last key struck to its first samples
+}
+
+type engineStatus struct {
+	voices       int
+	cpu          float64 // fraction of real time
+	noteLag      time.Duration
+	buffer       int // frames
+	latency      time.Duration
+	underruns    int
+	lastUnderrun time.Time
+	next         time.Time
+}
+
+// follow takes the engine's figures when it's time to show new ones.
+func (st *engineStatus) follow(sounding int, now time.Time) {
+	if now.Before(st.next) {
+		return
+	}
+	st.next = now.Add(statusEvery)
+	output.Lock()
+	load := mainOut.load
+	mainOut.load = engineLoad{noteLag: load.noteLag}
+	st.underruns, st.lastUnderrun = mainOut.underruns.count, mainOut.underruns.last
+	output.Unlock()
+	bufferMu.Lock()
+	st.buffer, st.latency = outputBuffer, output.Latency()
+	bufferMu.Unlock()
+
+	st.voices = sounding
+	if load.audio > 0 {
+		st.cpu = load.busy.Seconds() / load.audio.Seconds()
+	}
+	st.noteLag = load.noteLag
+}
+
+// view is the status line, shorter in the compact layout.
+func (st engineStatus) view(l layout) string {
+	latency := st.latency + st.noteLag
+	line := fmt.Sprintf("Voices %d  •  CPU %.0f%%  •  Buffer %d (%s)  •  Latency %s  •  %.1f kHz  •  Underruns %d",
+		st.voices, st.cpu*100, st.buffer, millis(sampleRate.D(st.buffer)), millis(latency),
+		float64(sampleRate)/1000, st.underruns)
+	if l.compact {
+		line = fmt.Sprintf("%d voices  •  CPU %.0f%%  •  Buf %s  •  Lat %s  •  %d xruns",
+			st.voices, st.cpu*100, millis(sampleRate.D(st.buffer)), millis(latency), st.underruns)
+	}
+	return presetTitleStyle.UnsetMarginTop().UnsetMarginBottom().Render(line)
+}
+
+func millis(d time.Duration) string {
+	return fmt.Sprintf("%.0f ms", d.Seconds()*1000)
+}
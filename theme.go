@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- THEMES ---
+
+// Theme is the palette every lipgloss style is built from (see applyTheme).
+// Colors are anything lipgloss.Color accepts: "#RRGGBB" or an ANSI index.
+type Theme struct {
+	Name       string `json:"name"`
+	Base       string `json:"base,omitempty"` // user themes: built-in to inherit unset colors from
+	Border     string `json:"border"`         // outer panel border
+	Accent     string `json:"accent"`         // title border, header text
+	Title      string `json:"title"`
+	Surface    string `json:"surface"` // header badge background
+	Notify     string `json:"notify"`
+	Visualizer string `json:"visualizer"`
+	KeyBorder  string `json:"key_border"`
+	KeyText    string `json:"key_text"`
+	ActiveKey  string `json:"active_key"`
+	ActiveText string `json:"active_text"`
+	Muted      string `json:"muted"` // row labels, section titles
+	Text       string `json:"text"`  // preset list
+	Help       string `json:"help"`
+}
+
+var builtinThemes = []Theme{
+	{
+		Name:       "neon",
+		Border:     "#444444",
+		Accent:     "#00E6C3",
+		Title:      "#FFFFFF",
+		Surface:    "#111111",
+		Notify:     "#50FA7B",
+		Visualizer: "#00E6C3",
+		KeyBorder:  "#333333",
+		KeyText:    "#AAAAAA",
+		ActiveKey:  "#00E6C3",
+		ActiveText: "#000000",
+		Muted:      "#6272A4",
+		Text:       "#8BE9FD",
+		Help:       "#666666",
+	},
+	{
+		Name:       "dracula",
+		Border:     "#44475A",
+		Accent:     "#BD93F9",
+		Title:      "#F8F8F2",
+		Surface:    "#282A36",
+		Notify:     "#50FA7B",
+		Visualizer: "#FF79C6",
+		KeyBorder:  "#44475A",
+		KeyText:    "#F8F8F2",
+		ActiveKey:  "#FF79C6",
+		ActiveText: "#282A36",
+		Muted:      "#6272A4",
+		Text:       "#8BE9FD",
+		Help:       "#6272A4",
+	},
+	{
+		Name:       "solarized",
+		Border:     "#073642",
+		Accent:     "#268BD2",
+		Title:      "#93A1A1",
+		Surface:    "#002B36",
+		Notify:     "#859900",
+		Visualizer: "#2AA198",
+		KeyBorder:  "#586E75",
+		KeyText:    "#839496",
+		ActiveKey:  "#B58900",
+		ActiveText: "#002B36",
+		Muted:      "#586E75",
+		Text:       "#2AA198",
+		Help:       "#586E75",
+	},
+	{
+		Name:       "monochrome",
+		Border:     "#5F5F5F",
+		Accent:     "#FFFFFF",
+		Title:      "#FFFFFF",
+		Surface:    "#1C1C1C",
+		Notify:     "#D0D0D0",
+		Visualizer: "#D0D0D0",
+		KeyBorder:  "#4E4E4E",
+		KeyText:    "#A8A8A8",
+		ActiveKey:  "#FFFFFF",
+		ActiveText: "#000000",
+		Muted:      "#808080",
+		Text:       "#BCBCBC",
+		Help:       "#6C6C6C",
+	},
+	{
+		// Pure black and white with yellow for what's lit, for low vision
+		Name:       "high-contrast",
+		Border:     "#FFFFFF",
+		Accent:     "#FFFF00",
+		Title:      "#FFFFFF",
+		Surface:    "#000000",
+		Notify:     "#FFFF00",
+		Visualizer: "#FFFFFF",
+		KeyBorder:  "#FFFFFF",
+		KeyText:    "#FFFFFF",
+		ActiveKey:  "#FFFF00",
+		ActiveText: "#000000",
+		Muted:      "#FFFFFF",
+		Text:       "#FFFFFF",
+		Help:       "#D0D0D0",
+	},
+	{
+		// Okabe-Ito colors, with nothing told apart by red against
+		// green: blues against orange and yellow
+		Name:       "deuteranopia",
+		Border:     "#4E4E4E",
+		Accent:     "#56B4E9",
+		Title:      "#FFFFFF",
+		Surface:    "#121212",
+		Notify:     "#F0E442",
+		Visualizer: "#56B4E9",
+		KeyBorder:  "#5F5F5F",
+		KeyText:    "#C6C6C6",
+		ActiveKey:  "#E69F00",
+		ActiveText: "#000000",
+		Muted:      "#8A8A8A",
+		Text:       "#56B4E9",
+		Help:       "#767676",
+	},
+	{
+		// As deuteranopia, but red looks dark without red cones, so
+		// the bright colors are yellow and sky blue
+		Name:       "protanopia",
+		Border:     "#4E4E4E",
+		Accent:     "#F0E442",
+		Title:      "#FFFFFF",
+		Surface:    "#121212",
+		Notify:     "#56B4E9",
+		Visualizer: "#F0E442",
+		KeyBorder:  "#5F5F5F",
+		KeyText:    "#C6C6C6",
+		ActiveKey:  "#56B4E9",
+		ActiveText: "#000000",
+		Muted:      "#8A8A8A",
+		Text:       "#F0E442",
+		Help:       "#767676",
+	},
+}
+
+// themes is the built-ins followed by any user themes from the config.
+var (
+	themes       = builtinThemes
+	currentTheme = 0
+)
+
+func init() {
+	applyTheme(themes[currentTheme])
+}
+
+var hexColor = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+func validColor(c string) bool {
+	if hexColor.MatchString(c) {
+		return true
+	}
+	n, err := strconv.Atoi(c)
+	return err == nil && n >= 0 && n <= 255
+}
+
+// colors lists a theme's color fields with their config names.
+func (t *Theme) colors() []struct {
+	key string
+	val *string
+} {
+	return []struct {
+		key string
+		val *string
+	}{
+		{"border", &t.Border}, {"accent", &t.Accent}, {"title", &t.Title},
+		{"surface", &t.Surface}, {"notify", &t.Notify}, {"visualizer", &t.Visualizer},
+		{"key_border", &t.KeyBorder}, {"key_text", &t.KeyText}, {"active_key", &t.ActiveKey},
+		{"active_text", &t.ActiveText}, {"muted", &t.Muted}, {"text", &t.Text}, {"help", &t.Help},
+	}
+}
+
+// buildThemes validates user themes and appends them to the built-ins.
+// Colors a user theme leaves out come from its base (neon by default).
+func buildThemes(custom []Theme) ([]Theme, error) {
+	all := append([]Theme(nil), builtinThemes...)
+	for _, t := range custom {
+		if t.Name == "" {
+			return nil, fmt.Errorf("theme without a name")
+		}
+		if _, ok := findTheme(all, t.Name); ok {
+			return nil, fmt.Errorf("theme %q defined twice", t.Name)
+		}
+
+		baseName := t.Base
+		if baseName == "" {
+			baseName = builtinThemes[0].Name
+		}
+		baseID, ok := findTheme(builtinThemes, baseName)
+		if !ok {
+			return nil, fmt.Errorf("theme %q: unknown base %q", t.Name, baseName)
+		}
+		base := builtinThemes[baseID]
+
+		baseColors := base.colors()
+		for i, c := range t.colors() {
+			if *c.val == "" {
+				*c.val = *baseColors[i].val
+			} else if !validColor(*c.val) {
+				return nil, fmt.Errorf("theme %q: %s: invalid color %q", t.Name, c.key, *c.val)
+			}
+		}
+		all = append(all, t)
+	}
+	return all, nil
+}
+
+func findTheme(list []Theme, name string) (int, bool) {
+	for i, t := range list {
+		if strings.EqualFold(t.Name, name) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// setThemes installs a theme list and selects name from it.
+func setThemes(list []Theme, name string) error {
+	id, ok := findTheme(list, name)
+	if !ok {
+		return fmt.Errorf("unknown theme %q", name)
+	}
+	themes = list
+	currentTheme = id
+	applyTheme(themes[id])
+	return nil
+}
+
+func (m model) cycleTheme() model {
+	currentTheme = (currentTheme + 1) % len(themes)
+	applyTheme(themes[currentTheme])
+	m.notification = "Theme: " + themes[currentTheme].Name
+	m.notifyClearTime = time.Now().Add(1 * time.Second)
+	return m
+}
+
+// reloadThemes re-reads the config after it changed on disk and applies
+// its themes. Errors are shown in the header and leave the UI as it was.
+func (m model) reloadThemes() model {
+	cfg, err := loadConfig(m.cfgWatch.path)
+	if err == nil {
+		var list []Theme
+		if list, err = buildThemes(cfg.Themes); err == nil {
+			err = setThemes(list, cfg.Theme)
+		}
+	}
+
+	if err != nil {
+		m.notification = "Config: " + err.Error()
+		m.notifyClearTime = time.Now().Add(4 * time.Second)
+		return m
+	}
+	m.notification = "Theme: " + themes[currentTheme].Name
+	m.notifyClearTime = time.Now().Add(1 * time.Second)
+	return m
+}
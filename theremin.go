@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// --- THEREMIN MODE ---
+//
+// Terminals stop auto-repeating a held key as soon as another key goes
+// down, so "hold a note and bend it with the arrows" can't work directly.
+// Instead a note key locks a single voice that keeps sounding on its own,
+// and the arrows (or horizontal mouse movement) sweep its pitch. Positions
+// are semitones relative to A4, the same scale initNotes uses.
+
+const (
+	thereminKey   = "theremin"
+	thereminGlide = 60 * time.Millisecond // how the locked voice follows the pitch
+)
+
+// Snap modes, cycled with ~. Scales are counted from the root of the key
+// picked in the scale panel, and Key keeps to its scale.
+var thereminSnaps = []struct {
+	Name  string
+	Steps []int
+}{
+	{Name: "Free"},
+	{Name: "Chromatic", Steps: []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}},
+	{Name: "Major", Steps: []int{0, 2, 4, 5, 7, 9, 11}},
+	{Name: "Minor", Steps: []int{0, 2, 3, 5, 7, 8, 10}},
+	{Name: "Pentatonic", Steps: []int{0, 2, 4, 7, 9}},
+	{Name: "Key"},
+}
+
+var noteNames = []string{"C", "C#", "D", "D#", "E", "F", "F#", "G", "G#", "A", "A#", "B"}
+
+type theremin struct {
+	active bool
+	locked bool
+	pos    float64
+	snap   int
+}
+
+// bounds is the playable range (C3-B5, the three rows) moved by the
+// current octave shift.
+func (t *theremin) bounds(octaveShift int) (lo, hi float64) {
+	shift := float64(octaveShift * 12)
+	return -21 + shift, 14 + shift
+}
+
+func (t *theremin) clamp(octaveShift int) {
+	lo, hi := t.bounds(octaveShift)
+	t.pos = math.Max(lo, math.Min(hi, t.pos))
+}
+
+// pitch returns the position after scale snapping.
+func (t *theremin) pitch() float64 {
+	steps := thereminSnaps[t.snap].Steps
+	if thereminSnaps[t.snap].Name == "Key" {
+		steps = currentKey.steps()
+	}
+	if steps == nil {
+		return t.pos
+	}
+
+	// Search the neighbouring octaves of the pitch, counted from the
+	// key's root, for the closest allowed degree.
+	root := float64(currentKey.root)
+	fromRoot := t.pos + 9 - root
+	octave := math.Floor(fromRoot / 12)
+	best, bestDist := t.pos, math.Inf(1)
+	for o := octave - 1; o <= octave+1; o++ {
+		for _, st := range steps {
+			cand := o*12 + float64(st)
+			if d := math.Abs(cand - fromRoot); d < bestDist {
+				best, bestDist = cand-9+root, d
+			}
+		}
+	}
+	return best
+}
+
+func (t *theremin) freq() float64 {
+	return 440.0 * math.Pow(2.0, t.pitch()/12.0)
+}
+
+// label renders the snapped pitch as a note name with cent offset.
+func (t *theremin) label() string {
+	p := t.pitch()
+	nearest := math.Round(p)
+	cents := int(math.Round((p - nearest) * 100))
+	idx := int(nearest) + 9 + 48
+	name := fmt.Sprintf("%s%d", noteNames[idx%12], idx/12)
+	if cents != 0 {
+		name += fmt.Sprintf(" %+d¢", cents)
+	}
+	return name
+}
+
+// thereminPlay locks the theremin voice (starting it if needed) and glides
+// it to the current pitch.
+func thereminPlay(t *theremin) {
+	voiceLock.Lock()
+	defer voiceLock.Unlock()
+
+	freq := t.freq()
+	if v, ok := voices[thereminKey]; ok && !v.finished() {
+		v.freq = freq
+		v.locked = true
+		v.retune(freq, glideCoef(thereminGlide))
+		v.sustain()
+		t.locked = true
+		return
+	}
+
+	v := pool.get(instruments[currentInstID], freq, 1, false)
+	v.streamer.glide = glideCoef(thereminGlide)
+	v.lastSeen, v.freq, v.locked = time.Now(), freq, true
+	voices[thereminKey] = v
+	v.start()
+	t.locked = true
+}
+
+func thereminRelease(t *theremin) {
+	voiceLock.Lock()
+	defer voiceLock.Unlock()
+
+	if v, ok := voices[thereminKey]; ok {
+		v.locked = false
+		v.release()
+	}
+	t.locked = false
+}
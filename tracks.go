@@ -0,0 +1,383 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// --- TRACKS ---
+//
+// CTRL+T opens a take as a stack of tracks, each with its own instrument,
+// to build a piece up part by part. CTRL+R in the panel records the armed
+// track while the others play, replacing what it held, or adding to it
+// when the track is armed to overdub; SPACE plays them all mixed. Tracks
+// can be muted or soloed. The take is saved with its tracks after every
+// change, so it replays and renders the same way, and CTRL+Z takes the
+// changes back one at a time (see undo.go).
+
+const maxTracks = 8
+
+var trackColumns = []string{"Arm", "Mute", "Solo", "Instrument"}
+
+type tracksPanel struct {
+	open      bool
+	song      take
+	path      string // where the song is saved; empty until it is
+	row, col  int    // row len(song.Tracks) is "add a track"
+	armed     int
+	overdub   bool // the armed track keeps what it has and a pass adds to it
+	player    *takePlayer
+	recording bool
+	since     time.Time // when the recording started
+}
+
+func newSong() take {
+	output.Lock()
+	bpm := transport.bpm
+	output.Unlock()
+	name := instruments[currentInstID].Name
+	return take{
+		Version: takeVersion, Recorded: time.Now(), Instrument: name, BPM: bpm,
+		Tracks: []takeTrack{{Name: "Track 1", Instrument: name}},
+	}
+}
+
+// asSong gives a plain take its one track.
+func asSong(tk take) take {
+	if len(tk.Tracks) == 0 {
+		inst := tk.Instrument
+		if findInstrument(inst) == nil {
+			inst = instruments[currentInstID].Name
+		}
+		tk.Tracks = []takeTrack{{Name: "Track 1", Instrument: inst}}
+	}
+	return tk
+}
+
+// openTracks reopens the song last worked on, or starts one.
+func (m model) openTracks() model {
+	if len(m.tracks.song.Tracks) == 0 {
+		m.tracks = tracksPanel{song: newSong()}
+	}
+	m.tracks.open = true
+	return m
+}
+
+// openTracksOn opens a saved take. Imported files are saved as a new
+// take when changed.
+func (m model) openTracksOn(tf takeFile) model {
+	path := tf.path
+	if !strings.EqualFold(filepath.Ext(path), ".json") {
+		path = ""
+	}
+	m.tracks = tracksPanel{open: true, song: asSong(tf.take), path: path}
+	return m
+}
+
+func (m model) closeTracks() model {
+	if m.tracks.recording {
+		m = m.stopTrackRecording()
+	}
+	if m.tracks.player != nil {
+		m.tracks.player.pause()
+		m.tracks.player = nil
+	}
+	m.tracks.open = false
+	return m
+}
+
+// armTrack arms a track and puts its instrument on the keyboard.
+func (m model) armTrack(i int) model {
+	m.tracks.armed = i
+	voiceLock.Lock()
+	if findInstrument(m.tracks.song.Tracks[i].Instrument) != nil {
+		currentInstID = indexOfInstrument(m.tracks.song.Tracks[i].Instrument)
+		m.instName = instruments[currentInstID].Name
+	}
+	voiceLock.Unlock()
+	return m
+}
+
+func (m model) startTrackRecording(now time.Time) model {
+	t := &m.tracks
+	if t.player != nil {
+		t.player.pause()
+	}
+	m = m.armTrack(t.armed)
+
+	voiceLock.Lock()
+	if rec.on {
+		voiceLock.Unlock()
+		m.notification = "Already recording"
+		m.notifyClearTime = time.Now().Add(2 * time.Second)
+		return m
+	}
+	rec = recorder{
+		on:    true,
+		start: now,
+		take:  take{Version: takeVersion, Recorded: now, Instrument: instruments[currentInstID].Name},
+		held:  make(map[string]bool),
+		track: t.armed,
+	}
+	voiceLock.Unlock()
+
+	// The other tracks play along, on the same clock as the recording, and
+	// the armed one too when overdubbing
+	backing := t.song
+	backing.Events = slices.DeleteFunc(slices.Clone(t.song.Events), func(ev takeEvent) bool {
+		return ev.Track == t.armed && !t.overdub
+	})
+	t.player = newTakePlayer(t.song.Tracks[t.armed].Name, backing)
+	t.player.last = now
+	t.recording, t.since = true, now
+	m.recording = true
+	return m
+}
+
+// stopTrackRecording puts the pass on the armed track, in place of what
+// was there or added to it, and saves the song.
+func (m model) stopTrackRecording() model {
+	t := &m.tracks
+	voiceLock.Lock()
+	pass := rec.finish()
+	voiceLock.Unlock()
+	t.recording = false
+	m.recording = false
+	if t.player != nil {
+		t.player.pause()
+		t.player = nil
+	}
+	return m.addPass(pass)
+}
+
+// addPass puts a recorded pass on the armed track and saves the song.
+func (m model) addPass(pass take) model {
+	t := &m.tracks
+	if len(pass.Events) == 0 {
+		m.notification = "Nothing recorded"
+		m.notifyClearTime = time.Now().Add(2 * time.Second)
+		return m
+	}
+	song, before := &t.song, t.song.clone()
+	if !t.overdub {
+		song.Events = slices.DeleteFunc(song.Events, func(ev takeEvent) bool { return ev.Track == t.armed })
+	}
+	song.Events = append(song.Events, pass.Events...)
+	sortEvents(song.Events)
+	song.Length = pass.Length
+	for _, ev := range song.Events {
+		song.Length = max(song.Length, ev.T)
+	}
+	return m.songEdited("pass on "+song.Tracks[t.armed].Name, before)
+}
+
+// songEdited saves the song after a change, an edit that takes it back
+// to before.
+func (m model) songEdited(name string, before take) model {
+	m = m.saveSong()
+	if m.tracks.path == "" {
+		return m
+	}
+	after := m.tracks.song.clone()
+	return m.takeEdited(name, m.tracks.path, &before, &after)
+}
+
+func (m model) saveSong() model {
+	t := &m.tracks
+	var err error
+	if t.path == "" {
+		t.path, err = saveTake(takesDir(), t.song)
+	} else {
+		err = writeTake(t.path, t.song)
+	}
+	m.notifyClearTime = time.Now().Add(3 * time.Second)
+	if err != nil {
+		m.notification = fmt.Sprintf("Tracks: %v", err)
+		return m
+	}
+	m.notification = "Saved " + strings.TrimSuffix(filepath.Base(t.path), ".json")
+	return m
+}
+
+// handleTracksKey takes the panel's keys; note keys fall through to play.
+func (m model) handleTracksKey(msg tea.KeyMsg) (model, bool) {
+	t := &m.tracks
+	tracks := t.song.Tracks
+	switch msg.Type {
+	case tea.KeyCtrlT, tea.KeyEscape:
+		return m.closeTracks(), true
+	case tea.KeyUp:
+		t.row = (t.row - 1 + len(tracks) + 1) % (len(tracks) + 1)
+	case tea.KeyDown:
+		t.row = (t.row + 1) % (len(tracks) + 1)
+	case tea.KeyLeft:
+		t.col = (t.col - 1 + len(trackColumns)) % len(trackColumns)
+	case tea.KeyRight:
+		t.col = (t.col + 1) % len(trackColumns)
+	case tea.KeyCtrlR:
+		switch {
+		case m.count != nil:
+			return m.cancelCountIn(), true
+		case t.recording:
+			return m.stopTrackRecording(), true
+		}
+		return m.startCountIn(true), true
+	case tea.KeySpace:
+		if t.recording {
+			return m, true
+		}
+		if t.player != nil && t.player.playing {
+			t.player.pause()
+		} else {
+			t.player = newTakePlayer("mix", t.song)
+		}
+	case tea.KeyEnter:
+		if t.recording {
+			return m, true
+		}
+		if t.row == len(tracks) {
+			if len(tracks) == maxTracks {
+				return m, true
+			}
+			before := t.song.clone()
+			t.song.Tracks = append(t.song.Tracks, takeTrack{
+				Name:       fmt.Sprintf("Track %d", len(tracks)+1),
+				Instrument: instruments[currentInstID].Name,
+			})
+			return m.songEdited("adding a track", before).armTrack(len(tracks)), true
+		}
+		before := t.song.clone()
+		tr := &t.song.Tracks[t.row]
+		switch trackColumns[t.col] {
+		case "Arm":
+			if t.row == t.armed {
+				t.overdub = !t.overdub
+				return m, true
+			}
+			return m.armTrack(t.row), true
+		case "Mute":
+			tr.Mute = !tr.Mute
+		case "Solo":
+			tr.Solo = !tr.Solo
+		case "Instrument":
+			tr.Instrument = instruments[currentInstID].Name
+		}
+		return m.songEdited(strings.ToLower(trackColumns[t.col])+" on "+tr.Name, before), true
+	case tea.KeyBackspace, tea.KeyDelete:
+		if t.recording || t.row == len(tracks) {
+			return m, true
+		}
+		if t.player != nil { // it plays the events about to change
+			t.player.pause()
+			t.player = nil
+		}
+		// Clear the track, or take an empty one away
+		before, name := t.song.clone(), tracks[t.row].Name
+		if slices.ContainsFunc(t.song.Events, func(ev takeEvent) bool { return ev.Track == t.row }) {
+			t.song.Events = slices.DeleteFunc(t.song.Events, func(ev takeEvent) bool { return ev.Track == t.row })
+			return m.songEdited("clearing "+name, before), true
+		}
+		if len(tracks) > 1 {
+			t.song.Tracks = slices.Delete(t.song.Tracks, t.row, t.row+1)
+			for i := range t.song.Events {
+				if t.song.Events[i].Track > t.row {
+					t.song.Events[i].Track--
+				}
+			}
+			if t.armed >= t.row && t.armed > 0 {
+				t.armed--
+			}
+			return m.songEdited("removing "+name, before), true
+		}
+	default:
+		return m, false
+	}
+	return m, true
+}
+
+func (m model) tracksView() string {
+	t := m.tracks
+	name := "New song"
+	if t.path != "" {
+		name = strings.TrimSuffix(filepath.Base(t.path), ".json")
+	}
+	lines := []string{presetTitleStyle.Render(fmt.Sprintf("--- TRACKS: %s • %s ---", name, clock(t.song.Length)))}
+
+	notes := make([]int, len(t.song.Tracks))
+	for _, ev := range t.song.Events {
+		if ev.Type == "on" && ev.Track < len(notes) {
+			notes[ev.Track]++
+		}
+	}
+	cell := func(row, col int, text string) string {
+		style := presetTextStyle
+		if row == t.row && col == t.col {
+			style = notifyStyle.UnsetMarginBottom().UnsetPadding()
+		}
+		return style.Render(text)
+	}
+	mark := func(on bool, glyph string) string {
+		if on {
+			return fmt.Sprintf(" %-3s", glyph)
+		}
+		return " ·  "
+	}
+
+	lines = append(lines, helpStyle.UnsetMarginTop().Render("    Track      Arm  Mute Solo Instrument         Notes"))
+	for i, tr := range t.song.Tracks {
+		cursor := "  "
+		if i == t.row {
+			cursor = "▶ "
+		}
+		glyph := "●"
+		if t.overdub {
+			glyph = "●+"
+		}
+		arm := cell(i, 0, mark(i == t.armed, glyph))
+		if i == t.armed && t.recording {
+			arm = notifyStyle.UnsetMarginBottom().UnsetPadding().Render(" REC")
+		}
+		lines = append(lines, fmt.Sprintf("%s%s %s %s %s %s  %s",
+			cursor,
+			instStyle.UnsetMarginBottom().Render(fmt.Sprintf("%-9s", tr.Name)),
+			arm,
+			cell(i, 1, mark(tr.Mute, "M")),
+			cell(i, 2, mark(tr.Solo, "S")),
+			cell(i, 3, fmt.Sprintf(" %-17s", tr.Instrument)),
+			helpStyle.UnsetMarginTop().Render(fmt.Sprintf("%5d", notes[i])),
+		))
+	}
+	add := "  + Add track"
+	if t.row == len(t.song.Tracks) {
+		add = "▶ " + notifyStyle.UnsetMarginBottom().UnsetPadding().Render("+ Add track")
+	}
+	if len(t.song.Tracks) < maxTracks {
+		lines = append(lines, presetTextStyle.Render(add))
+	}
+
+	switch pl := t.player; {
+	case t.recording:
+		verb := "Recording"
+		if t.overdub {
+			verb = "Overdubbing"
+		}
+		lines = append(lines, "", instStyle.UnsetMarginBottom().Render(fmt.Sprintf("● %s %s  %s",
+			verb, t.song.Tracks[t.armed].Name, clock(time.Since(t.since).Seconds()))))
+	case pl != nil && pl.playing:
+		lines = append(lines, "", instStyle.UnsetMarginBottom().Render(fmt.Sprintf("▶ %s / %s",
+			clock(pl.pos), clock(pl.take.Length))))
+	}
+
+	help := "↑/↓/←/→: Move  •  ENTER: Arm / Overdub / Toggle / Take Instrument  •  CTRL+R: Record Armed  •  CTRL+Z: Undo  •  SPACE: Play  •  BKSP: Clear  •  ESC/CTRL+T: Close"
+	if t.recording {
+		help = "CTRL+R: Stop Recording  •  ESC/CTRL+T: Stop and Close"
+	}
+	lines = append(lines, helpStyle.Render(help))
+	return visStyle.Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
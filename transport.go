@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// --- TRANSPORT ---
+//
+// One clock for everything that keeps time: the drum machine, the
+// metronome, quantizing, and the tempo a take is recorded at. The tempo
+// is shown in the header. It's set in the settings overlay, with < > in
+// the drum machine, or by tapping CTRL+B along with the music; CTRL+K
+// clicks the metronome along with it. Recordings start after a bar or
+// two of count-in, so the first note lands where it was meant to. Fields
+// are changed under output.Lock().
+
+const (
+	minBPM     = 40
+	maxBPM     = 300
+	tapReset   = 2 * time.Second // a longer pause starts a new count
+	tapAverage = 4               // intervals averaged for the tempo
+	maxCountIn = 2               // bars
+	beatsInBar = 4
+)
+
+type Transport struct {
+	bpm     float64
+	swing   float64    // percent of a pair of sixteenths the first one takes, 50-75
+	countIn float64    // bars counted in before recording, 0 to maxCountIn
+	click   *Metronome // playing with the transport, nil when off
+}
+
+var transport = &Transport{bpm: 120, swing: 50, countIn: 1}
+
+// countIn is a count-in under way: the metronome clicks until the
+// recording starts.
+type countIn struct {
+	start  time.Time
+	beat   time.Duration
+	tracks bool       // the recording is a pass in the tracks panel
+	click  *Metronome // clicking just for the count, nil when it's the transport's
+}
+
+// setBPM changes the tempo, held to the range the clock allows.
+func (t *Transport) setBPM(bpm float64) {
+	t.bpm = max(minBPM, min(maxBPM, bpm))
+}
+
+// tapTempo takes a tap of CTRL+B. From the second tap on, the tempo is
+// the average of the last few intervals.
+func (m model) tapTempo(now time.Time) model {
+	if n := len(m.taps); n > 0 && now.Sub(m.taps[n-1]) > tapReset {
+		m.taps = nil
+	}
+	m.taps = append(m.taps, now)
+	if len(m.taps) > tapAverage+1 {
+		m.taps = m.taps[len(m.taps)-tapAverage-1:]
+	}
+
+	m.notifyClearTime = now.Add(2 * time.Second)
+	if len(m.taps) < 2 {
+		m.notification = "Tap again to set the tempo"
+		return m
+	}
+	beat := now.Sub(m.taps[0]).Seconds() / float64(len(m.taps)-1)
+	output.Lock()
+	transport.setBPM(60 / beat)
+	m.bpm = transport.bpm
+	output.Unlock()
+	m.notification = fmt.Sprintf("Tempo %.0f BPM", m.bpm)
+	return m
+}
+
+// toggleClick starts or stops the metronome on the transport.
+func (m model) toggleClick() model {
+	output.Lock()
+	if transport.click != nil {
+		transport.click.stopped = true
+		transport.click = nil
+	} else {
+		transport.click = newMetronome(0)
+		mainOut.sources.Add(transport.click)
+	}
+	m.click = transport.click != nil
+	output.Unlock()
+	return m
+}
+
+// startCountIn counts in the recording, or starts it straight away when
+// the count-in is off. The transport's metronome starts its bar over to
+// count; otherwise one clicks for the count alone.
+func (m model) startCountIn(tracks bool) model {
+	now := time.Now()
+	output.Lock()
+	bars := int(transport.countIn)
+	beat := time.Duration(60 / transport.bpm * float64(time.Second))
+	var click *Metronome
+	if bars > 0 {
+		if click = transport.click; click != nil {
+			click.pos, click.beat, click.phase = 0, 0, 0
+			click = nil
+		} else {
+			click = newMetronome(0)
+			mainOut.sources.Add(click)
+		}
+	}
+	output.Unlock()
+
+	if bars == 0 {
+		return m.startCounted(tracks, now)
+	}
+	m.count = &countIn{start: now.Add(beat * time.Duration(bars*beatsInBar)), beat: beat, tracks: tracks, click: click}
+	return m
+}
+
+// cancelCountIn stops a count-in without recording.
+func (m model) cancelCountIn() model {
+	if m.count != nil && m.count.click != nil {
+		output.Lock()
+		m.count.click.stopped = true
+		output.Unlock()
+	}
+	m.count = nil
+	return m
+}
+
+// followCountIn starts the recording once the count is up, on the beat
+// it was due rather than the tick that noticed.
+func (m model) followCountIn(now time.Time) model {
+	c := m.count
+	switch {
+	case c.tracks && !m.tracks.open:
+		return m.cancelCountIn()
+	case now.Before(c.start):
+		return m
+	}
+	m = m.cancelCountIn()
+	return m.startCounted(c.tracks, c.start)
+}
+
+func (m model) startCounted(tracks bool, at time.Time) model {
+	if tracks {
+		return m.startTrackRecording(at)
+	}
+	return m.startRecording(at)
+}
+
+// label is the beats left in the count, for the header.
+func (c *countIn) label(now time.Time) string {
+	left := int(c.start.Sub(now)/c.beat) + 1
+	return fmt.Sprintf("Count-in %d", max(1, left))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// --- UNDERRUNS ---
+//
+// An underrun is the backend running out of sound: the render callback came
+// so late that everything queued had been played, which is heard as a click
+// or a gap. The callback is timed as it starts, and a wait since the last
+// one longer than the sound that one made plus the whole of the backend's
+// buffer means the queue ran dry. Each lights a warning in the header for a
+// moment and is counted in the status bar.
+//
+// With "auto_buffer" in the config, or -auto-buffer, the buffer doubles
+// after an underrun, up to half a second, on the backends that can change
+// it while they play: speaker, null and PortAudio. JACK's server keeps its
+// own.
+
+const (
+	underrunShown = 3 * time.Second
+	maxBufferTime = 500 * time.Millisecond
+)
+
+var (
+	autoBuffer bool
+
+	// bufferMu guards outputBuffer and the backend's size while it grows.
+	bufferMu sync.Mutex
+	grownFor int // underruns the buffer has already grown for
+)
+
+// underrunWatch times the render callback, under output.Lock().
+type underrunWatch struct {
+	count int
+	last  time.Time     // of the latest underrun
+	prev  time.Time     // when the last callback started
+	owed  time.Duration // of sound that callback made
+	slack time.Duration // the backend's buffer
+}
+
+// check is called as each callback starts, with the frames it's asked for.
+func (w *underrunWatch) check(now time.Time, n int) {
+	if !w.prev.IsZero() && w.slack > 0 && now.Sub(w.prev) > w.owed+w.slack {
+		w.count++
+		w.last = now
+	}
+	w.prev, w.owed = now, sampleRate.D(n)
+}
+
+// restart times the callback afresh, the backend's buffer being slack.
+func (w *underrunWatch) restart(slack time.Duration) {
+	w.prev, w.slack = time.Time{}, slack
+}
+
+// growBuffer doubles the backend's buffer if there have been underruns
+// since it last did, reporting what it did for a notification.
+func growBuffer(underruns int) (string, bool) {
+	bufferMu.Lock()
+	defer bufferMu.Unlock()
+	if underruns <= grownFor {
+		return "", false
+	}
+	grownFor = underruns
+	_, ok := output.(bufferResizer)
+	size := min(outputBuffer*2, sampleRate.N(maxBufferTime))
+	if !ok || size <= outputBuffer {
+		return "", false
+	}
+	if err := setBuffer(size); err != nil {
+		return "Buffer: " + err.Error(), true
+	}
+	return fmt.Sprintf("Underrun: buffer raised to %d (%s)", size, millis(sampleRate.D(size))), true
+}
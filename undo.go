@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// --- UNDO ---
+//
+// CTRL+Z takes back the last edit and CTRL+Y puts it back. Edits are the
+// takes recorded, every change in the tracks panel, quantizing and
+// deleting notes in the piano roll, and instrument sliders moved in the
+// editor, the partials editor or with the filter keys. Takes are saved as
+// they're edited, so undoing one writes it back as it was. The history
+// lasts the session, and an edit made after undoing drops what could
+// have been redone.
+
+const maxUndo = 100
+
+// edit is a step of the history. Steps with the same merge key one after
+// another are one edit, so nudging a slider is undone in one go.
+type edit struct {
+	name       string
+	merge      string
+	undo, redo func(m model) model
+}
+
+type editHistory struct {
+	done, undone []edit
+}
+
+// did adds an edit that's just been made to the history.
+func (m model) did(e edit) model {
+	h := &m.history
+	h.undone = nil
+	if n := len(h.done); n > 0 && e.merge != "" && h.done[n-1].merge == e.merge {
+		h.done[n-1].redo = e.redo
+		return m
+	}
+	h.done = append(h.done, e)
+	if len(h.done) > maxUndo {
+		h.done = h.done[1:]
+	}
+	return m
+}
+
+func (m model) undo() model {
+	h := &m.history
+	m.notifyClearTime = time.Now().Add(2 * time.Second)
+	switch {
+	case m.recording || m.count != nil:
+		m.notification = "Stop recording to undo"
+		return m
+	case len(h.done) == 0:
+		m.notification = "Nothing to undo"
+		return m
+	}
+	e := h.done[len(h.done)-1]
+	h.done = h.done[:len(h.done)-1]
+	h.undone = append(h.undone, e)
+	m.notification = "Undid " + e.name
+	return e.undo(m)
+}
+
+func (m model) redo() model {
+	h := &m.history
+	m.notifyClearTime = time.Now().Add(2 * time.Second)
+	switch {
+	case m.recording || m.count != nil:
+		m.notification = "Stop recording to redo"
+		return m
+	case len(h.undone) == 0:
+		m.notification = "Nothing to redo"
+		return m
+	}
+	e := h.undone[len(h.undone)-1]
+	h.undone = h.undone[:len(h.undone)-1]
+	h.done = append(h.done, e)
+	m.notification = "Redid " + e.name
+	return e.redo(m)
+}
+
+// takeEdited records a take saved at path going from before to after.
+// A nil take is one that wasn't there.
+func (m model) takeEdited(name, path string, before, after *take) model {
+	return m.did(edit{
+		name: name,
+		undo: func(m model) model { return m.putTake(path, before) },
+		redo: func(m model) model { return m.putTake(path, after) },
+	})
+}
+
+// putTake saves a take as an edit left it, or removes it, and shows it
+// that way wherever it's open.
+func (m model) putTake(path string, tk *take) model {
+	var err error
+	if tk == nil {
+		err = os.Remove(path)
+	} else {
+		err = writeTake(path, *tk)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		m.notification = fmt.Sprintf("Undo: %v", err)
+	}
+
+	if r := &m.roll; r.open && !r.live && r.path == path {
+		if tk == nil {
+			*r = pianoRoll{}
+		} else {
+			r.load(tk.clone())
+		}
+	}
+	if t := &m.tracks; t.path == path {
+		if t.player != nil {
+			t.player.pause()
+			t.player = nil
+		}
+		if tk == nil {
+			*t = tracksPanel{open: t.open, song: newSong()}
+		} else {
+			t.song = asSong(tk.clone())
+			t.row = min(t.row, len(t.song.Tracks))
+			t.armed = min(t.armed, len(t.song.Tracks)-1)
+		}
+	}
+	if m.replay.open {
+		if takes, err := listTakes(takesDir()); err == nil {
+			m.replay.takes = takes
+			m.replay.cursor = max(0, min(m.replay.cursor, len(takes)-1))
+		}
+	}
+	return m
+}
+
+// adjustInstrument nudges a slider of the current instrument.
+func (m model) adjustInstrument(pp patchParam, dir int) model {
+	from, to := adjustParam(pp, dir)
+	return m.instrumentEdited(pp, from, to)
+}
+
+// instrumentEdited records a slider of the current instrument moved.
+func (m model) instrumentEdited(pp patchParam, from, to float64) model {
+	if from == to {
+		return m
+	}
+	name := instruments[currentInstID].Name
+	set := func(v float64) func(model) model {
+		return func(m model) model {
+			output.Lock()
+			if inst := findInstrument(name); inst != nil {
+				*pp.Field(inst) = v
+			}
+			output.Unlock()
+			return m
+		}
+	}
+	return m.did(edit{name: name + " " + pp.Name, merge: name + "/" + pp.Name, undo: set(from), redo: set(to)})
+}
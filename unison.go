@@ -0,0 +1,97 @@
+package main
+
+import "math"
+
+// --- UNISON ---
+//
+// A patch with Unison above 1 plays that many copies of the oscillator per
+// note, detuned evenly across ±Detune cents and panned across the stereo
+// field by Spread. Each copy has its own oscillator, started at a random
+// phase so they don't comb against each other at the attack.
+
+const maxUnison = 7
+
+// unisonSpread is the per-copy tuning and panning worked out once per
+// block from the patch.
+type unisonSpread struct {
+	n            int
+	ratio        [maxUnison]float64
+	gainL, gainR [maxUnison]float64
+}
+
+func newUnisonSpread(p *Patch) unisonSpread {
+	u := unisonSpread{n: max(1, min(maxUnison, int(p.Unison)))}
+	if u.n == 1 {
+		u.ratio[0], u.gainL[0], u.gainR[0] = 1, 1, 1
+		return u
+	}
+
+	// Normalised so stacking copies doesn't get louder
+	norm := 1 / math.Sqrt(float64(u.n))
+	for j := 0; j < u.n; j++ {
+		pos := float64(j)/float64(u.n-1)*2 - 1 // -1..1
+		u.ratio[j] = math.Exp2(pos * p.Detune / 1200)
+		l, r := panGains(pos * p.Spread)
+		u.gainL[j], u.gainR[j] = l*norm, r*norm
+	}
+	return u
+}
+
+// newUnisonOscs builds an oscillator for every unison copy. They're all
+// made up front, so Unison can be raised while notes sound.
+func newUnisonOscs(inst *Instrument) [maxUnison]Oscillator {
+	var oscs [maxUnison]Oscillator
+	for j := range oscs {
+		oscs[j] = inst.newOsc()
+		if sc, ok := oscs[j].(scatterer); ok && j > 0 {
+			sc.scatter()
+		}
+	}
+	return oscs
+}
+
+// newSub builds inst's sub oscillator, a plain sine if it doesn't declare
+// one.
+func newSub(inst *Instrument) Oscillator {
+	if inst.Sub != nil {
+		return inst.Sub()
+	}
+	return sineWave()
+}
+
+// oscillate renders one sample of every unison copy plus the sub
+// oscillator at freq (scaled per copy).
+func (s *SynthStreamer) oscillate(freq float64, u *unisonSpread) (l, r float64) {
+	sr := float64(sampleRate)
+
+	// The sub keeps running while it's turned down, so raising it later
+	// doesn't start it mid-cycle with a click
+	sub := s.sub.NextSample(freq/2, sr)
+	if s.subLevel > 0.001 {
+		l, r = sub*s.subLevel, sub*s.subLevel
+	}
+
+	if s.fm != nil {
+		s.fm.advance(s.releasing)
+		step := freq * 2 * math.Pi / sr
+		for j := 0; j < u.n; j++ {
+			v := s.fm.render(j, step*u.ratio[j])
+			l += v * u.gainL[j]
+			r += v * u.gainR[j]
+		}
+		return l, r
+	}
+
+	factor := oversampling(s.patch)
+	for j := 0; j < u.n; j++ {
+		var v float64
+		if factor > 1 {
+			v = s.aa[j].next(s.oscs[j], freq*u.ratio[j], sr, factor)
+		} else {
+			v = s.oscs[j].NextSample(freq*u.ratio[j], sr)
+		}
+		l += v * u.gainL[j]
+		r += v * u.gainR[j]
+	}
+	return l, r
+}
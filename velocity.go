@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// --- KEYSTROKE VELOCITY ---
+//
+// Terminals only tell us that a key went down, not how hard. Two stand-ins
+// are offered: a fixed level per keyboard row, or the speed of repeated
+// strikes on the same key (hammering a key plays it louder).
+
+const (
+	velocityFixed = iota
+	velocityRows
+	velocityTap
+)
+
+var velocityModeNames = []string{"Fixed", "Per-Row", "Tap Speed"}
+
+const (
+	// Events closer than this are terminal auto-repeat of a held key,
+	// the same window updateVoice uses to keep a voice sustained.
+	repeatWindow = 75 * time.Millisecond
+
+	tapFast    = 120 * time.Millisecond
+	tapSlow    = 800 * time.Millisecond
+	tapMinimum = 0.35
+	tapDefault = 0.7
+)
+
+type velocityTracker struct {
+	mode      int
+	rowLevels [3]float64
+	last      float64
+
+	lastEvent  map[string]time.Time
+	lastStrike map[string]time.Time
+}
+
+func newVelocityTracker(rowLevels [3]float64) *velocityTracker {
+	return &velocityTracker{
+		rowLevels:  rowLevels,
+		last:       1.0,
+		lastEvent:  make(map[string]time.Time),
+		lastStrike: make(map[string]time.Time),
+	}
+}
+
+func (v *velocityTracker) nextMode() {
+	v.mode = (v.mode + 1) % len(velocityModeNames)
+}
+
+// strike is called for every key event of a note and returns the velocity
+// a newly triggered voice should get.
+func (v *velocityTracker) strike(n Note) float64 {
+	now := time.Now()
+	prevEvent, held := v.lastEvent[n.Key]
+	v.lastEvent[n.Key] = now
+	if held && now.Sub(prevEvent) < repeatWindow {
+		return v.last
+	}
+
+	prevStrike, struck := v.lastStrike[n.Key]
+	v.lastStrike[n.Key] = now
+
+	switch v.mode {
+	case velocityRows:
+		v.last = v.rowLevels[n.Row]
+	case velocityTap:
+		if !struck {
+			v.last = tapDefault
+			break
+		}
+		gap := now.Sub(prevStrike)
+		switch {
+		case gap <= tapFast:
+			v.last = 1.0
+		case gap >= tapSlow:
+			v.last = tapMinimum
+		default:
+			t := float64(gap-tapFast) / float64(tapSlow-tapFast)
+			v.last = 1.0 - t*(1.0-tapMinimum)
+		}
+	default:
+		v.last = 1.0
+	}
+	return v.last
+}
+
+func (v *velocityTracker) label() string {
+	if v.mode == velocityFixed {
+		return velocityModeNames[v.mode]
+	}
+	return fmt.Sprintf("%s %d%%", velocityModeNames[v.mode], int(v.last*100))
+}
+
+const (
+	humanizeGain  = 0.15                  // max gain deviation at Humanize 1
+	humanizeOnset = 25 * time.Millisecond // max start delay at Humanize 1
+)
+
+// humanize nudges a live note's velocity and start time by up to the
+// instrument's Humanize amount.
+func humanize(inst *Instrument, velocity float64) (float64, int) {
+	if inst.Humanize <= 0 {
+		return velocity, 0
+	}
+
+	gain := 1 + (rand.Float64()*2-1)*humanizeGain*inst.Humanize
+	velocity = math.Min(velocity*gain, 1.0)
+	delay := sampleRate.N(time.Duration(rand.Float64() * inst.Humanize * float64(humanizeOnset)))
+	return velocity, delay
+}
+
+// applyHumanizeConfig overrides the built-in amounts with the config's
+// per-instrument values, keyed by instrument name.
+func applyHumanizeConfig(amounts map[string]float64) {
+	for i := range instruments {
+		if amt, ok := amounts[instruments[i].Name]; ok {
+			instruments[i].Humanize = math.Max(0, math.Min(1, amt))
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// --- STILL VISUALIZERS ---
+//
+// "visualizer" in the config picks what sits above the keyboard while no
+// panel is open. "spectrum", the default, is the animated one, and
+// "braille" draws the same spectrum in braille dots, two across and four
+// down in each character, as a smooth curve instead of bars. For anyone
+// bothered by flashing, and for slow SSH links where its redraws add up,
+// "notes" is a still readout of the notes held by name, and "meter" is a
+// bar for each octave showing how loud its notes are, redrawn no more
+// than twice a second.
+
+const (
+	visSpectrum = iota
+	visNotes
+	visMeter
+	visBraille
+)
+
+var visualizerNames = []string{"spectrum", "notes", "meter", "braille"}
+
+const (
+	meterEvery   = 500 * time.Millisecond
+	meterOctaves = 7 // from the first
+	visHeight    = 7 // the spectrum's, so the panel keeps its size
+)
+
+type visualizerMode struct {
+	style  int
+	notes  []string  // held, low to high
+	levels []float64 // the meter's, one per octave
+	next   time.Time // when the meter may move again
+}
+
+// visualizerStyle looks up a "visualizer" from the config; an empty one
+// is the spectrum.
+func visualizerStyle(name string) (int, error) {
+	if name == "" {
+		return visSpectrum, nil
+	}
+	if i := slices.Index(visualizerNames, strings.ToLower(name)); i >= 0 {
+		return i, nil
+	}
+	return visSpectrum, fmt.Errorf("unknown visualizer %q (%s)", name, strings.Join(visualizerNames, ", "))
+}
+
+// follow takes the notes sounding this tick.
+func (v *visualizerMode) follow(held map[string]heldNote, now time.Time) {
+	switch v.style {
+	case visNotes:
+		var midis []int
+		for _, h := range held {
+			if n, ok := midiNote(h.freq); ok && !slices.Contains(midis, int(n)) {
+				midis = append(midis, int(n))
+			}
+		}
+		slices.Sort(midis)
+		v.notes = v.notes[:0]
+		for _, n := range midis {
+			v.notes = append(v.notes, pitchName(n))
+		}
+	case visMeter:
+		if now.Before(v.next) {
+			return
+		}
+		v.next = now.Add(meterEvery)
+		v.levels = make([]float64, meterOctaves)
+		for _, h := range held {
+			n, ok := midiNote(h.freq)
+			if i := int(n)/12 - 2; ok && i >= 0 && i < meterOctaves {
+				v.levels[i] = max(v.levels[i], h.velocity)
+			}
+		}
+	}
+}
+
+// visSize is the spectrum's size in characters.
+func visSize(l layout) (width, height int) {
+	if l.compact {
+		return min(numBars*2, l.width), 3
+	}
+	return numBars * 2, visHeight
+}
+
+// spectrumCurve spreads the spectrum over cols points. Between the bars'
+// centres it eases along a cosine, so a note is a smooth hill.
+func (m model) spectrumCurve(cols int) []float64 {
+	curve := make([]float64, cols)
+	for x := range curve {
+		at := float64(x) / float64(cols-1) * float64(len(m.spectrum)-1)
+		i := min(int(at), len(m.spectrum)-2)
+		t := (1 - math.Cos((at-float64(i))*math.Pi)) / 2
+		curve[x] = m.spectrum[i]*(1-t) + m.spectrum[i+1]*t
+	}
+	return curve
+}
+
+// brailleView draws the spectrum in braille, mirrored about its middle
+// line like the bars.
+func (m model) brailleView(l layout) string {
+	width, height := visSize(l)
+	curve := m.spectrumCurve(2 * width)
+	mid := float64(4*height) / 2
+	lines := brailleRows(width, height, func(x, y int) bool {
+		return math.Abs(float64(y)+0.5-mid) <= max(curve[x]*mid, 0.5)
+	})
+	for i, line := range lines {
+		lines[i] = waveColor.Render(line)
+	}
+	return l.vis().Render(strings.Join(lines, "\n"))
+}
+
+// brailleDots are the bits of a braille cell's dots, by column and row.
+var brailleDots = [2][4]rune{{0x01, 0x02, 0x04, 0x40}, {0x08, 0x10, 0x20, 0x80}}
+
+// brailleRows draws width by height characters of braille, with the dot
+// at x, y, counted from the top left, raised where dot says.
+func brailleRows(width, height int, dot func(x, y int) bool) []string {
+	lines := make([]string, height)
+	for r := range lines {
+		var line strings.Builder
+		for c := 0; c < width; c++ {
+			cell := rune(0x2800)
+			for dx := range 2 {
+				for dy := range 4 {
+					if dot(2*c+dx, 4*r+dy) {
+						cell |= brailleDots[dx][dy]
+					}
+				}
+			}
+			line.WriteRune(cell)
+		}
+		lines[r] = line.String()
+	}
+	return lines
+}
+
+// stillView draws the notes or meter visualizer.
+func (m model) stillView(l layout) string {
+	var block string
+	if m.vis.style == visNotes {
+		text := "—"
+		if len(m.vis.notes) > 0 {
+			text = strings.Join(m.vis.notes, "  ")
+			if m.chord != "" && !m.ear.open {
+				text += "   " + m.chord
+			}
+		}
+		block = presetTextStyle.Bold(true).Render(text)
+	} else {
+		levels := m.vis.levels
+		if levels == nil {
+			levels = make([]float64, meterOctaves)
+		}
+		labels := make([]string, meterOctaves)
+		for i := range labels {
+			labels[i] = fmt.Sprint(i + 1)
+		}
+		height := visHeight - 1
+		if l.compact {
+			height = 2
+		}
+		rows := barChart(levels, labels, height, 1)
+		for i, row := range rows[:len(rows)-1] {
+			rows[i] = waveColor.Render(row)
+		}
+		rows[len(rows)-1] = presetTitleStyle.UnsetMarginTop().UnsetMarginBottom().Render(rows[len(rows)-1])
+		block = lipgloss.JoinVertical(lipgloss.Left, rows...)
+	}
+	width, height := numBars*2, visHeight
+	if l.compact {
+		width, height = min(width, l.width), 3
+	}
+	return l.vis().Render(lipgloss.Place(width, height, lipgloss.Center, lipgloss.Center, block))
+}
@@ -0,0 +1,193 @@
+package main
+
+import "sync/atomic"
+
+// --- VOICE BANK ---
+//
+// The voices sounding belong to the render callback alone: they're kept in
+// bank, which the master bus streams, and nothing else touches them once
+// they've been handed over. Everywhere else a note is started, released,
+// held on or retuned by pushing a command onto bank's queue, a ring the
+// callback empties at the start of each buffer, so playing notes takes no
+// lock the callback shares and the UI never writes a voice the audio is
+// reading. The UI keeps its own record of each voice in voices, under
+// voiceLock, which also makes its goroutines one producer for the ring.
+// A voice tells the UI it's finished through an atomic flag.
+//
+// Settings are another matter. The volume, patches, master effects and the
+// rest are still changed under output.Lock(), which the render callbacks
+// hold while they render, so they can wait on the UI for as long as an
+// edit takes. Edits are kept to a few field writes. JACK's callback only
+// tries the lock, see jack.go.
+
+// voiceQueueSize is how many commands can wait for the callback. It's far
+// more than a buffer's worth of playing; a full queue means the callback
+// has stalled, and commands are dropped rather than block the UI.
+const voiceQueueSize = 1024 // a power of two
+
+type voiceOp uint8
+
+const (
+	opStart voiceOp = iota
+	opRelease
+	opSustain
+	opRetune
+	opChoke
+	opSilence // every voice
+)
+
+type voiceCmd struct {
+	op    voiceOp
+	voice *SynthStreamer
+	freq  float64 // opRetune's
+	glide float64 // opRetune's coefficient; 0 jumps straight there
+}
+
+// voiceQueue is a single-producer, single-consumer ring: head is only
+// moved by the callback and tail only under voiceLock.
+type voiceQueue struct {
+	cmds       [voiceQueueSize]voiceCmd
+	head, tail atomic.Uint64
+}
+
+func (q *voiceQueue) push(c voiceCmd) bool {
+	t := q.tail.Load()
+	if t-q.head.Load() == voiceQueueSize {
+		return false
+	}
+	q.cmds[t%voiceQueueSize] = c
+	q.tail.Store(t + 1)
+	return true
+}
+
+func (q *voiceQueue) pop() (voiceCmd, bool) {
+	h := q.head.Load()
+	if h == q.tail.Load() {
+		return voiceCmd{}, false
+	}
+	c := q.cmds[h%voiceQueueSize]
+	q.cmds[h%voiceQueueSize] = voiceCmd{} // don't keep the voice alive
+	q.head.Store(h + 1)
+	return c, true
+}
+
+type voiceBank struct {
+	queue   voiceQueue
+	playing []*SynthStreamer
+	buf     [][2]float64
+}
+
+var bank = &voiceBank{playing: make([]*SynthStreamer, 0, 256)}
+
+// silence fades every voice out, drum hits too, over panicFadeMs. Callers
+// hold voiceLock.
+func (b *voiceBank) silence() {
+	b.queue.push(voiceCmd{op: opSilence})
+}
+
+func (b *voiceBank) apply(c voiceCmd) {
+	switch c.op {
+	case opStart:
+		b.playing = append(b.playing, c.voice)
+	case opRelease:
+		c.voice.Stop()
+	case opSustain:
+		c.voice.Sustain()
+	case opChoke:
+		c.voice.Choke()
+	case opRetune:
+		c.voice.target = c.freq
+		if c.glide > 0 {
+			c.voice.glide = c.glide
+		} else {
+			c.voice.freq = c.freq
+		}
+	case opSilence:
+		for _, s := range b.playing {
+			s.fadeOut(panicFadeMs)
+		}
+	}
+}
+
+// Stream takes the commands waiting and mixes the voices, dropping those
+// that have finished.
+func (b *voiceBank) Stream(samples [][2]float64) (n int, ok bool) {
+	for c, ok := b.queue.pop(); ok; c, ok = b.queue.pop() {
+		b.apply(c)
+	}
+	if len(b.buf) < len(samples) {
+		b.buf = make([][2]float64, len(samples))
+	}
+	clear(samples)
+	kept := b.playing[:0]
+	for _, s := range b.playing {
+		buf := b.buf[:len(samples)]
+		n, ok := s.Stream(buf)
+		if !finite(buf[:n]) {
+			// Its state is poisoned for good, see sanitize.go.
+			s.ended.Store(true)
+			continue
+		}
+		for i := range buf[:n] {
+			samples[i][0] += buf[i][0]
+			samples[i][1] += buf[i][1]
+		}
+		if ok {
+			kept = append(kept, s)
+		} else {
+			s.ended.Store(true)
+		}
+	}
+	clear(b.playing[len(kept):])
+	b.playing = kept
+	return len(samples), true
+}
+
+func (b *voiceBank) Err() error { return nil }
+
+// send queues a command for the voice, noting where it is in the queue
+// so the pool knows when the callback is done with it. Like the rest of
+// ActiveVoice's methods, it's called with voiceLock held.
+func (v *ActiveVoice) send(c voiceCmd) bool {
+	c.voice = v.streamer
+	if !bank.queue.push(c) {
+		return false
+	}
+	v.sent = bank.queue.tail.Load()
+	return true
+}
+
+// start hands the voice over to the callback. One that can't be is
+// finished already.
+func (v *ActiveVoice) start() {
+	if !v.send(voiceCmd{op: opStart}) {
+		v.streamer.ended.Store(true)
+	}
+}
+
+// release lets the voice go; drum hits play out anyway.
+func (v *ActiveVoice) release() {
+	if v.released {
+		return
+	}
+	if v.streamer.drum == nil {
+		v.released = true
+	}
+	v.send(voiceCmd{op: opRelease})
+}
+
+// sustain takes back a release that hasn't finished.
+func (v *ActiveVoice) sustain() {
+	v.released = false
+	v.send(voiceCmd{op: opSustain})
+}
+
+// retune moves the voice to freq, gliding with the given coefficient.
+func (v *ActiveVoice) retune(freq, glide float64) {
+	v.send(voiceCmd{op: opRetune, freq: freq, glide: glide})
+}
+
+// finished reports whether the voice has played out.
+func (v *ActiveVoice) finished() bool {
+	return v.streamer.ended.Load()
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// --- WARM-UP ---
+//
+// Ctrl+W opens a daily drill: a handful of scales, arpeggios and interval
+// jumps generated from the date and the player's level, each one a little
+// faster than the last, played against the metronome. Progress is kept in
+// ~/.config/piango/warmup.json and the level moves up or down depending on
+// how cleanly the day's set was played.
+
+const (
+	maxWarmupLevel = 10
+	warmupStepBPM  = 4 // tempo increase from one exercise to the next
+)
+
+type exercise struct {
+	Name string
+	Keys []string
+	BPM  float64
+}
+
+type warmupDay struct {
+	Completed int `json:"completed"`
+	Total     int `json:"total"`
+	Notes     int `json:"notes"`
+	Mistakes  int `json:"mistakes"`
+}
+
+type warmupStats struct {
+	Level   int                  `json:"level"`
+	History map[string]warmupDay `json:"history"` // keyed by date
+}
+
+type warmupMode struct {
+	open      bool
+	date      string
+	stats     warmupStats
+	plan      []exercise
+	current   int
+	pos       int // next note to play in the current exercise
+	mistakes  int
+	running   bool
+	metronome *Metronome
+}
+
+func warmupPath() string {
+	dir := configDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "warmup.json")
+}
+
+func loadWarmupStats(path string) (warmupStats, error) {
+	st := warmupStats{Level: 1, History: make(map[string]warmupDay)}
+	if path == "" {
+		return st, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return st, err
+	}
+	if err := json.Unmarshal(data, &st); err != nil {
+		return st, fmt.Errorf("%s: %w", path, err)
+	}
+	st.Level = max(1, min(maxWarmupLevel, st.Level))
+	if st.History == nil {
+		st.History = make(map[string]warmupDay)
+	}
+	return st, nil
+}
+
+func saveWarmupStats(path string, st warmupStats) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// streak counts the days in a row, ending today, whose set was finished.
+func (st warmupStats) streak(today time.Time) int {
+	n := 0
+	for d := today; ; d = d.AddDate(0, 0, -1) {
+		day, ok := st.History[d.Format(time.DateOnly)]
+		if !ok || day.Total == 0 || day.Completed < day.Total {
+			if n == 0 && d.Equal(today) {
+				continue // today isn't over yet
+			}
+			return n
+		}
+		n++
+	}
+}
+
+var (
+	modeNames     = []string{"Ionian", "Dorian", "Phrygian", "Lydian", "Mixolydian", "Aeolian", "Locrian"}
+	triadNames    = []string{"major", "minor", "minor", "major", "major", "minor", "dim"}
+	intervalNames = map[int]string{2: "3rds", 3: "4ths", 4: "5ths", 5: "6ths", 6: "7ths", 7: "Octaves"}
+)
+
+// warmupKeys lists the keyboard from the lowest note to the highest, so
+// an index is a scale degree counted from the bottom Do.
+func warmupKeys() []Note {
+	var keys []Note
+	for r := len(sortedRows) - 1; r >= 0; r-- {
+		keys = append(keys, sortedRows[r]...)
+	}
+	return keys
+}
+
+// generateWarmup builds the day's set. The same date and level always give
+// the same exercises, so reopening the mode resumes the same drill.
+func generateWarmup(date string, level int) []exercise {
+	var seed int64
+	for _, c := range date {
+		seed = seed*31 + int64(c)
+	}
+	rng := rand.New(rand.NewSource(seed*100 + int64(level)))
+	keys := warmupKeys()
+	top := len(keys) - 1
+
+	octaves := 1
+	if level >= 3 {
+		octaves = 2
+	}
+	widest := min(2+level/2, 7)
+	count := min(4+level/3, 7)
+	bpm := 60.0 + 6*float64(level-1)
+
+	toKeys := func(idx []int) []string {
+		out := make([]string, len(idx))
+		for i, n := range idx {
+			out[i] = keys[n].Key
+		}
+		return out
+	}
+
+	// Up and back down, without repeating the top note
+	upDown := func(up []int) []int {
+		seq := append([]int{}, up...)
+		for i := len(up) - 2; i >= 0; i-- {
+			seq = append(seq, up[i])
+		}
+		return seq
+	}
+
+	var plan []exercise
+	first := rng.Intn(3)
+	for i := 0; i < count; i++ {
+		d := rng.Intn(7)
+		if octaves == 1 && rng.Intn(2) == 1 {
+			d += 7
+		}
+		var ex exercise
+
+		switch (first + i) % 3 {
+		case 0:
+			var up []int
+			for n := d; n <= d+7*octaves; n++ {
+				up = append(up, n)
+			}
+			ex.Name = fmt.Sprintf("%s scale (%s)", keys[d].Name, modeNames[d%7])
+			ex.Keys = toKeys(upDown(up))
+
+		case 1:
+			var up []int
+			for o := 0; o < octaves; o++ {
+				up = append(up, d+7*o, d+7*o+2, d+7*o+4)
+			}
+			up = append(up, d+7*octaves)
+			ex.Name = fmt.Sprintf("%s %s arpeggio", keys[d].Name, triadNames[d%7])
+			ex.Keys = toKeys(upDown(up))
+
+		case 2:
+			k := 2 + rng.Intn(widest-1)
+			base := rng.Intn(top - 5 - k + 1)
+			var seq []int
+			for j := 0; j < 6; j++ {
+				seq = append(seq, base+j, base+j+k)
+			}
+			ex.Name = fmt.Sprintf("Broken %s from %s", intervalNames[k], keys[base].Name)
+			ex.Keys = toKeys(seq)
+		}
+
+		ex.BPM = bpm + warmupStepBPM*float64(i)
+		plan = append(plan, ex)
+	}
+	return plan
+}
+
+// openWarmup loads the stats and picks up today's set where it was left.
+func (m model) openWarmup() model {
+	if currentKeyboard != kbSolfege {
+		return m.needSolfege("Warm-ups")
+	}
+	st, err := loadWarmupStats(warmupPath())
+	if err != nil {
+		m.notification = fmt.Sprintf("Warm-up: %v", err)
+		m.notifyClearTime = time.Now().Add(4 * time.Second)
+		return m
+	}
+
+	date := time.Now().Format(time.DateOnly)
+	plan := generateWarmup(date, st.Level)
+	if day, ok := st.History[date]; ok && day.Total == len(plan) {
+		m.warmup.current = min(day.Completed, len(plan))
+	} else {
+		m.warmup.current = 0
+	}
+
+	m.warmup.open = true
+	m.warmup.date = date
+	m.warmup.stats = st
+	m.warmup.plan = plan
+	m.warmup.pos = 0
+	m.warmup.mistakes = 0
+	return m
+}
+
+func (m model) closeWarmup() model {
+	m.warmup.stopMetronome()
+	m.warmup.open = false
+	m.warmup.running = false
+	return m
+}
+
+func (w *warmupMode) done() bool { return w.current >= len(w.plan) }
+
+func (w *warmupMode) start() {
+	w.stopMetronome()
+	w.pos = 0
+	w.mistakes = 0
+	w.running = true
+	w.metronome = newMetronome(w.plan[w.current].BPM)
+	playDry(w.metronome)
+}
+
+func (w *warmupMode) stopMetronome() {
+	if w.metronome == nil {
+		return
+	}
+	output.Lock()
+	w.metronome.stopped = true
+	output.Unlock()
+	w.metronome = nil
+}
+
+// handleWarmupKey consumes the keys the warm-up panel owns, reporting false
+// for anything else so note keys keep playing.
+func (m model) handleWarmupKey(msg tea.KeyMsg) (model, bool) {
+	switch msg.Type {
+	case tea.KeyCtrlW, tea.KeyEscape:
+		return m.closeWarmup(), true
+	case tea.KeyEnter:
+		if !m.warmup.running && !m.warmup.done() {
+			m.warmup.start()
+		}
+		return m, true
+	}
+	return m, false
+}
+
+// warmupHit checks a freshly struck key against the running exercise.
+func (m model) warmupHit(key string) model {
+	w := &m.warmup
+	if !w.open || !w.running {
+		return m
+	}
+
+	ex := w.plan[w.current]
+	if key != ex.Keys[w.pos] {
+		w.mistakes++
+		return m
+	}
+	w.pos++
+	if w.pos < len(ex.Keys) {
+		return m
+	}
+
+	// Exercise finished: record it, and level the player once the set is done
+	w.running = false
+	w.stopMetronome()
+	w.current++
+
+	day := w.stats.History[w.date]
+	day.Total = len(w.plan)
+	day.Completed = w.current
+	day.Notes += len(ex.Keys)
+	day.Mistakes += w.mistakes
+	w.stats.History[w.date] = day
+	m.practice.scored("Warm-up", len(ex.Keys), len(ex.Keys)+w.mistakes)
+
+	if w.done() {
+		accuracy := float64(day.Notes) / float64(day.Notes+day.Mistakes)
+		switch {
+		case accuracy >= 0.95 && w.stats.Level < maxWarmupLevel:
+			w.stats.Level++
+			m.notification = fmt.Sprintf("Warm-up done! Level up: %d", w.stats.Level)
+		case accuracy < 0.8 && w.stats.Level > 1:
+			w.stats.Level--
+			m.notification = fmt.Sprintf("Warm-up done. Easing off to level %d", w.stats.Level)
+		default:
+			m.notification = "Warm-up done!"
+		}
+		m.notifyClearTime = time.Now().Add(3 * time.Second)
+	}
+
+	if err := saveWarmupStats(warmupPath(), w.stats); err != nil {
+		m.notification = fmt.Sprintf("Warm-up: %v", err)
+		m.notifyClearTime = time.Now().Add(4 * time.Second)
+	}
+	return m
+}
+
+func (m model) warmupView() string {
+	w := m.warmup
+	today, _ := time.Parse(time.DateOnly, w.date)
+	title := fmt.Sprintf("--- WARM-UP • Level %d • Streak %d ---", w.stats.Level, w.stats.streak(today))
+	lines := []string{presetTitleStyle.Render(title)}
+
+	if w.done() {
+		day := w.stats.History[w.date]
+		lines = append(lines,
+			instStyle.UnsetMarginBottom().Render("All exercises done for today"),
+			presetTextStyle.Render(fmt.Sprintf("%d notes, %d mistakes", day.Notes, day.Mistakes)),
+			helpStyle.Render("ESC/CTRL+W: Close"))
+		return visStyle.Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+	}
+
+	ex := w.plan[w.current]
+	lines = append(lines, instStyle.UnsetMarginBottom().Render(
+		fmt.Sprintf("%d/%d  %s @ %.0f BPM", w.current+1, len(w.plan), ex.Name, ex.BPM)))
+
+	// The sequence, with played notes dimmed and the next one highlighted
+	played := helpStyle.UnsetMarginTop()
+	var seq []string
+	for i, k := range ex.Keys {
+		k = strings.ToUpper(k)
+		switch {
+		case w.running && i < w.pos:
+			seq = append(seq, played.Render(k))
+		case w.running && i == w.pos:
+			seq = append(seq, notifyStyle.UnsetMarginBottom().UnsetPadding().Render(k))
+		default:
+			seq = append(seq, presetTextStyle.Render(k))
+		}
+	}
+	lines = append(lines, "", strings.Join(seq, " "), "")
+
+	status := "ENTER: Start  •  ESC/CTRL+W: Close"
+	if w.running {
+		status = fmt.Sprintf("Mistakes: %d  •  ESC/CTRL+W: Close", w.mistakes)
+	}
+	lines = append(lines, helpStyle.UnsetMarginTop().Render(status))
+
+	return visStyle.Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
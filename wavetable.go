@@ -0,0 +1,55 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+)
+
+// --- WAVETABLES ---
+//
+// The fuller waveforms add up several sines for every sample of every
+// voice: five for the Accordion, four for the Glass Bell. "wavetables" in
+// the config, or -wavetables, plays the built-in waveforms from a table of
+// one cycle instead, worked out once at startup and read with linear
+// interpolation, so each sample is a lookup rather than the sines. That
+// matters on a Raspberry Pi. With 2048 points a cycle the difference is
+// far below hearing.
+
+const wavetableSize = 2048
+
+// waveTable is a Waveform and, once built, its table.
+type waveTable struct {
+	shape  Waveform
+	points []float64 // wavetableSize+1 of them, the last closing the cycle
+}
+
+// waveTables has every Waveform played through wave.
+var waveTables []*waveTable
+
+// sineWave is the plain sine, for sub oscillators.
+var sineWave = wave(oscSine)
+
+// buildWavetables fills in the tables, before any note is played.
+func buildWavetables() {
+	for _, t := range waveTables {
+		t.points = make([]float64, wavetableSize+1)
+		for i := range t.points {
+			t.points[i] = t.shape(2 * math.Pi * float64(i) / wavetableSize)
+		}
+	}
+}
+
+type tableOsc struct {
+	points []float64
+	phase  float64
+}
+
+func (o *tableOsc) NextSample(freq, sampleRate float64) float64 {
+	x := o.phase * (wavetableSize / (2 * math.Pi))
+	i := min(int(x), wavetableSize-1)
+	v := o.points[i] + (o.points[i+1]-o.points[i])*(x-float64(i))
+	o.phase = advancePhase(o.phase, freq, sampleRate)
+	return v
+}
+
+func (o *tableOsc) scatter() { o.phase = rand.Float64() * 2 * math.Pi }
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// --- WEBSOCKET API ---
+//
+// With "websocket": "127.0.0.1:7702" in the config, ws://host:port/ws
+// streams performance events as JSON and takes commands back, so a
+// browser page or a phone can act as a remote keyboard. Commands go
+// through the same handler as OSC messages:
+//
+//	{"cmd": "note", "note": 60, "velocity": 0.8}   or "key": "a"; 0 releases
+//	{"cmd": "instrument", "name": "Wind"}          or "index": 3
+//	{"cmd": "param", "name": "cutoff", "value": 800}
+//	{"cmd": "action", "name": "panic"}
+//
+// Events look like {"type": "note_on", "key": "a", "freq": 261.6,
+// "velocity": 1}, {"type": "instrument", "index": 3, "name": "Wind"} and
+// {"type": "spectrum", "bars": [...]}.
+//
+// Browsers let any page open a WebSocket to localhost, so a connection
+// from a page is only taken when the page was served by the same host
+// and port or its origin is listed in "origins" in the config. Clients
+// outside a browser send no Origin and are let in.
+
+const (
+	wsGUID       = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsMaxMessage = 64 << 10
+	wsQueue      = 64 // frames buffered per client before it starts missing events
+)
+
+type wsCommand struct {
+	Cmd      string   `json:"cmd"`
+	Note     *float64 `json:"note"`
+	Key      string   `json:"key"`
+	Velocity *float64 `json:"velocity"`
+	Index    *int     `json:"index"`
+	Name     string   `json:"name"`
+	Value    float64  `json:"value"`
+}
+
+// message turns a command into the OSC message that does the same thing.
+func (c wsCommand) message() (oscMessage, error) {
+	msg := oscMessage{Address: "/piango/" + c.Cmd}
+	switch c.Cmd {
+	case "note":
+		switch {
+		case c.Key != "":
+			msg.Args = []any{c.Key}
+		case c.Note != nil:
+			msg.Args = []any{*c.Note}
+		default:
+			return msg, errors.New("note needs a note or a key")
+		}
+		if c.Velocity != nil {
+			msg.Args = append(msg.Args, *c.Velocity)
+		}
+	case "instrument":
+		if c.Index != nil {
+			msg.Args = []any{int32(*c.Index)}
+		} else {
+			msg.Args = []any{c.Name}
+		}
+	case "param":
+		msg.Args = []any{c.Name, c.Value}
+	case "action":
+		msg.Args = []any{c.Name}
+	default:
+		return msg, errors.New("unknown cmd " + c.Cmd)
+	}
+	return msg, nil
+}
+
+// wsHub keeps the connected clients and fans events out to them.
+type wsHub struct {
+	ln      net.Listener
+	origins []string // pages allowed besides the host's own
+	mu      sync.Mutex
+	clients map[*wsClient]bool
+}
+
+type wsClient struct {
+	conn net.Conn
+	out  chan []byte
+}
+
+func listenWebSocket(addr string, origins []string) (*wsHub, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &wsHub{ln: ln, origins: origins, clients: make(map[*wsClient]bool)}, nil
+}
+
+// serve starts accepting connections; commands are sent to p.
+func (h *wsHub) serve(p *tea.Program) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, req *http.Request) {
+		if !originAllowed(req, h.origins) {
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+			return
+		}
+		conn, rw, err := wsUpgrade(w, req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c := &wsClient{conn: conn, out: make(chan []byte, wsQueue)}
+		h.mu.Lock()
+		h.clients[c] = true
+		h.mu.Unlock()
+
+		go c.writeLoop()
+		c.readLoop(rw.Reader, p)
+
+		h.mu.Lock()
+		delete(h.clients, c)
+		h.mu.Unlock()
+		close(c.out)
+	})
+	go http.Serve(h.ln, mux)
+}
+
+// originAllowed reports whether a request may drive piango: it has no
+// Origin, as from a script, or comes from a page on the same host or one
+// of the allowed origins ("http://192.168.1.20:8080").
+func originAllowed(req *http.Request, allowed []string) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, req.Host) {
+		return true
+	}
+	for _, a := range allowed {
+		if strings.EqualFold(strings.TrimSuffix(a, "/"), u.Scheme+"://"+u.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+// wsUpgrade answers the opening handshake and takes over the connection.
+func wsUpgrade(w http.ResponseWriter, req *http.Request) (net.Conn, *bufio.ReadWriter, error) {
+	if !strings.EqualFold(req.Header.Get("Upgrade"), "websocket") {
+		return nil, nil, errors.New("expected a websocket upgrade")
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, nil, errors.New("missing Sec-WebSocket-Key")
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection can't be upgraded")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, rw, nil
+}
+
+// emit queues the events for every client. A client that can't keep up
+// misses frames rather than holding up the UI.
+func (h *wsHub) emit(events []perfEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.clients) == 0 {
+		return
+	}
+	for _, ev := range events {
+		frame := wsFrame(0x1, wsEventJSON(ev))
+		for c := range h.clients {
+			select {
+			case c.out <- frame:
+			default:
+			}
+		}
+	}
+}
+
+func wsEventJSON(ev perfEvent) []byte {
+	fields := map[string]any{"type": ev.Type}
+	switch ev.Type {
+	case "note_on":
+		fields["key"], fields["freq"], fields["velocity"] = ev.Key, ev.Freq, ev.Velocity
+	case "note_off":
+		fields["key"], fields["freq"] = ev.Key, ev.Freq
+	case "instrument":
+		fields["index"], fields["name"] = ev.Index, ev.Name
+	case "spectrum":
+		bars := make([]float64, len(ev.Bars))
+		for i, v := range ev.Bars {
+			bars[i] = math.Round(v*1000) / 1000
+		}
+		fields["bars"] = bars
+	}
+	data, _ := json.Marshal(fields)
+	return data
+}
+
+func (c *wsClient) writeLoop() {
+	for frame := range c.out {
+		if _, err := c.conn.Write(frame); err != nil {
+			c.conn.Close()
+			return
+		}
+	}
+	c.conn.Close()
+}
+
+// readLoop handles the client's frames until it goes away. Replies to
+// bad commands go back as {"type": "error"} events.
+func (c *wsClient) readLoop(r *bufio.Reader, p *tea.Program) {
+	var msg []byte
+	for {
+		fin, op, payload, err := wsReadFrame(r)
+		if err != nil {
+			return
+		}
+		switch op {
+		case 0x8: // close
+			c.send(wsFrame(0x8, nil))
+			return
+		case 0x9: // ping
+			c.send(wsFrame(0xA, payload))
+			continue
+		case 0xA: // pong
+			continue
+		}
+
+		msg = append(msg, payload...)
+		if len(msg) > wsMaxMessage {
+			return
+		}
+		if !fin {
+			continue
+		}
+
+		var cmd wsCommand
+		err = json.Unmarshal(msg, &cmd)
+		msg = msg[:0]
+		var om oscMessage
+		if err == nil {
+			om, err = cmd.message()
+		}
+		if err != nil {
+			data, _ := json.Marshal(map[string]string{"type": "error", "error": err.Error()})
+			c.send(wsFrame(0x1, data))
+			continue
+		}
+		p.Send(om)
+	}
+}
+
+func (c *wsClient) send(frame []byte) {
+	select {
+	case c.out <- frame:
+	default:
+	}
+}
+
+// wsReadFrame reads one client frame, unmasking its payload.
+func wsReadFrame(r *bufio.Reader) (fin bool, op byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(r, head[:]); err != nil {
+		return
+	}
+	fin = head[0]&0x80 != 0
+	op = head[0] & 0x0F
+	masked := head[1]&0x80 != 0
+
+	n := uint64(head[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(r, ext[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(r, ext[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > wsMaxMessage {
+		err = errors.New("websocket: frame too large")
+		return
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(r, mask[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return
+}
+
+// wsFrame builds an unmasked server frame.
+func wsFrame(op byte, payload []byte) []byte {
+	b := []byte{0x80 | op}
+	switch n := len(payload); {
+	case n < 126:
+		b = append(b, byte(n))
+	case n <= math.MaxUint16:
+		b = append(b, 126)
+		b = binary.BigEndian.AppendUint16(b, uint16(n))
+	default:
+		b = append(b, 127)
+		b = binary.BigEndian.AppendUint64(b, uint64(n))
+	}
+	return append(b, payload...)
+}